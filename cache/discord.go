@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// Discord is what the cache needs of the bot that can see a channel. The
+// server gives it the states of its bots, and tests fakes kept in memory.
+type Discord interface {
+	// CachedChannel returns a channel if the bot has it, without asking
+	// Discord for it.
+	CachedChannel(id discord.ChannelID) (*discord.Channel, error)
+	// Channel returns a channel, asking Discord for it if the bot doesn't
+	// have it.
+	Channel(id discord.ChannelID) (*discord.Channel, error)
+	// MessagesAfter fetches up to limit of the messages of a channel sent
+	// after a message from Discord, newest first like Discord sends them.
+	MessagesAfter(id discord.ChannelID, after discord.MessageID, limit uint) ([]discord.Message, error)
+	// ForgetMessages drops the messages of a channel that the bot has.
+	ForgetMessages(id discord.ChannelID)
+}
+
+// State is the Discord of a bot's state.
+type State struct {
+	*state.State
+}
+
+func (s State) CachedChannel(id discord.ChannelID) (*discord.Channel, error) {
+	return s.Cabinet.Channel(id)
+}
+
+func (s State) Channel(id discord.ChannelID) (*discord.Channel, error) {
+	return s.State.Channel(id)
+}
+
+func (s State) MessagesAfter(id discord.ChannelID, after discord.MessageID, limit uint) ([]discord.Message, error) {
+	return s.Client.MessagesAfter(id, after, limit)
+}
+
+func (s State) ForgetMessages(id discord.ChannelID) {
+	msgs, _ := s.Cabinet.Messages(id)
+	for _, m := range msgs {
+		s.Cabinet.MessageRemove(id, m.ID)
+	}
+}
@@ -0,0 +1,90 @@
+package cache
+
+import "container/list"
+
+// LRU is a map that holds at most max entries, dropping the least recently
+// used ones to make room for new ones. A max of 0 means there is no limit.
+// It isn't safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	max   int
+	order *list.List // of *lruEntry[K, V], most recently used first
+	items map[K]*list.Element
+	// evictable reports whether an entry can be dropped. Entries that
+	// can't are skipped over, so the map can hold more than max of them.
+	evictable func(K, V) bool
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func NewLRU[K comparable, V any](max int, evictable func(K, V) bool) *LRU[K, V] {
+	return &LRU[K, V]{
+		max:       max,
+		order:     list.New(),
+		items:     make(map[K]*list.Element),
+		evictable: evictable,
+	}
+}
+
+// Get returns the value of key and marks it as the most recently used.
+func (l *LRU[K, V]) Get(key K) (V, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// Add sets the value of key, and returns the keys of the entries that were
+// dropped to make room for it.
+func (l *LRU[K, V]) Add(key K, value V) (evicted []K) {
+	if e, ok := l.items[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		l.order.MoveToFront(e)
+		return nil
+	}
+	l.items[key] = l.order.PushFront(&lruEntry[K, V]{key, value})
+	if l.max <= 0 {
+		return nil
+	}
+	for e := l.order.Back(); e != nil && len(l.items) > l.max; {
+		prev := e.Prev()
+		ent := e.Value.(*lruEntry[K, V])
+		if ent.key != key && (l.evictable == nil || l.evictable(ent.key, ent.value)) {
+			l.order.Remove(e)
+			delete(l.items, ent.key)
+			evicted = append(evicted, ent.key)
+		}
+		e = prev
+	}
+	return evicted
+}
+
+func (l *LRU[K, V]) Remove(key K) {
+	if e, ok := l.items[key]; ok {
+		l.order.Remove(e)
+		delete(l.items, key)
+	}
+}
+
+func (l *LRU[K, V]) Clear() {
+	l.order.Init()
+	l.items = make(map[K]*list.Element)
+}
+
+func (l *LRU[K, V]) Len() int {
+	return len(l.items)
+}
+
+// Each calls fn with every entry, without changing how recently they were
+// used.
+func (l *LRU[K, V]) Each(fn func(K, V)) {
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		ent := e.Value.(*lruEntry[K, V])
+		fn(ent.key, ent.value)
+	}
+}
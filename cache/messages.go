@@ -0,0 +1,744 @@
+// Package cache keeps track of which of the messages of Discord channels
+// are stored in a database, fetching the histories of those that aren't
+// from Discord when they are needed.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// ErrFrozenChannel is returned for attempts to fetch the history of a
+// channel in a frozen guild again.
+var ErrFrozenChannel = errors.New("the channel is in a frozen guild and can't be fetched again")
+
+// Messages is a cache of which channels' messages are up to date in the
+// database. The messages of channels that aren't are fetched from Discord
+// when they are needed, and stored as they are.
+type Messages struct {
+	forChannel func(discord.ChannelID) Discord
+	db         database.Database
+	frozen     func(discord.GuildID) bool
+
+	mu       sync.Mutex
+	channels *LRU[discord.ChannelID, *channel]
+	// evictions is how many channels have been dropped from the cache to
+	// keep it under its size.
+	evictions atomic.Uint64
+
+	// pending is the number of channels whose history is being fetched.
+	pending atomic.Int64
+}
+
+// fetchCallback is a callback that is ran every time a batch of messages is
+// fetched. It returns true when it should stop being called.
+type fetchCallback func(msgs []discord.Message, full bool, err error) (done bool)
+
+type channel struct {
+	mut      sync.Mutex
+	uptodate *bool
+	// frozen is set for posts in frozen guilds, which are only ever served
+	// from the database.
+	frozen bool
+
+	// fetch is the fetch of the channel's history that is going on, if
+	// there is one.
+	fetch *fetch
+}
+
+// fetch is a fetch of a channel's history from Discord. Everything that
+// needs the messages of a channel while its history is being fetched joins
+// the fetch, so that however many requests come in for a post that isn't
+// cached, its history is only fetched once.
+type fetch struct {
+	mu        sync.Mutex
+	msgs      []discord.Message
+	full      bool
+	err       error
+	callbacks []fetchCallback
+	// done is closed once the fetch holds the channel's lock to write the
+	// messages to the database.
+	done chan struct{}
+}
+
+// join calls fn with the messages fetched so far, and then every time more
+// are fetched, until it is done.
+func (f *fetch) join(fn fetchCallback) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.full {
+		fn(f.msgs, true, f.err)
+		return
+	}
+	if len(f.msgs) > 0 && fn(f.msgs, false, nil) {
+		return
+	}
+	f.callbacks = append(f.callbacks, fn)
+}
+
+// add adds a batch of fetched messages, which are the last ones if full is
+// set or if the fetch failed, and calls the callbacks that aren't done with
+// them.
+func (f *fetch) add(batch []discord.Message, full bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgs = append(f.msgs, batch...)
+	f.full = full || err != nil
+	f.err = err
+	kept := f.callbacks[:0]
+	for _, fn := range f.callbacks {
+		if !fn(f.msgs, f.full, err) {
+			kept = append(kept, fn)
+		}
+	}
+	f.callbacks = kept
+	if f.full {
+		f.callbacks = nil
+	}
+}
+
+// New returns a message cache that holds the state of up to maxChannels
+// channels, or of any number of them if it is 0. forChannel returns the
+// bot that can see a channel, and frozen reports whether a guild is
+// frozen, in which case its posts are only ever served from db.
+func New(forChannel func(discord.ChannelID) Discord, db database.Database, frozen func(discord.GuildID) bool, maxChannels int) *Messages {
+	return &Messages{
+		forChannel: forChannel,
+		db:         db,
+		frozen:     frozen,
+		channels:   NewLRU(maxChannels, evictableChannel),
+	}
+}
+
+// evictableChannel reports whether a channel can be dropped from the cache,
+// which it can't while its history is being fetched or while it is in use,
+// since whoever is using it would then have a different channel than the
+// next one to look it up.
+func evictableChannel(_ discord.ChannelID, ch *channel) bool {
+	if !ch.mut.TryLock() {
+		return false
+	}
+	defer ch.mut.Unlock()
+	return ch.fetch == nil
+}
+
+// load returns the cached state of a channel, adding it if it isn't cached
+// yet. The messages Discord's state keeps of channels dropped to make room
+// for it are dropped as well, since nothing else would drop them.
+func (c *Messages) load(chID discord.ChannelID) *channel {
+	c.mu.Lock()
+	ch, ok := c.channels.Get(chID)
+	var evicted []discord.ChannelID
+	if !ok {
+		ch = &channel{}
+		evicted = c.channels.Add(chID, ch)
+	}
+	c.mu.Unlock()
+	for _, id := range evicted {
+		c.evictions.Add(1)
+		c.forChannel(id).ForgetMessages(id)
+	}
+	return ch
+}
+
+// Forget drops a channel from the cache, so its state is looked up again
+// when it is next needed.
+func (c *Messages) Forget(chID discord.ChannelID) {
+	c.mu.Lock()
+	c.channels.Remove(chID)
+	c.mu.Unlock()
+}
+
+// ForgetAll drops every channel from the cache.
+func (c *Messages) ForgetAll() {
+	c.mu.Lock()
+	c.channels.Clear()
+	c.mu.Unlock()
+}
+
+// Len returns how many channels are cached.
+func (c *Messages) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels.Len()
+}
+
+// Evictions returns how many channels have been dropped from the cache to
+// keep it under its size.
+func (c *Messages) Evictions() uint64 {
+	return c.evictions.Load()
+}
+
+// Pending returns the number of channels whose history is being fetched.
+func (c *Messages) Pending() int64 {
+	return c.pending.Load()
+}
+
+func (c *Messages) channel(chID discord.ChannelID) (*channel, error) {
+	ch := c.load(chID)
+	ch.mut.Lock()
+	if ch.uptodate != nil {
+		return ch, nil
+	}
+	if channel, err := c.forChannel(chID).CachedChannel(chID); err == nil {
+		if c.frozen(channel.GuildID) {
+			b := true
+			ch.uptodate = &b
+			ch.frozen = true
+			return ch, nil
+		}
+	}
+	upd, err := c.db.UpdatedAt(context.Background(), chID)
+	if err != nil {
+		ch.mut.Unlock()
+		return nil, err
+	}
+	if upd.IsZero() {
+		b := false
+		ch.uptodate = &b
+		return ch, nil
+	}
+	channel, err := c.forChannel(chID).Channel(chID)
+	if err != nil {
+		ch.mut.Unlock()
+		return nil, err
+	}
+	if !channel.ThreadMetadata.Archived {
+		b := false
+		ch.uptodate = &b
+		return ch, nil
+	}
+	b := channel.ThreadMetadata.ArchiveTimestamp.Time().Before(upd)
+	ch.uptodate = &b
+	return ch, nil
+}
+
+func (c *Messages) HandleThreadUpdateEvent(ev *gateway.ThreadUpdateEvent) error {
+	ch, err := c.channel(ev.ID)
+	if err != nil {
+		return err
+	}
+	if !ev.ThreadMetadata.Archived || ch.frozen {
+		ch.mut.Unlock()
+		return nil
+	}
+	uptodate := *ch.uptodate
+	ch.mut.Unlock()
+	if uptodate {
+		return c.db.SetUpdatedAt(context.Background(), ev.ID, ev.ThreadMetadata.ArchiveTimestamp.Time())
+	}
+	return nil
+}
+
+func (c *Messages) Set(ctx context.Context, m discord.Message, update bool) error {
+	ch, err := c.channel(m.ChannelID)
+	if err != nil {
+		return err
+	}
+	if ch.frozen {
+		ch.mut.Unlock()
+		return nil
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+	} else {
+		f := ch.fetch
+		ch.mut.Unlock()
+		if f != nil {
+			<-f.done
+		} else {
+			return nil
+		}
+	}
+	if update {
+		return c.db.UpdateMessage(ctx, m)
+	} else {
+		return c.db.InsertMessage(ctx, m)
+	}
+}
+
+func (c *Messages) Remove(ctx context.Context, chid discord.ChannelID, id discord.MessageID) error {
+	ch, err := c.channel(chid)
+	if err != nil {
+		return err
+	}
+	if ch.frozen {
+		ch.mut.Unlock()
+		return nil
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+	} else {
+		f := ch.fetch
+		ch.mut.Unlock()
+		if f != nil {
+			<-f.done
+		} else {
+			return nil
+		}
+	}
+	return c.db.DeleteMessage(ctx, id)
+}
+
+// RemoveBulk removes the messages of a channel that were deleted at once,
+// as when a moderator purges them, in the same way as Remove.
+func (c *Messages) RemoveBulk(ctx context.Context, chid discord.ChannelID, ids []discord.MessageID) error {
+	ch, err := c.channel(chid)
+	if err != nil {
+		return err
+	}
+	if ch.frozen {
+		ch.mut.Unlock()
+		return nil
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+	} else {
+		f := ch.fetch
+		ch.mut.Unlock()
+		if f != nil {
+			<-f.done
+		} else {
+			return nil
+		}
+	}
+	return c.db.DeleteMessages(ctx, ids)
+}
+
+type result struct {
+	msgs []discord.Message
+	err  error
+}
+
+func (c *Messages) MessagesAfter(ctx context.Context, chID discord.ChannelID, m discord.MessageID, limit uint) (messages []discord.Message, hasbefore, hasafter bool, err error) {
+	ch, err := c.channel(chID)
+	if err != nil {
+		return
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+		return c.storedMessagesAfter(ctx, chID, m, limit)
+	}
+	werr := c.messages(ctx, ch, chID, func(msgs []discord.Message, full bool, e error) (done bool) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return true
+		default:
+		}
+		if e != nil {
+			err = e
+			return true
+		}
+		// m doesn't have to be a message that still exists, so the
+		// messages are searched for the first one after it rather than for
+		// it, and the ones fetched so far are only judged by it until they
+		// reach past it.
+		i := sort.Search(len(msgs), func(i int) bool {
+			return msgs[i].ID > m
+		})
+		if i == len(msgs) && !full {
+			return false
+		}
+		if i > 0 {
+			hasbefore = true
+		}
+		if len(msgs)-i > int(limit) {
+			messages = msgs[i : i+int(limit)]
+			hasafter = true
+			return true
+		}
+		messages = msgs[i:]
+		return full
+	})
+	if werr != nil {
+		err = werr
+	}
+	if err != nil && c.stored(ctx, chID, err) {
+		return c.storedMessagesAfter(ctx, chID, m, limit)
+	}
+	return
+}
+
+func (c *Messages) storedMessagesAfter(ctx context.Context, chID discord.ChannelID, m discord.MessageID, limit uint) (messages []discord.Message, hasbefore, hasafter bool, err error) {
+	messages, hasbefore, err = c.db.MessagesAfter(ctx, chID, m, limit+1)
+	if err != nil {
+		return
+	}
+	if len(messages) == int(limit)+1 {
+		hasafter = true
+		messages = messages[:len(messages)-1]
+	}
+	return
+}
+
+func (c *Messages) MessagesBefore(ctx context.Context, chID discord.ChannelID, m discord.MessageID, limit uint) (messages []discord.Message, hasbefore, hasafter bool, err error) {
+	ch, err := c.channel(chID)
+	if err != nil {
+		return
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+		return c.storedMessagesBefore(ctx, chID, m, limit)
+	}
+	werr := c.messages(ctx, ch, chID, func(msgs []discord.Message, full bool, e error) (done bool) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return true
+		default:
+		}
+		if e != nil {
+			err = e
+			return true
+		}
+		i := sort.Search(len(msgs), func(i int) bool {
+			return msgs[i].ID >= m
+		})
+		if i == 0 {
+			hasafter = len(msgs) > 0
+			return true
+		}
+		if i == len(msgs) && !full {
+			return false
+		}
+		if i < len(msgs) {
+			hasafter = true
+		}
+		if uint(i) > limit {
+			messages = make([]discord.Message, limit)
+			copy(messages, msgs[i-int(limit):i])
+			hasbefore = true
+			return true
+		}
+		messages = make([]discord.Message, i)
+		copy(messages, msgs[:i])
+		return true
+	})
+	if werr != nil {
+		err = werr
+	}
+	if err != nil && c.stored(ctx, chID, err) {
+		return c.storedMessagesBefore(ctx, chID, m, limit)
+	}
+	return
+}
+
+func (c *Messages) storedMessagesBefore(ctx context.Context, chID discord.ChannelID, m discord.MessageID, limit uint) (messages []discord.Message, hasbefore, hasafter bool, err error) {
+	messages, hasafter, err = c.db.MessagesBefore(ctx, chID, m, limit+1)
+	if err != nil {
+		return
+	}
+	if len(messages) == int(limit)+1 {
+		hasbefore = true
+		messages = messages[1:]
+	}
+	return
+}
+
+// stored reports whether messages of a channel that couldn't be fetched
+// from Discord because of err are stored in the database, from when they
+// last were, so that those can be served instead while Discord is having
+// an outage.
+func (c *Messages) stored(ctx context.Context, chID discord.ChannelID, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	upd, dberr := c.db.UpdatedAt(ctx, chID)
+	if dberr != nil || upd.IsZero() {
+		return false
+	}
+	log.Printf("Serving stored messages of %s, fetching them failed: %v", chID, err)
+	markStale(ctx)
+	return true
+}
+
+// NearestMessage returns the ID of the message in the channel whose ID is
+// closest to m, for recovering pagination cursors that point at deleted
+// messages. It returns 0 if the channel has no messages.
+func (c *Messages) NearestMessage(ctx context.Context, chID discord.ChannelID, m discord.MessageID) (nearest discord.MessageID, err error) {
+	ch, err := c.channel(chID)
+	if err != nil {
+		return
+	}
+	if *ch.uptodate {
+		ch.mut.Unlock()
+		return c.db.NearestMessage(ctx, chID, m)
+	}
+	werr := c.messages(ctx, ch, chID, func(msgs []discord.Message, full bool, e error) (done bool) {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return true
+		default:
+		}
+		if e != nil {
+			err = e
+			return true
+		}
+		i := sort.Search(len(msgs), func(i int) bool {
+			return msgs[i].ID >= m
+		})
+		if i == len(msgs) && !full {
+			return false
+		}
+		switch {
+		case i == len(msgs):
+			if i > 0 {
+				nearest = msgs[i-1].ID
+			}
+		case i == 0 || msgs[i].ID-m < m-msgs[i-1].ID:
+			nearest = msgs[i].ID
+		default:
+			nearest = msgs[i-1].ID
+		}
+		return true
+	})
+	if werr != nil {
+		err = werr
+	}
+	if err != nil && c.stored(ctx, chID, err) {
+		return c.db.NearestMessage(ctx, chID, m)
+	}
+	return
+}
+
+// MessagesAsOf returns the messages of a channel as they were at a time.
+// Only edits and deletions made since the channel was first stored are
+// known, so older ones aren't undone, and edits are only known if edit
+// history is kept.
+func (c *Messages) MessagesAsOf(ctx context.Context, chID discord.ChannelID, at time.Time) ([]discord.Message, error) {
+	if err := c.Sync(ctx, chID); err != nil {
+		return nil, err
+	}
+	return c.db.MessagesAsOf(ctx, chID, at)
+}
+
+// Sync makes sure the whole history of a channel is in the database.
+func (c *Messages) Sync(ctx context.Context, chID discord.ChannelID) error {
+	fetched := false
+	for {
+		ch, err := c.channel(chID)
+		if err != nil {
+			return err
+		}
+		if *ch.uptodate {
+			ch.mut.Unlock()
+			return nil
+		}
+		// The fetch is done once it holds the channel's lock to write the
+		// messages to the database, so waiting on it and then taking the
+		// lock waits for the fetch to be stored.
+		if f := ch.fetch; f != nil {
+			ch.mut.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			fetched = true
+			continue
+		}
+		if fetched {
+			ch.mut.Unlock()
+			return fmt.Errorf("storing messages of %s failed", chID)
+		}
+		werr := c.messages(ctx, ch, chID, func(msgs []discord.Message, full bool, e error) (done bool) {
+			err = e
+			return full || e != nil
+		})
+		if werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		fetched = true
+	}
+}
+
+// ChannelState is how far the messages of a channel in the cache have been
+// fetched.
+type ChannelState string
+
+const (
+	ChannelUnknown  ChannelState = "unknown"
+	ChannelBusy     ChannelState = "busy"
+	ChannelFetching ChannelState = "fetching"
+	ChannelFrozen   ChannelState = "frozen"
+	ChannelUpToDate ChannelState = "up to date"
+	ChannelOutdated ChannelState = "outdated"
+)
+
+// States returns the state of every channel in the cache. Channels that are
+// being written to the database are busy.
+func (c *Messages) States() map[discord.ChannelID]ChannelState {
+	states := make(map[discord.ChannelID]ChannelState)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels.Each(func(id discord.ChannelID, ch *channel) {
+		state := ChannelBusy
+		if ch.mut.TryLock() {
+			switch {
+			case ch.fetch != nil:
+				state = ChannelFetching
+			case ch.uptodate == nil:
+				state = ChannelUnknown
+			case ch.frozen:
+				state = ChannelFrozen
+			case *ch.uptodate:
+				state = ChannelUpToDate
+			default:
+				state = ChannelOutdated
+			}
+			ch.mut.Unlock()
+		}
+		states[id] = state
+	})
+	return states
+}
+
+// Refetch marks a channel's messages as outdated, so that its whole history
+// is fetched again when it is next needed.
+func (c *Messages) Refetch(chID discord.ChannelID) error {
+	if channel, err := c.forChannel(chID).CachedChannel(chID); err == nil {
+		if c.frozen(channel.GuildID) {
+			return ErrFrozenChannel
+		}
+	}
+	ch := c.load(chID)
+	ch.mut.Lock()
+	defer ch.mut.Unlock()
+	if ch.frozen {
+		return ErrFrozenChannel
+	}
+	b := false
+	ch.uptodate = &b
+	return nil
+}
+
+// messages calls fn with the messages of a channel as they are fetched,
+// joining the fetch that is going on if there is one, until fn is done. It
+// returns ctx's error if ctx is done first.
+func (c *Messages) messages(ctx context.Context, ch *channel, chid discord.ChannelID, fn fetchCallback) error {
+	done := make(chan struct{})
+	var mu sync.Mutex
+	abandoned := false
+	wrapped := func(msgs []discord.Message, good bool, err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			return true
+		}
+		found := fn(msgs, good, err)
+		if found || good {
+			close(done)
+			return true
+		}
+		return false
+	}
+	// wait waits for fn to be done, or for ctx to be. The fetch goes on
+	// without the request if it gives up, so that the messages are there
+	// when it is retried, but fn isn't called anymore.
+	wait := func() error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		abandoned = true
+		return ctx.Err()
+	}
+	if f := ch.fetch; f != nil {
+		ch.mut.Unlock()
+		f.join(wrapped)
+		return wait()
+	}
+	f := &fetch{callbacks: []fetchCallback{wrapped}, done: make(chan struct{})}
+	ch.fetch = f
+	ch.mut.Unlock()
+	c.pending.Add(1)
+	go func() {
+		defer c.pending.Add(-1)
+		fetchHistory(c.forChannel(chid), chid, f)
+		ch.mut.Lock()
+		close(f.done)
+		err := f.err
+		if err == nil {
+			err = c.db.UpdateMessages(context.Background(), chid, f.msgs)
+			if err != nil {
+				// TODO(samhza): handle this better
+				log.Println("updating messages:", err)
+			}
+		}
+		ch.fetch = nil
+		b := err == nil
+		ch.uptodate = &b
+		ch.mut.Unlock()
+	}()
+	return wait()
+}
+
+// fetchHistory fetches the whole history of a channel into f, oldest
+// messages first.
+func fetchHistory(d Discord, chanID discord.ChannelID, f *fetch) {
+	var after discord.MessageID
+	for {
+		m, err := d.MessagesAfter(chanID, after, 100)
+		if err != nil {
+			f.add(nil, true, err)
+			return
+		}
+		for i, j := 0, len(m)-1; i < j; i, j = i+1, j-1 {
+			m[i], m[j] = m[j], m[i]
+		}
+		f.add(m, len(m) < 100, nil)
+		if len(m) < 100 {
+			return
+		}
+		after = m[99].ID
+	}
+}
+
+// staleKey is the key of the flag of a context that is set if messages
+// couldn't be fetched from Discord and were served as they were last
+// stored instead.
+type staleKey struct{}
+
+// WithStaleFlag returns a copy of ctx with a flag that is set if messages
+// fetched with it are served as they were last stored, because Discord
+// couldn't be reached.
+func WithStaleFlag(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, new(atomic.Bool))
+}
+
+// StaleFlag returns the flag of ctx given by WithStaleFlag, or nil if it
+// has none.
+func StaleFlag(ctx context.Context) *atomic.Bool {
+	stale, _ := ctx.Value(staleKey{}).(*atomic.Bool)
+	return stale
+}
+
+// markStale sets the flag of ctx, if it has one.
+func markStale(ctx context.Context) {
+	if stale := StaleFlag(ctx); stale != nil {
+		stale.Store(true)
+	}
+}
@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// fakeDiscord is a Discord kept in memory, whose channels are archived
+// posts with their messages kept oldest first.
+type fakeDiscord struct {
+	channels map[discord.ChannelID]*discord.Channel
+	messages map[discord.ChannelID][]discord.Message
+	// gate, if set, is received from before each batch of messages but the
+	// first is sent, so that tests can see fetches that are partly done.
+	gate chan struct{}
+
+	mu      sync.Mutex
+	batches int
+}
+
+func (d *fakeDiscord) CachedChannel(id discord.ChannelID) (*discord.Channel, error) {
+	return d.Channel(id)
+}
+
+func (d *fakeDiscord) Channel(id discord.ChannelID) (*discord.Channel, error) {
+	ch, ok := d.channels[id]
+	if !ok {
+		return nil, errors.New("Unknown Channel")
+	}
+	return ch, nil
+}
+
+func (d *fakeDiscord) MessagesAfter(id discord.ChannelID, after discord.MessageID, limit uint) ([]discord.Message, error) {
+	d.mu.Lock()
+	d.batches++
+	first := d.batches == 1
+	d.mu.Unlock()
+	if !first && d.gate != nil {
+		<-d.gate
+	}
+	msgs := d.messages[id]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].ID > after })
+	msgs = msgs[i:]
+	if len(msgs) > int(limit) {
+		msgs = msgs[:limit]
+	}
+	batch := make([]discord.Message, len(msgs))
+	for i, m := range msgs {
+		batch[len(msgs)-1-i] = m
+	}
+	return batch, nil
+}
+
+func (d *fakeDiscord) ForgetMessages(discord.ChannelID) {}
+
+// testPost is the ID of the post of the tests, which is archived.
+const testPost = discord.ChannelID(1 << 32)
+
+// testMessage returns the ID of the n-th message of the post of the tests.
+// The first is the post's starter message, which has the post's ID, and
+// the IDs of the others are 10 apart so that there are IDs between them.
+func testMessage(n int) discord.MessageID {
+	return discord.MessageID(testPost) + discord.MessageID(10*n)
+}
+
+// newFakeDiscord returns a Discord with the post of the tests, which has n
+// messages but the ones that were deleted.
+func newFakeDiscord(n int, deleted ...int) *fakeDiscord {
+	gone := make(map[int]bool)
+	for _, i := range deleted {
+		gone[i] = true
+	}
+	var msgs []discord.Message
+	for i := 0; i < n; i++ {
+		if !gone[i] {
+			msgs = append(msgs, discord.Message{ID: testMessage(i), ChannelID: testPost})
+		}
+	}
+	return &fakeDiscord{
+		channels: map[discord.ChannelID]*discord.Channel{testPost: {
+			ID:   testPost,
+			Type: discord.GuildPublicThread,
+			ThreadMetadata: &discord.ThreadMetadata{
+				Archived:         true,
+				ArchiveTimestamp: discord.NewTimestamp(time.Now().Add(-time.Hour)),
+			},
+		}},
+		messages: map[discord.ChannelID][]discord.Message{testPost: msgs},
+	}
+}
+
+func newTestCache(d Discord) *Messages {
+	return New(func(discord.ChannelID) Discord { return d }, database.OpenMemory(database.Options{}),
+		func(discord.GuildID) bool { return false }, 0)
+}
+
+func TestFetchHistory(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCache(newFakeDiscord(250))
+	msgs, hasbefore, hasafter, err := c.MessagesAfter(ctx, testPost, 0, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 25 || msgs[0].ID != testMessage(0) || msgs[24].ID != testMessage(24) || hasbefore || !hasafter {
+		t.Errorf("got %d messages, hasbefore %t, hasafter %t", len(msgs), hasbefore, hasafter)
+	}
+	if err := c.Sync(ctx, testPost); err != nil {
+		t.Fatal(err)
+	}
+	stored, _, err := c.db.MessagesAfter(ctx, testPost, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 250 {
+		t.Errorf("%d messages are stored, want 250", len(stored))
+	}
+}
+
+// testRange returns the IDs of the messages of the post of the tests from
+// the first-th up to the last-th, but the deleted ones.
+func testRange(first, last int, deleted ...int) []discord.MessageID {
+	gone := make(map[int]bool)
+	for _, i := range deleted {
+		gone[i] = true
+	}
+	var ids []discord.MessageID
+	for i := first; i <= last; i++ {
+		if !gone[i] {
+			ids = append(ids, testMessage(i))
+		}
+	}
+	return ids
+}
+
+// TestCursorsAtDeletedMessages pages through a post from cursors at
+// messages that were deleted, from the messages stored in the database
+// and while the post's history is being fetched. Fetches are held after
+// their first batch, which has the messages up to the 102nd, so the
+// cursors past it are only answered once more is fetched.
+func TestCursorsAtDeletedMessages(t *testing.T) {
+	deleted := []int{3, 30, 31, 150}
+	latest := discord.MessageID(1<<63 - 1)
+	tests := []struct {
+		name   string
+		before bool
+		cursor discord.MessageID
+		// firstBatch is set if the messages are found in the first batch
+		// of a fetch.
+		firstBatch          bool
+		want                []discord.MessageID
+		hasbefore, hasafter bool
+	}{
+		{"after the start", false, 0, true, testRange(0, 25, deleted...), false, true},
+		{"after a deleted message", false, testMessage(30), true, testRange(32, 56), true, true},
+		{"after a deleted message past the first batch", false, testMessage(150), false, testRange(151, 175), true, true},
+		{"after a message near the end of the first batch", false, testMessage(90), false, testRange(91, 115), true, true},
+		{"after the last message", false, testMessage(249) + 5, false, nil, true, false},
+		{"before a deleted message", true, testMessage(30), true, testRange(5, 29, deleted...), true, true},
+		{"before a deleted message near the start", true, testMessage(3), true, testRange(0, 2), false, true},
+		{"before the first message", true, testMessage(0), true, nil, false, true},
+		{"before a deleted message past the first batch", true, testMessage(150), false, testRange(125, 149), true, true},
+		{"before the latest message", true, latest, false, testRange(225, 249), true, false},
+	}
+	page := func(c *Messages, before bool, cursor discord.MessageID) ([]discord.Message, bool, bool, error) {
+		if before {
+			return c.MessagesBefore(context.Background(), testPost, cursor, 25)
+		}
+		return c.MessagesAfter(context.Background(), testPost, cursor, 25)
+	}
+	check := func(t *testing.T, msgs []discord.Message, hasbefore, hasafter bool, want []discord.MessageID, wantbefore, wantafter bool) {
+		t.Helper()
+		got := make([]discord.MessageID, len(msgs))
+		for i, m := range msgs {
+			got[i] = m.ID
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got messages %v, want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("got messages %v, want %v", got, want)
+			}
+		}
+		if hasbefore != wantbefore || hasafter != wantafter {
+			t.Errorf("hasbefore is %t and hasafter %t, want %t and %t", hasbefore, hasafter, wantbefore, wantafter)
+		}
+	}
+	for _, test := range tests {
+		t.Run("stored/"+test.name, func(t *testing.T) {
+			c := newTestCache(newFakeDiscord(250, deleted...))
+			if err := c.Sync(context.Background(), testPost); err != nil {
+				t.Fatal(err)
+			}
+			msgs, hasbefore, hasafter, err := page(c, test.before, test.cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, msgs, hasbefore, hasafter, test.want, test.hasbefore, test.hasafter)
+		})
+		t.Run("fetching/"+test.name, func(t *testing.T) {
+			d := newFakeDiscord(250, deleted...)
+			d.gate = make(chan struct{})
+			released := false
+			release := func() {
+				if !released {
+					close(d.gate)
+					released = true
+				}
+			}
+			defer release()
+			c := newTestCache(d)
+			type result struct {
+				msgs                []discord.Message
+				hasbefore, hasafter bool
+				err                 error
+			}
+			done := make(chan result, 1)
+			go func() {
+				var r result
+				r.msgs, r.hasbefore, r.hasafter, r.err = page(c, test.before, test.cursor)
+				done <- r
+			}()
+			var r result
+			if test.firstBatch {
+				select {
+				case r = <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the messages weren't found in the first batch")
+				}
+			} else {
+				release()
+				r = <-done
+			}
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			check(t, r.msgs, r.hasbefore, r.hasafter, test.want, test.hasbefore, test.hasafter)
+		})
+	}
+}
+
+// TestEmptyPost pages through a post whose messages were all deleted.
+func TestEmptyPost(t *testing.T) {
+	ctx := context.Background()
+	for _, stored := range []bool{false, true} {
+		c := newTestCache(newFakeDiscord(1, 0))
+		if stored {
+			if err := c.Sync(ctx, testPost); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msgs, hasbefore, hasafter, err := c.MessagesBefore(ctx, testPost, discord.MessageID(1<<63-1), 25)
+		if err != nil || len(msgs) != 0 || hasbefore || hasafter {
+			t.Errorf("stored %t: before the latest, got %d messages, hasbefore %t, hasafter %t, error %v",
+				stored, len(msgs), hasbefore, hasafter, err)
+		}
+		msgs, hasbefore, hasafter, err = c.MessagesAfter(ctx, testPost, 0, 25)
+		if err != nil || len(msgs) != 0 || hasbefore || hasafter {
+			t.Errorf("stored %t: after the start, got %d messages, hasbefore %t, hasafter %t, error %v",
+				stored, len(msgs), hasbefore, hasafter, err)
+		}
+	}
+}
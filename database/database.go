@@ -7,15 +7,136 @@ import (
 	"github.com/diamondburned/arikawa/v3/discord"
 )
 
+// Options are the settings of a database that change what it keeps.
+type Options struct {
+	// MaxRevisions is how many earlier versions of each edited message are
+	// kept. None are kept if it is 0. The last version of a deleted
+	// message is always kept.
+	MaxRevisions int
+	// Tombstones keeps deleted messages with when they were deleted, so
+	// links to them still find their place in the post. RedactTombstones
+	// drops everything of them but their author and time.
+	Tombstones       bool
+	RedactTombstones bool
+}
+
+// PageView is how many times a page was viewed on a day, from a site and
+// by a kind of client.
+type PageView struct {
+	Day  time.Time
+	Path string
+	// Referrer is the host of the site that readers came from, or "" if
+	// they came from none or from this one.
+	Referrer string
+	// Agent is the kind of client, like "desktop" or "bot".
+	Agent string
+	Views int
+}
+
+// ViewCount is how many views the pages with something in common got, like
+// the pages of a day or the page of a path.
+type ViewCount struct {
+	Key   string
+	Views int
+}
+
+// Freshness is how up to date the stored messages of a post are, for
+// citing the archive. FirstArchived is zero for posts that were first
+// stored before it was recorded.
+type Freshness struct {
+	// FirstArchived is when the post's messages were first stored, and
+	// LastRefreshed when they were last fetched from Discord as a whole,
+	// when the post had MessageCount messages.
+	FirstArchived time.Time
+	LastRefreshed time.Time
+	MessageCount  int
+}
+
+// MonthCount is how many messages were sent in a month.
+type MonthCount struct {
+	Month    time.Time
+	Messages int
+}
+
 type Database interface {
 	Close() error
 
 	SetUpdatedAt(ctx context.Context, post discord.ChannelID, t time.Time) error
 	UpdatedAt(ctx context.Context, post discord.ChannelID) (time.Time, error)
+	// Freshness returns how up to date the stored messages of a post are,
+	// or the zero Freshness if none were ever stored.
+	Freshness(ctx context.Context, post discord.ChannelID) (Freshness, error)
 	UpdateMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message) error
 	InsertMessage(ctx context.Context, msg discord.Message) error
+	// ImportMessages stores messages of a post from an export made at a
+	// time, keeping the ones that are already stored. A post that wasn't
+	// stored yet counts as archived and refreshed when it was exported.
+	// Imported messages that Discord doesn't have are kept when the post
+	// is fetched again, rather than taken to have been deleted.
+	ImportMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message, at time.Time) error
 	UpdateMessage(ctx context.Context, msg discord.Message) error
 	DeleteMessage(ctx context.Context, msg discord.MessageID) error
+	// Participants returns the first message of each of the first limit
+	// authors to post in a post, in the order they first posted, and how
+	// many authors have posted in it.
+	Participants(ctx context.Context, post discord.ChannelID, limit int) ([]discord.Message, int, error)
+	// DeleteMessages deletes the messages that were deleted at once, like
+	// DeleteMessage does each of them.
+	DeleteMessages(ctx context.Context, msgs []discord.MessageID) error
+	// MessagesAfter and MessagesBefore return up to limit messages of a
+	// post after or before a message, and whether there are any on the
+	// other side of it. The message doesn't have to exist, since the
+	// cursors of pages can be messages that have been deleted since.
 	MessagesAfter(ctx context.Context, post discord.ChannelID, after discord.MessageID, limit uint) ([]discord.Message, bool, error)
 	MessagesBefore(ctx context.Context, post discord.ChannelID, before discord.MessageID, limit uint) ([]discord.Message, bool, error)
+	// MessagesAsOf returns all the messages of a post as they were at a
+	// time, from the revisions kept when messages are edited or deleted.
+	MessagesAsOf(ctx context.Context, post discord.ChannelID, at time.Time) ([]discord.Message, error)
+	// Revisions returns the earlier versions of the messages in a post with
+	// IDs from first to last, oldest first.
+	Revisions(ctx context.Context, post discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error)
+	// Snapshots returns the stored copies of the messages that the messages
+	// with IDs from first to last in a post forwarded, and SaveSnapshots
+	// stores them for a message. Messages whose copies weren't stored are
+	// missing from the map, and ones stored without any map to none.
+	Snapshots(ctx context.Context, post discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error)
+	SaveSnapshots(ctx context.Context, post discord.ChannelID, msg discord.MessageID, snapshots []discord.Message) error
+	// Tombstones returns when the messages with IDs from first to last in a
+	// post that are kept as tombstones were deleted.
+	Tombstones(ctx context.Context, post discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID]time.Time, error)
+	// Headings returns the messages of a post that start with a markdown
+	// heading, oldest first.
+	Headings(ctx context.Context, post discord.ChannelID) ([]discord.Message, error)
+	// StarterMessages returns the stored starter messages of the given
+	// posts that haven't been deleted, by post. A starter message has the
+	// ID of its post.
+	StarterMessages(ctx context.Context, posts []discord.ChannelID) (map[discord.ChannelID]discord.Message, error)
+	// NearestMessage returns the ID of the message in the post closest to
+	// id, or 0 if the post has no messages.
+	NearestMessage(ctx context.Context, post discord.ChannelID, id discord.MessageID) (discord.MessageID, error)
+	// FreezeGuild marks a guild's archive as frozen at the given time, and
+	// ThawGuild undoes it.
+	FreezeGuild(ctx context.Context, guild discord.GuildID, at time.Time) error
+	ThawGuild(ctx context.Context, guild discord.GuildID) error
+	FrozenGuilds(ctx context.Context) (map[discord.GuildID]time.Time, error)
+	// OptOut records that a user opted out of being shown, when, and OptIn
+	// undoes it. OptedOut returns the users that have opted out.
+	OptOut(ctx context.Context, user discord.UserID, at time.Time) error
+	OptIn(ctx context.Context, user discord.UserID) error
+	OptedOut(ctx context.Context) (map[discord.UserID]time.Time, error)
+	// Members returns the stored members of a guild out of the given
+	// users, SaveMembers stores members of a guild over what was stored of
+	// them, and RemoveMember forgets a member that left the guild.
+	Members(ctx context.Context, guild discord.GuildID, users []discord.UserID) ([]discord.Member, error)
+	SaveMembers(ctx context.Context, guild discord.GuildID, members []discord.Member) error
+	RemoveMember(ctx context.Context, guild discord.GuildID, user discord.UserID) error
+	// MonthlyMessages counts the stored messages of the given posts that
+	// haven't been deleted by the month they were sent in, in UTC, oldest
+	// first. Months without any are left out.
+	MonthlyMessages(ctx context.Context, posts []discord.ChannelID) ([]MonthCount, error)
+	// AddPageViews adds to the stored counts of page views. PageViews
+	// returns the views since a day, grouped by "day", "path", "referrer"
+	// or "agent", the most viewed first, or the days in order for "day".
+	AddPageViews(ctx context.Context, views []PageView) error
+	PageViews(ctx context.Context, since time.Time, by string, limit int) ([]ViewCount, error)
 }
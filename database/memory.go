@@ -0,0 +1,578 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// Memory is a database that keeps everything in memory and loses it when
+// the process exits, for running the server without Postgres, like with
+// the demo guild.
+type Memory struct {
+	opts Options
+
+	mu       sync.Mutex
+	updated  map[discord.ChannelID]time.Time
+	fresh    map[discord.ChannelID]Freshness
+	messages map[discord.MessageID]*memoryMessage
+	// revisions are the earlier versions of messages, oldest first.
+	revisions map[discord.MessageID][]memoryRevision
+	snapshots map[discord.MessageID]memorySnapshot
+	frozen    map[discord.GuildID]time.Time
+	optedOut  map[discord.UserID]time.Time
+	members   map[discord.GuildID]map[discord.UserID]discord.Member
+	views     map[PageView]int
+}
+
+type memoryMessage struct {
+	msg discord.Message
+	// deletedAt is when the message was deleted, if it is a tombstone.
+	deletedAt time.Time
+	// imported is set for messages that were imported and haven't been
+	// fetched from Discord since.
+	imported bool
+}
+
+type memoryRevision struct {
+	msg discord.Message
+	// until is when the message stopped looking like this.
+	until time.Time
+}
+
+type memorySnapshot struct {
+	channel discord.ChannelID
+	msgs    []discord.Message
+}
+
+// memoryHeadingRegex matches the content of messages that Headings returns,
+// like the query of Postgres does.
+var memoryHeadingRegex = regexp.MustCompile(`^#{1,3} `)
+
+// OpenMemory returns an empty database kept in memory.
+func OpenMemory(opts Options) Database {
+	return &Memory{
+		opts:      opts,
+		updated:   make(map[discord.ChannelID]time.Time),
+		fresh:     make(map[discord.ChannelID]Freshness),
+		messages:  make(map[discord.MessageID]*memoryMessage),
+		revisions: make(map[discord.MessageID][]memoryRevision),
+		snapshots: make(map[discord.MessageID]memorySnapshot),
+		frozen:    make(map[discord.GuildID]time.Time),
+		optedOut:  make(map[discord.UserID]time.Time),
+		members:   make(map[discord.GuildID]map[discord.UserID]discord.Member),
+		views:     make(map[PageView]int),
+	}
+}
+
+func (db *Memory) Close() error {
+	return nil
+}
+
+// channelMessages returns the stored messages of a post, tombstones
+// included, in order.
+func (db *Memory) channelMessages(ch discord.ChannelID) []*memoryMessage {
+	var msgs []*memoryMessage
+	for _, m := range db.messages {
+		if m.msg.ChannelID == ch {
+			msgs = append(msgs, m)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].msg.ID < msgs[j].msg.ID })
+	return msgs
+}
+
+// saveRevision keeps the current version of a message as it was until a
+// time, unless it was edited at edited, like saveRevision of Postgres.
+func (db *Memory) saveRevision(id discord.MessageID, until, edited time.Time) {
+	m, ok := db.messages[id]
+	if !ok || m.msg.EditedTimestamp.Time().Equal(edited) {
+		return
+	}
+	revs := db.revisions[id]
+	for _, r := range revs {
+		if r.until.Equal(until) {
+			return
+		}
+	}
+	revs = append(revs, memoryRevision{msg: m.msg, until: until})
+	sort.Slice(revs, func(i, j int) bool { return revs[i].until.Before(revs[j].until) })
+	db.revisions[id] = revs
+}
+
+// saveEdit keeps the current version of a message that is being edited, if
+// edits are kept.
+func (db *Memory) saveEdit(msg discord.Message) {
+	if db.opts.MaxRevisions <= 0 {
+		return
+	}
+	edited := msg.EditedTimestamp.Time()
+	db.saveRevision(msg.ID, edited, edited)
+	if revs := db.revisions[msg.ID]; len(revs) > db.opts.MaxRevisions {
+		db.revisions[msg.ID] = revs[len(revs)-db.opts.MaxRevisions:]
+	}
+}
+
+func (db *Memory) SetUpdatedAt(ctx context.Context, post discord.ChannelID, t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.updated[post]; ok {
+		db.updated[post] = t
+	}
+	return nil
+}
+
+func (db *Memory) UpdatedAt(ctx context.Context, post discord.ChannelID) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.updated[post], nil
+}
+
+func (db *Memory) UpdateMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fetched := make(map[discord.MessageID]bool, len(msgs))
+	for _, msg := range msgs {
+		fetched[msg.ID] = true
+		m, ok := db.messages[msg.ID]
+		switch {
+		case !ok || !m.deletedAt.IsZero() || m.imported:
+			db.messages[msg.ID] = &memoryMessage{msg: msg}
+		case m.deletedAt.IsZero() && m.msg.EditedTimestamp.Time().Before(msg.EditedTimestamp.Time()):
+			db.saveEdit(msg)
+			m.msg = msg
+		}
+	}
+	now := time.Now().UTC()
+	for _, m := range db.channelMessages(post) {
+		if !fetched[m.msg.ID] && m.deletedAt.IsZero() && !m.imported {
+			db.deleteMessage(m.msg.ID, now)
+		}
+	}
+	db.updated[post] = now
+	f := db.fresh[post]
+	if f.FirstArchived.IsZero() {
+		f.FirstArchived = now
+	}
+	f.LastRefreshed, f.MessageCount = now, len(msgs)
+	db.fresh[post] = f
+	return nil
+}
+
+func (db *Memory) Freshness(ctx context.Context, post discord.ChannelID) (Freshness, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.fresh[post], nil
+}
+
+func (db *Memory) InsertMessage(ctx context.Context, msg discord.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.messages[msg.ID]; !ok {
+		db.messages[msg.ID] = &memoryMessage{msg: msg}
+	}
+	return nil
+}
+
+func (db *Memory) ImportMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.updated[post]; !ok {
+		at = at.UTC()
+		db.updated[post] = at
+		db.fresh[post] = Freshness{FirstArchived: at, LastRefreshed: at, MessageCount: len(msgs)}
+	}
+	for _, msg := range msgs {
+		if _, ok := db.messages[msg.ID]; !ok {
+			db.messages[msg.ID] = &memoryMessage{msg: msg, imported: true}
+		}
+	}
+	return nil
+}
+
+func (db *Memory) UpdateMessage(ctx context.Context, msg discord.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, ok := db.messages[msg.ID]
+	if !ok || msg.EditedTimestamp.Time().Before(m.msg.EditedTimestamp.Time()) {
+		return nil
+	}
+	db.saveEdit(msg)
+	m.msg = msg
+	return nil
+}
+
+func (db *Memory) DeleteMessage(ctx context.Context, msg discord.MessageID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.deleteMessage(msg, time.Now().UTC())
+	return nil
+}
+
+func (db *Memory) DeleteMessages(ctx context.Context, msgs []discord.MessageID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	at := time.Now().UTC()
+	for _, id := range msgs {
+		db.deleteMessage(id, at)
+	}
+	return nil
+}
+
+// deleteMessage deletes a message, or turns it into a tombstone if those
+// are kept, like deleteMessage of Postgres.
+func (db *Memory) deleteMessage(id discord.MessageID, at time.Time) {
+	m, ok := db.messages[id]
+	if !ok {
+		return
+	}
+	if !db.opts.Tombstones {
+		// Like saveDeletion of Postgres, the version that was current
+		// until the message was deleted is kept whether or not it was
+		// edited.
+		db.revisions[id] = append(db.revisions[id], memoryRevision{msg: m.msg, until: at})
+		delete(db.messages, id)
+		return
+	}
+	if m.deletedAt.IsZero() {
+		m.deletedAt = at
+	}
+	if db.opts.RedactTombstones {
+		delete(db.revisions, id)
+		m.msg = discord.Message{
+			ID:        m.msg.ID,
+			ChannelID: m.msg.ChannelID,
+			GuildID:   m.msg.GuildID,
+			Type:      m.msg.Type,
+			Author:    m.msg.Author,
+			Timestamp: m.msg.Timestamp,
+		}
+	}
+}
+
+func (db *Memory) MessagesAfter(ctx context.Context, ch discord.ChannelID, after discord.MessageID, limit uint) ([]discord.Message, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []discord.Message
+	hasBefore := false
+	for _, m := range db.channelMessages(ch) {
+		if m.msg.ID <= after {
+			hasBefore = true
+		} else if uint(len(msgs)) < limit {
+			msgs = append(msgs, m.msg)
+		}
+	}
+	return msgs, hasBefore, nil
+}
+
+func (db *Memory) MessagesBefore(ctx context.Context, ch discord.ChannelID, before discord.MessageID, limit uint) ([]discord.Message, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []discord.Message
+	hasAfter := false
+	for _, m := range db.channelMessages(ch) {
+		if m.msg.ID >= before {
+			hasAfter = true
+		} else {
+			msgs = append(msgs, m.msg)
+		}
+	}
+	if uint(len(msgs)) > limit {
+		msgs = msgs[uint(len(msgs))-limit:]
+	}
+	return msgs, hasAfter, nil
+}
+
+func (db *Memory) MessagesAsOf(ctx context.Context, ch discord.ChannelID, at time.Time) ([]discord.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	byID := make(map[discord.MessageID]discord.Message)
+	for _, m := range db.channelMessages(ch) {
+		if m.deletedAt.IsZero() || m.deletedAt.After(at) {
+			byID[m.msg.ID] = m.msg
+		}
+	}
+	// A message's version at a time is its first revision that was
+	// current until after it, or the stored one if there is none.
+	for id, revs := range db.revisions {
+		for _, r := range revs {
+			if r.msg.ChannelID == ch && r.until.After(at) {
+				byID[id] = r.msg
+				break
+			}
+		}
+	}
+	limit := discord.MessageID(discord.NewSnowflake(at))
+	var msgs []discord.Message
+	for id, msg := range byID {
+		if id < limit {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs, nil
+}
+
+func (db *Memory) Revisions(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	revs := make(map[discord.MessageID][]discord.Message)
+	for id, rs := range db.revisions {
+		if id < first || id > last {
+			continue
+		}
+		for _, r := range rs {
+			if r.msg.ChannelID == ch {
+				revs[id] = append(revs[id], r.msg)
+			}
+		}
+	}
+	return revs, nil
+}
+
+func (db *Memory) Snapshots(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	snapshots := make(map[discord.MessageID][]discord.Message)
+	for id, s := range db.snapshots {
+		if s.channel == ch && id >= first && id <= last {
+			snapshots[id] = s.msgs
+		}
+	}
+	return snapshots, nil
+}
+
+func (db *Memory) SaveSnapshots(ctx context.Context, ch discord.ChannelID, msg discord.MessageID, snapshots []discord.Message) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if snapshots == nil {
+		snapshots = []discord.Message{}
+	}
+	db.snapshots[msg] = memorySnapshot{channel: ch, msgs: snapshots}
+	return nil
+}
+
+func (db *Memory) Tombstones(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID]time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	deleted := make(map[discord.MessageID]time.Time)
+	for _, m := range db.channelMessages(ch) {
+		if m.msg.ID >= first && m.msg.ID <= last && !m.deletedAt.IsZero() {
+			deleted[m.msg.ID] = m.deletedAt
+		}
+	}
+	return deleted, nil
+}
+
+func (db *Memory) Headings(ctx context.Context, ch discord.ChannelID) ([]discord.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []discord.Message
+	for _, m := range db.channelMessages(ch) {
+		if m.deletedAt.IsZero() && memoryHeadingRegex.MatchString(m.msg.Content) {
+			msgs = append(msgs, m.msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (db *Memory) StarterMessages(ctx context.Context, posts []discord.ChannelID) (map[discord.ChannelID]discord.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	msgs := make(map[discord.ChannelID]discord.Message)
+	for _, id := range posts {
+		m, ok := db.messages[discord.MessageID(id)]
+		if ok && m.msg.ChannelID == id && m.deletedAt.IsZero() {
+			msgs[id] = m.msg
+		}
+	}
+	return msgs, nil
+}
+
+func (db *Memory) Participants(ctx context.Context, post discord.ChannelID, limit int) ([]discord.Message, int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var msgs []discord.Message
+	seen := make(map[discord.UserID]bool)
+	for _, m := range db.channelMessages(post) {
+		if !m.deletedAt.IsZero() || seen[m.msg.Author.ID] {
+			continue
+		}
+		seen[m.msg.Author.ID] = true
+		if len(msgs) < limit {
+			msgs = append(msgs, m.msg)
+		}
+	}
+	return msgs, len(seen), nil
+}
+
+func (db *Memory) NearestMessage(ctx context.Context, ch discord.ChannelID, id discord.MessageID) (discord.MessageID, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var nearest discord.MessageID
+	var distance uint64
+	for _, m := range db.channelMessages(ch) {
+		d := uint64(m.msg.ID - id)
+		if m.msg.ID < id {
+			d = uint64(id - m.msg.ID)
+		}
+		if !nearest.IsValid() || d < distance {
+			nearest, distance = m.msg.ID, d
+		}
+	}
+	return nearest, nil
+}
+
+func (db *Memory) FreezeGuild(ctx context.Context, guild discord.GuildID, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.frozen[guild] = at
+	return nil
+}
+
+func (db *Memory) ThawGuild(ctx context.Context, guild discord.GuildID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.frozen, guild)
+	return nil
+}
+
+func (db *Memory) FrozenGuilds(ctx context.Context) (map[discord.GuildID]time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	frozen := make(map[discord.GuildID]time.Time, len(db.frozen))
+	for id, at := range db.frozen {
+		frozen[id] = at
+	}
+	return frozen, nil
+}
+
+func (db *Memory) OptOut(ctx context.Context, user discord.UserID, at time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.optedOut[user]; !ok {
+		db.optedOut[user] = at
+	}
+	return nil
+}
+
+func (db *Memory) OptIn(ctx context.Context, user discord.UserID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.optedOut, user)
+	return nil
+}
+
+func (db *Memory) OptedOut(ctx context.Context) (map[discord.UserID]time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	users := make(map[discord.UserID]time.Time, len(db.optedOut))
+	for id, at := range db.optedOut {
+		users[id] = at
+	}
+	return users, nil
+}
+
+func (db *Memory) Members(ctx context.Context, guild discord.GuildID, users []discord.UserID) ([]discord.Member, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var members []discord.Member
+	for _, id := range users {
+		if m, ok := db.members[guild][id]; ok {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (db *Memory) SaveMembers(ctx context.Context, guild discord.GuildID, members []discord.Member) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.members[guild] == nil {
+		db.members[guild] = make(map[discord.UserID]discord.Member)
+	}
+	for _, m := range members {
+		db.members[guild][m.User.ID] = m
+	}
+	return nil
+}
+
+func (db *Memory) RemoveMember(ctx context.Context, guild discord.GuildID, user discord.UserID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.members[guild], user)
+	return nil
+}
+
+func (db *Memory) MonthlyMessages(ctx context.Context, posts []discord.ChannelID) ([]MonthCount, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	in := make(map[discord.ChannelID]bool, len(posts))
+	for _, id := range posts {
+		in[id] = true
+	}
+	months := make(map[time.Time]int)
+	for _, m := range db.messages {
+		if in[m.msg.ChannelID] && m.deletedAt.IsZero() {
+			t := m.msg.ID.Time().UTC()
+			months[time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)]++
+		}
+	}
+	counts := make([]MonthCount, 0, len(months))
+	for month, n := range months {
+		counts = append(counts, MonthCount{Month: month, Messages: n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Month.Before(counts[j].Month) })
+	return counts, nil
+}
+
+func (db *Memory) AddPageViews(ctx context.Context, views []PageView) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, v := range views {
+		n := v.Views
+		v.Views = 0
+		v.Day = v.Day.UTC()
+		db.views[v] += n
+	}
+	return nil
+}
+
+func (db *Memory) PageViews(ctx context.Context, since time.Time, by string, limit int) ([]ViewCount, error) {
+	key := map[string]func(PageView) string{
+		"day":      func(v PageView) string { return v.Day.Format("2006-01-02") },
+		"path":     func(v PageView) string { return v.Path },
+		"referrer": func(v PageView) string { return v.Referrer },
+		"agent":    func(v PageView) string { return v.Agent },
+	}[by]
+	if key == nil {
+		return nil, fmt.Errorf("can't group page views by %q", by)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sums := make(map[string]int)
+	for v, n := range db.views {
+		if !v.Day.Before(since) {
+			sums[key(v)] += n
+		}
+	}
+	counts := make([]ViewCount, 0, len(sums))
+	for key, n := range sums {
+		counts = append(counts, ViewCount{Key: key, Views: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if by != "day" && counts[i].Views != counts[j].Views {
+			return counts[i].Views > counts[j].Views
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts, nil
+}
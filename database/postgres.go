@@ -10,7 +10,7 @@ import (
 	"time"
 
 	"github.com/diamondburned/arikawa/v3/discord"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const postgresConfigSchema = `
@@ -28,20 +28,167 @@ CREATE TABLE "Message" (
 	author BIGINT NOT NULL,
 	channel BIGINT NOT NULL,
 	content TEXT NOT NULL,
-	json TEXT NOT NULL
+	json TEXT NOT NULL,
+	deleted_at TIMESTAMP WITH TIME ZONE,
+	imported BOOLEAN NOT NULL DEFAULT FALSE
 );
 
+CREATE INDEX ON "Message" (channel, id);
+
 CREATE TABLE "Channel" (
 	id BIGINT NOT NULL PRIMARY KEY,
-	updated_at TIMESTAMP NOT NULL
+	updated_at TIMESTAMP NOT NULL,
+	archived_at TIMESTAMP WITH TIME ZONE,
+	refreshed_at TIMESTAMP WITH TIME ZONE,
+	message_count INTEGER
+);
+
+CREATE TABLE "FrozenGuild" (
+	id BIGINT NOT NULL PRIMARY KEY,
+	frozen_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE "MessageRevision" (
+	message BIGINT NOT NULL,
+	channel BIGINT NOT NULL,
+	until TIMESTAMP WITH TIME ZONE NOT NULL,
+	edited_at TIMESTAMP WITH TIME ZONE,
+	content TEXT NOT NULL,
+	json TEXT NOT NULL,
+	PRIMARY KEY (message, until)
+);
+
+CREATE INDEX ON "MessageRevision" (channel, until);
+
+CREATE TABLE "MessageSnapshot" (
+	message BIGINT NOT NULL PRIMARY KEY,
+	channel BIGINT NOT NULL,
+	json TEXT NOT NULL
+);
+
+CREATE INDEX ON "MessageSnapshot" (channel, message);
+
+CREATE TABLE "Member" (
+	guild BIGINT NOT NULL,
+	id BIGINT NOT NULL,
+	json TEXT NOT NULL,
+	PRIMARY KEY (guild, id)
+);
+
+CREATE TABLE "OptOut" (
+	id BIGINT NOT NULL PRIMARY KEY,
+	opted_out_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE "PageView" (
+	day DATE NOT NULL,
+	path TEXT NOT NULL,
+	referrer TEXT NOT NULL,
+	agent TEXT NOT NULL,
+	views BIGINT NOT NULL,
+	PRIMARY KEY (day, path, referrer, agent)
 );
 `
 
-var postgresMigrations = []string{""}
+var postgresMigrations = []string{"", `
+CREATE TABLE "FrozenGuild" (
+	id BIGINT NOT NULL PRIMARY KEY,
+	frozen_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+`, `
+CREATE TABLE "MessageRevision" (
+	message BIGINT NOT NULL,
+	channel BIGINT NOT NULL,
+	until TIMESTAMP WITH TIME ZONE NOT NULL,
+	edited_at TIMESTAMP WITH TIME ZONE,
+	content TEXT NOT NULL,
+	json TEXT NOT NULL,
+	PRIMARY KEY (message, until)
+);
+
+CREATE INDEX ON "MessageRevision" (channel, until);
+`, `
+ALTER TABLE "Message" ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+`, `
+CREATE TABLE "MessageSnapshot" (
+	message BIGINT NOT NULL PRIMARY KEY,
+	channel BIGINT NOT NULL,
+	json TEXT NOT NULL
+);
+
+CREATE INDEX ON "MessageSnapshot" (channel, message);
+`, `
+CREATE TABLE "Member" (
+	guild BIGINT NOT NULL,
+	id BIGINT NOT NULL,
+	json TEXT NOT NULL,
+	PRIMARY KEY (guild, id)
+);
+`, `
+CREATE TABLE "OptOut" (
+	id BIGINT NOT NULL PRIMARY KEY,
+	opted_out_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+`, `
+CREATE TABLE "PageView" (
+	day DATE NOT NULL,
+	path TEXT NOT NULL,
+	referrer TEXT NOT NULL,
+	agent TEXT NOT NULL,
+	views BIGINT NOT NULL,
+	PRIMARY KEY (day, path, referrer, agent)
+);
+`, `
+ALTER TABLE "Channel" ADD COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE "Channel" ADD COLUMN refreshed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE "Channel" ADD COLUMN message_count INTEGER;
+`, `
+ALTER TABLE "Message" ADD COLUMN imported BOOLEAN NOT NULL DEFAULT FALSE;
+`, `
+CREATE INDEX ON "Message" (channel, id);
+`}
+
+// saveRevision copies a message into "MessageRevision" as the version of it
+// that was current until $2, before it is edited. Updates that don't change
+// edited_at, such as embeds being resolved, aren't revisions.
+const saveRevision = `INSERT INTO "MessageRevision" (message, channel, until, edited_at, content, json)
+	SELECT id, channel, $2, edited_at, content, json FROM "Message"
+	WHERE id = $1 AND edited_at IS DISTINCT FROM $3
+	ON CONFLICT DO NOTHING`
+
+// saveDeletion copies a message into "MessageRevision" as the version of
+// it that was current until it was deleted at $2, so that it is still shown
+// as of the times before. Unlike saveRevision, it doesn't compare edited_at,
+// which every message has a version of until it is deleted.
+const saveDeletion = `INSERT INTO "MessageRevision" (message, channel, until, edited_at, content, json)
+	SELECT id, channel, $2, edited_at, content, json FROM "Message"
+	WHERE id = $1
+	ON CONFLICT DO NOTHING`
+
+// pruneRevisions deletes all but the $2 latest revisions of a message.
+const pruneRevisions = `DELETE FROM "MessageRevision" WHERE message = $1 AND until NOT IN (
+	SELECT until FROM "MessageRevision" WHERE message = $1 ORDER BY until DESC LIMIT $2)`
 
 type Postgres struct {
 	db          *sql.DB
 	connectedAt time.Time
+	opts        Options
+}
+
+// saveEdit keeps the current version of a message that is being edited, if
+// edits are kept.
+func (db *Postgres) saveEdit(ctx context.Context, tx *sql.Tx, msg discord.Message) error {
+	if db.opts.MaxRevisions <= 0 {
+		return nil
+	}
+	edited := msg.EditedTimestamp.Time()
+	if _, err := tx.ExecContext(ctx, saveRevision, msg.ID, edited, edited); err != nil {
+		return fmt.Errorf("saving message revision: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, pruneRevisions, msg.ID, db.opts.MaxRevisions); err != nil {
+		return fmt.Errorf("pruning message revisions: %w", err)
+	}
+	return nil
 }
 
 func (db *Postgres) Close() error {
@@ -62,6 +209,17 @@ func (db *Postgres) UpdatedAt(ctx context.Context, post discord.ChannelID) (time
 	return t, nil
 }
 
+func (db *Postgres) Freshness(ctx context.Context, post discord.ChannelID) (Freshness, error) {
+	var archived, refreshed sql.NullTime
+	var count sql.NullInt64
+	err := db.db.QueryRowContext(ctx, `SELECT archived_at, refreshed_at, message_count FROM "Channel" WHERE id = $1`, post).
+		Scan(&archived, &refreshed, &count)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Freshness{}, err
+	}
+	return Freshness{FirstArchived: archived.Time, LastRefreshed: refreshed.Time, MessageCount: int(count.Int64)}, nil
+}
+
 func (db *Postgres) UpdateMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message) error {
 	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -73,15 +231,22 @@ func (db *Postgres) UpdateMessages(ctx context.Context, post discord.ChannelID,
 	if err != nil {
 		return fmt.Errorf("reading channel cache information: %w", err)
 	}
+	now := time.Now().UTC()
 	if exists {
-		_, err = tx.ExecContext(ctx, `UPDATE "Channel" SET updated_at = $1 WHERE id = $2`, time.Now().UTC(), post)
+		_, err = tx.ExecContext(ctx, `UPDATE "Channel" SET updated_at = $1, refreshed_at = $1, message_count = $3 WHERE id = $2`,
+			now, post, len(msgs))
 	} else {
-		_, err = tx.ExecContext(ctx, `INSERT INTO "Channel" (id, updated_at) VALUES ($1, $2)`, post, time.Now().UTC())
+		_, err = tx.ExecContext(ctx, `INSERT INTO "Channel" (id, updated_at, archived_at, refreshed_at, message_count) VALUES ($1, $2, $2, $2, $3)`,
+			post, now, len(msgs))
 	}
 	if err != nil {
 		return fmt.Errorf("writing channel cache information: %w", err)
 	}
-	insert, err := tx.PrepareContext(ctx, `INSERT INTO "Message" (id, author, channel, edited_at, content, json) VALUES($1, $2, $3, $4, $5, $6)`)
+	// Messages that were deleted and are back, as they are when a refetch
+	// is made after Discord left them out of one, replace their tombstones.
+	// Imported messages that Discord has are replaced by what it has.
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO "Message" (id, author, channel, edited_at, content, json) VALUES($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET author = $2, channel = $3, edited_at = $4, content = $5, json = $6, deleted_at = NULL, imported = FALSE`)
 	if err != nil {
 		return err
 	}
@@ -101,52 +266,36 @@ func (db *Postgres) UpdateMessages(ctx context.Context, post discord.ChannelID,
 		}
 		return tx.Commit()
 	}
-	rows, err := tx.QueryContext(ctx, `SELECT id, edited_at FROM "Message" WHERE channel = $1 ORDER BY id ASC`, post)
+	stored, err := storedMessages(ctx, tx, post)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	// Both the stored messages and the fetched ones are oldest first, so
+	// they are merged to find the ones that are new, edited or gone.
 	var toDelete []discord.MessageID
 	var toInsert []discord.Message
 	var toUpdate []discord.Message
+	i := 0
 	for _, msg := range msgs {
-		var id discord.MessageID
-		var updated time.Time
-		exists := false
-		for rows.Next() {
-			if err = rows.Scan(&id, &updated); err != nil {
-				return err
-			}
-			if id != msg.ID {
-				toDelete = append(toDelete, id)
-				continue
-			}
-			exists = true
+		for i < len(stored) && stored[i].id < msg.ID {
+			toDelete = append(toDelete, stored[i].id)
+			i++
 		}
-		if !exists {
+		if i == len(stored) || stored[i].id != msg.ID {
 			toInsert = append(toInsert, msg)
 			continue
 		}
-		if updated.Before(msg.EditedTimestamp.Time()) {
+		if stored[i].edited.Before(msg.EditedTimestamp.Time()) {
 			toUpdate = append(toUpdate, msg)
 		}
+		i++
 	}
-	for rows.Next() {
-		var id discord.MessageID
-		var updated time.Time
-		if err = rows.Scan(&id, &updated); err != nil {
-			return err
-		}
-		toDelete = append(toDelete, id)
+	for ; i < len(stored); i++ {
+		toDelete = append(toDelete, stored[i].id)
 	}
 	if len(toDelete) > 0 {
-		del, err := tx.PrepareContext(ctx, `DELETE FROM "Message" WHERE ID = $1`)
-		if err != nil {
-			return err
-		}
-		defer del.Close()
 		for _, id := range toDelete {
-			if _, err := del.ExecContext(ctx, id); err != nil {
+			if err := db.deleteMessage(ctx, tx, id, now); err != nil {
 				return err
 			}
 		}
@@ -158,6 +307,9 @@ func (db *Postgres) UpdateMessages(ctx context.Context, post discord.ChannelID,
 		}
 		defer update.Close()
 		for _, msg := range toUpdate {
+			if err := db.saveEdit(ctx, tx, msg); err != nil {
+				return err
+			}
 			content := msg.Content
 			msg.Content = ""
 			jsonb, err := json.Marshal(msg)
@@ -186,6 +338,34 @@ func (db *Postgres) UpdateMessages(ctx context.Context, post discord.ChannelID,
 	return tx.Commit()
 }
 
+// storedMessage is what UpdateMessages compares the messages fetched from
+// Discord with.
+type storedMessage struct {
+	id     discord.MessageID
+	edited time.Time
+}
+
+// storedMessages returns the messages of a post that are stored and not
+// deleted, oldest first. Imported messages are left out, since Discord not
+// having them doesn't mean they were deleted since they were imported. They are read whole before the post is updated,
+// since a transaction can't run statements while it reads rows.
+func storedMessages(ctx context.Context, tx *sql.Tx, post discord.ChannelID) ([]storedMessage, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, edited_at FROM "Message" WHERE channel = $1 AND deleted_at IS NULL AND NOT imported ORDER BY id ASC`, post)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stored []storedMessage
+	for rows.Next() {
+		var m storedMessage
+		if err := rows.Scan(&m.id, &m.edited); err != nil {
+			return nil, err
+		}
+		stored = append(stored, m)
+	}
+	return stored, rows.Err()
+}
+
 func (db *Postgres) InsertMessage(ctx context.Context, msg discord.Message) error {
 	content := msg.Content
 	msg.Content = ""
@@ -198,8 +378,109 @@ func (db *Postgres) InsertMessage(ctx context.Context, msg discord.Message) erro
 	return err
 }
 
+func (db *Postgres) ImportMessages(ctx context.Context, post discord.ChannelID, msgs []discord.Message, at time.Time) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO "Channel" (id, updated_at, archived_at, refreshed_at, message_count) VALUES ($1, $2, $2, $2, $3)
+		ON CONFLICT DO NOTHING`, post, at.UTC(), len(msgs))
+	if err != nil {
+		return fmt.Errorf("writing channel cache information: %w", err)
+	}
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO "Message" (id, author, channel, edited_at, content, json, imported) VALUES($1, $2, $3, $4, $5, $6, TRUE)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, msg := range msgs {
+		content := msg.Content
+		msg.Content = ""
+		jsonb, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = insert.ExecContext(ctx, msg.ID, msg.Author.ID, post, msg.EditedTimestamp.Time(), content, jsonb)
+		if err != nil {
+			return fmt.Errorf("inserting message: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 func (db *Postgres) DeleteMessage(ctx context.Context, msg discord.MessageID) error {
-	_, err := db.db.ExecContext(ctx, `DELETE FROM "Message" WHERE id = $1`, msg)
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := db.deleteMessage(ctx, tx, msg, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Postgres) DeleteMessages(ctx context.Context, msgs []discord.MessageID) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	at := time.Now().UTC()
+	for _, id := range msgs {
+		if err := db.deleteMessage(ctx, tx, id, at); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteMessage deletes a message, or turns it into a tombstone if those
+// are kept. A tombstone that is redacted only keeps who posted the message
+// and when, and none of its earlier versions. It must only be called for
+// messages that are really gone from Discord, since a message that is
+// deleted without tombstones is kept as a revision.
+func (db *Postgres) deleteMessage(ctx context.Context, tx *sql.Tx, id discord.MessageID, at time.Time) error {
+	if !db.opts.Tombstones {
+		if _, err := tx.ExecContext(ctx, saveDeletion, id, at); err != nil {
+			return fmt.Errorf("saving message revision: %w", err)
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM "Message" WHERE id = $1`, id)
+		return err
+	}
+	if !db.opts.RedactTombstones {
+		_, err := tx.ExecContext(ctx, `UPDATE "Message" SET deleted_at = COALESCE(deleted_at, $2) WHERE id = $1`, id, at)
+		return err
+	}
+	var jsonb []byte
+	err := tx.QueryRowContext(ctx, `SELECT json FROM "Message" WHERE id = $1`, id).Scan(&jsonb)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var msg discord.Message
+	if err := json.Unmarshal(jsonb, &msg); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+	jsonb, err = json.Marshal(discord.Message{
+		ID:        msg.ID,
+		ChannelID: msg.ChannelID,
+		GuildID:   msg.GuildID,
+		Type:      msg.Type,
+		Author:    msg.Author,
+		Timestamp: msg.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling message as JSON: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM "MessageRevision" WHERE message = $1`, id); err != nil {
+		return fmt.Errorf("deleting message revisions: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE "Message" SET deleted_at = COALESCE(deleted_at, $2), content = '', json = $3 WHERE id = $1`,
+		id, at, jsonb)
 	return err
 }
 
@@ -223,9 +504,15 @@ func (db *Postgres) UpdateMessage(ctx context.Context, msg discord.Message) erro
 	if err != nil {
 		return fmt.Errorf("marshaling message as JSON: %v", err)
 	}
-	_, err = db.db.ExecContext(ctx, `UPDATE "Message" SET content = $1, edited_at = $2, json = $3 WHERE id = $4`,
+	if err := db.saveEdit(ctx, tx, msg); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE "Message" SET content = $1, edited_at = $2, json = $3 WHERE id = $4`,
 		content, msg.EditedTimestamp.Time(), jsonb, msg.ID)
-	return err
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (db *Postgres) MessagesAfter(ctx context.Context, ch discord.ChannelID, msg discord.MessageID, limit uint) (msgs []discord.Message, hasbefore bool, err error) {
@@ -298,14 +585,410 @@ func (db *Postgres) MessagesBefore(ctx context.Context, ch discord.ChannelID, ms
 	return
 }
 
-func OpenPostgres(source string) (Database, error) {
+func (db *Postgres) MessagesAsOf(ctx context.Context, ch discord.ChannelID, at time.Time) (msgs []discord.Message, err error) {
+	// Each revision was current until its until column, and the row in
+	// "Message" is current from then on, so a message's version at a time
+	// is its first one that was current until after it.
+	rows, err := db.db.QueryContext(ctx, `SELECT DISTINCT ON (id) content, json FROM (
+		SELECT message AS id, until, content, json FROM "MessageRevision" WHERE channel = $1 AND until > $2
+		UNION ALL
+		SELECT id, 'infinity', content, json FROM "Message" WHERE channel = $1 AND (deleted_at IS NULL OR deleted_at > $2)
+	) AS v WHERE id < $3 ORDER BY id ASC, until ASC`,
+		ch, at, discord.NewSnowflake(at))
+	if err != nil {
+		err = fmt.Errorf("querying messages: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var content string
+		var jsonb []byte
+		if err = rows.Scan(&content, &jsonb); err != nil {
+			err = fmt.Errorf("error scanning message: %w", err)
+			return
+		}
+		var msg discord.Message
+		if err = json.Unmarshal(jsonb, &msg); err != nil {
+			err = fmt.Errorf("unmrshaling message content: %w", err)
+			return
+		}
+		msg.Content = content
+		msgs = append(msgs, msg)
+	}
+	err = rows.Err()
+	return
+}
+
+func (db *Postgres) Revisions(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT content, json FROM "MessageRevision"
+		WHERE channel = $1 AND message >= $2 AND message <= $3 ORDER BY message ASC, until ASC`,
+		ch, first, last)
+	if err != nil {
+		return nil, fmt.Errorf("querying revisions: %w", err)
+	}
+	defer rows.Close()
+	revs := make(map[discord.MessageID][]discord.Message)
+	for rows.Next() {
+		var content string
+		var jsonb []byte
+		if err := rows.Scan(&content, &jsonb); err != nil {
+			return nil, fmt.Errorf("scanning revision: %w", err)
+		}
+		var msg discord.Message
+		if err := json.Unmarshal(jsonb, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshaling revision: %w", err)
+		}
+		msg.Content = content
+		revs[msg.ID] = append(revs[msg.ID], msg)
+	}
+	return revs, rows.Err()
+}
+
+func (db *Postgres) Snapshots(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID][]discord.Message, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT message, json FROM "MessageSnapshot"
+		WHERE channel = $1 AND message >= $2 AND message <= $3`,
+		ch, first, last)
+	if err != nil {
+		return nil, fmt.Errorf("querying snapshots: %w", err)
+	}
+	defer rows.Close()
+	snapshots := make(map[discord.MessageID][]discord.Message)
+	for rows.Next() {
+		var id discord.MessageID
+		var jsonb []byte
+		if err := rows.Scan(&id, &jsonb); err != nil {
+			return nil, fmt.Errorf("scanning snapshot: %w", err)
+		}
+		var msgs []discord.Message
+		if err := json.Unmarshal(jsonb, &msgs); err != nil {
+			return nil, fmt.Errorf("unmarshaling snapshot: %w", err)
+		}
+		snapshots[id] = msgs
+	}
+	return snapshots, rows.Err()
+}
+
+func (db *Postgres) SaveSnapshots(ctx context.Context, ch discord.ChannelID, msg discord.MessageID, snapshots []discord.Message) error {
+	if snapshots == nil {
+		snapshots = []discord.Message{}
+	}
+	jsonb, err := json.Marshal(snapshots)
+	if err != nil {
+		return fmt.Errorf("marshaling snapshots as JSON: %v", err)
+	}
+	_, err = db.db.ExecContext(ctx, `INSERT INTO "MessageSnapshot" (message, channel, json) VALUES ($1, $2, $3)
+		ON CONFLICT (message) DO UPDATE SET json = excluded.json`, msg, ch, jsonb)
+	return err
+}
+
+func (db *Postgres) Tombstones(ctx context.Context, ch discord.ChannelID, first, last discord.MessageID) (map[discord.MessageID]time.Time, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT id, deleted_at FROM "Message"
+		WHERE channel = $1 AND id >= $2 AND id <= $3 AND deleted_at IS NOT NULL`,
+		ch, first, last)
+	if err != nil {
+		return nil, fmt.Errorf("querying tombstones: %w", err)
+	}
+	defer rows.Close()
+	deleted := make(map[discord.MessageID]time.Time)
+	for rows.Next() {
+		var id discord.MessageID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, fmt.Errorf("scanning tombstone: %w", err)
+		}
+		deleted[id] = at
+	}
+	return deleted, rows.Err()
+}
+
+func (db *Postgres) Headings(ctx context.Context, ch discord.ChannelID) ([]discord.Message, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT content, json FROM "Message"
+	WHERE channel = $1 AND deleted_at IS NULL AND content ~ '^#{1,3} ' ORDER BY id ASC`, ch)
+	if err != nil {
+		return nil, fmt.Errorf("querying headings: %w", err)
+	}
+	defer rows.Close()
+	var msgs []discord.Message
+	for rows.Next() {
+		var content string
+		var jsonb []byte
+		if err := rows.Scan(&content, &jsonb); err != nil {
+			return nil, fmt.Errorf("scanning heading: %w", err)
+		}
+		var msg discord.Message
+		if err := json.Unmarshal(jsonb, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshaling heading: %w", err)
+		}
+		msg.Content = content
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (db *Postgres) StarterMessages(ctx context.Context, posts []discord.ChannelID) (map[discord.ChannelID]discord.Message, error) {
+	ids := make([]int64, len(posts))
+	for i, id := range posts {
+		ids[i] = int64(id)
+	}
+	rows, err := db.db.QueryContext(ctx, `SELECT content, json FROM "Message"
+	WHERE id = ANY($1) AND id = channel AND deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("querying starter messages: %w", err)
+	}
+	defer rows.Close()
+	msgs := make(map[discord.ChannelID]discord.Message)
+	for rows.Next() {
+		var content string
+		var jsonb []byte
+		if err := rows.Scan(&content, &jsonb); err != nil {
+			return nil, fmt.Errorf("scanning starter message: %w", err)
+		}
+		var msg discord.Message
+		if err := json.Unmarshal(jsonb, &msg); err != nil {
+			return nil, fmt.Errorf("unmarshaling starter message: %w", err)
+		}
+		msg.Content = content
+		msgs[msg.ChannelID] = msg
+	}
+	return msgs, rows.Err()
+}
+
+func (db *Postgres) Participants(ctx context.Context, post discord.ChannelID, limit int) ([]discord.Message, int, error) {
+	var count int
+	err := db.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT author) FROM "Message"
+	WHERE channel = $1 AND deleted_at IS NULL`, post).Scan(&count)
+	if err != nil {
+		return nil, 0, fmt.Errorf("counting participants: %w", err)
+	}
+	rows, err := db.db.QueryContext(ctx, `SELECT content, json FROM (
+		SELECT DISTINCT ON (author) id, content, json FROM "Message"
+		WHERE channel = $1 AND deleted_at IS NULL ORDER BY author, id
+	) AS x ORDER BY id ASC LIMIT $2`, post, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying participants: %w", err)
+	}
+	defer rows.Close()
+	var msgs []discord.Message
+	for rows.Next() {
+		var content string
+		var jsonb []byte
+		if err := rows.Scan(&content, &jsonb); err != nil {
+			return nil, 0, fmt.Errorf("scanning participant's message: %w", err)
+		}
+		var msg discord.Message
+		if err := json.Unmarshal(jsonb, &msg); err != nil {
+			return nil, 0, fmt.Errorf("unmarshaling participant's message: %w", err)
+		}
+		msg.Content = content
+		msgs = append(msgs, msg)
+	}
+	return msgs, count, rows.Err()
+}
+
+func (db *Postgres) NearestMessage(ctx context.Context, ch discord.ChannelID, msg discord.MessageID) (discord.MessageID, error) {
+	// Only the messages right before and after msg are compared, so that
+	// both are found with the index instead of every message being sorted.
+	var id discord.MessageID
+	err := db.db.QueryRowContext(ctx, `SELECT id FROM (
+		(SELECT id FROM "Message" WHERE channel = $1 AND id <= $2 ORDER BY id DESC LIMIT 1)
+		UNION ALL
+		(SELECT id FROM "Message" WHERE channel = $1 AND id > $2 ORDER BY id ASC LIMIT 1)
+	) AS nearest ORDER BY ABS(id - $2) ASC, id ASC LIMIT 1`, ch, msg).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("querying nearest message: %w", err)
+	}
+	return id, nil
+}
+
+func (db *Postgres) FreezeGuild(ctx context.Context, guild discord.GuildID, at time.Time) error {
+	_, err := db.db.ExecContext(ctx, `INSERT INTO "FrozenGuild" (id, frozen_at) VALUES ($1, $2)
+	ON CONFLICT (id) DO UPDATE SET frozen_at = $2`, guild, at)
+	return err
+}
+
+func (db *Postgres) ThawGuild(ctx context.Context, guild discord.GuildID) error {
+	_, err := db.db.ExecContext(ctx, `DELETE FROM "FrozenGuild" WHERE id = $1`, guild)
+	return err
+}
+
+func (db *Postgres) FrozenGuilds(ctx context.Context) (map[discord.GuildID]time.Time, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT id, frozen_at FROM "FrozenGuild"`)
+	if err != nil {
+		return nil, fmt.Errorf("querying frozen guilds: %w", err)
+	}
+	defer rows.Close()
+	frozen := make(map[discord.GuildID]time.Time)
+	for rows.Next() {
+		var id discord.GuildID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, fmt.Errorf("scanning frozen guild: %w", err)
+		}
+		frozen[id] = at
+	}
+	return frozen, rows.Err()
+}
+
+func (db *Postgres) OptOut(ctx context.Context, user discord.UserID, at time.Time) error {
+	_, err := db.db.ExecContext(ctx, `INSERT INTO "OptOut" (id, opted_out_at) VALUES ($1, $2)
+	ON CONFLICT (id) DO NOTHING`, user, at)
+	return err
+}
+
+func (db *Postgres) OptIn(ctx context.Context, user discord.UserID) error {
+	_, err := db.db.ExecContext(ctx, `DELETE FROM "OptOut" WHERE id = $1`, user)
+	return err
+}
+
+func (db *Postgres) OptedOut(ctx context.Context) (map[discord.UserID]time.Time, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT id, opted_out_at FROM "OptOut"`)
+	if err != nil {
+		return nil, fmt.Errorf("querying opted out users: %w", err)
+	}
+	defer rows.Close()
+	users := make(map[discord.UserID]time.Time)
+	for rows.Next() {
+		var id discord.UserID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, fmt.Errorf("scanning opted out user: %w", err)
+		}
+		users[id] = at
+	}
+	return users, rows.Err()
+}
+
+func (db *Postgres) Members(ctx context.Context, guild discord.GuildID, users []discord.UserID) ([]discord.Member, error) {
+	ids := make([]int64, len(users))
+	for i, id := range users {
+		ids[i] = int64(id)
+	}
+	rows, err := db.db.QueryContext(ctx, `SELECT json FROM "Member" WHERE guild = $1 AND id = ANY($2)`,
+		guild, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("querying members: %w", err)
+	}
+	defer rows.Close()
+	var members []discord.Member
+	for rows.Next() {
+		var jsonb []byte
+		if err := rows.Scan(&jsonb); err != nil {
+			return nil, fmt.Errorf("scanning member: %w", err)
+		}
+		var m discord.Member
+		if err := json.Unmarshal(jsonb, &m); err != nil {
+			return nil, fmt.Errorf("unmarshaling member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (db *Postgres) SaveMembers(ctx context.Context, guild discord.GuildID, members []discord.Member) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range members {
+		jsonb, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("marshaling member as JSON: %v", err)
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO "Member" (guild, id, json) VALUES ($1, $2, $3)
+			ON CONFLICT (guild, id) DO UPDATE SET json = excluded.json`, guild, m.User.ID, jsonb)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Postgres) RemoveMember(ctx context.Context, guild discord.GuildID, user discord.UserID) error {
+	_, err := db.db.ExecContext(ctx, `DELETE FROM "Member" WHERE guild = $1 AND id = $2`, guild, user)
+	return err
+}
+
+func (db *Postgres) MonthlyMessages(ctx context.Context, posts []discord.ChannelID) ([]MonthCount, error) {
+	ids := make([]int64, len(posts))
+	for i, id := range posts {
+		ids[i] = int64(id)
+	}
+	// The time a message was sent is in the upper bits of its ID, in
+	// milliseconds since Discord's epoch.
+	rows, err := db.db.QueryContext(ctx, `SELECT date_trunc('month',
+		to_timestamp(((id >> 22) + 1420070400000) / 1000.0) AT TIME ZONE 'UTC') AS month, count(*)
+		FROM "Message" WHERE channel = ANY($1) AND deleted_at IS NULL
+		GROUP BY month ORDER BY month`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("counting messages: %w", err)
+	}
+	defer rows.Close()
+	var counts []MonthCount
+	for rows.Next() {
+		var c MonthCount
+		if err := rows.Scan(&c.Month, &c.Messages); err != nil {
+			return nil, fmt.Errorf("scanning message count: %w", err)
+		}
+		c.Month = c.Month.UTC()
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func (db *Postgres) AddPageViews(ctx context.Context, views []PageView) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range views {
+		_, err := tx.ExecContext(ctx, `INSERT INTO "PageView" (day, path, referrer, agent, views)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (day, path, referrer, agent) DO UPDATE SET views = "PageView".views + $5`,
+			v.Day, v.Path, v.Referrer, v.Agent, v.Views)
+		if err != nil {
+			return fmt.Errorf("adding page views: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Postgres) PageViews(ctx context.Context, since time.Time, by string, limit int) ([]ViewCount, error) {
+	var key, order string
+	switch by {
+	case "day":
+		key, order = "to_char(day, 'YYYY-MM-DD')", "1 ASC"
+	case "path", "referrer", "agent":
+		key, order = by, "2 DESC, 1 ASC"
+	default:
+		return nil, fmt.Errorf("can't group page views by %q", by)
+	}
+	rows, err := db.db.QueryContext(ctx, `SELECT `+key+`, sum(views) FROM "PageView"
+	WHERE day >= $1 GROUP BY 1 ORDER BY `+order+` LIMIT $2`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying page views: %w", err)
+	}
+	defer rows.Close()
+	var counts []ViewCount
+	for rows.Next() {
+		var c ViewCount
+		if err := rows.Scan(&c.Key, &c.Views); err != nil {
+			return nil, fmt.Errorf("scanning page views: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func OpenPostgres(source string, opts Options) (Database, error) {
 	sqldb, err := sql.Open("postgres", source)
 	if err != nil {
 		return nil, err
 	}
 	sqldb.SetMaxOpenConns(25)
 
-	db := &Postgres{db: sqldb, connectedAt: time.Now()}
+	db := &Postgres{db: sqldb, connectedAt: time.Now(), opts: opts}
 	if err := db.upgrade(); err != nil {
 		sqldb.Close()
 		return nil, err
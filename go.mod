@@ -5,6 +5,9 @@ go 1.19
 require (
 	github.com/diamondburned/ningen/v3 v3.0.0
 	github.com/naoina/toml v0.1.1
+	golang.org/x/crypto v0.12.0
+	golang.org/x/exp v0.0.0-20230905200255-921286631fa9
+	golang.org/x/time v0.3.0
 )
 
 require (
@@ -13,10 +16,9 @@ require (
 	github.com/naoina/go-stringutil v0.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
-	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
+	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
-	golang.org/x/time v0.3.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 )
 
 require (
@@ -25,4 +27,5 @@ require (
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/lib/pq v1.10.9
 	github.com/yuin/goldmark v1.5.5
+	golang.org/x/image v0.12.0
 )
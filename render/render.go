@@ -0,0 +1,130 @@
+// Package render turns the Markdown of Discord messages into HTML, the way
+// the pages of the forum show it.
+package render
+
+import (
+	"html"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state/store"
+	"github.com/diamondburned/ningen/v3/discordmd"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	mdhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// TimestampFunc renders a Discord timestamp in one of Discord's timestamp
+// styles, for a reader's locale and timezone.
+type TimestampFunc func(t time.Time, style string) template.HTML
+
+// Content renders the content of m. Mentions are looked up in cabinet, and
+// timestamps are rendered with timestamp. Links to messages still point to
+// Discord, for callers to point them elsewhere.
+func Content(m *discord.Message, cabinet store.Cabinet, timestamp TimestampFunc) template.HTML {
+	var sb strings.Builder
+	src := []byte(m.Content)
+	ast := discordmd.ParseWithMessage(src, cabinet, m, true)
+	parseTimestamps(ast, src)
+	renderer := renderer.NewRenderer(
+		renderer.WithNodeRenderers(
+			util.Prioritized(mdhtml.NewRenderer(), 0),
+			util.Prioritized(mentionRenderer{}, 0),
+			util.Prioritized(emoteRenderer{}, 0),
+			util.Prioritized(inlineRenderer{}, 0),
+			util.Prioritized(timestampRenderer{timestamp}, 0),
+		),
+	)
+	renderer.Render(&sb, src, ast)
+	return template.HTML(sb.String())
+}
+
+type mentionRenderer struct{}
+
+func (r mentionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(discordmd.KindMention, r.render)
+}
+func (r mentionRenderer) render(writer util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		m := n.(*discordmd.Mention)
+		switch {
+		case m.Channel != nil:
+			writer.WriteString("#")
+			writer.WriteString(html.EscapeString(m.Channel.Name))
+		case m.GuildUser != nil:
+			writer.WriteString("@")
+			writer.WriteString(html.EscapeString(m.GuildUser.Username))
+		case m.GuildRole != nil:
+			writer.WriteString("@")
+			writer.WriteString(html.EscapeString(m.GuildRole.Name))
+		default:
+			writer.WriteString(string(source))
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+type emoteRenderer struct{}
+
+func (r emoteRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(discordmd.KindEmoji, r.render)
+}
+func (r emoteRenderer) render(writer util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		e, ok := n.(*discordmd.Emoji)
+		if ok {
+			writer.WriteString(`<img src='https://cdn.discordapp.com/emojis/` + e.ID + `.webp?size=40'></img>`)
+		}
+	}
+	return ast.WalkContinue, nil
+}
+
+type inlineRenderer struct{}
+
+func (r inlineRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(discordmd.KindInline, r.render)
+}
+
+var attrElements = []struct {
+	Attr    discordmd.Attribute
+	Element string
+}{
+	{discordmd.AttrBold, "strong"},
+	{discordmd.AttrUnderline, "u"},
+	{discordmd.AttrItalics, "em"},
+	{discordmd.AttrStrikethrough, "del"},
+	{discordmd.AttrMonospace, "code"},
+}
+
+func (r inlineRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	i := n.(*discordmd.Inline)
+	// Spoilers are revealed by hovering over them or focusing them, which
+	// works without scripts, and tapping does on touch screens.
+	if entering {
+		if i.Attr.Has(discordmd.AttrSpoiler) {
+			w.WriteString(`<span class="spoiler" tabindex="0">`)
+		}
+		for _, at := range attrElements {
+			if i.Attr.Has(at.Attr) {
+				w.WriteString("<")
+				w.WriteString(at.Element)
+				w.WriteString(">")
+			}
+		}
+	} else {
+		for _, at := range attrElements {
+			if i.Attr.Has(at.Attr) {
+				w.WriteString("</")
+				w.WriteString(at.Element)
+				w.WriteString(">")
+			}
+		}
+		if i.Attr.Has(discordmd.AttrSpoiler) {
+			w.WriteString("</span>")
+		}
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,104 @@
+package render
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/diamondburned/ningen/v3/discordmd"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// timestampRegex matches Discord's timestamp markup, <t:unix> or
+// <t:unix:style>.
+var timestampRegex = regexp.MustCompile(`<t:(-?\d{1,13})(?::([tTdDfFR]))?>`)
+
+// Timestamp is a Discord timestamp in message content, shown to each reader
+// in their own locale and timezone.
+type Timestamp struct {
+	ast.BaseInline
+	Time  time.Time
+	Style string
+}
+
+var KindTimestamp = ast.NewNodeKind("Timestamp")
+
+// Kind implements Node.Kind.
+func (t *Timestamp) Kind() ast.NodeKind {
+	return KindTimestamp
+}
+
+// Dump implements Node.Dump.
+func (t *Timestamp) Dump(source []byte, level int) {
+	ast.DumpHelper(t, source, level, nil, nil)
+}
+
+// parseTimestamps replaces timestamp markup in the text of doc with
+// Timestamp nodes. discordmd doesn't know about timestamps and leaves them
+// as text, so this is done after parsing rather than with an inline parser.
+func parseTimestamps(doc ast.Node, source []byte) {
+	var texts []*ast.Text
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *discordmd.Inline:
+			if n.Attr.Has(discordmd.AttrMonospace) {
+				return ast.WalkSkipChildren, nil
+			}
+		case *ast.Text:
+			texts = append(texts, n)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, t := range texts {
+		seg := t.Segment
+		matches := timestampRegex.FindAllSubmatchIndex(seg.Value(source), -1)
+		if matches == nil {
+			continue
+		}
+		parent := t.Parent()
+		start := seg.Start
+		for _, m := range matches {
+			sec, err := strconv.ParseInt(string(source[seg.Start+m[2]:seg.Start+m[3]]), 10, 64)
+			if err != nil {
+				continue
+			}
+			if seg.Start+m[0] > start {
+				parent.InsertBefore(parent, t, ast.NewTextSegment(text.NewSegment(start, seg.Start+m[0])))
+			}
+			ts := &Timestamp{Time: time.Unix(sec, 0), Style: "f"}
+			if m[4] != -1 {
+				ts.Style = string(source[seg.Start+m[4] : seg.Start+m[5]])
+			}
+			parent.InsertBefore(parent, t, ts)
+			start = seg.Start + m[1]
+		}
+		// What's left keeps the original node, so its line break flags
+		// stay on the end of the text.
+		t.Segment = text.NewSegment(start, seg.Stop)
+		if start == seg.Stop && !t.SoftLineBreak() && !t.HardLineBreak() {
+			parent.RemoveChild(parent, t)
+		}
+	}
+}
+
+type timestampRenderer struct {
+	timestamp TimestampFunc
+}
+
+func (r timestampRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindTimestamp, r.render)
+}
+
+func (r timestampRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		t := n.(*Timestamp)
+		w.WriteString(string(r.timestamp(t.Time, t.Style)))
+	}
+	return ast.WalkContinue, nil
+}
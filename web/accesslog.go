@@ -0,0 +1,214 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// accessLogSaltLifetime is how long the key that IP addresses are hashed
+// with is kept, after which the same address hashes differently, so that
+// hashes can tell visitors apart within a day but not follow them.
+const accessLogSaltLifetime = 24 * time.Hour
+
+// accessLog writes a line for each request to a file, in the Common Log
+// Format or as JSON, with the IP addresses of clients truncated, hashed or
+// left out so that it can be kept without keeping who read what.
+type accessLog struct {
+	json bool
+	ips  string
+
+	mu   sync.Mutex
+	path string
+	out  io.Writer
+	// file is the file written to, and size how big it is, unless the log
+	// goes to the standard output.
+	file *os.File
+	size int64
+	// maxSize is the size past which the file is rotated, keeping backups
+	// of the earlier ones, or 0 if it is never rotated.
+	maxSize int64
+	backups int
+	salt    []byte
+	saltAt  time.Time
+}
+
+// validAccessLogIPs reports whether the AccessLogIPs option has one of the
+// values it can have.
+func validAccessLogIPs(ips string) bool {
+	return ips == "truncate" || ips == "hash" || ips == "omit"
+}
+
+// newAccessLog opens the access log at path, or the standard output if it
+// is "-".
+func newAccessLog(path, format, ips string, maxSizeMB, backups int) (*accessLog, error) {
+	if format != "clf" && format != "json" {
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	if !validAccessLogIPs(ips) {
+		return nil, fmt.Errorf("unknown way %q to log IP addresses", ips)
+	}
+	l := &accessLog{
+		json:    format == "json",
+		ips:     ips,
+		path:    path,
+		maxSize: int64(maxSizeMB) << 20,
+		backups: backups,
+	}
+	if path == "-" {
+		l.out = os.Stdout
+		return l, nil
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *accessLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening access log: %w", err)
+	}
+	l.file, l.out, l.size = f, f, stat.Size()
+	return nil
+}
+
+// rotate moves the log to its first backup, and each backup to the next,
+// dropping the oldest, and starts a new log.
+func (l *accessLog) rotate() error {
+	l.file.Close()
+	var err error
+	if l.backups == 0 {
+		err = os.Remove(l.path)
+	} else {
+		for i := l.backups; i > 1 && err == nil; i-- {
+			err = os.Rename(l.path+"."+strconv.Itoa(i-1), l.path+"."+strconv.Itoa(i))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err == nil {
+			err = os.Rename(l.path, l.path+".1")
+		}
+	}
+	// The log is opened again even if it couldn't be moved, so that lines
+	// keep being written to it.
+	if oerr := l.open(); oerr != nil {
+		l.file, l.out = nil, nil
+		return oerr
+	}
+	return err
+}
+
+// client returns how a client's IP address is written to the log.
+func (l *accessLog) client(ip net.IP, now time.Time) string {
+	if ip == nil || l.ips == "omit" {
+		return "-"
+	}
+	if l.ips == "hash" {
+		if l.salt == nil || now.Sub(l.saltAt) >= accessLogSaltLifetime {
+			l.salt = make([]byte, 32)
+			if _, err := rand.Read(l.salt); err != nil {
+				return "-"
+			}
+			l.saltAt = now
+		}
+		mac := hmac.New(sha256.New, l.salt)
+		mac.Write(ip)
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	// The addresses are truncated to the networks that they are in, which
+	// are /24 for IPv4 and /48 for IPv6.
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// accessLogEntry is a line of the log in JSON.
+type accessLogEntry struct {
+	Time     string  `json:"time"`
+	Client   string  `json:"client"`
+	Host     string  `json:"host"`
+	Method   string  `json:"method"`
+	URI      string  `json:"uri"`
+	Proto    string  `json:"proto"`
+	Status   int     `json:"status"`
+	Bytes    int     `json:"bytes"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// write writes the line of a request that was answered.
+func (l *accessLog) write(r *http.Request, status, size int, start time.Time, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	client := l.client(clientIP(r), start)
+	var line []byte
+	if l.json {
+		b, err := json.Marshal(accessLogEntry{
+			Time:     start.UTC().Format(time.RFC3339),
+			Client:   client,
+			Host:     r.Host,
+			Method:   r.Method,
+			URI:      r.RequestURI,
+			Proto:    r.Proto,
+			Status:   status,
+			Bytes:    size,
+			Duration: float64(d.Microseconds()) / 1000,
+		})
+		if err != nil {
+			return
+		}
+		line = append(b, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %d\n", client,
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, status, size))
+	}
+	if l.file != nil && l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Println("Error rotating access log:", err)
+		}
+	}
+	if l.out == nil && l.open() != nil {
+		return
+	}
+	n, _ := l.out.Write(line)
+	l.size += int64(n)
+}
+
+// middleware logs each request once it has been answered. It replaces chi's
+// Logger, which logs clients' addresses as they are.
+func (l *accessLog) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			l.write(r, status, ww.BytesWritten(), start, time.Since(start))
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns the manager of the certificates of the domains in
+// ACMEDomains, which it gets from Let's Encrypt, or the CA at
+// ACMEDirectory, when they are first needed and renews before they
+// expire. The account key and the certificates are kept in ACMEDir so
+// they survive restarts.
+func newACMEManager(c Config, client *http.Client) *autocert.Manager {
+	directory := c.ACMEDirectory
+	if directory == "" {
+		directory = acme.LetsEncryptURL
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.ACMEDir),
+		HostPolicy: autocert.HostWhitelist(c.ACMEDomains...),
+		Email:      c.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: directory, HTTPClient: client},
+	}
+}
+
+// redirectToHTTPS redirects plain HTTP requests to the same URL over HTTPS.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// PostLink links to another post from a post's page.
+type PostLink struct {
+	Name string
+	Path string
+}
+
+// adjacentPosts returns the posts in a forum that were made right before
+// and right after a post, for reading through a forum in order without
+// going back to its list. Either is nil if there is no such post.
+func (s *Server) adjacentPosts(guildPath string, forum, post *discord.Channel) (prev, next *PostLink, err error) {
+	channels, err := s.channels(forum.GuildID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Posts' IDs are made from when they were made, so the closest IDs on
+	// either side are the adjacent posts.
+	var before, after *discord.Channel
+	for i := range channels {
+		ch := &channels[i]
+		if ch.ParentID != forum.ID || ch.Type != discord.GuildPublicThread || ch.ID == post.ID {
+			continue
+		}
+		if ch.ID < post.ID && (before == nil || ch.ID > before.ID) {
+			before = ch
+		}
+		if ch.ID > post.ID && (after == nil || ch.ID < after.ID) {
+			after = ch
+		}
+	}
+	link := func(ch *discord.Channel) *PostLink {
+		if ch == nil {
+			return nil
+		}
+		return &PostLink{Name: ch.Name, Path: postPath(guildPath, forum.ID, ch.ID)}
+	}
+	return link(before), link(after), nil
+}
@@ -0,0 +1,165 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// adminAuth is a middleware that only lets through requests authenticated
+// with the AdminToken from the config, either as the password of basic
+// auth, which browsers prompt for, or as a bearer token. Browsers send
+// basic auth along with requests from other sites too, so those may only
+// read the dashboard and not change anything.
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, token, ok := r.BasicAuth()
+		if !ok {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dforum admin"`)
+			s.displayErr(w, r, http.StatusUnauthorized, nil)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" {
+				s.displayErr(w, r, http.StatusForbidden, errors.New("cross-site request"))
+				return
+			}
+		}
+		w.Header().Set("X-Robots-Tag", "noindex")
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminBot struct {
+	Name      string
+	LatencyMS int64
+	// RESTMembers is set for bots without the Server Members intent, which
+	// look members up over REST.
+	RESTMembers bool
+	// Shard is the number of the bot's shard counting from 1, out of
+	// Shards.
+	Shard, Shards int
+}
+
+type adminGuild struct {
+	discord.Guild
+	// Bot is the number of the bot serving the guild, counting from 1 in
+	// the order of the tokens in the config.
+	Bot      int
+	Channels int
+	Frozen   bool
+	// Export is the tarball of the guild made from the dashboard, if one
+	// was made.
+	Export *guildBundle
+}
+
+type adminChannel struct {
+	ID      discord.ChannelID
+	GuildID discord.GuildID
+	Name    string
+	State   cache.ChannelState
+}
+
+func (s *Server) getAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := struct {
+		Page
+		Status   Status
+		Bots     []adminBot
+		Guilds   []adminGuild
+		Channels []adminChannel
+		Errors   []loggedError
+		// Analytics is set if page views are counted.
+		Analytics bool
+	}{Page: s.page(w, r), Status: s.status(), Errors: s.stats.errors(), Analytics: s.pageViews != nil}
+	for _, st := range s.bots {
+		bot := adminBot{
+			LatencyMS:   st.Gateway().Latency().Milliseconds(),
+			RESTMembers: !st.HasIntents(gateway.IntentGuildMembers),
+		}
+		if shard := st.Ready().Shard; shard != nil {
+			bot.Shard, bot.Shards = shard.ShardID()+1, shard.NumShards()
+		}
+		if me, err := st.Cabinet.Me(); err == nil {
+			bot.Name = me.Tag()
+		}
+		ctx.Bots = append(ctx.Bots, bot)
+	}
+	guilds, err := s.bots.guilds()
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	for _, g := range guilds {
+		st := s.bots.forGuild(g.ID)
+		guild := adminGuild{Guild: g, Bot: s.bots.index(st) + 1}
+		chs, _ := st.Cabinet.Channels(g.ID)
+		guild.Channels = len(chs)
+		_, guild.Frozen = s.frozen.frozenAt(g.ID)
+		if b, ok := s.bundles.get(g.ID); ok {
+			guild.Export = &b
+		}
+		ctx.Guilds = append(ctx.Guilds, guild)
+	}
+	sort.Slice(ctx.Guilds, func(i, j int) bool {
+		return ctx.Guilds[i].ID < ctx.Guilds[j].ID
+	})
+	for id, state := range s.messageCache.States() {
+		ch := adminChannel{ID: id, State: state}
+		if c, err := s.bots.forChannel(id).Cabinet.Channel(id); err == nil {
+			ch.GuildID = c.GuildID
+			ch.Name = c.Name
+		}
+		ctx.Channels = append(ctx.Channels, ch)
+	}
+	sort.Slice(ctx.Channels, func(i, j int) bool {
+		return ctx.Channels[i].ID > ctx.Channels[j].ID
+	})
+	s.executeTemplate(w, r, "admin.gohtml", ctx)
+}
+
+// adminInvalidate drops what is cached about the guilds and channels with
+// the IDs given in the key form values, in the same way as purging them.
+func (s *Server) adminInvalidate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.invalidate(r.Form["key"]); err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Invalidated %s from the admin dashboard", strings.Join(r.Form["key"], " "))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// adminRefetch fetches the whole history of a post again from Discord.
+func (s *Server) adminRefetch(w http.ResponseWriter, r *http.Request) {
+	sf, err := discord.ParseSnowflake(r.PostFormValue("channel"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	id := discord.ChannelID(sf)
+	if err := s.messageCache.Refetch(id); err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Fetching %s again from the admin dashboard", id)
+	go func() {
+		if err := s.messageCache.Sync(context.Background(), id); err != nil {
+			log.Printf("Error fetching %s again: %v", id, err)
+		}
+	}()
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
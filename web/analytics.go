@@ -0,0 +1,199 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// analyticsInterval is how often the page views counted in memory are
+// added to the database.
+const analyticsInterval = time.Minute
+
+// analyticsDays is how many days the analytics page shows unless it is
+// asked for another number, and topViews how many paths, referrers and
+// kinds of clients it ranks.
+const (
+	analyticsDays = 30
+	topViews      = 25
+)
+
+// pageViewKey is what the views of a page are counted by. Nothing about
+// readers is kept besides the site they came from and the kind of client
+// they use, and no cookies are set, so views can't be tied to readers.
+type pageViewKey struct {
+	day      string
+	path     string
+	referrer string
+	agent    string
+}
+
+// pageViews counts the views of pages until they are added to the
+// database.
+type pageViews struct {
+	mu     sync.Mutex
+	counts map[pageViewKey]int
+}
+
+func newPageViews() *pageViews {
+	return &pageViews{counts: make(map[pageViewKey]int)}
+}
+
+// count is a middleware counting the views of pages that were served
+// whole, or found not to have changed.
+func (v *pageViews) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if r.Method != http.MethodGet || isRendering(r) {
+			return
+		}
+		switch ww.Status() {
+		case http.StatusOK:
+			if !strings.HasPrefix(ww.Header().Get("Content-Type"), "text/html") {
+				return
+			}
+		case http.StatusNotModified:
+		default:
+			return
+		}
+		key := pageViewKey{
+			day:      time.Now().UTC().Format("2006-01-02"),
+			path:     r.URL.Path,
+			referrer: referrerHost(r),
+			agent:    agentClass(r.UserAgent()),
+		}
+		v.mu.Lock()
+		v.counts[key]++
+		v.mu.Unlock()
+	})
+}
+
+// referrerHost returns the host of the site that a request came from, or
+// "" if it came from none or from this one.
+func referrerHost(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host == "" || strings.EqualFold(u.Host, r.Host) {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// agentClass returns the kind of client that a User-Agent is of, which is
+// all that is kept of it.
+func agentClass(ua string) string {
+	ua = strings.ToLower(ua)
+	switch {
+	case ua == "":
+		return "unknown"
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawl") ||
+		strings.Contains(ua, "spider") || strings.Contains(ua, "slurp") ||
+		strings.Contains(ua, "curl") || strings.Contains(ua, "wget") ||
+		strings.Contains(ua, "python") || strings.Contains(ua, "go-http-client"):
+		return "bot"
+	case strings.Contains(ua, "tablet") || strings.Contains(ua, "ipad"):
+		return "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "android"):
+		return "mobile"
+	case strings.Contains(ua, "mozilla"):
+		return "desktop"
+	default:
+		return "other"
+	}
+}
+
+// take returns the views counted since it was last called.
+func (v *pageViews) take() []database.PageView {
+	v.mu.Lock()
+	counts := v.counts
+	v.counts = make(map[pageViewKey]int)
+	v.mu.Unlock()
+	views := make([]database.PageView, 0, len(counts))
+	for key, n := range counts {
+		day, _ := time.Parse("2006-01-02", key.day)
+		views = append(views, database.PageView{
+			Day:      day,
+			Path:     key.path,
+			Referrer: key.referrer,
+			Agent:    key.agent,
+			Views:    n,
+		})
+	}
+	return views
+}
+
+// UpdatePageViews adds the page views counted to the database every
+// analyticsInterval.
+func (s *Server) UpdatePageViews() {
+	ticker := time.NewTicker(analyticsInterval)
+	for range ticker.C {
+		s.flushPageViews()
+	}
+}
+
+// flushPageViews adds the page views counted so far to the database.
+func (s *Server) flushPageViews() {
+	if s.pageViews == nil {
+		return
+	}
+	views := s.pageViews.take()
+	if len(views) == 0 {
+		return
+	}
+	if err := s.db.AddPageViews(context.Background(), views); err != nil {
+		log.Printf("Error saving %d page view counts: %v", len(views), err)
+	}
+}
+
+// getAdminAnalytics shows how many times pages were viewed in the last
+// days, which are analyticsDays unless the days query parameter says
+// otherwise.
+func (s *Server) getAdminAnalytics(w http.ResponseWriter, r *http.Request) {
+	days := analyticsDays
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	ctx := struct {
+		Page
+		Days      int
+		Total     int
+		MostViews int
+		ByDay     []database.ViewCount
+		Paths     []database.ViewCount
+		Referrers []database.ViewCount
+		Agents    []database.ViewCount
+	}{Page: s.page(w, r), Days: days}
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, time.UTC)
+	var err error
+	for _, list := range []struct {
+		by    string
+		limit int
+		to    *[]database.ViewCount
+	}{
+		{"day", days, &ctx.ByDay},
+		{"path", topViews, &ctx.Paths},
+		{"referrer", topViews, &ctx.Referrers},
+		{"agent", topViews, &ctx.Agents},
+	} {
+		if *list.to, err = s.db.PageViews(r.Context(), since, list.by, list.limit); err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	for _, c := range ctx.ByDay {
+		ctx.Total += c.Views
+		if c.Views > ctx.MostViews {
+			ctx.MostViews = c.Views
+		}
+	}
+	s.executeTemplate(w, r, "analytics.gohtml", ctx)
+}
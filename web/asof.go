@@ -0,0 +1,67 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// asOfLayouts are the layouts accepted by the asof parameter. Times without
+// a zone are in the reader's timezone, and a date on its own is the start
+// of that day.
+var asOfLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// asOfFromReq returns the time that a post is to be shown as of, given by
+// the asof parameter. It returns nil if the post is to be shown as it is
+// now.
+func (s *Server) asOfFromReq(w http.ResponseWriter, r *http.Request, loc *Locale) (*time.Time, bool) {
+	param := r.URL.Query().Get("asof")
+	if param == "" {
+		return nil, true
+	}
+	zone := time.UTC
+	if loc != nil && loc.Location != nil {
+		zone = loc.Location
+	}
+	for _, layout := range asOfLayouts {
+		if t, err := time.ParseInLocation(layout, param, zone); err == nil {
+			if t.After(time.Now()) {
+				return nil, true
+			}
+			return &t, true
+		}
+	}
+	s.displayErr(w, r, http.StatusBadRequest, errInvalidAsOf)
+	return nil, false
+}
+
+// messagesAsOf returns a page of a post's messages as they were at a time,
+// in the same way as messageCache.MessagesAfter and MessagesBefore.
+func (s *Server) messagesAsOf(ctx context.Context, post discord.ChannelID, at time.Time,
+	cur discord.MessageID, asc bool, limit int) (msgs []discord.Message, hasbefore, hasafter bool, err error) {
+	all, err := s.messageCache.MessagesAsOf(ctx, post, at)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if asc {
+		i := sort.Search(len(all), func(i int) bool {
+			return all[i].ID > cur
+		})
+		end := i + limit
+		if end > len(all) {
+			end = len(all)
+		}
+		return all[i:end], i > 0, end < len(all), nil
+	}
+	j := sort.Search(len(all), func(i int) bool {
+		return all[i].ID >= cur
+	})
+	start := j - limit
+	if start < 0 {
+		start = 0
+	}
+	return all[start:j], start > 0, j < len(all), nil
+}
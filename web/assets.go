@@ -0,0 +1,72 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// assets holds the fingerprinted paths of the static files, which have a
+// hash of the file's content in their name. They change whenever the file
+// does, so they can be cached forever without readers ever getting an old
+// stylesheet.
+type assets struct {
+	// paths maps the names of files under static to their fingerprinted
+	// paths.
+	paths map[string]string
+	// files maps fingerprinted paths back to the paths of the files.
+	files map[string]string
+}
+
+// loadAssets hashes the files under static in fsys.
+func loadAssets(fsys fs.FS) (*assets, error) {
+	a := &assets{paths: make(map[string]string), files: make(map[string]string)}
+	err := fs.WalkDir(fsys, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		ext := path.Ext(p)
+		fingerprinted := "/" + strings.TrimSuffix(p, ext) + "." + hex.EncodeToString(sum[:6]) + ext
+		a.paths[strings.TrimPrefix(p, "static/")] = fingerprinted
+		a.files[fingerprinted] = "/" + p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// noAssets is used instead of fingerprinting the static files when
+// templates are reloaded, since the files may change while the server is
+// running then.
+var noAssets = &assets{}
+
+// path returns the path that a file under static is served at, for the
+// asset template function.
+func (a *assets) path(name string) string {
+	if p, ok := a.paths[name]; ok {
+		return p
+	}
+	return "/static/" + name
+}
+
+// getStatic serves the static files, with the ones requested by their
+// fingerprinted paths marked as never changing.
+func (s *Server) getStatic(w http.ResponseWriter, r *http.Request) {
+	if p, ok := s.assets.files[r.URL.Path]; ok {
+		w.Header().Set("Cache-Control", cacheImmutable)
+		r.URL.Path = p
+	} else {
+		w.Header().Set("Cache-Control", cacheStatic)
+	}
+	s.static.ServeHTTP(w, r)
+}
@@ -0,0 +1,206 @@
+package web
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// maxZipSize is the most that the attachments of a post can add up to for
+// them to be downloaded as a ZIP file.
+const maxZipSize = 512 << 20
+
+// zipManifest describes the files in a ZIP file of a post's attachments,
+// and is put in it as manifest.json.
+type zipManifest struct {
+	Guild        string            `json:"guild"`
+	GuildID      discord.GuildID   `json:"guild_id"`
+	Forum        string            `json:"forum"`
+	ForumID      discord.ChannelID `json:"forum_id"`
+	Post         string            `json:"post"`
+	PostID       discord.ChannelID `json:"post_id"`
+	URL          string            `json:"url"`
+	DownloadedAt time.Time         `json:"downloaded_at"`
+	// License is the SPDX identifier of the license that the guild's
+	// content is published under, if it declared one.
+	License     string          `json:"license,omitempty"`
+	Attachments []zipAttachment `json:"attachments"`
+}
+
+type zipAttachment struct {
+	// File is the name of the attachment in the ZIP file, or empty if it
+	// couldn't be fetched, which Error says why.
+	File        string               `json:"file,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	ID          discord.AttachmentID `json:"id"`
+	Filename    string               `json:"filename"`
+	Description string               `json:"description,omitempty"`
+	ContentType string               `json:"content_type,omitempty"`
+	Size        uint64               `json:"size"`
+	MessageID   discord.MessageID    `json:"message_id"`
+	AuthorID    discord.UserID       `json:"author_id"`
+	Author      string               `json:"author"`
+	PostedAt    time.Time            `json:"posted_at"`
+	// URL is the message's link on the site.
+	URL string `json:"url"`
+}
+
+// getPostAttachments serves every attachment of a post in a ZIP file, with
+// a manifest.json saying who posted them and when, for keeping the files
+// of art and release posts. They are fetched through the media cache, and
+// those of authors who opted out are left out, as they are from pages.
+func (s *Server) getPostAttachments(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	restrictRole, err := s.consentRole(forum)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	var msgs []discord.Message
+	var after discord.MessageID
+	for {
+		page, _, hasafter, err := s.messageCache.MessagesAfter(r.Context(), post.ID, after, maxRangeMessages)
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching post's messages: %w", err))
+			return
+		}
+		msgs = append(msgs, page...)
+		if !hasafter || len(page) == 0 {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+	guildPath := s.guildPath(guild.ID)
+	var license string
+	if l := s.guildLicense(guild.ID); l != nil {
+		license = l.ID
+	}
+	manifest := zipManifest{
+		Guild:        guild.Name,
+		GuildID:      guild.ID,
+		Forum:        forum.Name,
+		ForumID:      forum.ID,
+		Post:         post.Name,
+		PostID:       post.ID,
+		URL:          s.site().URL + postPath(guildPath, forum.ID, post.ID),
+		DownloadedAt: time.Now().UTC(),
+		License:      license,
+		Attachments:  []zipAttachment{},
+	}
+	var size uint64
+	for _, m := range msgs {
+		if !consented(s.author(m), restrictRole) {
+			s.displayErr(w, r, http.StatusForbidden, errNoConsent)
+			return
+		}
+		if s.optOuts.has(m.Author.ID) {
+			continue
+		}
+		for _, at := range m.Attachments {
+			size += at.Size
+			manifest.Attachments = append(manifest.Attachments, zipAttachment{
+				ID:          at.ID,
+				Filename:    at.Filename,
+				Description: at.Description,
+				ContentType: at.ContentType,
+				Size:        at.Size,
+				MessageID:   m.ID,
+				AuthorID:    m.Author.ID,
+				Author:      m.Author.Username,
+				PostedAt:    m.ID.Time().UTC(),
+				URL:         s.site().URL + messagePath(guildPath, forum.ID, post.ID, m.ID),
+			})
+		}
+	}
+	if size > maxZipSize {
+		s.displayErr(w, r, http.StatusForbidden, errZipTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-attachments.zip"`, post.ID))
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if r.Method == http.MethodHead {
+		return
+	}
+	zw := zip.NewWriter(w)
+	for i := range manifest.Attachments {
+		at := &manifest.Attachments[i]
+		name := zipFilename(at.ID, at.Filename)
+		if err := s.zipAttachment(zw, post.ID, at, name); err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("Error adding attachment %s of %s to a ZIP file: %v", at.ID, post.ID, err)
+			at.Error = "couldn't be fetched"
+			if errors.Is(err, errMediaNotFound) {
+				at.Error = "deleted"
+			}
+			continue
+		}
+		at.File = name
+	}
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: manifest.DownloadedAt})
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(manifest); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing ZIP file of %s: %v", post.ID, err)
+	}
+}
+
+// zipAttachment adds an attachment to a ZIP file, out of the media cache.
+// Attachments are mostly compressed already, so they are stored as they
+// are.
+func (s *Server) zipAttachment(zw *zip.Writer, postID discord.ChannelID, at *zipAttachment, name string) error {
+	key := attachmentKey(postID, at.MessageID, at.ID, 0, 0)
+	if _, err := s.media.fetch(key, s.attachmentResolver(postID, at.MessageID, at.ID, 0, 0)); err != nil {
+		return err
+	}
+	datapath, _ := s.media.paths(key)
+	f, err := os.Open(datapath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zf, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: at.PostedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zf, f)
+	return err
+}
+
+// zipFilename returns the name of an attachment in a ZIP file. Attachments
+// of different messages can have the same name, so it starts with the
+// attachment's ID.
+func zipFilename(id discord.AttachmentID, filename string) string {
+	filename = strings.NewReplacer("/", "_", "\\", "_").Replace(filename)
+	return fmt.Sprintf("%s-%s", id, filename)
+}
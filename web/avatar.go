@@ -0,0 +1,107 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// AvatarSize is the size avatars are shown at, at twice the size of the
+// largest place they are shown in for high density screens.
+const AvatarSize = 128
+
+// avatarHashRegex matches the hashes of avatars, which animated ones prefix
+// with a_, so the hash can be used in cache keys as it is.
+var avatarHashRegex = regexp.MustCompile(`^(a_)?[0-9a-f]{32}$`)
+
+// defaultAvatar is the hash used in avatar URLs for users without an
+// avatar, who are shown one of Discord's default avatars.
+const defaultAvatar = "default"
+
+// validAvatarSize reports whether Discord's CDN can scale avatars to size,
+// which it only does for powers of two.
+func validAvatarSize(size uint64) bool {
+	return size >= 16 && size <= 4096 && size&(size-1) == 0
+}
+
+// avatarURL returns the URL that pages should use for a user's avatar,
+// which goes through the media proxy if it is enabled.
+func (s *Server) avatarURL(u discord.User) string {
+	if s.media == nil {
+		return u.AvatarURL() + "?size=" + strconv.Itoa(AvatarSize)
+	}
+	hash := string(u.Avatar)
+	if hash == "" {
+		hash = defaultAvatar
+	}
+	return fmt.Sprintf("/avatars/%s/%s.png?size=%d", u.ID, hash, AvatarSize)
+}
+
+// defaultAvatarIndex returns which of Discord's default avatars a user
+// has. It depends on the discriminator for users that haven't picked a
+// username yet, but only the ID is in avatar URLs, so they are given the
+// avatar they will have once they pick one.
+func defaultAvatarIndex(id discord.UserID) uint64 {
+	return uint64(id>>22) % 6
+}
+
+// getAvatar serves an avatar out of the media cache. Avatars whose hash is
+// gone from the CDN, because the user has changed theirs since the page
+// linking it was rendered, are answered with the user's default avatar.
+func (s *Server) getAvatar(w http.ResponseWriter, r *http.Request) {
+	sf, err := discord.ParseSnowflake(chi.URLParam(r, "userID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	userID := discord.UserID(sf)
+	addSurrogateKey(w, userID.String())
+	hash := chi.URLParam(r, "hash")
+	if hash != defaultAvatar && (!avatarHashRegex.MatchString(hash) || s.optOuts.has(userID)) {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	size := uint64(AvatarSize)
+	if q := r.URL.Query().Get("size"); q != "" {
+		size, err = strconv.ParseUint(q, 10, 16)
+		if err != nil || !validAvatarSize(size) {
+			s.displayErr(w, r, http.StatusBadRequest, errors.New("invalid avatar size"))
+			return
+		}
+	}
+	if hash != defaultAvatar {
+		resolve := func(refresh bool) (string, error) {
+			if refresh {
+				return "", errMediaNotFound
+			}
+			return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png?size=%d", userID, hash, size), nil
+		}
+		key := fmt.Sprintf("avatar-%s-%s-%d", userID, hash, size)
+		err = s.media.serve(w, r, key, resolve)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, errMediaNotFound) {
+			s.displayErr(w, r, http.StatusBadGateway,
+				fmt.Errorf("fetching avatar: %w", err))
+			return
+		}
+		// The fallback isn't what the URL names, so it is only cached
+		// like other static files.
+		w.Header().Set("Cache-Control", cacheStatic)
+	}
+	// Default avatars are small enough that they aren't scaled.
+	n := defaultAvatarIndex(userID)
+	resolve := func(bool) (string, error) {
+		return fmt.Sprintf("https://cdn.discordapp.com/embed/avatars/%d.png", n), nil
+	}
+	if err := s.media.serve(w, r, fmt.Sprintf("avatar-default-%d", n), resolve); err != nil {
+		s.displayErr(w, r, http.StatusBadGateway,
+			fmt.Errorf("fetching default avatar: %w", err))
+	}
+}
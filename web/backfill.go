@@ -0,0 +1,113 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// backfill stores the whole history of every post in the given guilds, or
+// in all guilds if none are given, so that a new instance doesn't have to
+// fetch it while its first readers wait. jobs posts are fetched at once;
+// the REST client waits out Discord's rate limits by itself, so more jobs
+// only help until the limits are hit. Posts that were stored since their
+// last message was posted are skipped, so an interrupted backfill picks up
+// where it stopped when it is run again.
+func (s *Server) backfill(ctx context.Context, guildIDs []discord.GuildID, jobs int) error {
+	if len(guildIDs) == 0 {
+		guilds, err := s.bots.guilds()
+		if err != nil {
+			return fmt.Errorf("listing guilds: %w", err)
+		}
+		for _, g := range guilds {
+			guildIDs = append(guildIDs, g.ID)
+		}
+	}
+	var posts []discord.Channel
+	for _, id := range guildIDs {
+		if _, ok := s.frozen.frozenAt(id); ok {
+			log.Printf("Skipping %s, it is frozen", id)
+			continue
+		}
+		channels, err := s.channels(id)
+		if err != nil {
+			return fmt.Errorf("fetching channels of %s: %w", id, err)
+		}
+		forums := make(map[discord.ChannelID]bool)
+		for _, ch := range channels {
+			if ch.Type == discord.GuildForum && s.canServe(&ch) {
+				forums[ch.ID] = true
+			}
+		}
+		for _, ch := range channels {
+			if forums[ch.ParentID] && ch.Type == discord.GuildPublicThread {
+				posts = append(posts, ch)
+			}
+		}
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	log.Printf("Backfilling %d posts in %d guilds, %d at a time", len(posts), len(guildIDs), jobs)
+	var done, skipped, failed atomic.Int64
+	queue := make(chan discord.Channel)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for post := range queue {
+				err := s.backfillPost(ctx, &post)
+				n := done.Add(1)
+				switch {
+				case err == errAlreadyStored:
+					skipped.Add(1)
+				case err != nil:
+					failed.Add(1)
+					log.Printf("[%d/%d] Error fetching %s (%s): %v", n, len(posts), post.Name, post.ID, err)
+				default:
+					log.Printf("[%d/%d] Stored %s (%s)", n, len(posts), post.Name, post.ID)
+				}
+			}
+		}()
+	}
+Posts:
+	for _, post := range posts {
+		select {
+		case queue <- post:
+		case <-ctx.Done():
+			break Posts
+		}
+	}
+	close(queue)
+	wg.Wait()
+	log.Printf("Backfilled %d posts, %d were already stored and %d failed",
+		done.Load()-skipped.Load()-failed.Load(), skipped.Load(), failed.Load())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed.Load() > 0 {
+		return fmt.Errorf("%d posts couldn't be fetched", failed.Load())
+	}
+	return nil
+}
+
+var errAlreadyStored = errors.New("post is already stored")
+
+// backfillPost stores the whole history of a post, unless it was already
+// stored since its last message was posted.
+func (s *Server) backfillPost(ctx context.Context, post *discord.Channel) error {
+	upd, err := s.db.UpdatedAt(ctx, post.ID)
+	if err != nil {
+		return err
+	}
+	if !upd.IsZero() && upd.After(postModTime(post)) {
+		return errAlreadyStored
+	}
+	return s.messageCache.Sync(ctx, post.ID)
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"fmt"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// bots are the instance's connections to Discord, one for each configured
+// token, or for each shard of it that the instance runs. Each has its own
+// gateway connection and cache, so an instance can serve more guilds than a
+// single bot is allowed to join, and a shard only has the guilds Discord
+// sends it. A guild that several bots are in is served by the first of
+// them.
+type bots []*state.State
+
+// forGuild returns the bot that is in a guild, or the first bot if none
+// are.
+func (b bots) forGuild(id discord.GuildID) *state.State {
+	for _, st := range b {
+		if _, err := st.Cabinet.Guild(id); err == nil {
+			return st
+		}
+	}
+	return b[0]
+}
+
+// forChannel returns the bot that has a channel cached, or the first bot if
+// none do.
+func (b bots) forChannel(id discord.ChannelID) *state.State {
+	for _, st := range b {
+		if _, err := st.Cabinet.Channel(id); err == nil {
+			return st
+		}
+	}
+	return b[0]
+}
+
+// cacheDiscord returns the bot that has a channel cached as the message
+// cache sees it.
+func (b bots) cacheDiscord(id discord.ChannelID) cache.Discord {
+	return cache.State{State: b.forChannel(id)}
+}
+
+// index returns the number of a bot in the order of the tokens in the
+// config, counting from 0.
+func (b bots) index(st *state.State) int {
+	for i := range b {
+		if b[i] == st {
+			return i
+		}
+	}
+	return 0
+}
+
+// guilds returns the guilds of all the bots.
+func (b bots) guilds() ([]discord.Guild, error) {
+	var guilds []discord.Guild
+	seen := make(map[discord.GuildID]bool)
+	for _, st := range b {
+		gs, err := st.Cabinet.Guilds()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range gs {
+			if !seen[g.ID] {
+				seen[g.ID] = true
+				guilds = append(guilds, g)
+			}
+		}
+	}
+	return guilds, nil
+}
+
+// selfMember returns the member of the bot serving a guild in it.
+func (s *Server) selfMember(id discord.GuildID) (*discord.Member, error) {
+	st := s.bots.forGuild(id)
+	me, err := st.Cabinet.Me()
+	if err != nil {
+		return nil, err
+	}
+	return st.Member(id, me.ID)
+}
+
+// membersIntentGranted reports whether a bot may use the privileged Server
+// Members intent. Unverified bots are granted it by turning it on in the
+// developer portal, and verified ones by Discord.
+func membersIntentGranted(st *state.State) (bool, error) {
+	app, err := st.CurrentApplication()
+	if err != nil {
+		return false, fmt.Errorf("checking the application's intents: %w", err)
+	}
+	return app.Flags&(discord.AppFlagGatewayGuildMembers|discord.AppFlagGatewayGuildMembersLimited) != 0, nil
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// Branding is how a guild looks on Discord, which its pages take on so
+// that they look like the community's rather than like every other
+// guild's.
+type Branding struct {
+	// Icon, Banner and Splash are the URLs of the guild's images, or empty
+	// if it has none. They are scaled with a size parameter, as Discord's
+	// CDN scales them.
+	Icon   string
+	Banner string
+	Splash string
+	// Accent is the color, as #rrggbb, that the guild's pages are trimmed
+	// with, or empty for the site's own.
+	Accent template.CSS
+}
+
+// accentColorRegex matches the colors that can be configured as a guild's
+// accent color.
+var accentColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// guildImages are the kinds of guild images that are served out of the
+// media cache, keyed by the name used for them in URLs, with the path
+// that Discord's CDN serves them under.
+var guildImages = map[string]string{
+	"icon":   "icons",
+	"banner": "banners",
+	"splash": "splashes",
+}
+
+// guildBranding returns the branding of a guild. Discord has no accent
+// colors for guilds, so it is only set if the operator configured one.
+func (s *Server) guildBranding(guild *discord.Guild) *Branding {
+	b := &Branding{
+		Icon:   s.guildImageURL(guild.ID, "icon", guild.Icon),
+		Banner: s.guildImageURL(guild.ID, "banner", guild.Banner),
+		Splash: s.guildImageURL(guild.ID, "splash", guild.Splash),
+	}
+	if c := s.guildConfig(guild.ID).AccentColor; c != "" {
+		// It was checked to be a color when the config was read.
+		b.Accent = template.CSS(c)
+	}
+	return b
+}
+
+// guildImageURL returns the URL that pages should use for one of a guild's
+// images, which goes through the media proxy if it is enabled, or "" if
+// the guild doesn't have the image.
+func (s *Server) guildImageURL(guildID discord.GuildID, kind, hash string) string {
+	if hash == "" {
+		return ""
+	}
+	if s.media == nil {
+		return fmt.Sprintf("https://cdn.discordapp.com/%s/%s/%s.png", guildImages[kind], guildID, hash)
+	}
+	return fmt.Sprintf("/branding/%s/%s/%s.png", guildID, kind, hash)
+}
+
+// getGuildImage serves a guild's icon, banner or splash out of the media
+// cache. Images whose hash is gone from the CDN, because the guild has
+// changed it since the page linking it was rendered, aren't found.
+func (s *Server) getGuildImage(w http.ResponseWriter, r *http.Request) {
+	sf, err := discord.ParseSnowflake(chi.URLParam(r, "guildID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	guildID := discord.GuildID(sf)
+	kind := chi.URLParam(r, "kind")
+	path, ok := guildImages[kind]
+	hash := chi.URLParam(r, "hash")
+	if !ok || !avatarHashRegex.MatchString(hash) {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	var size uint64
+	if q := r.URL.Query().Get("size"); q != "" {
+		size, err = strconv.ParseUint(q, 10, 16)
+		if err != nil || !validAvatarSize(size) {
+			s.displayErr(w, r, http.StatusBadRequest, errors.New("invalid image size"))
+			return
+		}
+	}
+	resolve := func(refresh bool) (string, error) {
+		if refresh {
+			return "", errMediaNotFound
+		}
+		u := fmt.Sprintf("https://cdn.discordapp.com/%s/%s/%s.png", path, guildID, hash)
+		if size != 0 {
+			u += "?size=" + strconv.FormatUint(size, 10)
+		}
+		return u, nil
+	}
+	key := fmt.Sprintf("guild-%s-%s-%s-%d", kind, guildID, hash, size)
+	err = s.media.serve(w, r, key, resolve)
+	if errors.Is(err, errMediaNotFound) {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+	} else if err != nil {
+		s.displayErr(w, r, http.StatusBadGateway,
+			fmt.Errorf("fetching guild %s: %w", kind, err))
+	}
+}
@@ -0,0 +1,311 @@
+package web
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// tarExport returns an exportWriter that writes files to a tarball, in a
+// directory named after the guild so that unpacking it doesn't spill
+// files all over.
+func tarExport(tw *tar.Writer, dir string, modified time.Time) exportWriter {
+	return func(name string, b []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     dir + "/" + strings.TrimPrefix(name, "/"),
+			Mode:     0644,
+			Size:     int64(len(b)),
+			ModTime:  modified,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	}
+}
+
+// bundleGuild writes a gzipped tarball of a guild's whole archive to w:
+// the pages that exportGuild exports, the static files, and a page that
+// searches the posts in the browser, for a community that is shutting
+// down to hand its members an archive that works offline. Unlike
+// freezing, it leaves the guild as it is.
+func (s *Server) bundleGuild(ctx context.Context, id discord.GuildID, w io.Writer) error {
+	guild, err := s.bots.forGuild(id).Cabinet.Guild(id)
+	if err != nil {
+		return fmt.Errorf("fetching guild: %w", err)
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := tarExport(tw, "dforum-"+guild.ID.String(), time.Now())
+	posts, err := s.exportGuild(ctx, guild.ID, write)
+	if err != nil {
+		return err
+	}
+	if err := s.exportSearch(ctx, guild, posts, write); err != nil {
+		return fmt.Errorf("exporting search: %w", err)
+	}
+	// Opening the tarball should show the guild's page rather than a list
+	// of directories.
+	index := fmt.Sprintf(`<!DOCTYPE html><meta http-equiv="refresh" content="0; url=%[1]s"><a href="%[1]s">%[2]s</a>`,
+		strings.TrimPrefix(s.guildPath(guild.ID), "/")+"/index.html", template.HTMLEscapeString(guild.Name))
+	if err := write("/index.html", []byte(index)); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportSearchIndex is what the search page of an export searches, loaded
+// from a script since browsers don't let pages opened from disk fetch
+// files.
+type exportSearchIndex struct {
+	Posts []exportSearchPost `json:"posts"`
+}
+
+type exportSearchPost struct {
+	Title string `json:"title"`
+	Forum string `json:"forum"`
+	// Path is the post's page, relative to the search page.
+	Path string `json:"path"`
+	// Text is the content of the post's messages, without those of
+	// authors who opted out.
+	Text string `json:"text"`
+}
+
+// exportSearch writes the search page of a guild's export, and the index
+// of the posts that it searches.
+func (s *Server) exportSearch(ctx context.Context, guild *discord.Guild, posts map[discord.ChannelID]string, write exportWriter) error {
+	prefix := s.guildPath(guild.ID)
+	var index exportSearchIndex
+	for id, file := range posts {
+		post, err := s.channel(id)
+		if err != nil {
+			return fmt.Errorf("fetching post %s: %w", id, err)
+		}
+		forum, err := s.channel(post.ParentID)
+		if err != nil {
+			return fmt.Errorf("fetching forum of %s: %w", id, err)
+		}
+		var text strings.Builder
+		var after discord.MessageID
+		for {
+			msgs, _, hasafter, err := s.messageCache.MessagesAfter(ctx, id, after, maxRangeMessages)
+			if err != nil {
+				return fmt.Errorf("fetching messages of %s: %w", id, err)
+			}
+			for _, m := range msgs {
+				if m.Content != "" && !s.optOuts.has(m.Author.ID) {
+					text.WriteString(m.Content)
+					text.WriteByte('\n')
+				}
+			}
+			if !hasafter || len(msgs) == 0 {
+				break
+			}
+			after = msgs[len(msgs)-1].ID
+		}
+		index.Posts = append(index.Posts, exportSearchPost{
+			Title: post.Name,
+			Forum: forum.Name,
+			Path:  exportRelative(prefix+searchPath, file),
+			Text:  text.String(),
+		})
+	}
+	sort.Slice(index.Posts, func(i, j int) bool {
+		return index.Posts[i].Title < index.Posts[j].Title
+	})
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	script := append([]byte("var searchIndex = "), b...)
+	script = append(script, ";\n"...)
+	dir := strings.TrimSuffix(prefix+searchPath, "index.html")
+	if err := write(dir+"search-index.js", script); err != nil {
+		return err
+	}
+
+	r := httptest.NewRequest(http.MethodGet, prefix+"/search", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	page := s.guildPage(rec, r, guild.ID)
+	page.Meta.Breadcrumbs = append(s.breadcrumbs(r, guild, nil, nil),
+		Breadcrumb{Name: page.Locale.T("Search")})
+	s.executeTemplate(rec, r, "exportsearch.gohtml", struct {
+		Page
+		Guild *discord.Guild
+	}{page, guild})
+	base, _ := url.Parse(prefix + "/search")
+	body := exportLinks(rec.Body.String(), prefix, base, prefix+searchPath, func(*url.URL, string) {})
+	return write(prefix+searchPath, []byte(body))
+}
+
+// guildBundles are the tarballs of guilds that were made from the admin
+// dashboard, which are kept in temporary files until the next one of the
+// same guild is made.
+type guildBundles struct {
+	mu      sync.Mutex
+	bundles map[discord.GuildID]*guildBundle
+}
+
+type guildBundle struct {
+	// Running is set while a tarball is being made, since Started. The
+	// one made before, if any, can be downloaded meanwhile.
+	Running bool
+	Started time.Time
+	// Finished is when the tarball that can be downloaded was made.
+	Finished time.Time
+	Size     int64
+	// Err is why making the last tarball failed, if it did.
+	Err  string
+	path string
+}
+
+func newGuildBundles() *guildBundles {
+	return &guildBundles{bundles: make(map[discord.GuildID]*guildBundle)}
+}
+
+// get returns the tarball of a guild, if one was made or is being made.
+func (b *guildBundles) get(id discord.GuildID) (guildBundle, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bundle, ok := b.bundles[id]
+	if !ok {
+		return guildBundle{}, false
+	}
+	return *bundle, true
+}
+
+// start marks a tarball of a guild as being made, reporting false if one
+// already is.
+func (b *guildBundles) start(id discord.GuildID) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bundle, ok := b.bundles[id]
+	if !ok {
+		bundle = &guildBundle{}
+		b.bundles[id] = bundle
+	}
+	if bundle.Running {
+		return false
+	}
+	bundle.Running, bundle.Started, bundle.Err = true, time.Now(), ""
+	return true
+}
+
+// finish records the tarball of a guild that was made, in place of the
+// one before it.
+func (b *guildBundles) finish(id discord.GuildID, path string, size int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bundle := b.bundles[id]
+	bundle.Running = false
+	if err != nil {
+		bundle.Err = err.Error()
+		return
+	}
+	if bundle.path != "" {
+		os.Remove(bundle.path)
+	}
+	bundle.path, bundle.Size, bundle.Finished = path, size, time.Now()
+}
+
+// adminBundle starts making a tarball of the guild in the guild form
+// value, which can be downloaded from the dashboard once it is made.
+// Making it takes longer than requests may, so it is made in the
+// background.
+func (s *Server) adminBundle(w http.ResponseWriter, r *http.Request) {
+	sf, err := discord.ParseSnowflake(r.PostFormValue("guild"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	id := discord.GuildID(sf)
+	if _, err := s.bots.forGuild(id).Cabinet.Guild(id); err != nil {
+		s.displayErr(w, r, http.StatusNotFound, errGuildNotServed)
+		return
+	}
+	if s.bundles.start(id) {
+		log.Printf("Exporting %s from the admin dashboard", id)
+		go s.makeBundle(id)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// makeBundle makes a tarball of a guild for the admin dashboard.
+func (s *Server) makeBundle(id discord.GuildID) {
+	f, err := os.CreateTemp("", "dforum-export-*.tar.gz")
+	if err != nil {
+		s.bundles.finish(id, "", 0, err)
+		return
+	}
+	err = s.bundleGuild(context.Background(), id, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Printf("Error exporting %s: %v", id, err)
+		os.Remove(f.Name())
+		s.bundles.finish(id, "", 0, err)
+		return
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		s.bundles.finish(id, "", 0, err)
+		return
+	}
+	s.bundles.finish(id, f.Name(), fi.Size(), nil)
+	log.Printf("Exported %s, %d bytes", id, fi.Size())
+}
+
+// getAdminBundle serves the tarball of a guild that was made from the
+// admin dashboard.
+func (s *Server) getAdminBundle(w http.ResponseWriter, r *http.Request) {
+	sf, err := discord.ParseSnowflake(chi.URLParam(r, "guildID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	id := discord.GuildID(sf)
+	bundle, ok := s.bundles.get(id)
+	if !ok || bundle.path == "" {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	f, err := os.Open(bundle.path)
+	if err != nil {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dforum-%s.tar.gz"`, id))
+	http.ServeContent(w, r, "", bundle.Finished, f)
+}
+
+// writeBundle writes a gzipped tarball of a guild's archive to a file, for
+// the export command.
+func (s *Server) writeBundle(ctx context.Context, id discord.GuildID, path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return s.bundleGuild(ctx, id, w)
+	})
+}
@@ -0,0 +1,141 @@
+package web
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// Cache-Control policies of the different kinds of responses.
+const (
+	// cacheStatic is for the stylesheets and other static files, which
+	// only change when the instance is upgraded.
+	cacheStatic = "public, max-age=86400"
+	// cachePage is for pages, which change whenever something is posted.
+	cachePage = "public, max-age=60, must-revalidate"
+	// cacheImmutable is for images named by their hash, which never
+	// change.
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// cacheMedia is for attachments and avatars, which never change but
+	// stop being served when their users opt out of being shown.
+	cacheMedia = "public, max-age=86400"
+	// cacheNone is for responses that must not be reused, like errors
+	// that are likely to go away.
+	cacheNone = "no-store"
+	// cachePrivate is for media from NSFW forums, which depends on the
+	// reader having confirmed their age, so shared caches must not keep it
+	// for readers who haven't.
+	cachePrivate = "private, no-store"
+)
+
+// cacheControl is a middleware that sets the Cache-Control header of
+// responses to policy. Pages depend on the reader's theme, timezone and
+// NSFW cookies, so caches are told to keep a copy for each set of cookies.
+func cacheControl(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			if policy == cachePage {
+				w.Header().Add("Vary", "Cookie")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// addSurrogateKey tags a response with the ID of a guild or channel that is
+// shown in it. CDNs that support the Surrogate-Key header can then purge
+// every page showing something at once.
+func addSurrogateKey(w http.ResponseWriter, key string) {
+	if keys := w.Header().Get("Surrogate-Key"); keys != "" {
+		key = keys + " " + key
+	}
+	w.Header().Set("Surrogate-Key", key)
+}
+
+// postModTime returns when a post last changed as far as what is cached
+// about it tells, which is when its last message was posted.
+func postModTime(post *discord.Channel) time.Time {
+	if post.LastMessageID.IsValid() {
+		return post.LastMessageID.Time()
+	}
+	return post.ID.Time()
+}
+
+// answerFromMetadata answers HEAD requests, and GET requests whose
+// If-Modified-Since is no earlier than modified, from when a page last
+// changed, so that crawlers checking pages don't make the server fetch
+// what is on them. It reports whether the request was answered.
+func answerFromMetadata(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	modified = modified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+	if r.Header.Get("If-None-Match") != "" {
+		// ETags are checksums of the page, so it has to be rendered.
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// purge handles requests to purge surrogate keys, which are authenticated
+// with the PurgeToken from the config as a bearer token. It drops what the
+// instance itself has cached about the guilds and channels with the keys
+// given in the key form values, so that the pages a CDN then refetches are
+// current. The CDN itself is purged by the operator with the same keys.
+func (s *Server) purge(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.purgeToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.displayErr(w, r, http.StatusUnauthorized, nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.invalidate(r.Form["key"]); err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Purged %s", strings.Join(r.Form["key"], " "))
+	w.Header().Set("Cache-Control", cacheNone)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// invalidate drops what is cached about the guilds and channels with the
+// given IDs.
+func (s *Server) invalidate(keys []string) error {
+	channels := make(map[discord.ChannelID]bool)
+	for _, key := range keys {
+		sf, err := discord.ParseSnowflake(key)
+		if err != nil {
+			return err
+		}
+		id := discord.GuildID(sf)
+		if _, err := s.bots.forGuild(id).Cabinet.Guild(id); err == nil {
+			chs, _ := s.bots.forGuild(id).Cabinet.Channels(id)
+			for _, ch := range chs {
+				channels[ch.ID] = true
+			}
+			s.roles.invalidate(id)
+			s.markSitemapDirty(id)
+			continue
+		}
+		channels[discord.ChannelID(sf)] = true
+	}
+	s.forgetChannels(channels)
+	return nil
+}
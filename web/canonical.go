@@ -0,0 +1,100 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// canonicalize is a middleware that redirects requests for a page by
+// another URL than its canonical one to that, so that search engines find
+// each page under one URL only. The canonical URL of a page has no trailing
+// slash, IDs without leading zeros or anything stuck to their end, like
+// the punctuation that follows links pasted into sentences, and the host
+// of SiteURL in the case it is written there, or else in lower case.
+func (s *Server) canonicalize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := s.canonicalHost(r.Host)
+		path := canonicalPath(r.URL.Path)
+		if host == r.Host && path == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		u := url.URL{Path: path, RawQuery: r.URL.RawQuery}
+		if host != r.Host {
+			u.Scheme = r.URL.Scheme
+			if u.Scheme == "" {
+				u.Scheme = "http"
+				if r.TLS != nil {
+					u.Scheme = "https"
+				}
+			}
+			u.Host = host
+		}
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
+
+// canonicalHost returns the host that pages requested from host are served
+// under.
+func (s *Server) canonicalHost(host string) string {
+	if site, err := url.Parse(s.site().URL); err == nil && strings.EqualFold(site.Host, host) {
+		return site.Host
+	}
+	return strings.ToLower(host)
+}
+
+// canonicalPath returns the canonical form of the path of a page. Only the
+// pages of guilds and embeds are changed, since the IDs in other paths,
+// like those of media, are looked up as they are.
+func canonicalPath(path string) string {
+	if path == "/" {
+		return path
+	}
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var ids []string
+	switch {
+	case segs[0] == "embed", validSlug(segs[0]):
+		ids = segs[1:]
+	case strings.IndexFunc(segs[0], notDigit) != 0:
+		// It is a guild's ID, or empty for a doubled slash.
+		ids = segs
+	default:
+		return path
+	}
+	for i, seg := range ids {
+		ids[i] = canonicalID(seg)
+	}
+	// Empty segments are left by trailing and doubled slashes.
+	kept := segs[:0]
+	for _, seg := range segs {
+		if seg != "" {
+			kept = append(kept, seg)
+		}
+	}
+	return "/" + strings.Join(kept, "/")
+}
+
+// canonicalID returns the snowflake that a path segment starts with, without
+// leading zeros, or the segment as it is if it doesn't start with a digit.
+func canonicalID(seg string) string {
+	end := strings.IndexFunc(seg, notDigit)
+	if seg == "" || end == 0 {
+		return seg
+	}
+	if end > 0 {
+		seg = seg[:end]
+	}
+	if trimmed := strings.TrimLeft(seg, "0"); trimmed != "" {
+		return trimmed
+	}
+	return "0"
+}
+
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
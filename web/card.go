@@ -0,0 +1,327 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/diamondburned/arikawa/v3/discord"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Social cards are rendered at the size recommended for og:image.
+const (
+	CardWidth  = 1200
+	CardHeight = 630
+	cardMargin = 64
+	// MaxCachedCards is the number of rendered cards kept in memory.
+	MaxCachedCards = 1000
+)
+
+var (
+	cardBackground = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	cardForeground = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	cardMuted      = color.RGBA{0x44, 0x44, 0x44, 0xff}
+	cardBar        = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	cardChip       = color.RGBA{0xbb, 0xbb, 0xbb, 0xff}
+)
+
+type renderedCard struct {
+	key string
+	png []byte
+}
+
+// cardCache holds rendered social cards per post, along with the guild icons
+// used to draw them.
+type cardCache struct {
+	mu    sync.Mutex
+	cards *cache.LRU[discord.ChannelID, renderedCard]
+	icons map[string]image.Image // icon URL -> decoded icon
+	// regular and bold are the fonts that cards are drawn with, in the
+	// order they are tried for each character.
+	regular, bold []*opentype.Font
+}
+
+// newCardCache returns a card cache drawing cards with the Go fonts, which
+// only cover Latin, Greek and Cyrillic scripts, after the font at path if
+// it isn't empty, for the characters that it covers.
+func newCardCache(path string) (*cardCache, error) {
+	c := &cardCache{
+		cards: cache.NewLRU[discord.ChannelID, renderedCard](MaxCachedCards, nil),
+		icons: make(map[string]image.Image),
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		coll, err := opentype.ParseCollection(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		f, err := coll.Font(0)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		c.regular, c.bold = append(c.regular, f), append(c.bold, f)
+	}
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	c.regular, c.bold = append(c.regular, regular), append(c.bold, bold)
+	return c, nil
+}
+
+func (s *Server) getPostCard(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	tags := postTags(forum, post)
+	// The key covers everything drawn on the card, so renames and retags
+	// produce a new card.
+	key := fmt.Sprint(guild.Name, guild.Icon, forum.Name, post.Name, post.AppliedTags)
+
+	s.cards.mu.Lock()
+	card, ok := s.cards.cards.Get(post.ID)
+	s.cards.mu.Unlock()
+	if !ok || card.key != key {
+		img := s.renderCard(guild, forum, post, tags)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("encoding card: %w", err))
+			return
+		}
+		card = renderedCard{key, buf.Bytes()}
+		s.cards.mu.Lock()
+		s.cards.cards.Add(post.ID, card)
+		s.cards.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("ETag", fmt.Sprintf("\"%x\"", crc32.ChecksumIEEE(card.png)))
+	http.ServeContent(w, r, "card.png", time.Time{}, bytes.NewReader(card.png))
+}
+
+func (s *Server) renderCard(guild *discord.Guild, forum, post *discord.Channel, tags []discord.Tag) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, CardWidth, CardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+
+	const iconSize = 96
+	x := cardMargin
+	if icon := s.guildIcon(guild); icon != nil {
+		drawCircle(img, image.Rect(x, cardMargin, x+iconSize, cardMargin+iconSize), icon)
+		x += iconSize + 24
+	}
+	s.cards.text(true, 36).draw(img, x, cardMargin+4, cardForeground, guild.Name)
+	s.cards.text(false, 26).draw(img, x, cardMargin+56, cardMuted, forum.Name)
+
+	title := s.cards.text(true, 56)
+	y := cardMargin + iconSize + 40
+	fits := func(line string) bool { return title.width(line) <= CardWidth-2*cardMargin }
+	for _, line := range wrapText(post.Name, fits, 3) {
+		title.draw(img, cardMargin, y, cardForeground, line)
+		y += title.height()
+	}
+
+	chip := s.cards.text(false, 24)
+	x, y = cardMargin, y+24
+	for _, tag := range tags {
+		w := chip.width(tag.Name) + 24
+		if x+w > CardWidth-cardMargin {
+			break
+		}
+		draw.Draw(img, image.Rect(x, y, x+w, y+chip.height()+16), image.NewUniform(cardChip), image.Point{}, draw.Src)
+		chip.draw(img, x+12, y+8, cardForeground, tag.Name)
+		x += w + 12
+	}
+
+	bar := image.Rect(0, CardHeight-72, CardWidth, CardHeight)
+	draw.Draw(img, bar, image.NewUniform(cardBar), image.Point{}, draw.Src)
+	footer := s.cards.text(false, 26)
+	footer.draw(img, cardMargin, bar.Min.Y+(bar.Dy()-footer.height())/2, cardForeground, s.site().ServiceName)
+	return img
+}
+
+// guildIcon fetches and decodes a guild's icon, returning nil if the guild
+// doesn't have one or it couldn't be fetched.
+func (s *Server) guildIcon(guild *discord.Guild) image.Image {
+	url := guild.IconURLWithType(discord.PNGImage)
+	if url == "" {
+		return nil
+	}
+	url += "?size=128"
+	s.cards.mu.Lock()
+	icon, ok := s.cards.icons[url]
+	s.cards.mu.Unlock()
+	if ok {
+		return icon
+	}
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		log.Println("Error fetching guild icon:", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Error fetching guild icon: %s", resp.Status)
+		return nil
+	}
+	icon, err = png.Decode(resp.Body)
+	if err != nil {
+		log.Println("Error decoding guild icon:", err)
+		return nil
+	}
+	s.cards.mu.Lock()
+	s.cards.icons[url] = icon
+	s.cards.mu.Unlock()
+	return icon
+}
+
+// drawCircle scales src into r on dst, masked to a circle like the guild
+// icons shown in the site's navigation bar.
+func drawCircle(dst draw.Image, r image.Rectangle, src image.Image) {
+	scaled := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+	mask := image.NewAlpha(scaled.Bounds())
+	radius := r.Dx() / 2
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			dx, dy := x-radius, y-radius
+			if dx*dx+dy*dy <= radius*radius {
+				mask.SetAlpha(x, y, color.Alpha{0xff})
+			}
+		}
+	}
+	draw.DrawMask(dst, r, scaled, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// cardText draws text on cards with faces of the card fonts at one size,
+// each character with the first face that has it. Characters that none
+// of them have are left out rather than drawn as boxes. Faces keep
+// buffers of their own, so each card is drawn with new ones.
+type cardText []font.Face
+
+func (c *cardCache) text(bold bool, size float64) cardText {
+	fonts := c.regular
+	if bold {
+		fonts = c.bold
+	}
+	t := make(cardText, len(fonts))
+	for i, f := range fonts {
+		// NewFace only fails for options that are out of range.
+		t[i], _ = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	}
+	return t
+}
+
+// face returns the face that draws r, or nil if none has it.
+func (t cardText) face(r rune) font.Face {
+	for _, f := range t {
+		if _, ok := f.GlyphAdvance(r); ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// height returns the height of a line of text.
+func (t cardText) height() int {
+	return t[len(t)-1].Metrics().Height.Ceil()
+}
+
+func (t cardText) width(text string) int {
+	var w fixed.Int26_6
+	for _, r := range text {
+		if f := t.face(r); f != nil {
+			adv, _ := f.GlyphAdvance(r)
+			w += adv
+		}
+	}
+	return w.Ceil()
+}
+
+// draw draws text with its top left corner at (x, y).
+func (t cardText) draw(dst draw.Image, x, y int, c color.Color, text string) {
+	src := image.NewUniform(c)
+	dot := fixed.P(x, y+t[len(t)-1].Metrics().Ascent.Ceil())
+	for _, r := range text {
+		f := t.face(r)
+		if f == nil {
+			continue
+		}
+		dr, mask, maskp, adv, ok := f.Glyph(dot, r)
+		if ok {
+			draw.DrawMask(dst, dr, src, image.Point{}, mask, maskp, draw.Over)
+		}
+		dot.X += adv
+	}
+}
+
+// wrapText splits text into at most maxLines lines that fit, breaking on
+// spaces, or inside words that don't fit on a line of their own, and
+// ending with an ellipsis if it is truncated.
+func wrapText(text string, fits func(string) bool, maxLines int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && fits(line+" "+word) {
+			line += " " + word
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		for !fits(word) {
+			r := []rune(word)
+			n := 1
+			for n < len(r) && fits(string(r[:n+1])) {
+				n++
+			}
+			lines = append(lines, string(r[:n]))
+			word = string(r[n:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := []rune(lines[maxLines-1])
+		for len(last) > 0 && !fits(string(last)+"...") {
+			last = last[:len(last)-1]
+		}
+		lines[maxLines-1] = string(last) + "..."
+	}
+	return lines
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// channelPolicy is how channels of a type are treated.
+type channelPolicy int
+
+const (
+	// channelHidden channels aren't listed anywhere, and their pages are
+	// 404s.
+	channelHidden channelPolicy = iota
+	// channelUnservable channels are listed on their guild's page, and
+	// their pages explain why they aren't archived, so that links to them
+	// from Discord don't dead end.
+	channelUnservable
+	// channelServable channels are archived.
+	channelServable
+)
+
+// guildMedia is the type of media channels, which arikawa doesn't know of.
+const guildMedia discord.ChannelType = 16
+
+// channelType is the policy of a type of channel, with what the type is
+// called and why its channels aren't archived if they aren't, in English
+// for Locale.T.
+type channelType struct {
+	policy channelPolicy
+	name   string
+	reason string
+}
+
+var channelTypes = map[discord.ChannelType]channelType{
+	discord.GuildForum: {policy: channelServable, name: "Forum channel"},
+	discord.GuildText: {channelUnservable, "Text channel",
+		"Only forum channels are archived here. The messages of text channels can be read on Discord."},
+	discord.GuildAnnouncement: {channelUnservable, "Announcement channel",
+		"Only forum channels are archived here. The messages of announcement channels can be read on Discord."},
+	discord.GuildVoice: {channelUnservable, "Voice channel",
+		"Voice channels and the text chats in them aren't archived here. They can be joined on Discord."},
+	discord.GuildStageVoice: {channelUnservable, "Stage channel",
+		"Stage channels and the text chats in them aren't archived here. They can be joined on Discord."},
+	guildMedia: {channelUnservable, "Media channel",
+		"Media channels aren't archived here yet. Their posts can be seen on Discord."},
+	// Threads are served as posts of the forum they are in, and other
+	// types of channels are never shown.
+}
+
+// threadTypes are the types of threads, which links from Discord can have
+// in place of a forum.
+var threadTypes = map[discord.ChannelType]bool{
+	discord.GuildPublicThread:       true,
+	discord.GuildPrivateThread:      true,
+	discord.GuildAnnouncementThread: true,
+}
+
+// errThreadNotInForum explains threads in text and announcement channels.
+var errThreadNotInForum = &readerError{http.StatusNotFound, "Not Found",
+	"Only threads in forum channels are archived here. This thread can be read on Discord."}
+
+// servableForum reports whether a channel a request was made for in place
+// of a forum is one, answering the request if it isn't. Threads, as in
+// links to them from Discord, are redirected to their post, and other
+// channels that aren't archived but are listed get a page explaining why.
+func (s *Server) servableForum(w http.ResponseWriter, r *http.Request, ch *discord.Channel) bool {
+	if threadTypes[ch.Type] {
+		s.redirectToPost(w, r, ch)
+		return false
+	}
+	typ := channelTypes[ch.Type]
+	switch typ.policy {
+	case channelServable:
+		return true
+	case channelUnservable:
+		guild, err := s.bots.forGuild(ch.GuildID).Cabinet.Guild(ch.GuildID)
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching guild: %w", err))
+			return false
+		}
+		ctx := struct {
+			Page
+			Guild   *discord.Guild
+			Channel *discord.Channel
+			// TypeName is what the type of the channel is called, Reason
+			// why it isn't archived, and DiscordURL where it can be seen
+			// instead.
+			TypeName   string
+			Reason     string
+			DiscordURL string
+		}{
+			Page:       s.guildPage(w, r, ch.GuildID),
+			Guild:      guild,
+			Channel:    ch,
+			TypeName:   typ.name,
+			Reason:     typ.reason,
+			DiscordURL: fmt.Sprintf("https://discord.com/channels/%s/%s", ch.GuildID, ch.ID),
+		}
+		ctx.Meta.Breadcrumbs = s.breadcrumbs(r, guild, ch, nil)
+		w.Header().Set("X-Robots-Tag", "noindex")
+		s.executeTemplate(w, r, "channel.gohtml", ctx)
+	default:
+		s.displayErr(w, r, http.StatusNotFound, nil)
+	}
+	return false
+}
+
+// redirectToPost redirects requests for a thread in place of a forum, as
+// in links from Discord, to the thread's post, and to the message in it
+// that was linked if one was.
+func (s *Server) redirectToPost(w http.ResponseWriter, r *http.Request, thread *discord.Channel) {
+	parent, err := s.channel(thread.ParentID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching thread's channel: %w", err))
+		return
+	}
+	if parent.Type != discord.GuildForum {
+		s.displayErr(w, r, http.StatusNotFound, errThreadNotInForum)
+		return
+	}
+	u := fmt.Sprintf("%s/%s/%s", s.requestGuildPath(r, thread.GuildID), parent.ID, thread.ID)
+	if sf, err := discord.ParseSnowflake(chi.URLParam(r, "postID")); err == nil {
+		u += "?after=" + (discord.MessageID(sf) - 1).String()
+	}
+	http.Redirect(w, r, u, http.StatusMovedPermanently)
+}
+
+// listedChannel is a channel that isn't archived but is listed on its
+// guild's page.
+type listedChannel struct {
+	discord.Channel
+	TypeName string
+}
@@ -0,0 +1,258 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/naoina/toml"
+)
+
+const usage = `Usage: dforum [-config path] [-jobs n] [command] [arguments]
+
+Commands:
+  serve                                 serve the archive, which is the default
+  check                                 check the config, the resources and the bot tokens
+  dump-config                           print the config with the defaults filled in
+  purge-cache <guild or channel ID...>  drop what the running server has cached of guilds or channels
+  backfill [guild ID...]                fetch the whole history of the posts of guilds
+  freeze <guild ID> <export directory>  freeze a guild's archive and export it as static files
+  export <guild ID> <file.tar.gz>       export a guild's archive as a tarball that works offline
+  import <export.json...>               store posts exported by DiscordChatExporter as JSON
+  demo                                  serve a made-up guild, without a bot token or a database
+
+Flags:
+`
+
+// tokens returns the bot tokens in the config.
+func (c Config) tokens() []string {
+	var tokens []string
+	for _, token := range append([]string{c.BotToken}, c.BotTokens...) {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// validateConfig checks the options of a config that can be checked without
+// connecting to anything or loading the resources.
+func validateConfig(c Config) error {
+	if len(c.tokens()) == 0 {
+		return errors.New("no bot token is configured")
+	}
+	if !strings.HasPrefix(c.Database, "postgres://") {
+		return errors.New("option 'Database' does not begin with postgres://")
+	}
+	return validateOptions(c)
+}
+
+// validateOptions checks the options of a config that validateConfig does,
+// but the bot tokens and the database, which the demo doesn't need.
+func validateOptions(c Config) error {
+	if _, err := parseNetworks(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if _, err := parseNetworks(c.RateLimitExempt); err != nil {
+		return fmt.Errorf("invalid rate limit exemptions: %w", err)
+	}
+	if c.TLSCert != "" && len(c.ACMEDomains) > 0 {
+		return errors.New("options 'TLSCert' and 'ACMEDomains' can't both be set")
+	}
+	if len(c.ACMEDomains) > 0 && c.ACMEDir == "" {
+		return errors.New("option 'ACMEDir' is needed to store certificates in")
+	}
+	if _, err := parseUserIDs(c.ReportUsers); err != nil {
+		return fmt.Errorf("invalid report users: %w", err)
+	}
+	if _, err := parseRedactions(c.Redactions); err != nil {
+		return err
+	}
+	if c.AccessLogFormat != "clf" && c.AccessLogFormat != "json" {
+		return fmt.Errorf("option 'AccessLogFormat' is %q, not clf or json", c.AccessLogFormat)
+	}
+	if !validAccessLogIPs(c.AccessLogIPs) {
+		return fmt.Errorf("option 'AccessLogIPs' is %q, not truncate, hash or omit", c.AccessLogIPs)
+	}
+	if c.AccessLogMaxSizeMB < 0 || c.AccessLogBackups < 0 {
+		return errors.New("access log rotation options can't be negative")
+	}
+	if c.DiscordConcurrency < 0 || c.DiscordRate < 0 {
+		return errors.New("limits of requests to Discord can't be negative")
+	}
+	if c.WarmPages < 0 {
+		return errors.New("option 'WarmPages' can't be negative")
+	}
+	if c.WarmPages > 0 && !c.Analytics {
+		return errors.New("option 'WarmPages' needs 'Analytics' to find the most viewed pages")
+	}
+	return nil
+}
+
+// resourceFS returns the resources that a config says to serve, which are
+// the embedded ones with the config's directories layered over them.
+// Embedded templates can't change, so they aren't reloaded if there are no
+// directories.
+func resourceFS(c *Config) (fs.FS, error) {
+	embedded, err := fs.Sub(embedfs, "resources")
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Resources) == 0 {
+		c.ReloadTemplates = false
+		return embedded, nil
+	}
+	return newOverlayFS(c.Resources, embedded), nil
+}
+
+// check checks everything that would make the server fail to start or to
+// serve with a config, printing what it finds, and reports whether all of it
+// is fine. The database isn't opened, since that would migrate it.
+func check(c Config) bool {
+	ok := true
+	report := func(what string, err error) {
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", what, err)
+			ok = false
+		} else {
+			fmt.Printf("ok   %s\n", what)
+		}
+	}
+	report("config", validateConfig(c))
+	fsys, err := resourceFS(&c)
+	if err == nil {
+		_, err = parseTemplates(fsys)
+	}
+	report("templates", err)
+	if err == nil {
+		s := &Server{fsys: fsys}
+		if s.locales, err = loadLocales(fsys); err == nil {
+			_, err = s.newSiteOptions(c)
+		}
+		report("locales, themes and site options", err)
+	}
+	if err == nil {
+		report("pages of the demo guild", checkDemoPages(c, fsys))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for i, token := range c.tokens() {
+		st := state.New("Bot " + token)
+		setDiscordHeader(st.Client, requestHeader(c))
+		me, err := st.WithContext(ctx).Me()
+		report(fmt.Sprintf("bot %d token", i+1), err)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("     logs in as %s (%s)\n", me.Tag(), me.ID)
+		if c.MembersIntent {
+			granted, err := membersIntentGranted(st.WithContext(ctx))
+			if err == nil && !granted {
+				err = errors.New("not granted, members will be looked up over REST; turn it on in the Discord developer portal")
+			}
+			report(fmt.Sprintf("bot %d Server Members intent", i+1), err)
+		}
+		idents, err := shardIdentifiers(ctx, "Bot "+token, c.ShardCount, c.ShardIDs)
+		report(fmt.Sprintf("bot %d shards", i+1), err)
+		if err == nil && len(idents) > 1 {
+			fmt.Printf("     runs %d shards\n", len(idents))
+		}
+	}
+	return ok
+}
+
+// dumpTOML writes keys the way the example config has them, which the
+// default snake case can't always be read back as, as in ACMEDir.
+var dumpTOML = &toml.Config{
+	NormFieldName: toml.DefaultConfig.NormFieldName,
+	FieldToKey:    func(_ reflect.Type, field string) string { return field },
+}
+
+// dumpConfig writes a config with the defaults of the options that aren't
+// set filled in as TOML. Tokens and the database's password are left out,
+// so that the output can be shared.
+func dumpConfig(w io.Writer, c Config) error {
+	redact := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "<redacted>"
+	}
+	c.BotToken = redact(c.BotToken)
+	for i := range c.BotTokens {
+		c.BotTokens[i] = redact(c.BotTokens[i])
+	}
+	c.PurgeToken = redact(c.PurgeToken)
+	c.AdminToken = redact(c.AdminToken)
+	c.ReportWebhook = redact(c.ReportWebhook)
+	if u, err := url.Parse(c.Database); err == nil {
+		c.Database = u.Redacted()
+	}
+	b, err := dumpTOML.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// purgeCache has the running server drop what it has cached of the guilds
+// and channels with the given IDs, through the endpoint that PurgeToken
+// enables. The server is reached at its listening address, or at SiteURL if
+// it serves HTTPS, whose certificate isn't for a local address.
+func purgeCache(c Config, ids []string) error {
+	if c.PurgeToken == "" {
+		return errors.New("option 'PurgeToken' isn't set, so the server doesn't accept purges")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	var base string
+	switch {
+	case strings.Contains(c.ListenAddr, "/"):
+		base = "http://localhost"
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", c.ListenAddr)
+			},
+		}
+	case c.TLSCert != "" || len(c.ACMEDomains) > 0:
+		if c.SiteURL == "" {
+			return errors.New("option 'SiteURL' is needed to reach a server that serves HTTPS")
+		}
+		base = strings.TrimSuffix(c.SiteURL, "/")
+	default:
+		host, port, err := net.SplitHostPort(c.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid listening address: %w", err)
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		base = "http://" + net.JoinHostPort(host, port)
+	}
+	form := url.Values{"key": ids}
+	req, err := http.NewRequest(http.MethodPost, base+"/purge", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+c.PurgeToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// ContextVersion is the version of the contexts below, which templates
+// are given and which custom templates in Resources are written against.
+// It is raised whenever a field of one of them is renamed or removed, or
+// comes to mean something else, so that custom templates can tell that
+// they need updating; adding fields leaves it as it is. Templates find it
+// in Page, which every context embeds.
+const ContextVersion = 1
+
+// IndexContext is what index.gohtml is given: the directory of the guilds
+// that the site archives.
+type IndexContext struct {
+	Page
+	GuildCount int
+	// Guilds are the guilds in the directory on this page, of the
+	// GuildsFound that match the search.
+	Guilds      []DirectoryGuild
+	GuildsFound int
+	// Query is what the directory is searched for, and Sort the key
+	// of the order it is in.
+	Query string
+	Sort  string
+	Sorts []guildSort
+	Prev  int
+	Next  int
+	Pages int
+	// PageNumbers are the pages to link to, with 0 for a gap.
+	PageNumbers []int
+	// PageQuery is the start of the query of the links to the other
+	// pages, which keeps the search and the sort.
+	PageQuery string
+}
+
+// GuildContext is what guild.gohtml is given: a guild's forums and the
+// channels it lists.
+type GuildContext struct {
+	Page
+	Guild *discord.Guild
+	// Categories are the guild's forums, grouped by their category.
+	Categories []ForumCategory
+	// OtherChannels are the channels that aren't archived but are
+	// listed.
+	OtherChannels []listedChannel
+	MemberCount   uint64
+	// Rules are the messages of the guild's rules channel, if the bot
+	// can read it.
+	Rules []Message
+	// Invite is the link that readers can join the guild with.
+	Invite string
+}
+
+// ForumContext is what forum.gohtml is given: a page of the posts in a
+// forum, or in one of its tags.
+type ForumContext struct {
+	Page
+	Guild *discord.Guild
+	Forum *discord.Channel
+	// Tag is the tag that the posts are filtered by, if any.
+	Tag   *discord.Tag
+	Posts []Post
+	Prev  int
+	Next  int
+	// PostCount is how many posts are listed over the Pages pages,
+	// and PageNumbers the pages to link to, with 0 for a gap.
+	PostCount   int
+	Pages       int
+	PageNumbers []int
+	// PagePath is the path that the pages of the list are under.
+	PagePath string
+	// Sort is the key of the order the posts are in, and SortParam
+	// the sort parameter to keep it if it isn't the forum's default.
+	Sort      string
+	SortParam string
+	Sorts     []postSort
+	// Layout is the key of the layout the posts are shown in, and
+	// LayoutParam the layout parameter to keep it, like SortParam.
+	Layout      string
+	LayoutParam string
+	Layouts     []struct{ Key, Name string }
+	// PageQuery is the query of the links to the other pages of the
+	// list, which keeps the sort and layout.
+	PageQuery   string
+	Query       string
+	AppendedStr string
+}
+
+// PostContext is what post.gohtml is given: a page of the messages in a
+// post.
+type PostContext struct {
+	Page
+	Guild         *discord.Guild
+	Forum         *discord.Channel
+	Post          Post
+	Prev          discord.MessageID
+	Next          discord.MessageID
+	MessageGroups []MessageGroup
+	// AsOf is the time the post is shown as of, if it isn't shown as
+	// it is now, and AsOfParam the asof parameter that gave it.
+	AsOf      *time.Time
+	AsOfParam string
+	// Descending is set if the newest messages are shown first, and
+	// OrderParam is the order parameter if it isn't the default order.
+	Descending bool
+	OrderParam string
+	// PrevLink and NextLink link to the pages before Prev and after
+	// Next, and Latest to the page with the newest messages if this
+	// isn't it.
+	PrevLink, NextLink string
+	Latest             string
+	// MessageCount is how many messages the post has, counting the
+	// starter message, over Pages pages. PageNumber is the number of
+	// this page in reading order if it is the first or the last one,
+	// and 0 otherwise, since the pages in between start at messages
+	// rather than at numbers.
+	MessageCount int
+	Pages        int
+	PageNumber   int
+	// TableOfContents lists the headings of the post, for the sidebar.
+	TableOfContents []TOCEntry
+	// Participants are the first authors to post in the post, out of
+	// ParticipantCount.
+	Participants     []Author
+	ParticipantCount int
+	// StructuredData describes the post to search engines.
+	StructuredData discussionPosting
+	// Poll is the URL that the page polls for new messages if it is the
+	// latest page of a post that is updated live, and PollRefresh how
+	// often it is reloaded instead if it can't be.
+	Poll        string
+	PollRefresh int
+	// AttachmentsZip is the link to a ZIP file of the post's
+	// attachments, if they can be downloaded.
+	AttachmentsZip string
+	// Snapshot is how up to date the archive of the post is.
+	Snapshot *PostSnapshot
+	// PrevPost and NextPost are the posts in the forum that were made
+	// right before and right after this one, if there are any.
+	PrevPost, NextPost *PostLink
+}
@@ -0,0 +1,541 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/httputil/httpdriver"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// fakeDiscord is a guild kept in memory that answers the requests the
+// server makes to Discord's REST API, and that its bot's gateway is told of
+// without connecting to Discord. It is what the demo command serves, so
+// that the archive can be tried, and templates worked on, without a bot
+// token or a database.
+type fakeDiscord struct {
+	me      discord.User
+	guild   discord.Guild
+	members []discord.Member
+	// channels are the guild's channels and posts, and messages the
+	// messages of the posts, oldest first.
+	channels []discord.Channel
+	messages map[discord.ChannelID][]discord.Message
+	r        chi.Router
+}
+
+// demoPostMessages is how many messages the longest post of the demo guild
+// has, which is enough for a few pages of them.
+const demoPostMessages = 60
+
+// newDemoDiscord returns the demo guild, with its posts sent in the days
+// before now.
+func newDemoDiscord(now time.Time) *fakeDiscord {
+	f := &fakeDiscord{messages: make(map[discord.ChannelID][]discord.Message)}
+	// Every ID is made from a time, and the IDs made from the same time
+	// are kept apart by counting up from it.
+	var seq discord.Snowflake
+	id := func(t time.Time) discord.Snowflake {
+		seq++
+		return discord.NewSnowflake(t) + seq
+	}
+	start := now.AddDate(0, 0, -30)
+	guildID := discord.GuildID(id(start))
+	f.me = discord.User{ID: discord.UserID(id(start)), Username: "dforum", Bot: true}
+	users := []discord.User{
+		{ID: discord.UserID(id(start)), Username: "ada", DisplayName: "Ada"},
+		{ID: discord.UserID(id(start)), Username: "grace", DisplayName: "Grace"},
+		{ID: discord.UserID(id(start)), Username: "linus"},
+	}
+	modRole := discord.RoleID(id(start))
+	f.guild = discord.Guild{
+		ID:      guildID,
+		Name:    "DFS Demo",
+		OwnerID: users[0].ID,
+		Roles: []discord.Role{
+			{
+				ID:          discord.RoleID(guildID),
+				Name:        "@everyone",
+				Permissions: discord.PermissionViewChannel | discord.PermissionReadMessageHistory,
+			},
+			{
+				ID:          modRole,
+				Name:        "Moderator",
+				Color:       0x3498db,
+				Hoist:       true,
+				Position:    1,
+				Permissions: discord.PermissionAll,
+			},
+		},
+	}
+	for i, u := range append([]discord.User{f.me}, users...) {
+		m := discord.Member{User: u, Joined: discord.NewTimestamp(start)}
+		if i == 1 {
+			m.RoleIDs = []discord.RoleID{modRole}
+		}
+		f.members = append(f.members, m)
+	}
+
+	help := discord.Channel{
+		ID:      discord.ChannelID(id(start)),
+		GuildID: guildID,
+		Type:    discord.GuildForum,
+		Name:    "help",
+		Topic:   "Ask anything about **dforum**.",
+		AvailableTags: []discord.Tag{
+			{ID: discord.TagID(id(start)), Name: "Question"},
+			{ID: discord.TagID(id(start)), Name: "Solved"},
+		},
+	}
+	// Only moderators can see the staff forum. The bot is let in as well,
+	// as bots often are, but the forum still isn't archived since it isn't
+	// open to everyone.
+	staff := discord.Channel{
+		ID:      discord.ChannelID(id(start)),
+		GuildID: guildID,
+		Type:    discord.GuildForum,
+		Name:    "staff",
+		Overwrites: []discord.Overwrite{
+			{ID: discord.Snowflake(guildID), Type: discord.OverwriteRole, Deny: discord.PermissionViewChannel},
+			{ID: discord.Snowflake(modRole), Type: discord.OverwriteRole, Allow: discord.PermissionViewChannel},
+			{ID: discord.Snowflake(f.me.ID), Type: discord.OverwriteMember, Allow: discord.PermissionViewChannel},
+		},
+	}
+	f.channels = append(f.channels, help, staff)
+
+	type demoMessage struct {
+		author  int
+		content string
+	}
+	post := func(forum discord.Channel, name string, at time.Time, msgs []demoMessage, tags ...int) *discord.Channel {
+		p := discord.Channel{
+			ID:       discord.ChannelID(id(at)),
+			GuildID:  guildID,
+			ParentID: forum.ID,
+			Type:     discord.GuildPublicThread,
+			Name:     name,
+			OwnerID:  users[msgs[0].author].ID,
+			ThreadMetadata: &discord.ThreadMetadata{
+				AutoArchiveDuration: discord.SevenDaysArchive,
+			},
+		}
+		for _, t := range tags {
+			p.AppliedTags = append(p.AppliedTags, forum.AvailableTags[t].ID)
+		}
+		for i, m := range msgs {
+			msgID := discord.MessageID(p.ID)
+			if i > 0 {
+				msgID = discord.MessageID(id(at.Add(time.Duration(i) * 7 * time.Minute)))
+			}
+			f.messages[p.ID] = append(f.messages[p.ID], discord.Message{
+				ID:        msgID,
+				ChannelID: p.ID,
+				GuildID:   guildID,
+				Author:    users[m.author],
+				Content:   m.content,
+				Timestamp: discord.NewTimestamp(msgID.Time()),
+			})
+		}
+		last := f.messages[p.ID][len(msgs)-1]
+		p.LastMessageID = last.ID
+		p.MessageCount = len(msgs) - 1
+		f.channels = append(f.channels, p)
+		return &f.channels[len(f.channels)-1]
+	}
+
+	welcome := post(help, "Welcome to the help forum", start.Add(time.Hour), []demoMessage{
+		{1, "# Welcome\nThis forum is archived by **dforum**, so its posts can be read and searched without a Discord account.\n\n## Asking\nSay what you tried and what happened, and tag your post as a *Question*."},
+		{0, "## Answering\nMark the post as *Solved* once it is, so that others can find the answer."},
+		{2, "Thanks! :)"},
+	})
+	welcome.Flags = discord.PinnedThread
+
+	var long []demoMessage
+	long = append(long, demoMessage{0, "How do I get pages of messages to show up? This post has enough of them to find out."})
+	for i := 1; i < demoPostMessages; i++ {
+		long = append(long, demoMessage{i % 3, fmt.Sprintf("Message number %d, with `code`, ||a spoiler|| and a link to https://example.com.", i)})
+	}
+	post(help, "A long discussion", now.Add(-8*time.Hour), long, 0)
+
+	solved := post(help, "Can I read archived posts?", start.Add(48*time.Hour), []demoMessage{
+		{2, "Posts that are closed on Discord still show up here, right?"},
+		{1, "> still show up here\nThey do, and locked ones are read-only."},
+		{2, "Great, that answers it."},
+	}, 0, 1)
+	archived := discord.NewTimestamp(start.Add(72 * time.Hour))
+	solved.ThreadMetadata.Archived = true
+	solved.ThreadMetadata.Locked = true
+	solved.ThreadMetadata.ArchiveTimestamp = archived
+
+	post(staff, "Moderation notes", start.Add(24*time.Hour), []demoMessage{
+		{0, "Nobody but moderators should see this post."},
+	})
+
+	r := chi.NewRouter()
+	r.Get("/users/@me", f.getMe)
+	r.Get("/users/{userID}", f.getUser)
+	r.Get("/guilds/{guildID}/members/{userID}", f.getMember)
+	r.Get("/guilds/{guildID}/roles", f.getRoles)
+	r.Get("/guilds/{guildID}/channels", f.getChannels)
+	r.Get("/channels/{channelID}", f.getChannel)
+	r.Get("/channels/{channelID}/messages", f.getMessages)
+	r.Get("/channels/{channelID}/messages/{messageID}", f.getMessage)
+	r.Get("/channels/{channelID}/threads/archived/public", f.getArchivedThreads)
+	r.Put("/applications/{appID}/commands", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []discord.Command{})
+	})
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeDiscordError(w, http.StatusNotFound, "404: Not Found")
+	})
+	f.r = r
+	return f
+}
+
+// writeDiscordError answers a request with an error like Discord does.
+func writeDiscordError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{0, message})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.r.ServeHTTP(w, r)
+}
+
+// snowflakeParam returns the snowflake in a URL parameter, or 0 if it isn't
+// one.
+func snowflakeParam(r *http.Request, name string) discord.Snowflake {
+	sf, _ := discord.ParseSnowflake(chi.URLParam(r, name))
+	return sf
+}
+
+func (f *fakeDiscord) findChannel(id discord.ChannelID) *discord.Channel {
+	for i := range f.channels {
+		if f.channels[i].ID == id {
+			return &f.channels[i]
+		}
+	}
+	return nil
+}
+
+// canView reports whether the bot can see a channel, which for posts is
+// whether it can see their forum. Discord hides the posts that it can't see
+// from it, and answers requests for them with errors.
+func (f *fakeDiscord) canView(ch *discord.Channel) bool {
+	if ch.Type != discord.GuildForum {
+		if ch = f.findChannel(ch.ParentID); ch == nil {
+			return false
+		}
+	}
+	return discord.CalcOverwrites(f.guild, *ch, f.members[0]).Has(discord.PermissionViewChannel)
+}
+
+// public reports whether everyone in the guild can see a channel, or the
+// forum of a post.
+func (f *fakeDiscord) public(ch *discord.Channel) bool {
+	if ch.Type != discord.GuildForum {
+		if ch = f.findChannel(ch.ParentID); ch == nil {
+			return false
+		}
+	}
+	return discord.CalcOverwrites(f.guild, *ch, discord.Member{}).Has(discord.PermissionViewChannel)
+}
+
+func (f *fakeDiscord) getMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, f.me)
+}
+
+func (f *fakeDiscord) getUser(w http.ResponseWriter, r *http.Request) {
+	id := discord.UserID(snowflakeParam(r, "userID"))
+	for _, m := range f.members {
+		if m.User.ID == id {
+			writeJSON(w, m.User)
+			return
+		}
+	}
+	writeDiscordError(w, http.StatusNotFound, "Unknown User")
+}
+
+func (f *fakeDiscord) getMember(w http.ResponseWriter, r *http.Request) {
+	id := discord.UserID(snowflakeParam(r, "userID"))
+	for _, m := range f.members {
+		if m.User.ID == id && discord.GuildID(snowflakeParam(r, "guildID")) == f.guild.ID {
+			writeJSON(w, m)
+			return
+		}
+	}
+	writeDiscordError(w, http.StatusNotFound, "Unknown Member")
+}
+
+func (f *fakeDiscord) getRoles(w http.ResponseWriter, r *http.Request) {
+	if discord.GuildID(snowflakeParam(r, "guildID")) != f.guild.ID {
+		writeDiscordError(w, http.StatusNotFound, "Unknown Guild")
+		return
+	}
+	writeJSON(w, f.guild.Roles)
+}
+
+func (f *fakeDiscord) getChannels(w http.ResponseWriter, r *http.Request) {
+	if discord.GuildID(snowflakeParam(r, "guildID")) != f.guild.ID {
+		writeDiscordError(w, http.StatusNotFound, "Unknown Guild")
+		return
+	}
+	var channels []discord.Channel
+	for _, ch := range f.channels {
+		if ch.Type == discord.GuildForum {
+			channels = append(channels, ch)
+		}
+	}
+	writeJSON(w, channels)
+}
+
+func (f *fakeDiscord) getChannel(w http.ResponseWriter, r *http.Request) {
+	ch := f.findChannel(discord.ChannelID(snowflakeParam(r, "channelID")))
+	switch {
+	case ch == nil:
+		writeDiscordError(w, http.StatusNotFound, "Unknown Channel")
+	case ch.Type != discord.GuildForum && !f.canView(ch):
+		writeDiscordError(w, http.StatusForbidden, "Missing Access")
+	default:
+		writeJSON(w, ch)
+	}
+}
+
+// getMessages answers with the messages of a post like Discord does, which
+// is newest first, up to the limit from the ones after or before a message,
+// or the newest ones.
+func (f *fakeDiscord) getMessages(w http.ResponseWriter, r *http.Request) {
+	id := discord.ChannelID(snowflakeParam(r, "channelID"))
+	if ch := f.findChannel(id); ch == nil {
+		writeDiscordError(w, http.StatusNotFound, "Unknown Channel")
+		return
+	} else if !f.canView(ch) {
+		writeDiscordError(w, http.StatusForbidden, "Missing Access")
+		return
+	}
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	msgs := f.messages[id]
+	if after, err := discord.ParseSnowflake(q.Get("after")); err == nil && q.Get("after") != "" {
+		i := sort.Search(len(msgs), func(i int) bool { return msgs[i].ID > discord.MessageID(after) })
+		msgs = msgs[i:]
+		if len(msgs) > limit {
+			msgs = msgs[:limit]
+		}
+	} else {
+		if before, err := discord.ParseSnowflake(q.Get("before")); err == nil && q.Get("before") != "" {
+			i := sort.Search(len(msgs), func(i int) bool { return msgs[i].ID >= discord.MessageID(before) })
+			msgs = msgs[:i]
+		}
+		if len(msgs) > limit {
+			msgs = msgs[len(msgs)-limit:]
+		}
+	}
+	newest := make([]discord.Message, len(msgs))
+	for i, m := range msgs {
+		newest[len(msgs)-1-i] = m
+	}
+	writeJSON(w, newest)
+}
+
+func (f *fakeDiscord) getMessage(w http.ResponseWriter, r *http.Request) {
+	chID := discord.ChannelID(snowflakeParam(r, "channelID"))
+	id := discord.MessageID(snowflakeParam(r, "messageID"))
+	if ch := f.findChannel(chID); ch != nil && !f.canView(ch) {
+		writeDiscordError(w, http.StatusForbidden, "Missing Access")
+		return
+	}
+	for _, m := range f.messages[chID] {
+		if m.ID == id {
+			writeJSON(w, m)
+			return
+		}
+	}
+	writeDiscordError(w, http.StatusNotFound, "Unknown Message")
+}
+
+// getArchivedThreads answers with all the archived posts of a forum at
+// once, most recently archived first.
+func (f *fakeDiscord) getArchivedThreads(w http.ResponseWriter, r *http.Request) {
+	id := discord.ChannelID(snowflakeParam(r, "channelID"))
+	if ch := f.findChannel(id); ch == nil || !f.canView(ch) {
+		writeDiscordError(w, http.StatusForbidden, "Missing Access")
+		return
+	}
+	var threads api.ArchivedThreads
+	threads.Threads = []discord.Channel{}
+	for _, ch := range f.channels {
+		if ch.ParentID == id && ch.ThreadMetadata != nil && ch.ThreadMetadata.Archived {
+			threads.Threads = append(threads.Threads, ch)
+		}
+	}
+	sort.Slice(threads.Threads, func(i, j int) bool {
+		return threads.Threads[i].ThreadMetadata.ArchiveTimestamp.Time().After(threads.Threads[j].ThreadMetadata.ArchiveTimestamp.Time())
+	})
+	writeJSON(w, threads)
+}
+
+// fakeClient sends the requests of a bot to a handler instead of Discord.
+type fakeClient struct {
+	httpdriver.Client
+	h http.Handler
+}
+
+func (c fakeClient) Do(req httpdriver.Request) (httpdriver.Response, error) {
+	dr, ok := req.(*httpdriver.DefaultRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected request type %T", req)
+	}
+	r := (*http.Request)(dr).Clone(dr.GetContext())
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, api.Path)
+	rec := httptest.NewRecorder()
+	c.h.ServeHTTP(rec, r)
+	return (*httpdriver.DefaultResponse)(rec.Result()), nil
+}
+
+// bot returns a bot whose requests are answered by the fake guild, which
+// has to be told that it is ready with ready once the server is handling
+// its events.
+func (f *fakeDiscord) bot() *state.State {
+	st := state.New("Bot demo")
+	st.Client.Client.Client = fakeClient{st.Client.Client.Client, f}
+	st.AddIntents(gateway.IntentGuildMessages | gateway.IntentGuilds)
+	return st
+}
+
+// ready sends a bot the events that Discord sends when a bot connects to
+// the gateway, with the guild in them.
+func (f *fakeDiscord) ready(st *state.State) {
+	guild := gateway.GuildCreateEvent{
+		Guild:       f.guild,
+		Joined:      discord.NewTimestamp(f.guild.ID.Time()),
+		MemberCount: uint64(len(f.members)),
+		Members:     f.members,
+	}
+	for _, ch := range f.channels {
+		switch {
+		case ch.Type == discord.GuildForum:
+			guild.Channels = append(guild.Channels, ch)
+		case !ch.ThreadMetadata.Archived && f.canView(&ch):
+			guild.Threads = append(guild.Threads, ch)
+		}
+	}
+	st.Session.Handler.Call(&gateway.ReadyEvent{
+		Version: 9,
+		User:    f.me,
+		Guilds:  []gateway.GuildCreateEvent{{Guild: discord.Guild{ID: f.guild.ID}, Unavailable: true}},
+	})
+	st.Session.Handler.Call(&guild)
+}
+
+// newDemoServer returns a server of the demo guild made at now, with the
+// resources in fsys, that keeps what it fetches in memory. Nothing is
+// written anywhere, and pages are always rendered.
+func newDemoServer(c Config, fsys fs.FS, now time.Time) (*Server, *fakeDiscord, error) {
+	c.AccessLog, c.MediaDir, c.RenderCacheDir = "", "", ""
+	c.Analytics, c.WarmPages, c.RateLimit = false, 0, 0
+	tmpl, err := parseTemplates(fsys)
+	if err != nil {
+		return nil, nil, err
+	}
+	demo := newDemoDiscord(now)
+	st := demo.bot()
+	srv, err := newServer(bots{st}, fsys, database.OpenMemory(database.Options{}), c)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv.executeTemplateFn = tmpl.ExecuteTemplate
+	demo.ready(st)
+	// Events are handled in goroutines of their own, and pages say that
+	// Discord can't be reached until the ready event has been, and the
+	// guild's page doesn't count its members until its create event has.
+	ready := func() bool {
+		return srv.gateways.connected(0) && srv.members.get(demo.guild.ID) != 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !ready(); {
+		if time.Now().After(deadline) {
+			return nil, nil, errors.New("the demo guild's bot never got ready")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return srv, demo, nil
+}
+
+// checkDemoPages renders the pages of the demo guild with the resources in
+// fsys, and reports the first one that couldn't be, or that is shown
+// although only moderators can see it on Discord.
+func checkDemoPages(c Config, fsys fs.FS) error {
+	// The requests would be logged in between what check prints.
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	defer func(logger func(http.Handler) http.Handler) { middleware.DefaultLogger = logger }(middleware.DefaultLogger)
+	middleware.DefaultLogger = middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.Default()})
+	srv, demo, err := newDemoServer(c, fsys, time.Now())
+	if err != nil {
+		return err
+	}
+	host := "localhost"
+	if site, err := url.Parse(c.SiteURL); err == nil && site.Host != "" {
+		host = site.Host
+	}
+	render := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	guildPath := "/" + demo.guild.ID.String()
+	paths := []string{"/", guildPath}
+	var hidden []string
+	for _, ch := range demo.channels {
+		path := guildPath + "/" + ch.ID.String()
+		if ch.Type != discord.GuildForum {
+			path = guildPath + "/" + ch.ParentID.String() + "/" + ch.ID.String()
+		}
+		if demo.public(&ch) {
+			paths = append(paths, path)
+		} else {
+			hidden = append(hidden, path)
+		}
+	}
+	for _, path := range paths {
+		if code := render(path); code != http.StatusOK {
+			return fmt.Errorf("%s: status %d", path, code)
+		}
+	}
+	for _, path := range hidden {
+		if render(path) == http.StatusOK {
+			return fmt.Errorf("%s is shown, but only moderators can see it", path)
+		}
+	}
+	return nil
+}
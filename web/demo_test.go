@@ -0,0 +1,207 @@
+package web
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+var update = flag.Bool("update", false, "write the pages that are rendered to the golden files")
+
+// demoTime is when the demo guild of the tests is made, so that its IDs,
+// and the pages that show them, are the same on every run.
+var demoTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestServer returns a server of the demo guild with the embedded
+// resources and the default options.
+func newTestServer(t *testing.T) (*Server, *fakeDiscord) {
+	t.Helper()
+	fsys, err := fs.Sub(embedfs, "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, demo, err := newDemoServer(defaultConfig(), fsys, demoTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, demo
+}
+
+func get(srv *Server, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+// demoChannel returns the channel of the demo guild with a name.
+func demoChannel(t *testing.T, demo *fakeDiscord, name string) discord.Channel {
+	t.Helper()
+	for _, ch := range demo.channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	t.Fatalf("no channel named %q", name)
+	return discord.Channel{}
+}
+
+// archivedRegex matches when posts were archived, which for the demo guild
+// is when the test fetched them.
+var archivedRegex = regexp.MustCompile(`(Archived here since|Last refreshed from Discord on) \w+ \d+, \d+ \d+:\d+ [AP]M`)
+
+// checkGolden compares a page with the golden file of a test, or writes it
+// there with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	got = archivedRegex.ReplaceAll(got, []byte("$1 <time>"))
+	path := filepath.Join("testdata", "golden", name+".html")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run the tests with -update to write it)", err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		if i == len(gotLines) || i == len(wantLines) || gotLines[i] != wantLines[i] {
+			var g, w string
+			if i < len(gotLines) {
+				g = gotLines[i]
+			}
+			if i < len(wantLines) {
+				w = wantLines[i]
+			}
+			t.Errorf("%s differs from %s at line %d (run the tests with -update if it should):\ngot:  %s\nwant: %s",
+				name, path, i+1, g, w)
+			return
+		}
+	}
+}
+
+func TestGoldenPages(t *testing.T) {
+	srv, demo := newTestServer(t)
+	guild := "/" + demo.guild.ID.String()
+	help := demoChannel(t, demo, "help")
+	long := demoChannel(t, demo, "A long discussion")
+	msgs := demo.messages[long.ID]
+	post := guild + "/" + help.ID.String() + "/" + long.ID.String()
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"guild", guild},
+		{"forum", guild + "/" + help.ID.String()},
+		{"post", post},
+		{"post-second-page", post + "?after=" + msgs[24].ID.String()},
+		{"post-last-page", post + "?before=" + latestCursor.String()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := get(srv, test.path)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: status %d", test.path, rec.Code)
+			}
+			checkGolden(t, test.name, rec.Body.Bytes())
+		})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	srv, demo := newTestServer(t)
+	help := demoChannel(t, demo, "help")
+	long := demoChannel(t, demo, "A long discussion")
+	msgs := demo.messages[long.ID]
+	post := "/" + demo.guild.ID.String() + "/" + help.ID.String() + "/" + long.ID.String()
+	// The pages show the messages from first up to last, by their index
+	// in the post.
+	tests := []struct {
+		name        string
+		query       string
+		first, last int
+	}{
+		{"first page", "", 0, 24},
+		{"after a page", "?after=" + msgs[24].ID.String(), 25, 49},
+		{"before the last page", "?before=" + msgs[50].ID.String(), 25, 49},
+		{"last page", "?before=" + latestCursor.String(), 35, 59},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := get(srv, post+test.query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			body := rec.Body.String()
+			for i, m := range msgs {
+				shown := strings.Contains(body, "id='m"+m.ID.String()+"'")
+				if want := i >= test.first && i <= test.last; shown != want {
+					t.Errorf("message %d: shown is %t, want %t", i, shown, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPermissionFiltering(t *testing.T) {
+	srv, demo := newTestServer(t)
+	guild := "/" + demo.guild.ID.String()
+	staff := demoChannel(t, demo, "staff")
+	notes := demoChannel(t, demo, "Moderation notes")
+
+	body := get(srv, guild).Body.String()
+	if strings.Contains(body, staff.ID.String()) {
+		t.Errorf("the guild's page links the staff forum, which only moderators can see")
+	}
+	for _, path := range []string{
+		guild + "/" + staff.ID.String(),
+		guild + "/" + staff.ID.String() + "/" + notes.ID.String(),
+	} {
+		if rec := get(srv, path); rec.Code == http.StatusOK {
+			t.Errorf("%s is shown, but only moderators can see it", path)
+		}
+	}
+}
+
+// TestMismatchedPaths requests posts under forums that they aren't in, and
+// forums under guilds that they aren't in, which would show them with the
+// checks of another channel than theirs.
+func TestMismatchedPaths(t *testing.T) {
+	srv, demo := newTestServer(t)
+	guild := "/" + demo.guild.ID.String()
+	help := demoChannel(t, demo, "help")
+	staff := demoChannel(t, demo, "staff")
+	long := demoChannel(t, demo, "A long discussion")
+	notes := demoChannel(t, demo, "Moderation notes")
+	for _, path := range []string{
+		guild + "/" + staff.ID.String() + "/" + long.ID.String(),
+		guild + "/" + help.ID.String() + "/" + notes.ID.String(),
+		guild + "/" + help.ID.String() + "/" + help.ID.String(),
+		"/" + long.ID.String() + "/" + help.ID.String() + "/" + long.ID.String(),
+	} {
+		for _, suffix := range []string{"", "/meta.json", "/messages", "/card.png"} {
+			if rec := get(srv, path+suffix); rec.Code == http.StatusOK {
+				t.Errorf("%s is shown", path+suffix)
+			}
+		}
+	}
+}
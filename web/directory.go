@@ -0,0 +1,203 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// guildsPerPage is how many guilds each page of the directory lists.
+const guildsPerPage = 50
+
+// DirectoryGuild is a guild as the directory on the index page lists it.
+type DirectoryGuild struct {
+	ID   discord.GuildID
+	Name string
+	Icon string
+	// Path is the path of the guild's page.
+	Path string
+	// ForumCount is how many forums in the guild are served, and
+	// LastActive when the last post in them was made or replied to.
+	ForumCount int
+	LastActive time.Time
+}
+
+// guildSort is a way that the directory can be ordered, chosen with the
+// sort parameter.
+type guildSort struct {
+	Key  string
+	Name string
+	less func(a, b *DirectoryGuild) bool
+}
+
+var guildSorts = []guildSort{
+	{"active", "Recently active", func(a, b *DirectoryGuild) bool {
+		return a.LastActive.After(b.LastActive)
+	}},
+	{"name", "Name", func(a, b *DirectoryGuild) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}},
+	{"forums", "Most forums", func(a, b *DirectoryGuild) bool {
+		return a.ForumCount > b.ForumCount
+	}},
+}
+
+// directorySort returns the sort with the given key, or the first one if
+// there is none.
+func directorySort(key string) guildSort {
+	for _, s := range guildSorts {
+		if s.Key == key {
+			return s
+		}
+	}
+	return guildSorts[0]
+}
+
+// directory returns the guilds that are listed in the directory, which are
+// those that have forums that are served and that the operator didn't
+// unlist. Only what the bots already know of is looked at, so listing the
+// guilds doesn't fetch anything from Discord.
+func (s *Server) directory() ([]DirectoryGuild, error) {
+	guilds, err := s.bots.guilds()
+	if err != nil {
+		return nil, err
+	}
+	var listed []DirectoryGuild
+	for _, g := range guilds {
+		if s.guildConfig(g.ID).Unlisted {
+			continue
+		}
+		d, err := s.directoryGuild(g)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", g.ID, err)
+		}
+		if d.ForumCount > 0 {
+			listed = append(listed, d)
+		}
+	}
+	return listed, nil
+}
+
+func (s *Server) directoryGuild(g discord.Guild) (DirectoryGuild, error) {
+	d := DirectoryGuild{ID: g.ID, Name: g.Name, Icon: s.guildImageURL(g.ID, "icon", g.Icon), Path: s.guildPath(g.ID)}
+	channels, err := s.bots.forGuild(g.ID).Cabinet.Channels(g.ID)
+	if err != nil {
+		return d, err
+	}
+	forums := make(map[discord.ChannelID]bool)
+	for _, ch := range channels {
+		if ch.Type != discord.GuildForum {
+			continue
+		}
+		if !s.canServe(&ch) {
+			continue
+		}
+		forums[ch.ID] = true
+		d.ForumCount++
+		if ch.LastMessageID.IsValid() && ch.LastMessageID.Time().After(d.LastActive) {
+			d.LastActive = ch.LastMessageID.Time()
+		}
+	}
+	for _, ch := range channels {
+		if ch.Type == discord.GuildPublicThread && forums[ch.ParentID] &&
+			ch.LastMessageID.IsValid() && ch.LastMessageID.Time().After(d.LastActive) {
+			d.LastActive = ch.LastMessageID.Time()
+		}
+	}
+	return d, nil
+}
+
+// searchDirectory returns the guilds whose names contain every word of
+// query, ignoring case.
+func searchDirectory(guilds []DirectoryGuild, query string) []DirectoryGuild {
+	words := strings.Fields(strings.ToLower(query))
+	var found []DirectoryGuild
+	for _, g := range guilds {
+		name := strings.ToLower(g.Name)
+		match := true
+		for _, w := range words {
+			if !strings.Contains(name, w) {
+				match = false
+				break
+			}
+		}
+		if match {
+			found = append(found, g)
+		}
+	}
+	return found
+}
+
+func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
+	guilds, err := s.bots.guilds()
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	ctx := IndexContext{
+		Page:       s.page(w, r),
+		GuildCount: len(guilds),
+		Query:      strings.TrimSpace(r.URL.Query().Get("q")),
+		Sorts:      guildSorts,
+	}
+	sorting := directorySort(r.URL.Query().Get("sort"))
+	ctx.Sort = sorting.Key
+	listed, err := s.directory()
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if ctx.Query != "" {
+		listed = searchDirectory(listed, ctx.Query)
+		// Searches are for readers, and would only be duplicates of the
+		// directory for search engines.
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		return sorting.less(&listed[i], &listed[j])
+	})
+	pageQuery := url.Values{}
+	if ctx.Query != "" {
+		pageQuery.Set("q", ctx.Query)
+	}
+	if ctx.Sort != guildSorts[0].Key {
+		pageQuery.Set("sort", ctx.Sort)
+	}
+	if len(pageQuery) > 0 {
+		ctx.PageQuery = pageQuery.Encode() + "&"
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	ctx.GuildsFound = len(listed)
+	ctx.Pages = pageCount(len(listed), guildsPerPage)
+	ctx.PageNumbers = pageWindow(page, ctx.Pages, 2)
+	if page > 1 {
+		ctx.Prev = page - 1
+	}
+	if len(listed) > page*guildsPerPage {
+		ctx.Next = page + 1
+		ctx.Guilds = listed[(page-1)*guildsPerPage : page*guildsPerPage]
+	} else if len(listed) >= (page-1)*guildsPerPage {
+		ctx.Guilds = listed[(page-1)*guildsPerPage:]
+	}
+	ctx.Meta.PageNumber = page
+	pageURL := func(n int) string {
+		return fmt.Sprintf("%s/?%spage=%d", ctx.SiteURL, ctx.PageQuery, n)
+	}
+	if ctx.Prev != 0 {
+		ctx.Meta.Prev = pageURL(ctx.Prev)
+	}
+	if ctx.Next != 0 {
+		ctx.Meta.Next = pageURL(ctx.Next)
+	}
+	setPageLinks(w, ctx.Meta)
+	s.executeTemplate(w, r, "index.gohtml", ctx)
+}
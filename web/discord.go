@@ -0,0 +1,76 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+func (s *Server) channel(channelID discord.ChannelID) (*discord.Channel, error) {
+	s.fetchedInactiveMu.Lock()
+	defer s.fetchedInactiveMu.Unlock()
+	channel, err := s.bots.forChannel(channelID).Channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+func (s *Server) channels(guildID discord.GuildID) ([]discord.Channel, error) {
+	s.fetchedInactiveMu.Lock()
+	defer s.fetchedInactiveMu.Unlock()
+	st := s.bots.forGuild(guildID)
+	channels, err := st.Channels(guildID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		if channels[i].Flags^channels[j].Flags&discord.PinnedThread != 0 {
+			return channels[i].Flags&discord.PinnedThread != 0
+		}
+		return channels[i].LastMessageID.Time().After(channels[j].LastMessageID.Time())
+	})
+	guild, _ := st.Cabinet.Guild(guildID)
+	selfMember, err := s.selfMember(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get self as member: %w", err)
+	}
+	for _, ch := range channels {
+		if ch.Type != discord.GuildForum {
+			continue
+		}
+		if _, ok := s.fetchedInactive[ch.ID]; ok {
+			continue
+		}
+		perms := discord.CalcOverwrites(*guild, ch, *selfMember)
+		if !perms.Has(0 |
+			discord.PermissionReadMessageHistory |
+			discord.PermissionViewChannel) {
+			continue
+		}
+		var before discord.Timestamp
+		for {
+			threads, err := st.PublicArchivedThreads(ch.ID, before, 0)
+			if err != nil && !s.gateways.connected(s.bots.index(st)) {
+				// Discord is likely having an outage, so serve what is
+				// cached and try again later.
+				log.Printf("Error fetching archived posts of %s: %v", ch.ID, err)
+				return channels, nil
+			} else if err != nil {
+				return nil, err
+			}
+			for _, t := range threads.Threads {
+				st.Cabinet.ChannelStore.ChannelSet(&t, false)
+				channels = append(channels, t)
+			}
+			if !threads.More {
+				break
+			}
+			before = threads.Threads[len(threads.Threads)-1].ThreadMetadata.ArchiveTimestamp
+		}
+		s.fetchedInactive[ch.ID] = struct{}{}
+	}
+	return channels, nil
+}
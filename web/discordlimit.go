@@ -0,0 +1,62 @@
+package web
+
+import (
+	"math"
+
+	"github.com/diamondburned/arikawa/v3/utils/httputil/httpdriver"
+	"golang.org/x/time/rate"
+)
+
+// discordLimiter limits the requests that all the bots make to Discord's
+// REST API together. Discord's own rate limits are per bot and per route,
+// and arikawa keeps to them, but Cloudflare bans addresses that make too
+// many requests overall, which a burst of pages that aren't cached can.
+type discordLimiter struct {
+	// slots has room for as many requests as may be made at once, or is
+	// nil if that isn't limited.
+	slots chan struct{}
+	// rate limits how many requests are made a second, or is nil if that
+	// isn't limited.
+	rate *rate.Limiter
+}
+
+// newDiscordLimiter returns a limiter of requests to Discord that lets
+// concurrency of them be made at once and perSecond of them a second, or
+// nil if neither is limited.
+func newDiscordLimiter(concurrency int, perSecond float64) *discordLimiter {
+	if concurrency <= 0 && perSecond <= 0 {
+		return nil
+	}
+	l := &discordLimiter{}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	if perSecond > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(perSecond), int(math.Ceil(perSecond)))
+	}
+	return l
+}
+
+// limitedClient makes requests to Discord once its limiter lets it.
+type limitedClient struct {
+	httpdriver.Client
+	limiter *discordLimiter
+}
+
+func (c limitedClient) Do(req httpdriver.Request) (httpdriver.Response, error) {
+	ctx := req.GetContext()
+	if c.limiter.slots != nil {
+		select {
+		case c.limiter.slots <- struct{}{}:
+			defer func() { <-c.limiter.slots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if c.limiter.rate != nil {
+		if err := c.limiter.rate.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.Client.Do(req)
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// domainPaths are the top level paths that custom domains serve as they
+// are, rather than as paths of their guild's pages. The pages that list
+// every guild, like /recent, aren't among them.
+var domainPaths = map[string]bool{
+	"static":      true,
+	"sitemap":     true,
+	"sitemap.xml": true,
+	"media":       true,
+	"privacy":     true,
+	"tos":         true,
+	"confirm-age": true,
+	"embed":       true,
+	"avatars":     true,
+	"branding":    true,
+	"report":      true,
+}
+
+type hostGuildKey struct{}
+
+// validDomain reports whether d is a host name that a guild can be served
+// under, without a scheme, port or path.
+func validDomain(d string) bool {
+	if d == "" || d != strings.ToLower(d) || strings.ContainsAny(d, ":/") {
+		return false
+	}
+	u, err := url.Parse("https://" + d)
+	return err == nil && u.Host == d
+}
+
+// guildDomains maps the configured custom domains to their guilds.
+func guildDomains(guilds map[discord.GuildID]GuildConfig) (map[string]discord.GuildID, error) {
+	domains := make(map[string]discord.GuildID)
+	for id, cfg := range guilds {
+		if cfg.Domain == "" {
+			continue
+		}
+		if other, ok := domains[cfg.Domain]; ok {
+			return nil, fmt.Errorf("guilds %s and %s have the same domain %q", other, id, cfg.Domain)
+		}
+		domains[cfg.Domain] = id
+	}
+	return domains, nil
+}
+
+// resolveDomains is a middleware that serves the custom domains of guilds,
+// which have the guild's pages at their root: the paths of requests to them
+// get the guild's path put in front, so that /123/456 on a guild's domain
+// is its post 456 in forum 123. Links to the guild's pages by its usual
+// path are redirected to its domain's.
+func (s *Server) resolveDomains(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		id, ok := s.site().domains[strings.ToLower(host)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		first, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if first == id.String() || (first != "" && first == s.guildSlug(id)) {
+			u := url.URL{Path: "/" + rest, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), hostGuildKey{}, id))
+		if !domainPaths[first] {
+			u := *r.URL
+			u.Path = strings.TrimSuffix("/"+id.String()+r.URL.Path, "/")
+			u.RawPath = ""
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostGuild returns the guild whose custom domain a request was made to.
+func hostGuild(r *http.Request) (discord.GuildID, bool) {
+	id, ok := r.Context().Value(hostGuildKey{}).(discord.GuildID)
+	return id, ok
+}
+
+// domainURL returns the URL of the root of a custom domain, using the
+// scheme of SiteURL.
+func (s *Server) domainURL(domain string) string {
+	scheme := "https"
+	if u, err := url.Parse(s.site().URL); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	return scheme + "://" + domain
+}
+
+// requestGuildPath is guildPath for the links of a page: on a guild's custom
+// domain, the guild's pages are at the root, so its path is "".
+func (s *Server) requestGuildPath(r *http.Request, id discord.GuildID) string {
+	if host, ok := hostGuild(r); ok && host == id {
+		return ""
+	}
+	return s.guildPath(id)
+}
+
+// guildURL returns the URL of a guild's page, which is on its custom domain
+// if it has one.
+func (s *Server) guildURL(id discord.GuildID) string {
+	if d := s.guildConfig(id).Domain; d != "" {
+		return s.domainURL(d)
+	}
+	return s.site().URL + s.guildPath(id)
+}
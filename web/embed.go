@@ -0,0 +1,104 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// embedCSP is the Content-Security-Policy of embedded messages. They are
+// shown inside other sites, so nothing is allowed to run and only images
+// and the site's own stylesheet are loaded. Any site may frame them.
+const embedCSP = "default-src 'none'; img-src 'self' https:; style-src 'self'; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors *"
+
+// getEmbed renders a single message on its own, for other sites to quote in
+// an iframe.
+func (s *Server) getEmbed(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	sf, err := discord.ParseSnowflake(chi.URLParam(r, "messageID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	msgID := discord.MessageID(sf)
+	msgs, _, _, err := s.messageCache.MessagesAfter(r.Context(), post.ID, msgID-1, 1)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching message: %w", err))
+		return
+	}
+	if len(msgs) == 0 || msgs[0].ID != msgID {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	if err := s.ensureMembers(r.Context(), *post, msgs); err != nil {
+		log.Printf("Error looking up members of %s: %v", post.ID, err)
+	}
+	restrictRole, err := s.consentRole(forum)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	forwarded, err := s.snapshots(r.Context(), post, msgs)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching forwarded messages: %w", err))
+		return
+	}
+	m := msgs[0]
+	m.GuildID = guild.ID
+	auth := s.author(m)
+	if !consented(auth, restrictRole) {
+		s.displayErr(w, r, http.StatusForbidden, errNoConsent)
+		return
+	}
+	page := s.guildPage(w, r, guild.ID)
+	ctx := struct {
+		Page
+		Guild   *discord.Guild
+		Forum   *discord.Channel
+		Post    *discord.Channel
+		Author  Author
+		Message Message
+		// Link is where the message can be read in context.
+		Link        string
+		ServiceName string
+	}{
+		Page:        page,
+		Guild:       guild,
+		Forum:       forum,
+		Post:        post,
+		Author:      auth,
+		Message:     s.message(m, page.Locale),
+		Link:        fmt.Sprintf("%s%s/%s/%s?after=%s", page.SiteURL, page.GuildPath, forum.ID, post.ID, msgID-1),
+		ServiceName: s.site().ServiceName,
+	}
+	if !ctx.Message.Anonymized {
+		for _, fwd := range forwarded[m.ID] {
+			fwd.GuildID = guild.ID
+			ctx.Message.Forwarded = append(ctx.Message.Forwarded, s.message(fwd, page.Locale))
+		}
+	}
+	shown := []*Message{&ctx.Message}
+	for i := range ctx.Message.Forwarded {
+		shown = append(shown, &ctx.Message.Forwarded[i])
+	}
+	s.addInvites(shown)
+	w.Header().Set("Content-Security-Policy", embedCSP)
+	s.executeTemplate(w, r, "embed.gohtml", ctx)
+}
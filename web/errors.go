@@ -0,0 +1,70 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// readerError is an error that readers are shown an explanation of, with
+// what they can do about it, instead of the error itself. Its title and
+// message are in English and translated on the error page.
+type readerError struct {
+	status  int
+	title   string
+	message string
+}
+
+func (e *readerError) Error() string {
+	return e.message
+}
+
+var (
+	errGuildNotServed = &readerError{http.StatusNotFound, "Guild not served",
+		"This guild isn't archived here. The bot may have been removed from it, or it was never added."}
+	errThreadPrivate = &readerError{http.StatusForbidden, "Private post",
+		"This post is private, so it can only be read on Discord by those who were added to it."}
+	errChannelPrivate = &readerError{http.StatusForbidden, "Private channel",
+		"This channel isn't open to everyone on Discord, so it isn't archived here."}
+	errNotForumPost = &readerError{http.StatusNotFound, "Not Found",
+		"Only posts in forum channels are archived here."}
+	errRateLimited = &readerError{http.StatusTooManyRequests, "Too Many Requests",
+		"You are loading pages faster than this instance allows. Wait a few seconds and try again."}
+	errGatewayDown = &readerError{http.StatusServiceUnavailable, "Disconnected from Discord",
+		"This instance has lost its connection to Discord and can't load this page until it is back. Try again in a few minutes."}
+	errDiscordSlow = &readerError{http.StatusGatewayTimeout, "Gateway Timeout",
+		"Discord is slow to answer right now, try again in a moment."}
+	errNSFW = &readerError{http.StatusForbidden, "NSFW content is not served",
+		"This forum is marked as NSFW, and this instance doesn't show NSFW forums."}
+	errAgeUnconfirmed = &readerError{http.StatusForbidden, "NSFW content",
+		"This file is from a forum marked as NSFW. Confirm your age on the forum's page to see it."}
+	errNoConsent = &readerError{http.StatusForbidden, "Forbidden",
+		"One or more users in this post did not consent to their post being shown."}
+	errInvalidAsOf = &readerError{http.StatusBadRequest, "Bad Request",
+		"The time to show the post as of should be a date like 2006-01-02."}
+	errInvalidOrder = &readerError{http.StatusBadRequest, "Bad Request",
+		"Messages can only be shown in asc or desc order."}
+	errInvalidRange = &readerError{http.StatusBadRequest, "Bad Request",
+		"A range of messages goes from the ID of its first message to the ID of its last one."}
+	errZipTooLarge = &readerError{http.StatusForbidden, "Too large",
+		"The attachments of this post are too large to be downloaded at once."}
+	errInvalidAfter = &readerError{http.StatusBadRequest, "Bad Request",
+		"New messages are polled for after the ID of the last message that was shown."}
+)
+
+// asReaderError returns the explanation readers are given of an error
+// that a page failed with, or nil if there is none for it. Server errors
+// while a gateway is disconnected are put down to that, since that is
+// usually why they happen.
+func (s *Server) asReaderError(status int, err error) *readerError {
+	var rerr *readerError
+	switch {
+	case errors.As(err, &rerr):
+		return rerr
+	case errors.Is(err, context.DeadlineExceeded):
+		return errDiscordSlow
+	case status >= 500 && s.gateways.degraded():
+		return errGatewayDown
+	}
+	return nil
+}
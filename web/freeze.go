@@ -0,0 +1,282 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// frozenGuilds holds the guilds whose archive was frozen with the freeze
+// command, and when. Posts in them are only served from the database, and
+// events from Discord no longer change them.
+type frozenGuilds struct {
+	mu sync.RWMutex
+	at map[discord.GuildID]time.Time
+}
+
+func newFrozenGuilds(at map[discord.GuildID]time.Time) *frozenGuilds {
+	return &frozenGuilds{at: at}
+}
+
+func (f *frozenGuilds) frozenAt(id discord.GuildID) (time.Time, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	t, ok := f.at[id]
+	return t, ok
+}
+
+func (f *frozenGuilds) isFrozen(id discord.GuildID) bool {
+	_, ok := f.frozenAt(id)
+	return ok
+}
+
+func (f *frozenGuilds) set(id discord.GuildID, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.at[id] = t
+}
+
+// freezeGuild archives the whole of a guild, marks it as frozen and writes
+// a static export of its pages to dir. A running instance picks up the
+// freeze when it is restarted.
+func (s *Server) freezeGuild(ctx context.Context, id discord.GuildID, dir string) error {
+	guild, err := s.bots.forGuild(id).Cabinet.Guild(id)
+	if err != nil {
+		return fmt.Errorf("fetching guild: %w", err)
+	}
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		return fmt.Errorf("fetching guild channels: %w", err)
+	}
+	forums := make(map[discord.ChannelID]bool)
+	for _, ch := range channels {
+		if ch.Type == discord.GuildForum && s.canServe(&ch) {
+			forums[ch.ID] = true
+		}
+	}
+	for _, ch := range channels {
+		if !forums[ch.ParentID] || ch.Type != discord.GuildPublicThread {
+			continue
+		}
+		log.Printf("Archiving %s (%s)", ch.Name, ch.ID)
+		if err := s.messageCache.Sync(ctx, ch.ID); err != nil {
+			return fmt.Errorf("archiving %s: %w", ch.ID, err)
+		}
+	}
+	now := time.Now().UTC()
+	if err := s.db.FreezeGuild(ctx, guild.ID, now); err != nil {
+		return fmt.Errorf("marking guild as frozen: %w", err)
+	}
+	s.frozen.set(guild.ID, now)
+	// Posts that were already looked at before the freeze still have
+	// their unfrozen state cached.
+	s.messageCache.ForgetAll()
+	log.Printf("Froze %s at %s, exporting to %s", guild.Name, now.Format(time.RFC3339), dir)
+	_, err = s.exportGuild(ctx, guild.ID, dirExport(dir))
+	return err
+}
+
+// exportWriter writes a file of an export, named by its path from the root
+// of the export.
+type exportWriter func(name string, b []byte) error
+
+// dirExport returns an exportWriter that writes files to a directory.
+func dirExport(dir string) exportWriter {
+	return func(name string, b []byte) error {
+		return writeExportFile(dir, name, bytes.NewReader(b))
+	}
+}
+
+// exportLinkRegex matches the links in a page that the export follows or
+// rewrites.
+var exportLinkRegex = regexp.MustCompile(`(href|src|action)=["']([^"']*)["']`)
+
+// exportGuild writes a guild's pages as static files, which link each
+// other relatively, so that they can be served from any web server or
+// opened from disk. Pages are found by following links from the guild's
+// page. Post pagination, which uses query parameters, is written to
+// separate files and the links rewritten. Searches link to searchPath,
+// which the export doesn't have a page for, and the media proxy needs
+// the server, so it isn't exported. It returns the files of the pages of
+// posts that were exported, keyed by the posts' IDs. Pages are requested
+// like the warmer requests them, so that they aren't rate limited or
+// counted as views. Linked pages that aren't found are left out, but any
+// other error fails the export rather than leaving a hole in it.
+func (s *Server) exportGuild(ctx context.Context, id discord.GuildID, write exportWriter) (map[discord.ChannelID]string, error) {
+	if err := s.exportStatic(write); err != nil {
+		return nil, fmt.Errorf("exporting static files: %w", err)
+	}
+	prefix := s.guildPath(id)
+	posts := make(map[discord.ChannelID]string)
+	seen := map[string]bool{prefix + "/index.html": true}
+	queue := []string{prefix}
+	ctx = context.WithValue(ctx, renderingKey{}, true)
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page := queue[0]
+		queue = queue[1:]
+		req := httptest.NewRequest(http.MethodGet, page, nil).WithContext(ctx)
+		req.RemoteAddr = ""
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		switch rec.Code {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			log.Printf("Skipping %s in export: not found", page)
+			continue
+		default:
+			return nil, fmt.Errorf("exporting %s: %d %s", page, rec.Code, http.StatusText(rec.Code))
+		}
+		base, _ := url.Parse(page)
+		file, _ := exportFile(base)
+		body := exportLinks(rec.Body.String(), prefix, base, file, func(u *url.URL, linked string) {
+			if !seen[linked] {
+				seen[linked] = true
+				queue = append(queue, u.RequestURI())
+			}
+		})
+		if err := write(file, []byte(body)); err != nil {
+			return nil, err
+		}
+		if id, ok := exportedPost(prefix, base); ok {
+			posts[id] = file
+		}
+	}
+	return posts, nil
+}
+
+// searchPath is the path, under a guild's, of the page of an export that
+// searches the guild's posts.
+const searchPath = "/search/index.html"
+
+// exportLinks rewrites the links of a page of the export of the guild at
+// prefix, found at base and exported to file, to the files they are
+// exported to, relative to file. follow is called with the pages of the
+// guild that are linked.
+func exportLinks(body, prefix string, base *url.URL, file string, follow func(u *url.URL, linked string)) string {
+	return exportLinkRegex.ReplaceAllStringFunc(body, func(attr string) string {
+		m := exportLinkRegex.FindStringSubmatch(attr)
+		u, err := base.Parse(strings.ReplaceAll(m[2], "&amp;", "&"))
+		if err != nil || u.Host != "" {
+			return attr
+		}
+		var linked string
+		switch {
+		case strings.HasPrefix(u.Path, "/static/"):
+			linked = u.Path
+		case !strings.HasPrefix(u.Path, prefix):
+			return attr
+		case strings.HasSuffix(u.Path, "/search"):
+			linked = prefix + searchPath
+		case m[1] != "href":
+			return attr
+		default:
+			var ok bool
+			if linked, ok = exportFile(u); !ok {
+				return attr
+			}
+			follow(u, linked)
+		}
+		return m[1] + `="` + exportRelative(file, linked) + `"`
+	})
+}
+
+// exportedPost returns the ID of the post whose first page is at u, if it
+// is one.
+func exportedPost(prefix string, u *url.URL) (discord.ChannelID, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(u.Path, prefix), "/"), "/")
+	if u.RawQuery != "" || len(parts) != 2 {
+		return 0, false
+	}
+	var ids [2]discord.Snowflake
+	for i, part := range parts {
+		sf, err := discord.ParseSnowflake(part)
+		if err != nil {
+			return 0, false
+		}
+		ids[i] = sf
+	}
+	return discord.ChannelID(ids[1]), true
+}
+
+// exportRelative returns the link from one file of an export to another.
+func exportRelative(from, to string) string {
+	rel, err := filepath.Rel(path.Dir(from), to)
+	if err != nil {
+		return to
+	}
+	return filepath.ToSlash(rel)
+}
+
+// exportFile returns the path of the file a page is exported to.
+func exportFile(u *url.URL) (string, bool) {
+	p := strings.TrimSuffix(u.Path, "/")
+	if u.RawQuery == "" {
+		return p + "/index.html", true
+	}
+	q := u.Query()
+	if len(q) != 1 {
+		return "", false
+	}
+	for _, key := range []string{"before", "after"} {
+		if v := q.Get(key); v != "" {
+			if _, err := discord.ParseSnowflake(v); err != nil {
+				return "", false
+			}
+			return p + "/" + key + "-" + v + ".html", true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) exportStatic(write exportWriter) error {
+	return fs.WalkDir(s.fsys, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(s.fsys, p)
+		if err != nil {
+			return err
+		}
+		if err := write("/"+p, b); err != nil {
+			return err
+		}
+		// Pages link to the fingerprinted copies.
+		if fp, ok := s.assets.paths[strings.TrimPrefix(p, "static/")]; ok {
+			return write(fp, b)
+		}
+		return nil
+	})
+}
+
+func writeExportFile(dir, name string, r io.Reader) error {
+	p := filepath.Join(dir, filepath.FromSlash(path.Clean(name)))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	err := writeFileAtomic(p, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, 0644)
+}
@@ -0,0 +1,165 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// funcMap holds the functions that templates can call. Functions are added
+// with addTemplateFunc, and each is described where it is added, so that
+// the list below is what template authors have to work with.
+var funcMap = template.FuncMap{}
+
+// addTemplateFunc adds a function for templates to call. A name can only be
+// added once, so that a function isn't replaced by mistake by another with
+// the same name.
+func addTemplateFunc(name string, fn any) {
+	if _, ok := funcMap[name]; ok {
+		panic("template function " + name + " added twice")
+	}
+	funcMap[name] = fn
+}
+
+func init() {
+	// t translates a string: {{t .Locale "%d posts" 3}}.
+	addTemplateFunc("t", (*Locale).T)
+	// plural translates the first string for a count of 1 and the second
+	// for any other, formatted with the count: {{plural .Locale .N "%d
+	// reply" "%d replies"}}.
+	addTemplateFunc("plural", plural)
+	// date, longdate and timestamp format times in a locale, the last like
+	// Discord's timestamps with one of their styles.
+	addTemplateFunc("date", (*Locale).Date)
+	addTemplateFunc("longdate", (*Locale).LongDate)
+	addTemplateFunc("timestamp", (*Locale).Timestamp)
+	// month names a month with its year, as in "Mar 2024".
+	addTemplateFunc("month", (*Locale).Month)
+	// duration describes a length of time roughly, as in "3 days".
+	addTemplateFunc("duration", duration)
+	// bytes describes a size, as in "1.4 MB".
+	addTemplateFunc("bytes", byteSize)
+	// truncate shortens text to at most n characters, cutting it between
+	// words: {{truncate 80 .Content}}. TrimForMeta does it for meta tags.
+	addTemplateFunc("truncate", truncate)
+	addTemplateFunc("TrimForMeta", TrimForMeta)
+	// snowflakeTime returns when a Discord ID was made, which for messages
+	// and posts is when they were posted.
+	addTemplateFunc("snowflakeTime", snowflakeTime)
+	// hexColor returns a color from Discord, such as a role's, as #rrggbb.
+	addTemplateFunc("hexColor", hexColor)
+	// forumPath, postPath and messagePath return the paths of pages in a
+	// guild, from the guild's path: {{postPath $.GuildPath $.Forum.ID .ID}}.
+	addTemplateFunc("forumPath", forumPath)
+	addTemplateFunc("postPath", postPath)
+	addTemplateFunc("messagePath", messagePath)
+	// asset returns the path of a static file. It is replaced with the path
+	// function of the fingerprinted static files once they are loaded.
+	addTemplateFunc("asset", noAssets.path)
+}
+
+// Trim a string to 128 characters, for meta tags.
+func TrimForMeta(value string) string {
+	if len(value) <= 127 {
+		return value
+	}
+	return value[:128] + "..."
+}
+
+func plural(l *Locale, n int, one, other string) string {
+	if n == 1 {
+		return l.T(one, n)
+	}
+	return l.T(other, n)
+}
+
+// duration rounds d down to the largest unit it has at least one of.
+func duration(l *Locale, d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d >= 365*day:
+		return plural(l, int(d/(365*day)), "%d year", "%d years")
+	case d >= 30*day:
+		return plural(l, int(d/(30*day)), "%d month", "%d months")
+	case d >= day:
+		return plural(l, int(d/day), "%d day", "%d days")
+	case d >= time.Hour:
+		return plural(l, int(d/time.Hour), "%d hour", "%d hours")
+	case d >= time.Minute:
+		return plural(l, int(d/time.Minute), "%d minute", "%d minutes")
+	}
+	return l.T("less than a minute")
+}
+
+func byteSize(n int) string {
+	if n < 1000 {
+		return strconv.Itoa(n) + " B"
+	}
+	size := float64(n)
+	for _, unit := range []string{"kB", "MB", "GB"} {
+		size /= 1000
+		if size < 1000 || unit == "GB" {
+			return strconv.FormatFloat(size, 'f', 1, 64) + " " + unit
+		}
+	}
+	return ""
+}
+
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	// Leave room for the ellipsis.
+	cut, runes := 0, 0
+	for i := range s {
+		if runes == n-1 {
+			cut = i
+			break
+		}
+		runes++
+	}
+	// Back up to the last space before the cut, unless the first word is
+	// longer than n on its own.
+	if i := strings.LastIndexFunc(s[:cut], unicode.IsSpace); i > 0 {
+		cut = i
+	}
+	return strings.TrimRightFunc(s[:cut], unicode.IsSpace) + "…"
+}
+
+// snowflakeTime takes any kind of Discord ID, as well as one as a string.
+func snowflakeTime(id any) (time.Time, error) {
+	switch id := id.(type) {
+	case interface{ Time() time.Time }:
+		return id.Time(), nil
+	case string:
+		sf, err := discord.ParseSnowflake(id)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return sf.Time(), nil
+	}
+	return time.Time{}, fmt.Errorf("%T isn't a Discord ID", id)
+}
+
+func hexColor(c discord.Color) string {
+	return fmt.Sprintf("#%06x", c.Uint32()&0xffffff)
+}
+
+func forumPath(guildPath string, forum discord.ChannelID) string {
+	return guildPath + "/" + forum.String()
+}
+
+func postPath(guildPath string, forum, post discord.ChannelID) string {
+	return forumPath(guildPath, forum) + "/" + post.String()
+}
+
+// messagePath links to the page of a post that starts with a message.
+func messagePath(guildPath string, forum, post discord.ChannelID, msg discord.MessageID) string {
+	return postPath(guildPath, forum, post) + "?after=" + (msg - 1).String() + "#m" + msg.String()
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/utils/ws"
+)
+
+// gatewayStates tracks whether the gateway of each bot is connected. While
+// one isn't, the bot's caches miss the changes made in its guilds, so pages
+// are served from them with a banner saying that they may be out of date,
+// and nothing that needs the gateway is waited on.
+type gatewayStates struct {
+	mu   sync.Mutex
+	bots []gatewayState
+}
+
+type gatewayState struct {
+	connected bool
+	// since is when the gateway last connected or disconnected.
+	since time.Time
+	// attempts is how many times reconnecting failed since the gateway
+	// disconnected.
+	attempts int
+}
+
+func newGatewayStates(n int) *gatewayStates {
+	return &gatewayStates{bots: make([]gatewayState, n)}
+}
+
+// handler returns the event handler tracking the gateway of the i-th bot.
+// The gateway reconnects with backoff by itself, which is only logged here.
+func (g *gatewayStates) handler(i int) func(ev interface{}) {
+	return func(ev interface{}) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		st := &g.bots[i]
+		switch ev := ev.(type) {
+		case *gateway.ReadyEvent, *gateway.ResumedEvent:
+			if !st.connected && !st.since.IsZero() {
+				log.Printf("Gateway of bot %d reconnected after %s", i+1,
+					time.Since(st.since).Round(time.Second))
+			}
+			*st = gatewayState{connected: true, since: time.Now()}
+		case *ws.CloseEvent:
+			if st.connected || st.since.IsZero() {
+				log.Printf("Gateway of bot %d disconnected, reconnecting: %v", i+1, ev.Err)
+				*st = gatewayState{since: time.Now()}
+				return
+			}
+			st.attempts++
+			log.Printf("Gateway of bot %d failed to reconnect %d times in %s: %v", i+1,
+				st.attempts, time.Since(st.since).Round(time.Second), ev.Err)
+		}
+	}
+}
+
+// connected reports whether the gateway of the i-th bot is connected.
+func (g *gatewayStates) connected(i int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.bots[i].connected
+}
+
+// degraded reports whether the gateway of any bot is disconnected.
+func (g *gatewayStates) degraded() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, st := range g.bots {
+		if !st.connected {
+			return true
+		}
+	}
+	return false
+}
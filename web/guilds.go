@@ -0,0 +1,147 @@
+package web
+
+import (
+	"log"
+	"sync"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// handleGuildJoin primes the caches of a guild that a bot was added to, so
+// its archived posts are listed right away, and adds it to the sitemap.
+func (s *Server) handleGuildJoin(ev *state.GuildJoinEvent) {
+	log.Printf("Joined %s (%s)", ev.Name, ev.ID)
+	s.roles.invalidate(ev.ID)
+	go func() {
+		if _, err := s.channels(ev.ID); err != nil {
+			log.Printf("Error fetching channels of %s: %v", ev.ID, err)
+		}
+		s.markSitemapDirty(ev.ID)
+	}()
+}
+
+// handleGuildLeave forgets what was cached about a guild that a bot was
+// removed from, and drops it from the sitemap. The guild itself is removed
+// from the bot's cache by the state, but its channels aren't.
+func (s *Server) handleGuildLeave(st *state.State, ev *state.GuildLeaveEvent) {
+	log.Printf("Left %s", ev.ID)
+	channels, _ := st.Cabinet.Channels(ev.ID)
+	for i := range channels {
+		st.Cabinet.ChannelRemove(&channels[i])
+	}
+	if _, err := s.bots.forGuild(ev.ID).Cabinet.Guild(ev.ID); err == nil {
+		// Another bot is still in the guild and serves it from now on.
+		return
+	}
+	ids := make(map[discord.ChannelID]bool, len(channels))
+	for _, ch := range channels {
+		ids[ch.ID] = true
+	}
+	s.forgetChannels(ids)
+	s.roles.invalidate(ev.ID)
+	s.requestMembers.Lock()
+	delete(s.membersRequested, ev.ID)
+	s.requestMembers.Unlock()
+	s.users.forgetGuild(ev.ID)
+	s.guildStatsCache.forget(ev.ID)
+	s.markSitemapDirty(ev.ID)
+}
+
+// forgetChannels drops everything cached about channels besides the bots'
+// own caches, so that it is fetched again when next needed.
+func (s *Server) forgetChannels(ids map[discord.ChannelID]bool) {
+	s.fetchedInactiveMu.Lock()
+	for id := range ids {
+		delete(s.fetchedInactive, id)
+	}
+	s.fetchedInactiveMu.Unlock()
+	s.cards.mu.Lock()
+	for id := range ids {
+		s.cards.cards.Remove(id)
+	}
+	s.cards.mu.Unlock()
+	if s.warmer != nil {
+		s.warmer.forget(ids)
+	}
+	if s.renderCache != nil {
+		s.renderCache.forget(ids)
+	}
+	for id := range ids {
+		s.messageCache.Forget(id)
+	}
+}
+
+// memberCounts holds how many members each guild has. The bots' caches
+// don't keep the count that guilds are announced with, so it is kept here
+// and updated as members join and leave.
+type memberCounts struct {
+	mu     sync.Mutex
+	counts map[discord.GuildID]uint64
+}
+
+func newMemberCounts() *memberCounts {
+	return &memberCounts{counts: make(map[discord.GuildID]uint64)}
+}
+
+func (c *memberCounts) get(guildID discord.GuildID) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[guildID]
+}
+
+// handleMemberCount returns the handler updating the counts from the events of a bot.
+// Only the bot serving a guild counts its members, so they aren't counted
+// twice in guilds that several bots are in.
+func (s *Server) handleMemberCount(st *state.State) func(interface{}) {
+	c := s.members
+	return func(ev interface{}) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch ev := ev.(type) {
+		case *gateway.GuildCreateEvent:
+			if s.bots.forGuild(ev.ID) == st || c.counts[ev.ID] == 0 {
+				c.counts[ev.ID] = ev.MemberCount
+			}
+		case *gateway.GuildMemberAddEvent:
+			if s.bots.forGuild(ev.GuildID) == st {
+				c.counts[ev.GuildID]++
+			}
+		case *gateway.GuildMemberRemoveEvent:
+			if s.bots.forGuild(ev.GuildID) == st && c.counts[ev.GuildID] > 0 {
+				c.counts[ev.GuildID]--
+			}
+		}
+	}
+}
+
+// rulesMessages is how many messages of a guild's rules channel are shown
+// on its page.
+const rulesMessages = 10
+
+// rules returns the messages of a guild's rules channel, oldest first, or
+// nil if the guild has none or the bots can't read it.
+func (s *Server) rules(guild *discord.Guild) []discord.Message {
+	if !guild.RulesChannelID.IsValid() {
+		return nil
+	}
+	ch, err := s.channel(guild.RulesChannelID)
+	if err != nil || (ch.NSFW && !s.site().ServeNSFW) {
+		return nil
+	}
+	if !s.canServe(ch) {
+		return nil
+	}
+	msgs, err := s.bots.forGuild(guild.ID).Messages(ch.ID, rulesMessages)
+	if err != nil {
+		log.Printf("Error fetching rules of %s: %v", guild.ID, err)
+		return nil
+	}
+	rules := make([]discord.Message, len(msgs))
+	for i, m := range msgs {
+		m.GuildID = guild.ID
+		rules[len(msgs)-1-i] = m
+	}
+	return rules
+}
@@ -0,0 +1,252 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// guildStatsInterval is how often the statistics of guilds are computed
+// again, since counting their messages goes through all that are stored.
+const guildStatsInterval = 6 * time.Hour
+
+// topStats is how many posts and tags the statistics of a guild rank.
+const topStats = 10
+
+// GuildStats are the statistics of a guild's archive, computed from what is
+// stored of it.
+type GuildStats struct {
+	ComputedAt time.Time
+	Forums     []ForumChannel
+	// Months are the months from the first message stored of the guild to
+	// the last, with Chart showing them, and MostMessages the number of
+	// the month with the most.
+	Months       []database.MonthCount
+	Chart        []chartBar
+	MostMessages int
+	// ActivePosts are the posts with the most messages, and TopTags the
+	// tags applied to the most posts.
+	ActivePosts []Post
+	TopTags     []TagCount
+}
+
+// chartBar is the bar of a month in the chart of a guild's messages, in
+// the coordinates of the chart's viewBox.
+type chartBar struct {
+	X, Y, Width, Height int
+	database.MonthCount
+}
+
+// chartWidth and chartHeight are the size of the viewBox of the chart of a
+// guild's messages.
+const (
+	chartWidth  = 600
+	chartHeight = 150
+)
+
+// guildStatsCache holds the statistics last computed of each guild.
+type guildStatsCache struct {
+	mu    sync.Mutex
+	stats map[discord.GuildID]*GuildStats
+}
+
+func newGuildStatsCache() *guildStatsCache {
+	return &guildStatsCache{stats: make(map[discord.GuildID]*GuildStats)}
+}
+
+func (c *guildStatsCache) forget(id discord.GuildID) {
+	c.mu.Lock()
+	delete(c.stats, id)
+	c.mu.Unlock()
+}
+
+// UpdateGuildStats computes the statistics of every guild again every
+// guildStatsInterval. Guilds that are viewed before their statistics are
+// computed get them computed then.
+func (s *Server) UpdateGuildStats() {
+	ticker := time.NewTicker(guildStatsInterval)
+	for range ticker.C {
+		guilds, err := s.bots.guilds()
+		if err != nil {
+			log.Println("Error listing guilds to compute statistics:", err)
+			continue
+		}
+		for _, g := range guilds {
+			if _, err := s.computeGuildStats(context.Background(), g.ID); err != nil {
+				log.Printf("Error computing statistics of %s: %v", g.ID, err)
+			}
+		}
+	}
+}
+
+// guildStats returns the statistics of a guild, which are only computed
+// if they haven't been yet.
+func (s *Server) guildStats(ctx context.Context, id discord.GuildID) (*GuildStats, error) {
+	s.guildStatsCache.mu.Lock()
+	stats, ok := s.guildStatsCache.stats[id]
+	s.guildStatsCache.mu.Unlock()
+	if ok {
+		return stats, nil
+	}
+	return s.computeGuildStats(ctx, id)
+}
+
+func (s *Server) computeGuildStats(ctx context.Context, id discord.GuildID) (*GuildStats, error) {
+	if _, err := s.bots.forGuild(id).Cabinet.Guild(id); err != nil {
+		return nil, fmt.Errorf("fetching guild: %w", err)
+	}
+	channels, err := s.channels(id)
+	if err != nil {
+		return nil, fmt.Errorf("fetching guild channels: %w", err)
+	}
+	stats := &GuildStats{ComputedAt: time.Now()}
+	var posts []discord.ChannelID
+	tags := make(map[discord.TagID]*TagCount)
+	for _, forum := range channels {
+		// NSFW forums are left out, as they are of the sitemap, so that
+		// their posts' titles don't show without the age check.
+		if forum.Type != discord.GuildForum || forum.NSFW {
+			continue
+		}
+		if !s.canServe(&forum) {
+			continue
+		}
+		fc := newForumChannel(forum, channels)
+		stats.Forums = append(stats.Forums, fc)
+		for _, post := range fc.Posts {
+			posts = append(posts, post.ID)
+			stats.ActivePosts = append(stats.ActivePosts, Post{Channel: post, Tags: postTags(&forum, &post)})
+		}
+		for _, tag := range forum.AvailableTags {
+			tags[tag.ID] = &TagCount{Tag: tag}
+		}
+		for _, post := range fc.Posts {
+			for _, tag := range post.AppliedTags {
+				if tc, ok := tags[tag]; ok {
+					tc.Posts++
+				}
+			}
+		}
+	}
+	sort.SliceStable(stats.Forums, func(i, j int) bool {
+		return len(stats.Forums[i].Posts) > len(stats.Forums[j].Posts)
+	})
+	sort.SliceStable(stats.ActivePosts, func(i, j int) bool {
+		return stats.ActivePosts[i].MessageCount > stats.ActivePosts[j].MessageCount
+	})
+	if len(stats.ActivePosts) > topStats {
+		stats.ActivePosts = stats.ActivePosts[:topStats]
+	}
+	for _, tc := range tags {
+		if tc.Posts > 0 {
+			stats.TopTags = append(stats.TopTags, *tc)
+		}
+	}
+	sort.Slice(stats.TopTags, func(i, j int) bool {
+		if stats.TopTags[i].Posts != stats.TopTags[j].Posts {
+			return stats.TopTags[i].Posts > stats.TopTags[j].Posts
+		}
+		return stats.TopTags[i].Name < stats.TopTags[j].Name
+	})
+	if len(stats.TopTags) > topStats {
+		stats.TopTags = stats.TopTags[:topStats]
+	}
+	counts, err := s.db.MonthlyMessages(ctx, posts)
+	if err != nil {
+		return nil, err
+	}
+	stats.Months = fillMonths(counts)
+	stats.Chart, stats.MostMessages = chart(stats.Months)
+
+	s.guildStatsCache.mu.Lock()
+	s.guildStatsCache.stats[id] = stats
+	s.guildStatsCache.mu.Unlock()
+	return stats, nil
+}
+
+// fillMonths adds the months without messages between the ones counted,
+// so that gaps in a guild's activity show in its chart.
+func fillMonths(counts []database.MonthCount) []database.MonthCount {
+	if len(counts) == 0 {
+		return nil
+	}
+	var months []database.MonthCount
+	for m, i := counts[0].Month, 0; i < len(counts); m = m.AddDate(0, 1, 0) {
+		if counts[i].Month.Equal(m) {
+			months = append(months, counts[i])
+			i++
+		} else {
+			months = append(months, database.MonthCount{Month: m})
+		}
+	}
+	return months
+}
+
+// chart lays out the bars of the months in the chart of a guild's
+// messages, and returns them with the most messages of a month.
+func chart(months []database.MonthCount) ([]chartBar, int) {
+	var most int
+	for _, m := range months {
+		if m.Messages > most {
+			most = m.Messages
+		}
+	}
+	if most == 0 {
+		return nil, 0
+	}
+	bars := make([]chartBar, len(months))
+	// Bars wide enough for it are kept apart by a gap.
+	width := chartWidth / len(months)
+	if width > 2 {
+		width--
+	} else if width < 1 {
+		width = 1
+	}
+	for i, m := range months {
+		height := m.Messages * chartHeight / most
+		bars[i] = chartBar{
+			X:          i * chartWidth / len(months),
+			Y:          chartHeight - height,
+			Width:      width,
+			Height:     height,
+			MonthCount: m,
+		}
+	}
+	return bars, most
+}
+
+func (s *Server) getGuildStats(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	stats, err := s.guildStats(r.Context(), guild.ID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("computing statistics: %w", err))
+		return
+	}
+	ctx := struct {
+		Page
+		Guild *discord.Guild
+		Stats *GuildStats
+		// ChartWidth and ChartHeight are the size of the chart's viewBox.
+		ChartWidth, ChartHeight int
+	}{
+		Page:        s.guildPage(w, r, guild.ID),
+		Guild:       guild,
+		Stats:       stats,
+		ChartWidth:  chartWidth,
+		ChartHeight: chartHeight,
+	}
+	ctx.Meta.Breadcrumbs = append(s.breadcrumbs(r, guild, nil, nil),
+		Breadcrumb{Name: ctx.Locale.T("Statistics"), Path: ctx.GuildPath + "/stats"})
+	s.executeTemplate(w, r, "stats.gohtml", ctx)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/utils/httputil/httpdriver"
+)
+
+// ProjectURL is where dforum's source lives, used to identify it in the
+// User-Agent when no site URL is configured.
+const ProjectURL = "https://github.com/IoIxD/dforum"
+
+// version returns the version of dforum from the build info, which is
+// "(devel)" for builds outside of go install.
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// userAgent returns the User-Agent sent with requests to Discord. Discord
+// asks bots to use the form "DiscordBot ($url, $version)"; anything after
+// that is free-form.
+func userAgent(c Config) string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	url := c.SiteURL
+	if url == "" {
+		url = ProjectURL
+	}
+	ua := fmt.Sprintf("DiscordBot (%s, %s) dforum", url, version())
+	if c.ServiceName != "" {
+		ua += " " + c.ServiceName
+	}
+	if c.OperatorContact != "" {
+		ua += " (" + c.OperatorContact + ")"
+	}
+	return ua
+}
+
+// requestHeader returns the headers identifying the instance that are added
+// to every outgoing request.
+func requestHeader(c Config) http.Header {
+	h := http.Header{"User-Agent": {userAgent(c)}}
+	if c.OperatorContact != "" {
+		h.Set("From", c.OperatorContact)
+	}
+	return h
+}
+
+// setDiscordHeader makes the REST client send header with every request.
+func setDiscordHeader(c *api.Client, header http.Header) {
+	c.Session.UserAgent = header.Get("User-Agent")
+	c.Client.OnRequest = append(c.Client.OnRequest, func(r httpdriver.Request) error {
+		r.AddHeader(header)
+		return nil
+	})
+}
+
+// headerTransport adds a fixed set of headers to each request, for clients
+// fetching from Discord's CDN.
+type headerTransport struct {
+	http.RoundTripper
+	header http.Header
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func newHTTPClient(header http.Header, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: headerTransport{http.DefaultTransport, header},
+		Timeout:   timeout,
+	}
+}
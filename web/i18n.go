@@ -0,0 +1,229 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/naoina/toml"
+)
+
+// Locale is a set of translations for the site's interface, loaded from
+// locales/<tag>.toml in the resources. Strings are keyed by their English
+// text, so anything missing from a locale falls back to English.
+type Locale struct {
+	Tag  string `toml:"-"`
+	Name string
+	// The formats are time.Format layouts. English month and weekday names
+	// in the result are replaced by the locale's. DateFormat is used
+	// throughout the site, the others for the matching Discord timestamp
+	// styles.
+	DateFormat        string
+	TimeFormat        string // t
+	LongTimeFormat    string // T
+	NumericDateFormat string // d
+	DayFormat         string // D
+	LongDateFormat    string // f
+	FullDateFormat    string // F
+	Months            []string
+	ShortMonths       []string
+	Weekdays          []string
+	ShortWeekdays     []string
+	Strings           map[string]string
+
+	// Location is the timezone times are shown in. It is set per request,
+	// on a copy of the loaded locale.
+	Location *time.Location `toml:"-"`
+}
+
+// defaultFormats are the layouts used when a locale doesn't set its own.
+var defaultFormats = map[string]string{
+	"":  "Jan 2 2006 3:04 PM",
+	"t": "3:04 PM",
+	"T": "3:04:05 PM",
+	"d": "01/02/2006",
+	"D": "January 2, 2006",
+	"f": "January 2, 2006 3:04 PM",
+	"F": "Monday, January 2, 2006 3:04 PM",
+}
+
+type localeKey struct{}
+
+// loadLocales reads every locale in the resources.
+func loadLocales(fsys fs.FS) (map[string]*Locale, error) {
+	matches, err := fs.Glob(fsys, "locales/*.toml")
+	if err != nil {
+		return nil, err
+	}
+	locales := make(map[string]*Locale, len(matches))
+	for _, m := range matches {
+		b, err := fs.ReadFile(fsys, m)
+		if err != nil {
+			return nil, err
+		}
+		loc := &Locale{}
+		if err := toml.Unmarshal(b, loc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", m, err)
+		}
+		if len(loc.Months) != 0 && len(loc.Months) != 12 ||
+			len(loc.ShortMonths) != 0 && len(loc.ShortMonths) != 12 {
+			return nil, fmt.Errorf("%s: month lists must have 12 entries", m)
+		}
+		if len(loc.Weekdays) != 0 && len(loc.Weekdays) != 7 ||
+			len(loc.ShortWeekdays) != 0 && len(loc.ShortWeekdays) != 7 {
+			return nil, fmt.Errorf("%s: weekday lists must have 7 entries", m)
+		}
+		loc.Tag = strings.ToLower(strings.TrimSuffix(path.Base(m), ".toml"))
+		locales[loc.Tag] = loc
+	}
+	return locales, nil
+}
+
+// T translates key, formatting it with args like fmt.Sprintf if any are
+// given. It is safe to call on a nil Locale.
+func (l *Locale) T(key string, args ...any) string {
+	if l != nil {
+		if s, ok := l.Strings[key]; ok {
+			key = s
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(key, args...)
+	}
+	return key
+}
+
+// Format formats t in the locale's layout for a Discord timestamp style,
+// or its DateFormat if style is empty.
+func (l *Locale) Format(t time.Time, style string) string {
+	layout := defaultFormats[style]
+	if l == nil {
+		return t.Format(layout)
+	}
+	if l.Location != nil {
+		t = t.In(l.Location)
+	}
+	if f := l.format(style); f != "" {
+		layout = f
+	}
+	s := t.Format(layout)
+	month, weekday := t.Month().String(), t.Weekday().String()
+	switch {
+	case strings.Contains(layout, "January") && len(l.Months) == 12:
+		s = strings.Replace(s, month, l.Months[t.Month()-1], 1)
+	case strings.Contains(layout, "Jan") && len(l.ShortMonths) == 12:
+		s = strings.Replace(s, month[:3], l.ShortMonths[t.Month()-1], 1)
+	}
+	switch {
+	case strings.Contains(layout, "Monday") && len(l.Weekdays) == 7:
+		s = strings.Replace(s, weekday, l.Weekdays[t.Weekday()], 1)
+	case strings.Contains(layout, "Mon") && len(l.ShortWeekdays) == 7:
+		s = strings.Replace(s, weekday[:3], l.ShortWeekdays[t.Weekday()], 1)
+	}
+	return s
+}
+
+func (l *Locale) format(style string) string {
+	switch style {
+	case "t":
+		return l.TimeFormat
+	case "T":
+		return l.LongTimeFormat
+	case "d":
+		return l.NumericDateFormat
+	case "D":
+		return l.DayFormat
+	case "f":
+		return l.LongDateFormat
+	case "F":
+		return l.FullDateFormat
+	}
+	return l.DateFormat
+}
+
+// Date formats t in the locale's short date format.
+func (l *Locale) Date(t time.Time) string {
+	return l.Format(t, "")
+}
+
+// LongDate formats t in the locale's long date format.
+func (l *Locale) LongDate(t time.Time) string {
+	return l.Format(t, "f")
+}
+
+// Month names the month that t is in with its year, in the locale's short
+// month names. t isn't moved to the locale's timezone, since the months
+// that messages are counted in are UTC ones.
+func (l *Locale) Month(t time.Time) string {
+	name := t.Month().String()[:3]
+	if l != nil && len(l.ShortMonths) == 12 {
+		name = l.ShortMonths[t.Month()-1]
+	}
+	return fmt.Sprintf("%s %d", name, t.Year())
+}
+
+// matchLocale picks the best available locale for an Accept-Language
+// header, falling back to def.
+func matchLocale(locales map[string]*Locale, header string, def *Locale) *Locale {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if f, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				q = f
+			}
+		}
+		if tag != "" && q > 0 {
+			prefs = append(prefs, pref{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if loc, ok := locales[p.tag]; ok {
+			return loc
+		}
+		base, _, _ := strings.Cut(p.tag, "-")
+		if loc, ok := locales[base]; ok {
+			return loc
+		}
+	}
+	return def
+}
+
+// localize is a middleware that chooses the locale for the request from
+// its Accept-Language header, and the timezone to show times in.
+func (s *Server) localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loc := *matchLocale(s.locales, r.Header.Get("Accept-Language"), s.site().defaultLocale)
+		loc.Location = s.timezone(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, &loc)))
+	})
+}
+
+// varyLanguage marks a response as depending on the reader's language. Only
+// the responses that are localized are, so that caches keep one copy of
+// static files and media for every reader.
+func varyLanguage(w http.ResponseWriter) {
+	for _, v := range w.Header().Values("Vary") {
+		if v == "Accept-Language" {
+			return
+		}
+	}
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+func requestLocale(r *http.Request) *Locale {
+	loc, _ := r.Context().Value(localeKey{}).(*Locale)
+	return loc
+}
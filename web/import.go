@@ -0,0 +1,237 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// chatExport is a channel exported by DiscordChatExporter as JSON. Only
+// what the archive shows is read from it.
+type chatExport struct {
+	Guild struct {
+		ID   discord.GuildID `json:"id"`
+		Name string          `json:"name"`
+	} `json:"guild"`
+	Channel struct {
+		ID   discord.ChannelID `json:"id"`
+		Type string            `json:"type"`
+		Name string            `json:"name"`
+	} `json:"channel"`
+	// ExportedAt is missing from exports made by older versions.
+	ExportedAt *time.Time      `json:"exportedAt"`
+	Messages   []exportMessage `json:"messages"`
+}
+
+type exportMessage struct {
+	ID              discord.MessageID `json:"id"`
+	Type            string            `json:"type"`
+	Timestamp       time.Time         `json:"timestamp"`
+	TimestampEdited *time.Time        `json:"timestampEdited"`
+	IsPinned        bool              `json:"isPinned"`
+	Content         string            `json:"content"`
+	Author          exportUser        `json:"author"`
+	Attachments     []struct {
+		ID            discord.AttachmentID `json:"id"`
+		URL           string               `json:"url"`
+		FileName      string               `json:"fileName"`
+		FileSizeBytes uint64               `json:"fileSizeBytes"`
+	} `json:"attachments"`
+	Reactions []struct {
+		Emoji struct {
+			// ID is empty for Unicode emojis.
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			IsAnimated bool   `json:"isAnimated"`
+		} `json:"emoji"`
+		Count int `json:"count"`
+	} `json:"reactions"`
+	Mentions  []exportUser `json:"mentions"`
+	Reference *struct {
+		MessageID discord.MessageID `json:"messageId"`
+		ChannelID discord.ChannelID `json:"channelId"`
+		GuildID   discord.GuildID   `json:"guildId"`
+	} `json:"reference"`
+}
+
+type exportUser struct {
+	ID            discord.UserID `json:"id"`
+	Name          string         `json:"name"`
+	Discriminator string         `json:"discriminator"`
+	IsBot         bool           `json:"isBot"`
+	AvatarURL     string         `json:"avatarUrl"`
+}
+
+// exportMessageTypes are the types of messages in exports that the
+// archive shows, with the types Discord has for them.
+var exportMessageTypes = map[string]discord.MessageType{
+	"Default":              discord.DefaultMessage,
+	"Reply":                discord.InlinedReplyMessage,
+	"ChannelPinnedMessage": discord.ChannelPinnedMessage,
+	"GuildMemberJoin":      discord.GuildMemberJoinMessage,
+	"ThreadCreated":        discord.ThreadCreatedMessage,
+	"ChannelNameChange":    discord.ChannelNameChangeMessage,
+}
+
+// exportAvatarRegex matches the URLs of avatars on Discord's CDN, which is
+// what exports link unless they were made with their media downloaded.
+var exportAvatarRegex = regexp.MustCompile(`^https://cdn\.discordapp\.com/avatars/\d+/((a_)?[0-9a-f]{32})\.\w+`)
+
+// importExports stores the posts exported by DiscordChatExporter in the
+// files at paths, so that history from before the bot joined a guild, or
+// messages that were deleted from Discord since, are served under the
+// post's own guild and channel IDs. The posts themselves must still be on
+// Discord, since that is where what is shown of them, and whether they may
+// be shown at all, comes from. Messages that are already stored are kept,
+// and so are posts that were archived before, since what the bot fetched
+// itself is fresher than an export.
+func importExports(c Config, paths []string) error {
+	if !strings.HasPrefix(c.Database, "postgres://") {
+		return errors.New("option 'Database' does not begin with postgres://")
+	}
+	db, err := database.OpenPostgres(c.Database, databaseOptions(c))
+	if err != nil {
+		return fmt.Errorf("opening database connection: %w", err)
+	}
+	for _, p := range paths {
+		export, err := readChatExport(p)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		msgs, skipped := export.messages()
+		at := export.exportedAt()
+		if err := db.ImportMessages(context.Background(), export.Channel.ID, msgs, at); err != nil {
+			return fmt.Errorf("importing %s: %w", p, err)
+		}
+		log.Printf("Imported %d messages of %q (%s) in %q (%s)", len(msgs), export.Channel.Name, export.Channel.ID, export.Guild.Name, export.Guild.ID)
+		if skipped > 0 {
+			log.Printf("Skipped %d messages of %s that the archive doesn't show", skipped, export.Channel.ID)
+		}
+	}
+	log.Println("Purge the imported posts from a running server's cache with purge-cache for them to be served")
+	return nil
+}
+
+// readChatExport reads an export from a file, checking that it is of a
+// post that could be served.
+func readChatExport(p string) (*chatExport, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var export chatExport
+	if err := json.NewDecoder(f).Decode(&export); err != nil {
+		return nil, err
+	}
+	if !export.Guild.ID.IsValid() || !export.Channel.ID.IsValid() {
+		return nil, errors.New("not an export of a guild's channel")
+	}
+	// Forum posts are public threads, and the archive serves nothing else.
+	if export.Channel.Type != "GuildPublicThread" {
+		return nil, fmt.Errorf("channel %s is a %s, not a forum post", export.Channel.ID, export.Channel.Type)
+	}
+	return &export, nil
+}
+
+// exportedAt returns when an export was made. Exports that don't say are
+// taken to have been made when their last message was sent.
+func (e *chatExport) exportedAt() time.Time {
+	if e.ExportedAt != nil {
+		return *e.ExportedAt
+	}
+	var last time.Time
+	for _, m := range e.Messages {
+		if m.Timestamp.After(last) {
+			last = m.Timestamp
+		}
+	}
+	return last
+}
+
+// messages returns the messages of an export as Discord would have sent
+// them, oldest first, and how many were left out because the archive
+// doesn't show messages of their type.
+func (e *chatExport) messages() (msgs []discord.Message, skipped int) {
+	for _, em := range e.Messages {
+		typ, ok := exportMessageTypes[em.Type]
+		if !ok {
+			skipped++
+			continue
+		}
+		m := discord.Message{
+			ID:        em.ID,
+			ChannelID: e.Channel.ID,
+			GuildID:   e.Guild.ID,
+			Type:      typ,
+			Pinned:    em.IsPinned,
+			Author:    em.Author.user(),
+			Content:   em.Content,
+			Timestamp: discord.NewTimestamp(em.Timestamp),
+		}
+		if em.TimestampEdited != nil {
+			m.EditedTimestamp = discord.NewTimestamp(*em.TimestampEdited)
+		}
+		for _, u := range em.Mentions {
+			m.Mentions = append(m.Mentions, discord.GuildUser{User: u.user()})
+		}
+		for _, at := range em.Attachments {
+			// Exports made with their media downloaded link the files
+			// they were downloaded to, which can't be served.
+			if !strings.HasPrefix(at.URL, "https://") {
+				continue
+			}
+			m.Attachments = append(m.Attachments, discord.Attachment{
+				ID:          at.ID,
+				Filename:    at.FileName,
+				ContentType: mime.TypeByExtension(path.Ext(at.FileName)),
+				Size:        at.FileSizeBytes,
+				URL:         at.URL,
+				Proxy:       at.URL,
+			})
+		}
+		for _, r := range em.Reactions {
+			emoji := discord.Emoji{Name: r.Emoji.Name, Animated: r.Emoji.IsAnimated}
+			if sf, err := discord.ParseSnowflake(r.Emoji.ID); err == nil {
+				emoji.ID = discord.EmojiID(sf)
+			}
+			m.Reactions = append(m.Reactions, discord.Reaction{Count: r.Count, Emoji: emoji})
+		}
+		if em.Reference != nil {
+			m.Reference = &discord.MessageReference{
+				MessageID: em.Reference.MessageID,
+				ChannelID: em.Reference.ChannelID,
+				GuildID:   em.Reference.GuildID,
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs, skipped
+}
+
+// user returns an exported user as Discord would have sent them.
+func (u exportUser) user() discord.User {
+	user := discord.User{
+		ID:            u.ID,
+		Username:      u.Name,
+		Discriminator: u.Discriminator,
+		Bot:           u.IsBot,
+	}
+	if m := exportAvatarRegex.FindStringSubmatch(u.AvatarURL); m != nil {
+		user.Avatar = m[1]
+	}
+	return user
+}
@@ -0,0 +1,167 @@
+package web
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/IoIxD/dforum/cache"
+)
+
+// inviteRegex matches the invite links in messages, with the invite's code
+// as its group.
+var inviteRegex = regexp.MustCompile(`(?:https?://)?(?:www\.)?(?:discord\.gg|discord(?:app)?\.com/invite)/([A-Za-z0-9-]+)`)
+
+// maxInvitesPerMessage is how many of the invites in a message are shown,
+// and maxInvitesPerPage how many are looked up for a page, which bounds how
+// long rendering it waits on Discord for the ones that aren't cached.
+const (
+	maxInvitesPerMessage = 3
+	maxInvitesPerPage    = 10
+)
+
+// maxCachedInvites is how many resolved invites are kept, and
+// inviteCacheTTL is how long they are kept before being resolved again.
+const (
+	maxCachedInvites = 1000
+	inviteCacheTTL   = time.Hour
+)
+
+// InvitePreview is the guild that an invite in a message leads to.
+type InvitePreview struct {
+	URL       string
+	GuildName string
+	// Icon is the URL of the guild's icon, if it has one.
+	Icon    string
+	Members uint
+}
+
+// inviteCache holds the invites resolved over REST.
+type inviteCache struct {
+	mu      sync.Mutex
+	invites *cache.LRU[string, cachedInvite]
+}
+
+type cachedInvite struct {
+	// invite is nil if it couldn't be resolved, because it expired or
+	// Discord didn't answer.
+	invite     *InvitePreview
+	resolvedAt time.Time
+}
+
+func newInviteCache() *inviteCache {
+	return &inviteCache{invites: cache.NewLRU[string, cachedInvite](maxCachedInvites, nil)}
+}
+
+// invite resolves an invite code to the guild it leads to, or returns nil
+// if it can't be. Like formerMember, invites that couldn't be resolved
+// aren't tried again until the cache expires.
+func (s *Server) invite(code string) *InvitePreview {
+	s.invites.mu.Lock()
+	cached, ok := s.invites.invites.Get(code)
+	s.invites.mu.Unlock()
+	if ok && time.Since(cached.resolvedAt) < inviteCacheTTL {
+		return cached.invite
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var preview *InvitePreview
+	inv, err := s.bots[0].Client.WithContext(ctx).InviteWithCounts(code)
+	if err == nil && inv.Guild != nil {
+		preview = &InvitePreview{
+			URL:       inv.URL(),
+			GuildName: inv.Guild.Name,
+			Members:   inv.ApproximateMembers,
+		}
+		if inv.Guild.Icon != "" {
+			preview.Icon = inv.Guild.IconURL() + "?size=64"
+		}
+	}
+	s.invites.mu.Lock()
+	s.invites.invites.Add(code, cachedInvite{invite: preview, resolvedAt: time.Now()})
+	s.invites.mu.Unlock()
+	return preview
+}
+
+// messageInviteCodes returns the codes of the invites in a message's
+// content that are shown.
+func messageInviteCodes(content string) []string {
+	var codes []string
+	seen := make(map[string]bool)
+	for _, match := range inviteRegex.FindAllStringSubmatch(content, -1) {
+		code := match[1]
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		if len(codes) == maxInvitesPerMessage {
+			break
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// addInvites sets the guilds of the invites in the content of the messages
+// of a page on them. The invites are looked up at once, and only the first
+// maxInvitesPerPage of them, so that pages full of invites still render
+// quickly.
+func (s *Server) addInvites(msgs []*Message) {
+	invites := make(map[string]*InvitePreview)
+	var lookups []string
+	codes := make([][]string, len(msgs))
+	for i, m := range msgs {
+		for _, code := range messageInviteCodes(m.Content) {
+			if _, ok := invites[code]; !ok {
+				if len(lookups) == maxInvitesPerPage {
+					continue
+				}
+				invites[code] = nil
+				lookups = append(lookups, code)
+			}
+			codes[i] = append(codes[i], code)
+		}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, code := range lookups {
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			inv := s.invite(code)
+			mu.Lock()
+			invites[code] = inv
+			mu.Unlock()
+		}(code)
+	}
+	wg.Wait()
+	for i, m := range msgs {
+		for _, code := range codes[i] {
+			if inv := invites[code]; inv != nil {
+				m.Invites = append(m.Invites, *inv)
+			}
+		}
+	}
+}
+
+// inviteCode returns the code of an invite given as a link or as the code
+// itself.
+func inviteCode(invite string) string {
+	if match := inviteRegex.FindStringSubmatch(invite); match != nil {
+		return match[1]
+	}
+	return invite
+}
+
+func validInviteCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	for _, r := range code {
+		if r != '-' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
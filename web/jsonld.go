@@ -0,0 +1,100 @@
+package web
+
+import (
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// discussionPosting is the schema.org structured data of a post, which
+// search engines use to show it as a forum thread in their results.
+type discussionPosting struct {
+	Context              string              `json:"@context"`
+	Type                 string              `json:"@type"`
+	Headline             string              `json:"headline"`
+	URL                  string              `json:"url"`
+	Author               *personData         `json:"author,omitempty"`
+	Text                 string              `json:"text,omitempty"`
+	DateCreated          time.Time           `json:"dateCreated"`
+	DateModified         *time.Time          `json:"dateModified,omitempty"`
+	InteractionStatistic interactionCounter  `json:"interactionStatistic"`
+	Comment              []commentData       `json:"comment,omitempty"`
+	IsPartOf             *discussionForumRef `json:"isPartOf,omitempty"`
+	// License is the URL of the license that the guild's content is
+	// published under, if it declared one.
+	License string `json:"license,omitempty"`
+}
+
+type personData struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type interactionCounter struct {
+	Type                 string `json:"@type"`
+	InteractionType      string `json:"interactionType"`
+	UserInteractionCount int    `json:"userInteractionCount"`
+}
+
+type commentData struct {
+	Type        string     `json:"@type"`
+	URL         string     `json:"url"`
+	Author      personData `json:"author"`
+	Text        string     `json:"text,omitempty"`
+	DateCreated time.Time  `json:"dateCreated"`
+}
+
+type discussionForumRef struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// postStructuredData returns the structured data of a page of a post. The
+// post's own text and author are only known on the page that starts with
+// its first message, and the messages after it are its comments.
+func postStructuredData(page Page, post Post, groups []MessageGroup) discussionPosting {
+	crumbs := page.Meta.Breadcrumbs
+	postURL := page.SiteURL + crumbs[len(crumbs)-1].Path
+	data := discussionPosting{
+		Context:     "https://schema.org",
+		Type:        "DiscussionForumPosting",
+		Headline:    post.Name,
+		URL:         postURL,
+		DateCreated: post.ID.Time().UTC(),
+		InteractionStatistic: interactionCounter{
+			Type:                 "InteractionCounter",
+			InteractionType:      "https://schema.org/CommentAction",
+			UserInteractionCount: post.MessageCount,
+		},
+	}
+	if page.License != nil {
+		data.License = page.License.URL
+	}
+	if len(crumbs) > 1 {
+		forum := crumbs[len(crumbs)-2]
+		data.IsPartOf = &discussionForumRef{Type: "WebPage", Name: forum.Name, URL: page.SiteURL + forum.Path}
+	}
+	if last := post.LastMessageID; last.IsValid() {
+		t := last.Time().UTC()
+		data.DateModified = &t
+	}
+	for _, grp := range groups {
+		author := personData{Type: "Person", Name: grp.Author.Name}
+		for _, m := range grp.Messages {
+			if discord.ChannelID(m.ID) == post.ID {
+				data.Author = &author
+				data.Text = m.Content
+				continue
+			}
+			data.Comment = append(data.Comment, commentData{
+				Type:        "Comment",
+				URL:         postURL + "?after=" + (m.ID - 1).String(),
+				Author:      author,
+				Text:        m.Content,
+				DateCreated: m.ID.Time().UTC(),
+			})
+		}
+	}
+	return data
+}
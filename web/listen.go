@@ -0,0 +1,150 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor that systemd and restart
+// pass listening sockets as.
+const listenFDsStart = 3
+
+// restartFDsEnv and restartReadyEnv tell a process started by restart how
+// many listeners it was handed and which descriptor to report that it is
+// serving on. systemd's LISTEN_PID can't be used for this, since the new
+// process's PID isn't known before it starts.
+const (
+	restartFDsEnv   = "DFORUM_LISTEN_FDS"
+	restartReadyEnv = "DFORUM_READY_FD"
+)
+
+// inheritedListeners returns the listening sockets that systemd's socket
+// activation or a restarting process handed over, in the order they were
+// passed, or nil if there are none.
+func inheritedListeners() ([]net.Listener, error) {
+	n := os.Getenv(restartFDsEnv)
+	if n == "" && os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		n = os.Getenv("LISTEN_FDS")
+	}
+	os.Unsetenv(restartFDsEnv)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n == "" {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid number of inherited sockets %q", n)
+	}
+	var lns []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), "listener")
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inheriting socket %d: %w", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listen returns the i-th inherited listener if there is one, or a new one
+// listening on addr, which is a unix socket if it is a path.
+func listen(inherited []net.Listener, i int, addr string) (net.Listener, error) {
+	if i < len(inherited) {
+		return inherited[i], nil
+	}
+	if !strings.Contains(addr, "/") {
+		return net.Listen("tcp", addr)
+	}
+	// A socket left behind by a process that didn't stop cleanly would
+	// keep the address in use.
+	if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(addr)
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	// A process started by restart serves on the same socket after this
+	// one stops, so stopping mustn't remove it.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	return ln, nil
+}
+
+// signalReady tells the process that started this one with restart that it
+// is serving, so the old one can stop.
+func signalReady() {
+	fd, err := strconv.Atoi(os.Getenv(restartReadyEnv))
+	os.Unsetenv(restartReadyEnv)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// restart starts a new process of the executable the server was started
+// as, handing it the listeners, and waits for it to start serving on them.
+// Both serve from the same sockets until the old process stops, so no
+// connection is refused in between.
+func restart(lns []net.Listener) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		fl, ok := ln.(fileListener)
+		if !ok {
+			return fmt.Errorf("can't hand over %s listener", ln.Addr().Network())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	ready, readyw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		readyw.Close()
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyw)
+	cmd.Env = append(os.Environ(),
+		restartFDsEnv+"="+strconv.Itoa(len(files)),
+		restartReadyEnv+"="+strconv.Itoa(listenFDsStart+len(files)))
+	err = cmd.Start()
+	readyw.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	// The pipe is closed without anything written to it if the new process
+	// exits before it is ready.
+	b := make([]byte, 1)
+	if n, _ := ready.Read(b); n == 0 {
+		return errors.New("new process exited before serving")
+	}
+	return nil
+}
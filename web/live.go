@@ -0,0 +1,118 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// pollWait is how long a poll for new messages waits for one before it is
+// answered with none. It has to end before the server's write timeout.
+const pollWait = 8 * time.Second
+
+// pollRefresh is how often, in seconds, the latest page of a post is
+// reloaded in browsers that can't poll for new messages.
+const pollRefresh = 60
+
+// liveUpdates wakes up the polls waiting for new messages in posts.
+type liveUpdates struct {
+	mu sync.Mutex
+	// waiting has a channel for each post that is polled, which is closed
+	// when a message is posted in it. Channels stay until then, even if
+	// the polls waiting on them are gone.
+	waiting map[discord.ChannelID]chan struct{}
+}
+
+func newLiveUpdates() *liveUpdates {
+	return &liveUpdates{waiting: make(map[discord.ChannelID]chan struct{})}
+}
+
+// wait returns a channel that is closed when the next message is posted
+// in a post.
+func (l *liveUpdates) wait(id discord.ChannelID) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch, ok := l.waiting[id]
+	if !ok {
+		ch = make(chan struct{})
+		l.waiting[id] = ch
+	}
+	return ch
+}
+
+// notify wakes up the polls of a post that a message was posted in.
+func (l *liveUpdates) notify(id discord.ChannelID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ch, ok := l.waiting[id]; ok {
+		close(ch)
+		delete(l.waiting, id)
+	}
+}
+
+// pollURL returns the URL that the latest page of a post polls for the
+// messages after the last one on it, or "" if the page isn't updated
+// live.
+func (s *Server) pollURL(r *http.Request, guildID discord.GuildID, post *discord.Channel, last discord.MessageID) string {
+	p := Post{Channel: *post}
+	if s.live == nil || p.IsArchived() || p.IsLocked() {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%s/poll?after=%s", s.requestGuildPath(r, guildID), post.ParentID, post.ID, last)
+}
+
+// getPostPoll answers with the messages of a post after the one in the
+// after parameter, as a fragment of HTML like getPostMessages does. If
+// there are none yet, it waits for up to pollWait for one to be posted,
+// and answers with No Content if none was, so that open pages of posts
+// can show new messages as they are posted.
+func (s *Server) getPostPoll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", cacheNone)
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	sf, err := discord.ParseSnowflake(r.URL.Query().Get("after"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, errInvalidAfter)
+		return
+	}
+	after := discord.MessageID(sf)
+	for {
+		// The channel is taken before looking for messages, so that none
+		// posted in between are missed.
+		posted := s.live.wait(post.ID)
+		msgs, _, _, err := s.messageCache.MessagesAfter(r.Context(), post.ID, after, maxRangeMessages)
+		if err != nil && r.Context().Err() != nil {
+			// The wait ran out while the messages were being fetched.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching post's messages: %w", err))
+			return
+		}
+		if len(msgs) > 0 {
+			s.executeMessages(w, r, guild.ID, forum, post, msgs)
+			return
+		}
+		select {
+		case <-posted:
+		case <-r.Context().Done():
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+)
+
+// MediaRevalidateAfter is how long a cached file is served before it is
+// revalidated against Discord's CDN.
+const MediaRevalidateAfter = 24 * time.Hour
+
+var errMediaNotFound = errors.New("media not found")
+
+// mediaProxy serves files from Discord's CDN out of a disk cache. Each
+// cached file has a metadata file next to it holding the upstream
+// validators, so stale files are revalidated with a conditional request
+// instead of being downloaded again.
+type mediaProxy struct {
+	dir    string
+	client *http.Client
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// mediaMeta is stored as JSON alongside each cached file.
+type mediaMeta struct {
+	URL          string
+	ContentType  string
+	ETag         string
+	LastModified string
+	FetchedAt    time.Time
+}
+
+// mediaResolver returns the upstream URL of a file. If refresh is true the
+// previously known URL was rejected by the CDN and a new one must be
+// fetched from the Discord API.
+type mediaResolver func(refresh bool) (string, error)
+
+func newMediaProxy(dir string, client *http.Client) (*mediaProxy, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &mediaProxy{
+		dir:    dir,
+		client: client,
+		locks:  make(map[string]*keyLock),
+	}, nil
+}
+
+func (p *mediaProxy) lock(key string) func() {
+	p.mu.Lock()
+	l, ok := p.locks[key]
+	if !ok {
+		l = &keyLock{}
+		p.locks[key] = l
+	}
+	l.refs++
+	p.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(p.locks, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *mediaProxy) paths(key string) (data, meta string) {
+	base := filepath.Join(p.dir, key)
+	return base, base + ".json"
+}
+
+func (p *mediaProxy) readMeta(key string) (*mediaMeta, error) {
+	_, metapath := p.paths(key)
+	b, err := os.ReadFile(metapath)
+	if err != nil {
+		return nil, err
+	}
+	var meta mediaMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (p *mediaProxy) writeMeta(key string, meta *mediaMeta) error {
+	_, metapath := p.paths(key)
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metapath, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// fetch makes sure the file for key is in the cache and fresh, returning
+// its metadata.
+func (p *mediaProxy) fetch(key string, resolve mediaResolver) (*mediaMeta, error) {
+	unlock := p.lock(key)
+	defer unlock()
+
+	meta, err := p.readMeta(key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error reading media metadata for %s: %v", key, err)
+	}
+	if meta != nil && time.Since(meta.FetchedAt) < MediaRevalidateAfter {
+		return meta, nil
+	}
+	if meta == nil {
+		u, err := resolve(false)
+		if err != nil {
+			return nil, err
+		}
+		meta = &mediaMeta{URL: u}
+	}
+	fresh, err := p.download(key, meta)
+	if errors.Is(err, errMediaNotFound) {
+		// Discord's CDN URLs are signed and expire, so get a new one from
+		// the API and try again. Only the file being gone from Discord as
+		// well keeps the stale copy from being served.
+		u, rerr := resolve(true)
+		switch {
+		case errors.Is(rerr, errMediaNotFound):
+			return nil, rerr
+		case rerr != nil:
+			err = rerr
+		default:
+			meta.URL = u
+			fresh, err = p.download(key, meta)
+		}
+	}
+	if err != nil {
+		if meta.FetchedAt.IsZero() {
+			return nil, err
+		}
+		log.Printf("Error revalidating %s, serving stale copy: %v", key, err)
+		return meta, nil
+	}
+	return fresh, nil
+}
+
+// download requests meta.URL, conditionally if meta holds validators from a
+// previous fetch, and stores the result.
+func (p *mediaProxy) download(key string, meta *mediaMeta) (*mediaMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, meta.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.FetchedAt.IsZero() {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if meta.FetchedAt.IsZero() {
+			return nil, fmt.Errorf("unexpected %s from CDN", resp.Status)
+		}
+		updated := *meta
+		updated.FetchedAt = time.Now()
+		return &updated, p.writeMeta(key, &updated)
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return nil, errMediaNotFound
+	default:
+		return nil, fmt.Errorf("unexpected %s from CDN", resp.Status)
+	}
+	datapath, _ := p.paths(key)
+	err = writeFileAtomic(datapath, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	updated := &mediaMeta{
+		URL:          meta.URL,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	}
+	return updated, p.writeMeta(key, updated)
+}
+
+// serve writes the cached file for key to w, fetching it first if needed.
+func (p *mediaProxy) serve(w http.ResponseWriter, r *http.Request, key string, resolve mediaResolver) error {
+	meta, err := p.fetch(key, resolve)
+	if err != nil {
+		return err
+	}
+	datapath, _ := p.paths(key)
+	f, err := os.Open(datapath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
+	if meta.ETag != "" {
+		w.Header().Set("ETag", meta.ETag)
+	}
+	modtime, _ := http.ParseTime(meta.LastModified)
+	http.ServeContent(w, r, "", modtime, f)
+	return nil
+}
+
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// attachmentURL returns the URL that pages should use for an attachment,
+// which goes through the media proxy if it is enabled. A non-zero width
+// and height request a scaled thumbnail.
+func (s *Server) attachmentURL(m discord.Message, at discord.Attachment, width, height uint) string {
+	// Copies of forwarded messages have no ID of their own to proxy their
+	// attachments by.
+	if s.media == nil || !m.ID.IsValid() {
+		if width != 0 && height != 0 {
+			return thumbnailURL(at.URL, width, height)
+		}
+		return at.URL
+	}
+	u := fmt.Sprintf("/media/attachments/%s/%s/%s/%s",
+		m.ChannelID, m.ID, at.ID, url.PathEscape(at.Filename))
+	if width != 0 && height != 0 {
+		u += fmt.Sprintf("?width=%d&height=%d", width, height)
+	}
+	return u
+}
+
+// thumbnailURL rewrites a CDN attachment URL to Discord's media proxy,
+// which scales images to the requested size.
+func thumbnailURL(attachment string, width, height uint) string {
+	u, err := url.Parse(attachment)
+	if err != nil {
+		return ""
+	}
+	u.Host = "media.discordapp.net"
+	q := u.Query()
+	q.Set("width", strconv.FormatUint(uint64(width), 10))
+	q.Set("height", strconv.FormatUint(uint64(height), 10))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *Server) getAttachment(w http.ResponseWriter, r *http.Request) {
+	var ids [3]discord.Snowflake
+	for i, param := range []string{"channelID", "messageID", "attachmentID"} {
+		sf, err := discord.ParseSnowflake(chi.URLParam(r, param))
+		if err != nil {
+			s.displayErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+		ids[i] = sf
+	}
+	chID, msgID, atID := discord.ChannelID(ids[0]), discord.MessageID(ids[1]), discord.AttachmentID(ids[2])
+	addSurrogateKey(w, chID.String())
+	var width, height uint64
+	if q := r.URL.Query(); q.Get("width") != "" || q.Get("height") != "" {
+		var werr, herr error
+		width, werr = strconv.ParseUint(q.Get("width"), 10, 16)
+		height, herr = strconv.ParseUint(q.Get("height"), 10, 16)
+		if werr != nil || herr != nil || width > MaxThumbnailWidth || height > MaxThumbnailHeight {
+			s.displayErr(w, r, http.StatusBadRequest, errors.New("invalid thumbnail size"))
+			return
+		}
+	}
+	if _, ok := s.servableChannel(w, r, chID); !ok {
+		return
+	}
+	// The files of users who opted out are hidden along with the rest of
+	// what they posted, even if they are in the media cache.
+	author, err := s.attachmentAuthor(r.Context(), chID, msgID, atID)
+	if errors.Is(err, errMediaNotFound) || err == nil && s.optOuts.has(author) {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	} else if err != nil {
+		s.displayErr(w, r, http.StatusBadGateway,
+			fmt.Errorf("fetching message: %w", err))
+		return
+	}
+	addSurrogateKey(w, author.String())
+	resolve := s.attachmentResolver(chID, msgID, atID, uint(width), uint(height))
+	key := attachmentKey(chID, msgID, atID, uint(width), uint(height))
+	if err := s.media.serve(w, r, key, resolve); err != nil {
+		if errors.Is(err, errMediaNotFound) {
+			s.displayErr(w, r, http.StatusNotFound, nil)
+			return
+		}
+		s.displayErr(w, r, http.StatusBadGateway,
+			fmt.Errorf("fetching attachment: %w", err))
+	}
+}
+
+// attachmentAuthor returns the author of the message of a channel that an
+// attachment was posted in, from the message cache, or errMediaNotFound if
+// there is no such message or the attachment isn't one of its.
+func (s *Server) attachmentAuthor(ctx context.Context, chID discord.ChannelID, msgID discord.MessageID, atID discord.AttachmentID) (discord.UserID, error) {
+	msgs, _, _, err := s.messageCache.MessagesAfter(ctx, chID, msgID-1, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 || msgs[0].ID != msgID {
+		return 0, errMediaNotFound
+	}
+	for _, at := range msgs[0].Attachments {
+		if at.ID == atID {
+			return msgs[0].Author.ID, nil
+		}
+	}
+	return 0, errMediaNotFound
+}
+
+// attachmentKey returns the key of an attachment in the media cache, or of
+// its thumbnail if width and height aren't 0. It has the message and
+// channel that the attachment was posted in, so that a file is only ever
+// served for the message it belongs to, which is the one that is checked.
+func attachmentKey(chID discord.ChannelID, msgID discord.MessageID, atID discord.AttachmentID, width, height uint) string {
+	key := fmt.Sprintf("attachment-%s-%s-%s", chID, msgID, atID)
+	if width != 0 {
+		key += fmt.Sprintf("-%dx%d", width, height)
+	}
+	return key
+}
+
+// attachmentResolver returns the resolver of the CDN URL of an attachment,
+// or of its thumbnail if width and height aren't 0.
+func (s *Server) attachmentResolver(chID discord.ChannelID, msgID discord.MessageID, atID discord.AttachmentID, width, height uint) mediaResolver {
+	return func(refresh bool) (string, error) {
+		var msg *discord.Message
+		var err error
+		if refresh {
+			msg, err = s.bots.forChannel(chID).Client.Message(chID, msgID)
+		} else {
+			msg, err = s.bots.forChannel(chID).Message(chID, msgID)
+		}
+		if err != nil {
+			if discordStatusIs(err, http.StatusNotFound) {
+				return "", errMediaNotFound
+			}
+			return "", err
+		}
+		for _, at := range msg.Attachments {
+			if at.ID != atID {
+				continue
+			}
+			if width != 0 {
+				return thumbnailURL(at.URL, width, height), nil
+			}
+			return at.URL, nil
+		}
+		return "", errMediaNotFound
+	}
+}
+
+// servableChannel checks that a channel belongs to a forum that the site
+// serves, so the proxy can't be used to fetch arbitrary channels' files.
+func (s *Server) servableChannel(w http.ResponseWriter, r *http.Request, id discord.ChannelID) (*discord.Channel, bool) {
+	ch, err := s.channel(id)
+	if err != nil {
+		if discordStatusIs(err, http.StatusNotFound) {
+			s.displayErr(w, r, http.StatusNotFound, nil)
+		} else {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching channel: %w", err))
+		}
+		return nil, false
+	}
+	parent, err := s.channel(ch.ParentID)
+	if err != nil || parent.Type != discord.GuildForum || !s.canServe(ch) {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+	if parent.NSFW {
+		// Files can't be answered with the page asking readers to confirm
+		// their age, which would be cached or shown as the file.
+		w.Header().Set("Cache-Control", cachePrivate)
+		w.Header().Set("X-Robots-Tag", "noindex")
+		switch {
+		case !s.site().ServeNSFW:
+			s.displayErr(w, r, http.StatusForbidden, errNSFW)
+			return nil, false
+		case !ageConfirmed(r):
+			s.displayErr(w, r, http.StatusForbidden, errAgeUnconfirmed)
+			return nil, false
+		}
+	}
+	return ch, true
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+)
+
+// ensureMembers adds the authors of messages that the bots' caches don't
+// have to them from the database, and has the ones that aren't stored
+// either requested from the gateway in the background, so that they are
+// there the next time the post is viewed. Authors whose members can't be
+// found are shown with what the messages say of them.
+func (s *Server) ensureMembers(ctx context.Context, post discord.Channel, msgs []discord.Message) error {
+	st := s.bots.forGuild(post.GuildID)
+	var missing []discord.UserID
+	seen := make(map[discord.UserID]bool)
+	for _, msg := range msgs {
+		if seen[msg.Author.ID] || msg.WebhookID.IsValid() {
+			continue
+		}
+		seen[msg.Author.ID] = true
+		if _, err := st.Cabinet.Member(post.GuildID, msg.Author.ID); err != nil {
+			missing = append(missing, msg.Author.ID)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	stored, err := s.db.Members(ctx, post.GuildID, missing)
+	if err != nil {
+		return err
+	}
+	for i := range stored {
+		st.Cabinet.MemberSet(post.GuildID, &stored[i], false)
+		delete(seen, stored[i].User.ID)
+	}
+	var request []discord.UserID
+	s.requestMembers.Lock()
+	requested := s.membersRequested[post.GuildID]
+	if requested == nil {
+		requested = make(map[discord.UserID]struct{})
+		s.membersRequested[post.GuildID] = requested
+	}
+	for _, id := range missing {
+		if _, ok := requested[id]; !ok && seen[id] {
+			requested[id] = struct{}{}
+			request = append(request, id)
+		}
+	}
+	s.requestMembers.Unlock()
+	switch {
+	case len(request) == 0:
+	case !st.HasIntents(gateway.IntentGuildMembers):
+		go s.fetchMembers(st, post.GuildID, request)
+	case s.gateways.connected(s.bots.index(st)):
+		go s.requestGuildMembers(st, post.GuildID, request)
+	}
+	return nil
+}
+
+// fetchMembers fetches members of a guild over REST one by one and stores
+// them, for bots that can't request them from the gateway because they
+// don't have the Server Members intent.
+func (s *Server) fetchMembers(st *state.State, guild discord.GuildID, users []discord.UserID) {
+	ctx := context.Background()
+	var members []discord.Member
+	for _, id := range users {
+		m, err := st.Client.Member(guild, id)
+		switch {
+		case discordStatusIs(err, http.StatusNotFound):
+			s.users.setFormer(guild, id, true)
+		case err != nil:
+			log.Printf("Error fetching member %s of %s: %v", id, guild, err)
+		default:
+			st.Cabinet.MemberSet(guild, m, false)
+			s.users.setFormer(guild, id, false)
+			members = append(members, *m)
+		}
+	}
+	if err := s.db.SaveMembers(ctx, guild, members); err != nil {
+		log.Println("Error storing members:", err)
+	}
+}
+
+// requestGuildMembers asks the gateway for members of a guild, or for all
+// of them if users is empty. They come in chunks, which handleMembers
+// stores.
+func (s *Server) requestGuildMembers(st *state.State, guild discord.GuildID, users []discord.UserID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := &gateway.RequestGuildMembersCommand{
+		GuildIDs: []discord.GuildID{guild},
+		UserIDs:  users,
+	}
+	if len(users) == 0 {
+		cmd.Query = option.NewString("")
+	}
+	if err := st.Gateway().Send(ctx, cmd); err != nil {
+		log.Printf("Error requesting members of %s: %v", guild, err)
+	}
+}
+
+// handleMembers returns the handler keeping the stored members of the guilds
+// a bot is in up to date. Every member of a guild is requested by the bot
+// serving it once the bot has it, so that they are stored before anyone
+// views its posts, if the bot has the Server Members intent.
+func (s *Server) handleMembers(st *state.State) func(interface{}) {
+	return func(ev interface{}) {
+		ctx := context.Background()
+		var err error
+		switch ev := ev.(type) {
+		case *state.GuildReadyEvent:
+			if s.bots.forGuild(ev.ID) == st && st.HasIntents(gateway.IntentGuildMembers) {
+				go s.requestGuildMembers(st, ev.ID, nil)
+			}
+		case *state.GuildJoinEvent:
+			if s.bots.forGuild(ev.ID) == st && st.HasIntents(gateway.IntentGuildMembers) {
+				go s.requestGuildMembers(st, ev.ID, nil)
+			}
+		case *gateway.GuildMembersChunkEvent:
+			for _, m := range ev.Members {
+				s.users.setFormer(ev.GuildID, m.User.ID, false)
+			}
+			// Users that were asked for but aren't members have left.
+			for _, id := range ev.NotFound {
+				if sf, err := discord.ParseSnowflake(id); err == nil {
+					s.users.setFormer(ev.GuildID, discord.UserID(sf), true)
+				}
+			}
+			err = s.db.SaveMembers(ctx, ev.GuildID, ev.Members)
+		case *gateway.GuildMemberAddEvent:
+			s.users.setFormer(ev.GuildID, ev.User.ID, false)
+			err = s.db.SaveMembers(ctx, ev.GuildID, []discord.Member{ev.Member})
+		case *gateway.GuildMemberUpdateEvent:
+			m, cerr := st.Cabinet.Member(ev.GuildID, ev.User.ID)
+			if cerr != nil {
+				m = &discord.Member{}
+			}
+			ev.UpdateMember(m)
+			err = s.db.SaveMembers(ctx, ev.GuildID, []discord.Member{*m})
+		case *gateway.GuildMemberRemoveEvent:
+			s.users.setFormer(ev.GuildID, ev.User.ID, true)
+			err = s.db.RemoveMember(ctx, ev.GuildID, ev.User.ID)
+		}
+		if err != nil {
+			log.Println("Error storing members:", err)
+		}
+	}
+}
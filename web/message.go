@@ -0,0 +1,297 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/IoIxD/dforum/render"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+const (
+	MaxThumbnailWidth  = 600
+	MaxThumbnailHeight = 600
+)
+
+type MessageGroup struct {
+	Author
+	Messages []Message
+}
+
+type Message struct {
+	discord.Message
+	Role             string
+	RenderedContent  template.HTML
+	MediaPreviews    []MediaPreview
+	PlainAttachments []PlainAttachment
+	// Revisions are the earlier versions of the message, oldest first,
+	// if edit history is kept.
+	Revisions []Revision
+	// DeletedAt is when the message was deleted, if it is kept as a
+	// tombstone.
+	DeletedAt time.Time
+	Stickers  []Sticker
+	// Forwarded are the copies of the messages that the message forwarded.
+	Forwarded []Message
+	// Invites are the guilds that the invites in the message lead to.
+	Invites []InvitePreview
+	// Anonymized is set if the author opted out of being shown, and the
+	// message was stripped of what they posted.
+	Anonymized bool
+}
+
+// Sticker is a sticker sent with a message.
+type Sticker struct {
+	Name string
+	URL  template.URL
+}
+
+// stickerFormatGIF is the format of animated stickers uploaded as GIFs,
+// which arikawa doesn't know of.
+const stickerFormatGIF discord.StickerFormatType = 4
+
+// StickerSize is the size stickers are shown at, like in Discord.
+const StickerSize = 160
+
+// stickerURL returns the URL of a sticker's image. Browsers can't play
+// Lottie stickers, so they are requested as PNGs like APNG ones, which
+// browsers without APNG support show as still images.
+func stickerURL(st discord.StickerItem) string {
+	ext := "png"
+	if st.FormatType == stickerFormatGIF {
+		ext = "gif"
+	}
+	return fmt.Sprintf("https://media.discordapp.net/stickers/%s.%s?size=%d", st.ID, ext, StickerSize)
+}
+
+// Revision is an earlier version of an edited message.
+type Revision struct {
+	// Time is when the version was posted or made by an edit.
+	Time            time.Time
+	RenderedContent template.HTML
+}
+
+type Author struct {
+	ID     discord.UserID
+	Name   string
+	Avatar string
+	Bot    bool
+	System bool
+	// Former is set for authors that have left the guild.
+	Former bool
+	// Anonymous is set for authors that opted out of being shown, who are
+	// shown by a default avatar and no name.
+	Anonymous  bool
+	Role       string
+	OtherRoles []*discord.Role
+	RoleColor  string
+	// Badges are the badges that the operator configured for the author's
+	// roles, from their highest role to their lowest.
+	Badges []string
+}
+
+type MediaPreview struct {
+	Thumbnail   template.URL
+	URL         template.URL
+	Description string
+	// Spoiler is set for attachments marked as spoilers, which are hidden
+	// until they are clicked.
+	Spoiler bool
+}
+
+type PlainAttachment struct {
+	Name    string
+	URL     template.URL
+	Spoiler bool
+}
+
+// isSpoiler reports whether an attachment was marked as a spoiler, which
+// Discord does by prefixing its file name.
+func isSpoiler(at discord.Attachment) bool {
+	return strings.HasPrefix(at.Filename, "SPOILER_")
+}
+
+// spoilerRegex matches the spoilers in the markdown of a message.
+var spoilerRegex = regexp.MustCompile(`(?s)\|\|(.+?)\|\|`)
+
+// inSpoiler reports whether a link is in a spoiler in a message's content,
+// so that the embed Discord made of it is hidden as well.
+func inSpoiler(content, link string) bool {
+	if link == "" {
+		return false
+	}
+	for _, match := range spoilerRegex.FindAllStringSubmatch(content, -1) {
+		if strings.Contains(match[1], link) {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnailSize scales an image attachment's dimensions down to fit within
+// the maximum thumbnail size.
+func thumbnailSize(at discord.Attachment) (uint, uint) {
+	return fitSize(at, MaxThumbnailWidth, MaxThumbnailHeight)
+}
+
+// fitSize scales an image attachment's dimensions down to fit within
+// maxW by maxH.
+func fitSize(at discord.Attachment, maxW, maxH uint) (uint, uint) {
+	w, h := at.Width, at.Height
+	if w > maxW {
+		h = h * maxW / w
+		w = maxW
+	}
+	if h > maxH {
+		w = w * maxH / h
+		h = maxH
+	}
+	return w, h
+}
+
+func (s *Server) revision(m discord.Message, loc *Locale) Revision {
+	m.Content = s.site().redact(m.Content)
+	t := m.ID.Time()
+	if m.EditedTimestamp.IsValid() {
+		t = m.EditedTimestamp.Time()
+	}
+	return Revision{Time: t, RenderedContent: s.renderContent(m, loc)}
+}
+
+// message massages a discord.Message into a Message for passing to templates
+func (s *Server) message(m discord.Message, loc *Locale) Message {
+	m.Content = s.site().redact(m.Content)
+	if s.optOuts.has(m.Author.ID) {
+		return anonymize(Message{Message: m})
+	}
+	msg := Message{
+		Message:         m,
+		RenderedContent: s.renderContent(m, loc),
+	}
+	var mediapreviews []MediaPreview
+	for _, e := range m.Embeds {
+		if e.Thumbnail == nil {
+			continue
+		}
+		var url string
+		switch {
+		case e.Video != nil:
+			if e.Provider != nil {
+				url = e.URL
+			} else {
+				url = e.Video.URL
+			}
+		case e.Image != nil:
+			url = e.Image.Proxy
+		default:
+			url = e.Thumbnail.URL
+		}
+		mediapreviews = append(
+			mediapreviews,
+			MediaPreview{
+				Thumbnail: template.URL(e.Thumbnail.URL),
+				URL:       template.URL(url),
+				Spoiler:   inSpoiler(m.Content, e.URL),
+			},
+		)
+	}
+	var plainatt []PlainAttachment
+	for _, att := range m.Attachments {
+		if att.Height == 0 ||
+			!strings.HasPrefix(att.ContentType, "image/") {
+			plainatt = append(plainatt, PlainAttachment{
+				att.Filename,
+				template.URL(s.attachmentURL(m, att, 0, 0)),
+				isSpoiler(att),
+			})
+			continue
+		}
+		w, h := thumbnailSize(att)
+		mediapreviews = append(mediapreviews, MediaPreview{
+			Thumbnail:   template.URL(s.attachmentURL(m, att, w, h)),
+			URL:         template.URL(s.attachmentURL(m, att, 0, 0)),
+			Description: att.Description,
+			Spoiler:     isSpoiler(att),
+		})
+	}
+	msg.MediaPreviews = mediapreviews
+	msg.PlainAttachments = plainatt
+	for _, st := range m.Stickers {
+		msg.Stickers = append(msg.Stickers, Sticker{
+			Name: st.Name,
+			URL:  template.URL(stickerURL(st)),
+		})
+	}
+	return msg
+}
+
+func (s *Server) author(m discord.Message) Author {
+	if s.optOuts.has(m.Author.ID) {
+		return Author{ID: m.Author.ID, Name: "anonymous", Avatar: anonymousAvatar, Anonymous: true}
+	}
+	auth := Author{
+		ID:     m.Author.ID,
+		Name:   m.Author.Username,
+		Bot:    m.Author.Bot,
+		System: m.Author.DiscordSystem,
+	}
+	mr, err := s.bots.forGuild(m.GuildID).Cabinet.Member(m.GuildID, m.Author.ID)
+	if err != nil {
+		// not a real error, just means the user is not in the guild. The
+		// avatar in the message may have been changed since, so it is only
+		// shown if it is still theirs.
+		if !m.WebhookID.IsValid() && s.users.isFormer(m.GuildID, m.Author.ID) {
+			auth.Former = true
+			if u := s.formerMember(m.GuildID, m.Author.ID); u != nil {
+				auth.Name = u.DisplayOrUsername()
+				auth.Avatar = s.avatarURL(*u)
+				return auth
+			}
+		}
+		m.Author.Avatar = ""
+		auth.Avatar = s.avatarURL(m.Author)
+		return auth
+	}
+	auth.Avatar = s.avatarURL(mr.User)
+	auth.OtherRoles = make([]*discord.Role, 0)
+
+	roles, err := s.guildRoles(m.GuildID)
+	if err != nil {
+		return auth
+	}
+	has := make(map[discord.RoleID]struct{}, len(mr.RoleIDs))
+	for _, rid := range mr.RoleIDs {
+		has[rid] = struct{}{}
+	}
+	badges := s.guildConfig(m.GuildID).Badges
+	// roles are ordered highest first, so the first hoisted and the first
+	// colored role are the ones Discord displays.
+	for i, rl := range roles {
+		if _, ok := has[rl.ID]; !ok {
+			continue
+		}
+		auth.OtherRoles = append(auth.OtherRoles, &roles[i])
+		if rl.Hoist && auth.Role == "" {
+			auth.Role = rl.Name
+		}
+		if rl.Color != 0 && auth.RoleColor == "" {
+			auth.RoleColor = rl.Color.String()
+		}
+		if badge, ok := badges[rl.ID.String()]; ok {
+			auth.Badges = append(auth.Badges, badge)
+		}
+	}
+	return auth
+}
+
+func (s *Server) renderContent(m discord.Message, loc *Locale) template.HTML {
+	if m.Content != "" &&
+		(len(m.Embeds) == 1 && m.Embeds[0].Type == discord.ImageEmbed && m.Embeds[0].URL == m.Content) {
+		return ""
+	}
+	content := render.Content(&m, *s.bots.forGuild(m.GuildID).Cabinet, loc.Timestamp)
+	return template.HTML(strings.ReplaceAll(string(content), "https://discord.com/channels", s.site().URL))
+}
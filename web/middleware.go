@@ -0,0 +1,56 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// pageTimeout is how long pages are given to be rendered, which they only
+// take long for while waiting for Discord. It is shorter than the HTTP
+// server's write timeout so that readers are still sent a page saying so.
+const pageTimeout = 8 * time.Second
+
+// recoverPanics is a middleware that answers requests whose handler
+// panicked with an error page, and logs the panic with its stack, instead
+// of dropping the connection.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("Panic serving %s: %v\n%s", r.URL.Path, v, debug.Stack())
+			s.stats.logError(r, fmt.Errorf("panic: %v", v))
+			// If part of the page has been sent, the error page can't be.
+			if ww.Status() == 0 {
+				s.displayErr(w, r, http.StatusInternalServerError, nil)
+			}
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// timeout is a middleware that gives up on requests that haven't been
+// answered after d, so that a stuck call to Discord doesn't hold the
+// connection. Requests that time out get an error page with a 504, from
+// displayErr.
+func timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
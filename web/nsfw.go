@@ -0,0 +1,53 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ageCookie is set once a reader confirms they are old enough to view NSFW
+// forums.
+const ageCookie = "dforum_age_confirmed"
+
+func ageConfirmed(r *http.Request) bool {
+	c, err := r.Cookie(ageCookie)
+	return err == nil && c.Value == "1"
+}
+
+// nsfwAllowed reports whether NSFW content may be shown for the request,
+// rendering the appropriate page if not. It also marks the response as not
+// to be indexed.
+func (s *Server) nsfwAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if !s.site().ServeNSFW {
+		s.displayErr(w, r, http.StatusForbidden, errNSFW)
+		return false
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if ageConfirmed(r) {
+		return true
+	}
+	ctx := struct {
+		Page
+		Return string
+	}{s.page(w, r), r.URL.RequestURI()}
+	s.executeTemplate(w, r, "nsfw.gohtml", ctx)
+	return false
+}
+
+func (s *Server) confirmAge(w http.ResponseWriter, r *http.Request) {
+	ret := r.PostFormValue("return")
+	// Only redirect back to pages on this site.
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") {
+		ret = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ageCookie,
+		Value:    "1",
+		Path:     "/",
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, ret, http.StatusSeeOther)
+}
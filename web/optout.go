@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+)
+
+// anonymousAvatar is the avatar shown for users that have opted out, which
+// is one of Discord's default avatars so that it doesn't tell them apart.
+const anonymousAvatar = "https://cdn.discordapp.com/embed/avatars/0.png"
+
+// optOutCommand is the slash command that users opt out of being shown
+// with, and back in.
+var optOutCommand = api.CreateCommandData{
+	Name:        "dfs",
+	Description: "Manage how you are shown in the web archive of this server",
+	Options: discord.CommandOptions{
+		&discord.SubcommandOption{
+			OptionName:  "optout",
+			Description: "Stop showing your name, avatar and messages in the web archive",
+		},
+		&discord.SubcommandOption{
+			OptionName:  "optin",
+			Description: "Show your name, avatar and messages in the web archive again",
+		},
+	},
+}
+
+// optOuts holds the users that opted out of being shown with /dfs optout.
+// Their messages are still archived, but pages show them without their
+// content, by an anonymous author, so opting back in makes them show
+// again.
+type optOuts struct {
+	mu    sync.RWMutex
+	users map[discord.UserID]time.Time
+	// registered are the applications that the command has been
+	// registered for, since each shard of a bot becomes ready.
+	registered map[discord.AppID]bool
+}
+
+func newOptOuts(users map[discord.UserID]time.Time) *optOuts {
+	return &optOuts{users: users, registered: make(map[discord.AppID]bool)}
+}
+
+func (o *optOuts) has(id discord.UserID) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	_, ok := o.users[id]
+	return ok
+}
+
+func (o *optOuts) set(id discord.UserID, optedOut bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if optedOut {
+		o.users[id] = time.Now()
+	} else {
+		delete(o.users, id)
+	}
+}
+
+// handleOptOuts returns the handler that registers the opt-out command of
+// a bot and answers it.
+func (s *Server) handleOptOuts(st *state.State) func(interface{}) {
+	return func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *gateway.ReadyEvent:
+			s.optOuts.mu.Lock()
+			done := s.optOuts.registered[ev.Application.ID]
+			s.optOuts.registered[ev.Application.ID] = true
+			s.optOuts.mu.Unlock()
+			if done {
+				return
+			}
+			if _, err := st.BulkOverwriteCommands(ev.Application.ID, []api.CreateCommandData{optOutCommand}); err != nil {
+				log.Println("Error registering the opt-out command:", err)
+			}
+		case *gateway.InteractionCreateEvent:
+			cmd, ok := ev.Data.(*discord.CommandInteraction)
+			if !ok || cmd.Name != optOutCommand.Name || len(cmd.Options) == 0 {
+				return
+			}
+			s.answerOptOut(st, &ev.InteractionEvent, cmd.Options[0].Name)
+		}
+	}
+}
+
+// answerOptOut opts the user of an interaction out of being shown or back
+// in, and tells them so in a message only they see.
+func (s *Server) answerOptOut(st *state.State, ev *discord.InteractionEvent, sub string) {
+	user := ev.SenderID()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var err error
+	var reply string
+	switch sub {
+	case "optout":
+		err = s.db.OptOut(ctx, user, time.Now())
+		reply = "You have opted out. The web archive no longer shows your name, avatar or messages. " +
+			"Pages that were already cached may take a while to change. Use /dfs optin to undo it."
+	case "optin":
+		err = s.db.OptIn(ctx, user)
+		reply = "You have opted back in. The web archive shows your name, avatar and messages again."
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving opt-out of %s: %v", user, err)
+		reply = "Your choice couldn't be saved. Try again later."
+	} else {
+		s.optOuts.set(user, sub == "optout")
+		if s.renderCache != nil {
+			// The user's messages can be on any rendered page.
+			s.renderCache.clear()
+		}
+		if s.warmer != nil {
+			s.warmer.clear()
+		}
+		if sub == "optout" {
+			// The instance's own caches check opt-outs, but a CDN in
+			// front of it keeps the user's avatar and attachments, which
+			// are tagged with their ID, until they are purged.
+			log.Printf("%s opted out; purge the surrogate key %s from the CDN", user, user)
+		}
+	}
+	err = st.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
+		Type: api.MessageInteractionWithSource,
+		Data: &api.InteractionResponseData{
+			Content: option.NewNullableString(reply),
+			Flags:   discord.EphemeralMessage,
+		},
+	})
+	if err != nil {
+		log.Printf("Error answering opt-out command of %s: %v", user, err)
+	}
+}
+
+// anonymize strips a message of everything that an author who opted out
+// posted in it, and returns it as the message to show.
+func anonymize(msg Message) Message {
+	msg.Content = ""
+	msg.Embeds = nil
+	msg.Attachments = nil
+	msg.Stickers = nil
+	msg.RenderedContent = ""
+	msg.MediaPreviews = nil
+	msg.PlainAttachments = nil
+	msg.Revisions = nil
+	msg.Forwarded = nil
+	msg.Invites = nil
+	msg.Message.Stickers = nil
+	msg.Anonymized = true
+	return msg
+}
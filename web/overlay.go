@@ -0,0 +1,83 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// resourceDirs are the directories of resources that are layered over the
+// embedded ones, each over the ones before it. The config can have a
+// single directory as a string.
+type resourceDirs []string
+
+func (d *resourceDirs) UnmarshalTOML(decode func(interface{}) error) error {
+	var dirs []string
+	if err := decode(&dirs); err == nil {
+		*d = dirs
+		return nil
+	}
+	var dir string
+	if err := decode(&dir); err != nil {
+		return err
+	}
+	*d = resourceDirs{dir}
+	return nil
+}
+
+// overlayFS is a file system made of layers, where files in a layer hide
+// the ones with the same name in the layers after it, and the entries of
+// directories are those of the directory in every layer. It lets operators
+// change some of the resources without copying all of them.
+type overlayFS []fs.FS
+
+// newOverlayFS returns the resource directories layered over the embedded
+// resources.
+func newOverlayFS(dirs []string, embedded fs.FS) overlayFS {
+	layers := make(overlayFS, 0, len(dirs)+1)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		layers = append(layers, os.DirFS(dirs[i]))
+	}
+	return append(layers, embedded)
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	var err error
+	for _, layer := range o {
+		var f fs.File
+		if f, err = layer.Open(name); err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	found := false
+	for _, layer := range o {
+		des, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, de := range des {
+			if !seen[de.Name()] {
+				seen[de.Name()] = true
+				entries = append(entries, de)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// envPrefix is the prefix of the environment variables that override
+// options of the config file, as in DFS_BOT_TOKEN for BotToken.
+const envPrefix = "DFS_"
+
+// flagOverrides are the options set with flags, by the name of their field,
+// which override both the config file and the environment.
+var flagOverrides = make(map[string]string)
+
+// overrideFlag is the flag of an option of the config.
+type overrideFlag struct {
+	field string
+	bool  bool
+}
+
+func (f overrideFlag) String() string { return "" }
+
+func (f overrideFlag) Set(s string) error {
+	flagOverrides[f.field] = s
+	return nil
+}
+
+func (f overrideFlag) IsBoolFlag() bool { return f.bool }
+
+// overridable reports whether options of a type can be given as a single
+// string, which leaves out the per guild settings and the webhooks.
+func overridable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String || t.Elem().Kind() == reflect.Int
+	}
+	return false
+}
+
+// addConfigFlags adds a flag for each option of the config that can be
+// overridden, named like -bot-token for BotToken.
+func addConfigFlags(fs *flag.FlagSet) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !overridable(f.Type) {
+			continue
+		}
+		name := strings.ReplaceAll(strings.ToLower(splitWords(f.Name)), "_", "-")
+		usage := fmt.Sprintf("set %s, overriding the config file and %s%s", f.Name, envPrefix, strings.ToUpper(splitWords(f.Name)))
+		fs.Var(overrideFlag{field: f.Name, bool: f.Type.Kind() == reflect.Bool}, name, usage)
+	}
+}
+
+// applyOverrides sets the options of a config that are given in the
+// environment or with flags. Lists are separated by commas.
+func applyOverrides(c *Config) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !overridable(f.Type) {
+			continue
+		}
+		env := envPrefix + strings.ToUpper(splitWords(f.Name))
+		if s, ok := os.LookupEnv(env); ok {
+			if err := setOption(v.Field(i), s); err != nil {
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+		}
+		if s, ok := flagOverrides[f.Name]; ok {
+			if err := setOption(v.Field(i), s); err != nil {
+				return fmt.Errorf("invalid value for flag of %s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setOption(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		list := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setOption(list.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	}
+	return nil
+}
+
+// splitWords separates the words of a field name with underscores, keeping
+// acronyms together, as in HTTP_Listen_Addr and Shard_IDs.
+func splitWords(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rune(name[i-1])
+			nextLower := i+1 < len(name) && unicode.IsLower(rune(name[i+1]))
+			// The s of a plural acronym isn't the start of a word.
+			plural := i+1 < len(name) && name[i+1] == 's' &&
+				(i+2 == len(name) || unicode.IsUpper(rune(name[i+2])))
+			if unicode.IsLower(prev) || (nextLower && !plural) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
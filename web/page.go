@@ -0,0 +1,190 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// Page holds the data that is shared by every page, for the header and
+// footer templates. Each page's context embeds it.
+type Page struct {
+	// ContextVersion is ContextVersion, for custom templates to check that
+	// they are given the contexts they were written against.
+	ContextVersion int
+	// Theme is the name of the stylesheet under static/themes to use on top
+	// of the default one, or empty for the default look.
+	Theme  string
+	Themes []string
+	// License is the license of the guild whose content is on the page.
+	License *License
+	Locale  *Locale
+	// FrozenAt is when the guild's archive was frozen, if it was.
+	FrozenAt *time.Time
+	// Branding is how the guild whose content is on the page looks on
+	// Discord.
+	Branding *Branding
+	// GuildPath is the path of the guild's page, which links to its
+	// content start with.
+	GuildPath string
+	// SiteURL is the URL the site is served at, for the links that have
+	// to be absolute.
+	SiteURL string
+	Meta    PageMeta
+	// Degraded is set while the gateway is disconnected, so that what is
+	// shown may be out of date.
+	Degraded bool
+	// Reports is set if readers can report pages to the operator.
+	Reports bool
+	// CustomHead and CustomFooter are the operator's own HTML for the head
+	// and the end of the page.
+	CustomHead   template.HTML
+	CustomFooter template.HTML
+	// stale is set if something on the page couldn't be fetched from
+	// Discord and is shown as it was last stored.
+	stale *atomic.Bool
+}
+
+// Stale reports whether the page shows what was stored because Discord
+// couldn't be reached. It is only known once the page has been put
+// together, so it is a method for templates to call as they render.
+func (p Page) Stale() bool {
+	return p.stale != nil && p.stale.Load()
+}
+
+func (s *Server) page(w http.ResponseWriter, r *http.Request) Page {
+	varyLanguage(w)
+	return Page{
+		ContextVersion: ContextVersion,
+		Theme:          s.theme(w, r),
+		Themes:         s.site().themes,
+		Locale:         requestLocale(r),
+		SiteURL:        s.baseURL(r),
+		Degraded:       s.gateways.degraded(),
+		Reports:        s.reportLimiter != nil,
+		CustomHead:     s.site().CustomHead,
+		CustomFooter:   s.site().CustomFooter,
+		stale:          staleFlag(r),
+	}
+}
+
+// guildPage is like page, for pages that show a guild's content.
+func (s *Server) guildPage(w http.ResponseWriter, r *http.Request, guildID discord.GuildID) Page {
+	p := s.page(w, r)
+	p.License = s.guildLicense(guildID)
+	p.GuildPath = s.requestGuildPath(r, guildID)
+	p.Meta.Canonical = s.canonicalURL(r, guildID)
+	p.Degraded = !s.gateways.connected(s.bots.index(s.bots.forGuild(guildID)))
+	if t, ok := s.frozen.frozenAt(guildID); ok {
+		p.FrozenAt = &t
+	}
+	if guild, err := s.bots.forGuild(guildID).Cabinet.Guild(guildID); err == nil {
+		p.Branding = s.guildBranding(guild)
+	}
+	return p
+}
+
+// PageMeta is where a page is on the site, for the links in its head and
+// the breadcrumbs above it.
+type PageMeta struct {
+	// Canonical is the URL that search engines should index the page as.
+	Canonical string
+	// Prev and Next are the URLs of the pages before and after this one,
+	// if it is one of several.
+	Prev, Next string
+	// PageNumber is the number of the page, counting from 1, if it is one
+	// of several numbered pages.
+	PageNumber int
+	// Feed is the URL of an Atom feed of what the page lists, if it has
+	// one.
+	Feed string
+	// Breadcrumbs lead from the guild to the page, which is the last one.
+	Breadcrumbs []Breadcrumb
+}
+
+// setPageLinks sets the Link header of a page to the pages before and
+// after it, as its <link> elements have them, so that crawlers and other
+// clients can walk through the pages without parsing them.
+func setPageLinks(w http.ResponseWriter, m PageMeta) {
+	var links []string
+	if m.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, m.Prev))
+	}
+	if m.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, m.Next))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// Breadcrumb is a page on the way from a guild to the page being shown.
+type Breadcrumb struct {
+	Name string
+	Path string
+}
+
+// Parents returns the breadcrumbs leading up to the page.
+func (m PageMeta) Parents() []Breadcrumb {
+	if len(m.Breadcrumbs) == 0 {
+		return nil
+	}
+	return m.Breadcrumbs[:len(m.Breadcrumbs)-1]
+}
+
+// Current returns the breadcrumb of the page itself.
+func (m PageMeta) Current() *Breadcrumb {
+	if len(m.Breadcrumbs) == 0 {
+		return nil
+	}
+	return &m.Breadcrumbs[len(m.Breadcrumbs)-1]
+}
+
+// breadcrumbs returns the trail from a guild to one of its forums and a
+// post in it, which stops early if forum or post is nil.
+func (s *Server) breadcrumbs(r *http.Request, guild *discord.Guild, forum, post *discord.Channel) []Breadcrumb {
+	path := s.requestGuildPath(r, guild.ID)
+	crumbs := []Breadcrumb{{Name: guild.Name, Path: path}}
+	if path == "" {
+		crumbs[0].Path = "/"
+	}
+	if forum == nil {
+		return crumbs
+	}
+	path += "/" + forum.ID.String()
+	crumbs = append(crumbs, Breadcrumb{Name: forum.Name, Path: path})
+	if post == nil {
+		return crumbs
+	}
+	path += "/" + post.ID.String()
+	return append(crumbs, Breadcrumb{Name: post.Name, Path: path})
+}
+
+// pageCount returns how many pages items are shown on, with per on each.
+// There is always at least one page, even if it is empty.
+func pageCount(items, per int) int {
+	if items <= per {
+		return 1
+	}
+	return (items + per - 1) / per
+}
+
+// pageWindow returns the numbers of the pages to link to from one of pages
+// numbered pages: the first and last, and the ones within radius of page.
+// Gaps between them are 0, to show as an ellipsis.
+func pageWindow(page, pages, radius int) []int {
+	var window []int
+	for n := 1; n <= pages; n++ {
+		if n == 1 || n == pages || (n >= page-radius && n <= page+radius) {
+			window = append(window, n)
+		} else if len(window) == 0 || window[len(window)-1] != 0 {
+			window = append(window, 0)
+		}
+	}
+	return window
+}
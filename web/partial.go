@@ -0,0 +1,115 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// maxRangeMessages is the most messages that a range of a post's messages
+// is rendered with. Longer ranges are cut off there, with the rest linked
+// to as the next page in the Link header.
+const maxRangeMessages = 100
+
+// getPostMessages renders the messages of a post from the one with the
+// ID in the from parameter to the one in to, both included, as a fragment
+// of HTML without the rest of the page around them. Either can be left out
+// to start at the first message or go on to the last. They are meant to be
+// loaded into frames, or taken out of the post by other programs.
+func (s *Server) getPostMessages(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	from, to, err := messageRange(r)
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	after := from
+	if after.IsValid() {
+		after--
+	}
+	msgs, _, hasafter, err := s.messageCache.MessagesAfter(r.Context(), post.ID, after, maxRangeMessages)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching post's messages: %w", err))
+		return
+	}
+	for i, m := range msgs {
+		if to.IsValid() && m.ID > to {
+			msgs, hasafter = msgs[:i], false
+			break
+		}
+	}
+	if hasafter && len(msgs) > 0 {
+		// The range was cut off, so the rest of it is linked to.
+		q := r.URL.Query()
+		q.Set("from", (msgs[len(msgs)-1].ID + 1).String())
+		next := fmt.Sprintf("%s%s/%s/%s/messages?%s", s.baseURL(r), s.requestGuildPath(r, guild.ID), forum.ID, post.ID, q.Encode())
+		setPageLinks(w, PageMeta{Next: next})
+	}
+	s.executeMessages(w, r, guild.ID, forum, post, msgs)
+}
+
+// executeMessages renders messages of a post as a fragment of HTML.
+func (s *Server) executeMessages(w http.ResponseWriter, r *http.Request, guildID discord.GuildID, forum, post *discord.Channel, msgs []discord.Message) {
+	if err := s.ensureMembers(r.Context(), *post, msgs); err != nil {
+		log.Printf("Error looking up members of %s: %v", post.ID, err)
+	}
+	restrictRole, err := s.consentRole(forum)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	page := s.guildPage(w, r, guildID)
+	groups, err := s.messageGroups(r.Context(), guildID, post, msgs, restrictRole, page.Locale, true, false)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	ctx := struct {
+		Page
+		Post          Post
+		MessageGroups []MessageGroup
+	}{
+		Page:          page,
+		Post:          Post{Channel: *post, Tags: postTags(forum, post)},
+		MessageGroups: groups,
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	s.executeTemplate(w, r, "messages.gohtml", ctx)
+}
+
+// messageRange returns the IDs of the first and last messages of the range
+// that a request asks for, which are 0 if they are left out.
+func messageRange(r *http.Request) (from, to discord.MessageID, err error) {
+	for _, p := range []struct {
+		name string
+		id   *discord.MessageID
+	}{{"from", &from}, {"to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		sf, err := discord.ParseSnowflake(v)
+		if err != nil {
+			return 0, 0, errInvalidRange
+		}
+		*p.id = discord.MessageID(sf)
+	}
+	if from.IsValid() && to.IsValid() && to < from {
+		return 0, 0, errInvalidRange
+	}
+	return from, to, nil
+}
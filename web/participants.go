@@ -0,0 +1,36 @@
+package web
+
+import (
+	"context"
+	"log"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// participants returns the first authors to post in a post, for the strip
+// of avatars above its messages, and how many have posted in it. Authors
+// that opted out or haven't consented to being shown are left out of the
+// strip, but are still counted.
+func (s *Server) participants(ctx context.Context, post *discord.Channel, restrictRole int) ([]Author, int, error) {
+	limit := s.site().Participants
+	if limit == 0 {
+		return nil, 0, nil
+	}
+	msgs, count, err := s.db.Participants(ctx, post.ID, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := s.ensureMembers(ctx, *post, msgs); err != nil {
+		log.Printf("Error looking up participants of %s: %v", post.ID, err)
+	}
+	var authors []Author
+	for _, m := range msgs {
+		m.GuildID = post.GuildID
+		auth := s.author(m)
+		if auth.Anonymous || !consented(auth, restrictRole) {
+			continue
+		}
+		authors = append(authors, auth)
+	}
+	return authors, count, nil
+}
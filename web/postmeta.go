@@ -0,0 +1,101 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// PostMeta is what is known about a post without fetching its messages,
+// for archivers to plan how much of a guild they crawl.
+type PostMeta struct {
+	ID    discord.ChannelID `json:"id"`
+	Title string            `json:"title"`
+	URL   string            `json:"url"`
+	// MessageCount counts the starter message as well, unlike Discord's
+	// count of a thread's messages. An edit or deletion doesn't change
+	// LastMessage.
+	MessageCount int       `json:"message_count"`
+	FirstMessage time.Time `json:"first_message"`
+	LastMessage  time.Time `json:"last_message"`
+	// PageCount is how many pages the messages of the post are shown on,
+	// with MessagesPerPage on each.
+	PageCount       int  `json:"page_count"`
+	MessagesPerPage int  `json:"messages_per_page"`
+	Archived        bool `json:"archived"`
+	Locked          bool `json:"locked"`
+	// Snapshot is how up to date the archive of the post is, if its
+	// messages are stored.
+	Snapshot *PostSnapshot `json:"snapshot,omitempty"`
+}
+
+// PostSnapshot is when the stored messages of a post were archived, for
+// citing how up to date the archive is.
+type PostSnapshot struct {
+	// FirstArchived is when the post was first archived, if that is
+	// known, and LastRefreshed when its messages were last fetched from
+	// Discord, when it had MessageCount messages.
+	FirstArchived *time.Time `json:"first_archived,omitempty"`
+	LastRefreshed time.Time  `json:"last_refreshed"`
+	MessageCount  int        `json:"message_count"`
+}
+
+// postSnapshot returns how up to date the stored messages of a post are,
+// or nil if none are stored.
+func (s *Server) postSnapshot(ctx context.Context, post discord.ChannelID) *PostSnapshot {
+	f, err := s.db.Freshness(ctx, post)
+	if err != nil {
+		log.Printf("Error reading when %s was archived: %v", post, err)
+		return nil
+	}
+	if f.LastRefreshed.IsZero() {
+		return nil
+	}
+	snap := &PostSnapshot{LastRefreshed: f.LastRefreshed.UTC(), MessageCount: f.MessageCount}
+	if !f.FirstArchived.IsZero() {
+		first := f.FirstArchived.UTC()
+		snap.FirstArchived = &first
+	}
+	return snap
+}
+
+// getPostMeta serves the metadata of a post, from the cached channel
+// without fetching any messages.
+func (s *Server) getPostMeta(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+	per := s.site().MessagesPerPage
+	meta := PostMeta{
+		ID:              post.ID,
+		Title:           post.Name,
+		URL:             s.baseURL(r) + s.breadcrumbs(r, guild, forum, post)[2].Path,
+		MessageCount:    post.MessageCount + 1,
+		FirstMessage:    post.ID.Time().UTC(),
+		LastMessage:     postModTime(post).UTC(),
+		MessagesPerPage: per,
+	}
+	meta.PageCount = pageCount(meta.MessageCount, per)
+	if md := post.ThreadMetadata; md != nil {
+		meta.Archived, meta.Locked = md.Archived, md.Locked
+	}
+	meta.Snapshot = s.postSnapshot(r.Context(), post.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", meta.LastMessage.Format(http.TimeFormat))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(meta)
+}
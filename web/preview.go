@@ -0,0 +1,62 @@
+package web
+
+import (
+	"context"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// maxExcerpt is how many characters of a post's starter message forum
+// pages show, and previewSize the size its thumbnail fits in, or
+// galleryPreviewSize in the gallery layout.
+const (
+	maxExcerpt         = 200
+	previewSize        = 160
+	galleryPreviewSize = 320
+)
+
+// addPreviews fills in the excerpts and thumbnails of posts listed on a
+// page from their stored starter messages. Like the table of contents, it
+// only looks at what is stored, so posts whose messages haven't been
+// fetched yet are listed without one.
+func (s *Server) addPreviews(ctx context.Context, posts []Post, size uint) error {
+	ids := make([]discord.ChannelID, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	starters, err := s.db.StarterMessages(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		m, ok := starters[posts[i].ID]
+		if !ok || s.optOuts.has(m.Author.ID) {
+			continue
+		}
+		posts[i].Excerpt = excerpt(s.site().redact(m.Content))
+		for _, at := range m.Attachments {
+			if at.Height == 0 || !strings.HasPrefix(at.ContentType, "image/") || isSpoiler(at) {
+				continue
+			}
+			w, h := fitSize(at, size, size)
+			posts[i].Thumbnail = template.URL(s.attachmentURL(m, at, w, h))
+			break
+		}
+	}
+	return nil
+}
+
+// markupRegex matches the mentions and custom emojis in the markdown of a
+// message, with the name of the emoji as its group.
+var markupRegex = regexp.MustCompile(`<(?:@[!&]?|#)\d+>|<a?(:\w+:)\d+>`)
+
+// excerpt returns the start of a message's content as one line of text,
+// without its spoilers and mentions, and with custom emojis by their name.
+func excerpt(content string) string {
+	content = spoilerRegex.ReplaceAllString(content, "…")
+	content = markupRegex.ReplaceAllString(content, "$1")
+	return truncate(maxExcerpt, strings.Join(strings.Fields(content), " "))
+}
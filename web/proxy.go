@@ -0,0 +1,109 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNetworks parses a list of CIDRs, where a single address stands for a
+// network with just that address.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// trustedProxy reports whether a peer is a reverse proxy whose forwarding
+// headers are believed. Peers over a unix socket have no IP address, and
+// are trusted, since only local processes can connect to one.
+func (s *Server) trustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return true
+	}
+	for _, n := range s.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedIP parses an address in X-Forwarded-For, which some proxies put
+// the client's port in as well.
+func forwardedIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// proxyHeaders is a middleware that replaces the address and scheme of
+// requests that come through a trusted reverse proxy with the ones of the
+// client, from X-Forwarded-For and X-Forwarded-Proto, so that they are what
+// is logged and rate limited and what absolute URLs are built from. Each
+// proxy appends the address it got the request from to X-Forwarded-For, so
+// the client is the last address that isn't a trusted proxy; the ones
+// before it could have been made up by the client.
+func (s *Server) proxyHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.trustedProxy(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := *r
+		var hops []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(h, ",")...)
+		}
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := forwardedIP(hops[i])
+			if ip == nil {
+				break
+			}
+			r2.RemoteAddr = ip.String()
+			if !s.trustedProxy(ip) {
+				break
+			}
+		}
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			u := *r.URL
+			u.Scheme = proto
+			r2.URL = &u
+		}
+		next.ServeHTTP(w, &r2)
+	})
+}
+
+// baseURL returns the URL the site is served at, for absolute links. It is
+// SiteURL if that is set, or else the scheme and host the request was made
+// to.
+func (s *Server) baseURL(r *http.Request) string {
+	if id, ok := hostGuild(r); ok {
+		return s.domainURL(s.guildConfig(id).Domain)
+	}
+	if u := s.site().URL; u != "" {
+		return u
+	}
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits how many pages each client can request, with a token
+// bucket per IP address, so that a crawler can't make the server fetch the
+// history of every post from Discord at once. IPv6 clients are limited per
+// /64, since that is what a single host is usually given.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	exempt    []*net.IPNet
+	clients   map[string]*rateClient
+	lastSweep time.Time
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateClientIdle is how long a client's bucket is kept after its last
+// request. A bucket is full again long before then.
+const rateClientIdle = 10 * time.Minute
+
+// newRateLimiter returns a rate limiter allowing rps requests a second with
+// bursts of up to burst requests, to clients outside of the exempt CIDRs.
+func newRateLimiter(rps float64, burst int, exempt []string) (*rateLimiter, error) {
+	l := &rateLimiter{
+		limit:     rate.Limit(rps),
+		burst:     burst,
+		clients:   make(map[string]*rateClient),
+		lastSweep: time.Now(),
+	}
+	if l.burst < 1 {
+		l.burst = int(math.Ceil(rps))
+	}
+	if err := l.setExempt(exempt); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// setExempt replaces the CIDRs of the clients that aren't limited.
+func (l *rateLimiter) setExempt(exempt []string) error {
+	nets, err := parseNetworks(exempt)
+	if err != nil {
+		return fmt.Errorf("invalid exempt networks: %w", err)
+	}
+	l.mu.Lock()
+	l.exempt = nets
+	l.mu.Unlock()
+	return nil
+}
+
+// clientKey returns the key of the bucket of the client with the address
+// ip, or an empty key if the client is exempt.
+func (l *rateLimiter) clientKey(ip net.IP) string {
+	l.mu.Lock()
+	exempt := l.exempt
+	l.mu.Unlock()
+	for _, n := range exempt {
+		if n.Contains(ip) {
+			return ""
+		}
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
+
+// reserve takes a token from the bucket of a client, returning how long the
+// client has to wait before its request can be served if there are none.
+func (l *rateLimiter) reserve(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > rateClientIdle {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	res := c.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// clientIP returns the IP address of the client that made a request.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// rateLimit is a middleware that answers requests from clients that are
+// over their limit with a 429 telling them when to come back.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := s.limiter.clientKey(ip)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if delay := s.limiter.reserve(key); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			s.displayErr(w, r, http.StatusTooManyRequests, errRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,264 @@
+package web
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// maxRecentPosts is how many of the newest posts across every guild are
+// kept for /recent and its feed.
+const maxRecentPosts = 100
+
+// recentPosts are the newest posts of the guilds the bots serve, newest
+// first. They are collected from the gateway, from the active posts of
+// guilds as they become available and then from new posts as they are
+// made, so that they don't have to be gathered from every guild for each
+// view.
+type recentPosts struct {
+	mu    sync.Mutex
+	posts []discord.Channel
+}
+
+// add adds posts to the list, dropping the oldest ones over
+// maxRecentPosts.
+func (p *recentPosts) add(posts ...discord.Channel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, post := range posts {
+		if post.Type != discord.GuildPublicThread {
+			continue
+		}
+		i := sort.Search(len(p.posts), func(i int) bool { return p.posts[i].ID <= post.ID })
+		if i < len(p.posts) && p.posts[i].ID == post.ID {
+			p.posts[i] = post
+			continue
+		}
+		if i == maxRecentPosts {
+			continue
+		}
+		p.posts = append(p.posts, discord.Channel{})
+		copy(p.posts[i+1:], p.posts[i:])
+		p.posts[i] = post
+		if len(p.posts) > maxRecentPosts {
+			p.posts = p.posts[:maxRecentPosts]
+		}
+	}
+}
+
+// update replaces a post that was renamed or retagged. Posts that aren't
+// in the list aren't added, since only new posts are.
+func (p *recentPosts) update(post discord.Channel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.posts {
+		if p.posts[i].ID == post.ID {
+			p.posts[i] = post
+		}
+	}
+}
+
+func (p *recentPosts) remove(id discord.ChannelID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.posts {
+		if p.posts[i].ID == id {
+			p.posts = append(p.posts[:i], p.posts[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *recentPosts) list() []discord.Channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]discord.Channel(nil), p.posts...)
+}
+
+// handleRecentPosts returns the handler that collects the newest posts
+// from a bot's gateway connection.
+func (s *Server) handleRecentPosts() func(interface{}) {
+	return func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *state.GuildReadyEvent:
+			s.recent.add(ev.Threads...)
+		case *state.GuildJoinEvent:
+			s.recent.add(ev.Threads...)
+		case *gateway.ThreadCreateEvent:
+			s.recent.add(ev.Channel)
+			// Threads are also created for bots that are added to them,
+			// which doesn't put them in the feed.
+			if ev.Type == discord.GuildPublicThread && time.Since(ev.ID.Time()) <= newPostAge {
+				s.requestFeedPublish()
+			}
+		case *gateway.ThreadUpdateEvent:
+			s.recent.update(ev.Channel)
+		case *gateway.ThreadDeleteEvent:
+			s.recent.remove(ev.ID)
+			s.requestFeedPublish()
+		}
+	}
+}
+
+// RecentPost is a post listed on /recent, with where it was posted.
+type RecentPost struct {
+	Post
+	Guild *discord.Guild
+	Forum *discord.Channel
+	// Path and ForumPath are the paths of the pages of the post and of
+	// its forum.
+	Path, ForumPath string
+}
+
+// recentPostsToShow returns the newest posts that can be shown, which are
+// the posts of forums that are served to everyone and that the bot can
+// read. Posts in archives frozen before they were made are left out.
+func (s *Server) recentPostsToShow() []RecentPost {
+	var posts []RecentPost
+	for _, post := range s.recent.list() {
+		if post.Type != discord.GuildPublicThread {
+			continue
+		}
+		if t, ok := s.frozen.frozenAt(post.GuildID); ok && post.ID.Time().After(t) {
+			continue
+		}
+		st := s.bots.forGuild(post.GuildID)
+		guild, err := st.Cabinet.Guild(post.GuildID)
+		if err != nil {
+			continue
+		}
+		forum, err := s.channel(post.ParentID)
+		if err != nil || forum.Type != discord.GuildForum || forum.NSFW {
+			continue
+		}
+		if !s.canServe(forum) {
+			continue
+		}
+		post := post
+		posts = append(posts, RecentPost{
+			Post:      Post{Channel: post, Tags: postTags(forum, &post)},
+			Guild:     guild,
+			Forum:     forum,
+			Path:      postPath(s.guildPath(guild.ID), forum.ID, post.ID),
+			ForumPath: forumPath(s.guildPath(guild.ID), forum.ID),
+		})
+	}
+	return posts
+}
+
+func (s *Server) getRecent(w http.ResponseWriter, r *http.Request) {
+	ctx := struct {
+		Page
+		Posts []RecentPost
+	}{
+		Page:  s.page(w, r),
+		Posts: s.recentPostsToShow(),
+	}
+	ctx.Meta.Canonical = s.baseURL(r) + "/recent"
+	ctx.Meta.Feed = s.baseURL(r) + feedPath
+	s.executeTemplate(w, r, "recent.gohtml", ctx)
+}
+
+// atomFeed is an Atom feed, as described by RFC 4287.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Link       atomLink       `xml:"link"`
+	Summary    string         `xml:"summary"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// getRecentFeed serves the newest posts as an Atom feed. Posts are dated
+// by when they were made, so that readers don't show them again when
+// someone replies.
+func (s *Server) getRecentFeed(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+	name := s.site().ServiceName
+	if name == "" {
+		name = "dforum"
+	}
+	posts := s.recentPostsToShow()
+	varyLanguage(w)
+	feed := atomFeed{
+		ID:    base + "/recent",
+		Title: requestLocale(r).T("New posts on %s", name),
+		Links: []atomLink{
+			{Href: base + feedPath, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/recent", Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: name},
+	}
+	if s.webSubHub != "" {
+		// Subscribers find the hub that the feed is published to in the
+		// feed or in its headers.
+		feed.Links = append(feed.Links, atomLink{Href: s.webSubHub, Rel: "hub"})
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, s.webSubHub))
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, base+feedPath))
+	}
+	// A feed without entries is as old as the process, which is when the
+	// posts started being collected.
+	updated := s.stats.startedAt
+	if len(posts) > 0 {
+		updated = posts[0].ID.Time()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	for _, post := range posts {
+		created := post.ID.Time().UTC().Format(time.RFC3339)
+		entry := atomEntry{
+			ID:        base + post.Path,
+			Title:     post.Name,
+			Published: created,
+			Updated:   created,
+			Link:      atomLink{Href: base + post.Path},
+			Summary:   requestLocale(r).T("New post in %s on %s", post.Forum.Name, post.Guild.Name),
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag.Name})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	if _, err := fmt.Fprint(w, xml.Header); err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Error writing feed: %v", err)
+	}
+}
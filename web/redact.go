@@ -0,0 +1,57 @@
+package web
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RedactionConfig is a pattern that is taken out of messages before they
+// are shown, for personal information that people posted without thinking
+// of it being published, like email addresses or phone numbers. It is
+// read from the [[Redactions]] tables in config.toml.
+type RedactionConfig struct {
+	// Pattern is a regular expression in the syntax of Go's regexp
+	// package.
+	Pattern string
+	// Replacement is what matches are replaced with, in which $1 stands
+	// for the text of the first group. It defaults to "[redacted]".
+	Replacement string
+}
+
+// defaultRedaction is what matches of a pattern without a replacement are
+// replaced with.
+const defaultRedaction = "[redacted]"
+
+type redaction struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// parseRedactions compiles the configured redaction patterns.
+func parseRedactions(cfgs []RedactionConfig) ([]redaction, error) {
+	var redactions []redaction
+	for _, cfg := range cfgs {
+		if cfg.Pattern == "" {
+			return nil, fmt.Errorf("redaction patterns can't be empty")
+		}
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", cfg.Pattern, err)
+		}
+		replacement := cfg.Replacement
+		if replacement == "" {
+			replacement = defaultRedaction
+		}
+		redactions = append(redactions, redaction{re: re, replacement: replacement})
+	}
+	return redactions, nil
+}
+
+// redact replaces what the redaction patterns match in the content of a
+// message, in the order they are configured.
+func (o *siteOptions) redact(content string) string {
+	for _, r := range o.redactions {
+		content = r.re.ReplaceAllString(content, r.replacement)
+	}
+	return content
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/naoina/toml"
+)
+
+// siteOptions are the configuration options that are applied to the
+// running server when the config file is reloaded. They are replaced as a
+// whole, so they are read through site rather than kept.
+type siteOptions struct {
+	URL            string
+	ServiceName    string
+	ServerHostedIn string
+	ServeNSFW      bool
+	// PostsPerPage is how many posts each page of a forum lists, and
+	// MessagesPerPage how many messages each page of a post shows.
+	PostsPerPage    int
+	MessagesPerPage int
+	// NewestFirst shows posts' messages newest first unless readers ask
+	// for them the other way around.
+	NewestFirst bool
+	// MinForumPosts and MaxForumInactive leave forums with fewer posts or
+	// no messages for longer out of guild pages and sitemaps, unless they
+	// are 0.
+	MinForumPosts    int
+	MaxForumInactive time.Duration
+	// Participants is how many of the authors of a post are shown above
+	// its messages, or 0 for none.
+	Participants int
+	// CustomHead is put into the head of every page, and CustomFooter at
+	// the end of their bodies, as the operator wrote them.
+	CustomHead   template.HTML
+	CustomFooter template.HTML
+	// themes are the themes found in the resources, and DefaultTheme the
+	// one readers get if they haven't picked one.
+	themes       []string
+	DefaultTheme string
+
+	// redactions are the patterns taken out of messages.
+	redactions []redaction
+
+	guilds          map[discord.GuildID]GuildConfig
+	slugs           map[string]discord.GuildID
+	domains         map[string]discord.GuildID
+	defaultLocale   *Locale
+	defaultTimezone *time.Location
+}
+
+// newSiteOptions checks the reloadable options of a config.
+func (s *Server) newSiteOptions(config Config) (*siteOptions, error) {
+	guilds, err := parseGuildConfigs(config.Guilds)
+	if err != nil {
+		return nil, err
+	}
+	slugs, err := guildSlugs(guilds)
+	if err != nil {
+		return nil, err
+	}
+	domains, err := guildDomains(guilds)
+	if err != nil {
+		return nil, err
+	}
+	themes, err := findThemes(s.fsys)
+	if err != nil {
+		return nil, fmt.Errorf("finding themes: %w", err)
+	}
+	if config.DefaultTheme != "" && !containsTheme(themes, config.DefaultTheme) {
+		return nil, fmt.Errorf("default theme %q not found", config.DefaultTheme)
+	}
+	defaultLocale, ok := s.locales[strings.ToLower(config.DefaultLocale)]
+	if !ok {
+		return nil, fmt.Errorf("default locale %q not found", config.DefaultLocale)
+	}
+	defaultTimezone, err := time.LoadLocation(config.DefaultTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("loading default timezone: %w", err)
+	}
+	if config.PostsPerPage < 1 || config.MessagesPerPage < 1 {
+		return nil, fmt.Errorf("page sizes must be at least 1")
+	}
+	if config.MinForumPosts < 0 || config.MaxForumInactiveDays < 0 {
+		return nil, fmt.Errorf("forum listing thresholds can't be negative")
+	}
+	if config.Participants < 0 {
+		return nil, fmt.Errorf("participants can't be negative")
+	}
+	redactions, err := parseRedactions(config.Redactions)
+	if err != nil {
+		return nil, err
+	}
+	var footer []byte
+	if config.CustomFooterFile != "" {
+		// It is read again on every reload, so it can be changed without
+		// a restart.
+		footer, err = os.ReadFile(config.CustomFooterFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading custom footer: %w", err)
+		}
+	}
+	return &siteOptions{
+		URL:              config.SiteURL,
+		ServiceName:      config.ServiceName,
+		ServerHostedIn:   config.ServerHostedIn,
+		ServeNSFW:        config.ServeNSFW,
+		PostsPerPage:     config.PostsPerPage,
+		MessagesPerPage:  config.MessagesPerPage,
+		NewestFirst:      config.NewestFirst,
+		MinForumPosts:    config.MinForumPosts,
+		MaxForumInactive: time.Duration(config.MaxForumInactiveDays) * 24 * time.Hour,
+		Participants:     config.Participants,
+		CustomHead:       template.HTML(config.CustomHeadHTML),
+		CustomFooter:     template.HTML(footer),
+		themes:           themes,
+		DefaultTheme:     config.DefaultTheme,
+		redactions:       redactions,
+		guilds:           guilds,
+		slugs:            slugs,
+		domains:          domains,
+		defaultLocale:    defaultLocale,
+		defaultTimezone:  defaultTimezone,
+	}, nil
+}
+
+// listsForum reports whether a forum is active enough to be listed on its
+// guild's page and in its sitemap.
+func (o *siteOptions) listsForum(forum ForumChannel) bool {
+	if o.MinForumPosts > 0 && len(forum.Posts) < o.MinForumPosts {
+		return false
+	}
+	if o.MaxForumInactive > 0 && time.Since(forum.LastActive) > o.MaxForumInactive {
+		return false
+	}
+	return true
+}
+
+// site returns the current reloadable options.
+func (s *Server) site() *siteOptions {
+	return s.opts.Load()
+}
+
+// reload applies the reloadable options of a config to the running server,
+// keeping the current ones if any of them is invalid. The gateway
+// connections and the HTTP server stay up; the other options only take
+// effect after a restart.
+func (s *Server) reload(config Config) error {
+	opts, err := s.newSiteOptions(config)
+	if err != nil {
+		return err
+	}
+	if s.limiter != nil {
+		if err := s.limiter.setExempt(config.RateLimitExempt); err != nil {
+			return err
+		}
+	}
+	old := s.opts.Swap(opts)
+	if s.warmer != nil {
+		// Warmed pages were rendered with the old options.
+		s.warmer.clear()
+	}
+	if s.renderCache != nil {
+		s.renderCache.clear()
+	}
+	if old.URL != opts.URL || !slugsEqual(old.slugs, opts.slugs) || !slugsEqual(old.domains, opts.domains) ||
+		old.MinForumPosts != opts.MinForumPosts || old.MaxForumInactive != opts.MaxForumInactive {
+		// Every guild's sitemap has the site's URL, slugs and domains in
+		// it, and the forums that the thresholds leave in.
+		guilds, err := s.bots.guilds()
+		if err != nil {
+			log.Printf("Error listing guilds to update sitemaps: %v", err)
+		}
+		for _, g := range guilds {
+			s.markSitemapDirty(g.ID)
+		}
+	}
+	return nil
+}
+
+func slugsEqual(a, b map[string]discord.GuildID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for slug, id := range a {
+		if b[slug] != id {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultConfig returns the defaults of the options that config files
+// don't set.
+func defaultConfig() Config {
+	return Config{
+		ListenAddr:         ":8084",
+		DefaultLocale:      "en",
+		DefaultTimezone:    "UTC",
+		MaxRevisions:       10,
+		MembersIntent:      true,
+		MaxCachedChannels:  10000,
+		PostsPerPage:       25,
+		MessagesPerPage:    25,
+		Participants:       10,
+		AccessLogFormat:    "clf",
+		AccessLogIPs:       "truncate",
+		AccessLogMaxSizeMB: 100,
+		AccessLogBackups:   5,
+	}
+}
+
+// ReadConfig reads and parses the config file, with the defaults of the
+// options that aren't set, and then applies the options given in the
+// environment and with flags. The file doesn't have to exist if those are
+// all the options that are needed.
+func ReadConfig(path string) (Config, error) {
+	config := defaultConfig()
+	file, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("No config file at %s, using the environment and flags", path)
+	} else if err != nil {
+		return config, fmt.Errorf("reading config: %w", err)
+	} else if err := toml.Unmarshal(file, &config); err != nil {
+		return config, fmt.Errorf("parsing config: %w", err)
+	}
+	if err := applyOverrides(&config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// renderCache keeps the rendered pages of posts that are locked and
+// archived on disk. Those posts can only change if moderators edit them,
+// so their pages are rendered once and then served from the files, without
+// looking at their messages again.
+type renderCache struct {
+	// dir is where the pages are written for the current resources, which
+	// is a directory named after a hash of them, so that pages rendered
+	// with other templates aren't served.
+	dir string
+
+	mu        sync.Mutex
+	rendering map[discord.ChannelID]bool
+}
+
+// newRenderCache opens the render cache in dir, removing the pages in it
+// that were rendered with other resources than those in fsys.
+func newRenderCache(dir string, fsys fs.FS) (*renderCache, error) {
+	hash := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", p, len(b))
+		hash.Write(b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing resources: %w", err)
+	}
+	fmt.Fprint(hash, version())
+	key := hex.EncodeToString(hash.Sum(nil)[:8])
+	if err := os.MkdirAll(filepath.Join(dir, key), 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name() != key {
+			if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				log.Printf("Error removing outdated rendered pages in %s: %v", e.Name(), err)
+			}
+		}
+	}
+	return &renderCache{
+		dir:       filepath.Join(dir, key),
+		rendering: make(map[discord.ChannelID]bool),
+	}, nil
+}
+
+func (c *renderCache) file(id discord.ChannelID) string {
+	return filepath.Join(c.dir, id.String()+".html")
+}
+
+// forget removes the rendered pages of posts.
+func (c *renderCache) forget(ids map[discord.ChannelID]bool) {
+	for id := range ids {
+		if err := os.Remove(c.file(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing rendered page of %s: %v", id, err)
+		}
+	}
+}
+
+// clear removes every rendered page, for changes that can show on any of
+// them.
+func (c *renderCache) clear() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Println("Error listing rendered pages:", err)
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".html") {
+			os.Remove(filepath.Join(c.dir, e.Name()))
+		}
+	}
+}
+
+// handleEvent removes the rendered pages of posts that moderators changed.
+// Changes to forums or roles can change who may see any post, so they
+// remove every page, like changes to the site options do.
+func (c *renderCache) handleEvent(ev interface{}) {
+	var id discord.ChannelID
+	switch ev := ev.(type) {
+	case *gateway.ChannelUpdateEvent:
+		if ev.Type == discord.GuildForum {
+			c.clear()
+		}
+		return
+	case *gateway.GuildRoleUpdateEvent, *gateway.GuildRoleDeleteEvent:
+		c.clear()
+		return
+	case *gateway.MessageCreateEvent:
+		id = ev.ChannelID
+	case *gateway.MessageUpdateEvent:
+		id = ev.ChannelID
+	case *gateway.MessageDeleteEvent:
+		id = ev.ChannelID
+	case *gateway.MessageDeleteBulkEvent:
+		id = ev.ChannelID
+	case *gateway.ThreadUpdateEvent:
+		id = ev.ID
+	case *gateway.ThreadDeleteEvent:
+		id = ev.ID
+	default:
+		return
+	}
+	c.forget(map[discord.ChannelID]bool{id: true})
+}
+
+// serveRendered is a middleware that answers plain requests for the pages
+// of locked and archived posts from the render cache, rendering them into
+// it first if they aren't there yet.
+func (s *Server) serveRendered(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := postPathRegex.FindStringSubmatch(r.URL.Path)
+		if m == nil || isRendering(r) || !s.plainRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sf, _ := discord.ParseSnowflake(m[1])
+		id := discord.ChannelID(sf)
+		post, err := s.bots.forChannel(id).Cabinet.Channel(id)
+		if err != nil || post.ThreadMetadata == nil || !post.ThreadMetadata.Locked ||
+			!post.ThreadMetadata.Archived || r.URL.Path != fmt.Sprintf("/%s/%s/%s", post.GuildID, post.ParentID, post.ID) ||
+			!s.servableFromCache(post) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c := s.renderCache
+		f, err := os.Open(c.file(id))
+		if os.IsNotExist(err) {
+			if !s.renderLocked(id, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			f, err = os.Open(c.file(id))
+		}
+		if err != nil {
+			log.Printf("Error opening rendered page of %s: %v", id, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()))
+		varyLanguage(w)
+		addSurrogateKey(w, post.GuildID.String())
+		addSurrogateKey(w, post.ID.String())
+		http.ServeContent(w, r, "", postModTime(post), f)
+	})
+}
+
+// renderLocked renders the page of a locked post at path into the render
+// cache, and reports whether it did. Posts that are already being rendered
+// aren't rendered twice.
+func (s *Server) renderLocked(id discord.ChannelID, path string) bool {
+	c := s.renderCache
+	c.mu.Lock()
+	if c.rendering[id] {
+		c.mu.Unlock()
+		return false
+	}
+	c.rendering[id] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.rendering, id)
+		c.mu.Unlock()
+	}()
+	rec, ok := s.renderPlainPage(path)
+	if !ok {
+		return false
+	}
+	err := writeFileAtomic(c.file(id), func(w io.Writer) error {
+		_, err := rec.Body.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error writing rendered page of %s: %v", id, err)
+		return false
+	}
+	return true
+}
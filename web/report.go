@@ -0,0 +1,219 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// reportReasons are the kinds of problems that reports can be about, in
+// English for Locale.T, by the keys the form sends.
+var reportReasons = []struct{ Key, Name string }{
+	{"illegal", "Illegal content"},
+	{"personal", "Personal information"},
+	{"copyright", "Copyright infringement"},
+	{"harassment", "Harassment"},
+	{"other", "Something else"},
+}
+
+// maxReportDetails is how long the details of a report can be, which fits
+// in the description of a Discord embed.
+const maxReportDetails = 4000
+
+// reportTimeout is how long sending a report to the operator is given.
+const reportTimeout = 10 * time.Second
+
+// reportLimit and reportBurst limit how many reports each client can send:
+// a few at once, and then one every 10 minutes.
+const (
+	reportLimit = 1.0 / 600
+	reportBurst = 3
+)
+
+var (
+	errReportLimited = &readerError{http.StatusTooManyRequests, "Too Many Requests",
+		"You have sent several reports in a short time. Wait a few minutes and try again."}
+	errReportURL = &readerError{http.StatusBadRequest, "Bad Request",
+		"Reports can only be made about pages on this site."}
+	errReportNotSent = &readerError{http.StatusBadGateway, "Report Not Sent",
+		"Your report couldn't be passed on to the operator of this instance. Try again later."}
+)
+
+// Report is a report of a problem with archived content, which the
+// operator is notified of.
+type Report struct {
+	URL     string
+	Reason  string
+	Details string
+	Contact string
+}
+
+func (s *Server) getReport(w http.ResponseWriter, r *http.Request) {
+	s.executeReport(w, r, Report{URL: r.URL.Query().Get("url")}, false)
+}
+
+func (s *Server) executeReport(w http.ResponseWriter, r *http.Request, report Report, sent bool) {
+	ctx := struct {
+		Page
+		Report  Report
+		Reasons []struct{ Key, Name string }
+		// Sent is set once the report has been passed on.
+		Sent bool
+	}{
+		Page:    s.page(w, r),
+		Report:  report,
+		Reasons: reportReasons,
+		Sent:    sent,
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	s.executeTemplate(w, r, "report.gohtml", ctx)
+}
+
+// postReport passes a report on to the operator, by the report webhook and
+// in a direct message to each of the report users. Forms that have the
+// field hidden from people filled in were filled in by spam bots, and are
+// dropped as if they were sent.
+func (s *Server) postReport(w http.ResponseWriter, r *http.Request) {
+	if ip := clientIP(r); ip != nil && s.reportLimiter != nil {
+		if key := s.reportLimiter.clientKey(ip); key != "" && s.reportLimiter.reserve(key) > 0 {
+			s.displayErr(w, r, http.StatusTooManyRequests, errReportLimited)
+			return
+		}
+	}
+	report := Report{
+		URL:     strings.TrimSpace(r.PostFormValue("url")),
+		Reason:  r.PostFormValue("reason"),
+		Details: strings.TrimSpace(r.PostFormValue("details")),
+		Contact: strings.TrimSpace(r.PostFormValue("contact")),
+	}
+	if r.PostFormValue("website") != "" {
+		s.executeReport(w, r, Report{}, true)
+		return
+	}
+	u, ok := s.reportedURL(r, report.URL)
+	if !ok {
+		s.displayErr(w, r, http.StatusBadRequest, errReportURL)
+		return
+	}
+	report.URL = u
+	if !validReportReason(report.Reason) {
+		report.Reason = "other"
+	}
+	if len(report.Details) > maxReportDetails {
+		report.Details = truncate(maxReportDetails, report.Details)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), reportTimeout)
+	defer cancel()
+	if err := s.sendReport(ctx, report); err != nil {
+		log.Printf("Error sending report about %s: %v", report.URL, err)
+		s.displayErr(w, r, http.StatusBadGateway, errReportNotSent)
+		return
+	}
+	s.executeReport(w, r, Report{}, true)
+}
+
+// maxReportedURL is the length of the longest URL that reports can be
+// about, which is as long as the values of the fields of Discord's embeds,
+// that reports are sent in, can be. The site's own pages are far shorter.
+const maxReportedURL = 1024
+
+// reportedURL returns the absolute URL of a page on this site that a report
+// is about, which can be given as its path.
+func (s *Server) reportedURL(r *http.Request, page string) (string, bool) {
+	base := s.baseURL(r)
+	if strings.HasPrefix(page, "/") && !strings.HasPrefix(page, "//") {
+		page = base + page
+	}
+	u, err := url.Parse(page)
+	if err != nil {
+		return "", false
+	}
+	b, err := url.Parse(base)
+	if err != nil || !strings.EqualFold(u.Host, b.Host) || len(u.String()) > maxReportedURL {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// parseUserIDs parses the IDs of the users that reports are sent to.
+func parseUserIDs(ids []string) ([]discord.UserID, error) {
+	users := make([]discord.UserID, len(ids))
+	for i, id := range ids {
+		sf, err := discord.ParseSnowflake(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q: %w", id, err)
+		}
+		users[i] = discord.UserID(sf)
+	}
+	return users, nil
+}
+
+func validReportReason(key string) bool {
+	for _, reason := range reportReasons {
+		if reason.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// sendReport notifies the operator of a report, succeeding if any of the
+// ways it is sent did.
+func (s *Server) sendReport(ctx context.Context, report Report) error {
+	reason := report.Reason
+	for _, rr := range reportReasons {
+		if rr.Key == report.Reason {
+			reason = rr.Name
+		}
+	}
+	embed := discord.Embed{
+		Title:       "Report: " + reason,
+		URL:         report.URL,
+		Description: report.Details,
+		Timestamp:   discord.NewTimestamp(time.Now()),
+		Fields:      []discord.EmbedField{{Name: "Page", Value: report.URL}},
+	}
+	if report.Contact != "" {
+		embed.Fields = append(embed.Fields, discord.EmbedField{Name: "Contact", Value: truncate(1024, report.Contact)})
+	}
+	var sent bool
+	var lastErr error
+	if s.reportWebhook != "" {
+		body, err := json.Marshal(discordWebhookMessage{
+			Username: s.site().ServiceName,
+			Embeds:   []discord.Embed{embed},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := s.postWebhook(s.reportWebhook, body); err != nil {
+			lastErr = fmt.Errorf("sending report webhook: %w", err)
+		} else {
+			sent = true
+		}
+	}
+	client := s.bots[0].Client.WithContext(ctx)
+	for _, id := range s.reportUsers {
+		dm, err := client.CreatePrivateChannel(id)
+		if err == nil {
+			_, err = client.SendMessageComplex(dm.ID, api.SendMessageData{Embeds: []discord.Embed{embed}})
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("sending report to %s: %w", id, err)
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return lastErr
+	}
+	return nil
+}
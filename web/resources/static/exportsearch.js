@@ -0,0 +1,43 @@
+// exportsearch.js searches the posts of an exported guild in the browser,
+// since exports have no server to search them. The posts are in
+// searchIndex, which search-index.js next to the search page sets.
+(function () {
+    var results = document.getElementById("results");
+    var input = document.querySelector("input[name=q]");
+    var query = new URLSearchParams(location.search).get("q") || "";
+    input.value = query;
+    var words = query.toLowerCase().split(/\s+/).filter(function (w) { return w; });
+    if (words.length === 0 || typeof searchIndex === "undefined") {
+        return;
+    }
+    function matches(text) {
+        text = text.toLowerCase();
+        return words.every(function (w) { return text.indexOf(w) !== -1; });
+    }
+    // Posts whose titles match come before those that only mention the
+    // words in their messages.
+    var byTitle = [], byText = [];
+    searchIndex.posts.forEach(function (post) {
+        if (matches(post.title)) {
+            byTitle.push(post);
+        } else if (matches(post.text)) {
+            byText.push(post);
+        }
+    });
+    var found = byTitle.concat(byText);
+    if (found.length === 0) {
+        var none = document.createElement("li");
+        none.textContent = results.getAttribute("data-none");
+        results.appendChild(none);
+        return;
+    }
+    found.forEach(function (post) {
+        var li = document.createElement("li");
+        var a = document.createElement("a");
+        a.href = post.path;
+        a.textContent = post.title;
+        li.appendChild(a);
+        li.appendChild(document.createTextNode(" - " + post.forum));
+        results.appendChild(li);
+    });
+})();
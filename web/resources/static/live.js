@@ -0,0 +1,51 @@
+// live.js adds the messages that are posted in a post to its latest page
+// as they are posted, by polling the server for them. Browsers that can't
+// are reloaded every so often instead.
+(function () {
+    var messages = document.querySelector(".live[data-poll]");
+    if (!messages) {
+        return;
+    }
+    var url = messages.getAttribute("data-poll");
+    var refresh = Number(messages.getAttribute("data-refresh")) * 1000;
+    if (!window.fetch || !window.DOMParser) {
+        setTimeout(function () { location.reload(); }, refresh);
+        return;
+    }
+    var failures = 0;
+
+    function add(html) {
+        var doc = new DOMParser().parseFromString(html, "text/html");
+        var anchors = doc.querySelectorAll(".anchor[id^='m']");
+        if (anchors.length === 0) {
+            return;
+        }
+        // The next poll is for the messages after the last one added.
+        var last = anchors[anchors.length - 1].id.slice(1);
+        url = url.replace(/after=\d+/, "after=" + last);
+        while (doc.body.firstChild) {
+            messages.appendChild(document.adoptNode(doc.body.firstChild));
+        }
+    }
+
+    function poll() {
+        fetch(url, { credentials: "same-origin" }).then(function (resp) {
+            if (resp.status === 204) {
+                return "";
+            }
+            if (!resp.ok) {
+                throw new Error(resp.statusText);
+            }
+            return resp.text();
+        }).then(function (html) {
+            failures = 0;
+            add(html);
+            poll();
+        }).catch(function () {
+            // Wait longer between tries while the server can't be reached.
+            failures++;
+            setTimeout(poll, Math.min(failures * 5000, refresh));
+        });
+    }
+    poll();
+})();
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/diamondburned/arikawa/v3/utils/httputil/httpdriver"
+)
+
+// Requests to Discord that fail with a server error or a rate limit are
+// tried again up to discordAttempts times in all, waiting a random time of
+// up to discordBackoff before the second try and twice as long up to
+// before each one after. Pages only have pageTimeout to load, so the waits
+// add up to well under it.
+const (
+	discordAttempts = 3
+	discordBackoff  = 500 * time.Millisecond
+	// maxRetryAfter is the longest a Retry-After from Discord is waited
+	// for, rather than giving up right away.
+	maxRetryAfter = 2 * time.Second
+)
+
+// retryingClient tries fetches from Discord again when they fail in ways
+// that are likely to pass. Only GET requests are, since trying anything else
+// again could do it twice.
+type retryingClient struct {
+	httpdriver.Client
+}
+
+func (c retryingClient) Do(req httpdriver.Request) (httpdriver.Response, error) {
+	if dr, ok := req.(*httpdriver.DefaultRequest); !ok || dr.Method != http.MethodGet {
+		return c.Client.Do(req)
+	}
+	ctx := req.GetContext()
+	backoff := discordBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.Client.Do(req)
+		if attempt == discordAttempts || !retryable(resp, err) {
+			return resp, err
+		}
+		wait := time.Duration(rand.Int63n(int64(backoff)))
+		if resp != nil {
+			if after, err := strconv.Atoi(resp.GetHeader().Get("Retry-After")); err == nil {
+				if time.Duration(after)*time.Second > maxRetryAfter {
+					return resp, nil
+				}
+				wait = time.Duration(after) * time.Second
+			}
+			resp.GetBody().Close()
+		}
+		if !sleepContext(ctx, wait) {
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a request to Discord that got resp or err is
+// worth trying again.
+func retryable(resp httpdriver.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	status := resp.GetStatus()
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// sleepContext waits for d, and reports whether it did before ctx was done.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// trackStale is a middleware that gives each request a flag to mark it as
+// stale with, which is set if what is on its page couldn't be fetched from
+// Discord and is shown as it was last stored instead.
+func trackStale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(cache.WithStaleFlag(r.Context())))
+	})
+}
+
+// staleFlag returns the flag that marks a request as stale, which is nil
+// outside of pages.
+func staleFlag(r *http.Request) *atomic.Bool {
+	return cache.StaleFlag(r.Context())
+}
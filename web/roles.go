@@ -0,0 +1,63 @@
+package web
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// roleCache holds the roles of each guild, sorted from the highest position
+// to the lowest, so that author rendering doesn't have to sort them for
+// every message group.
+type roleCache struct {
+	mu    sync.Mutex
+	roles map[discord.GuildID][]discord.Role
+}
+
+func newRoleCache() *roleCache {
+	return &roleCache{roles: make(map[discord.GuildID][]discord.Role)}
+}
+
+func (c *roleCache) invalidate(guildID discord.GuildID) {
+	c.mu.Lock()
+	delete(c.roles, guildID)
+	c.mu.Unlock()
+}
+
+func (c *roleCache) HandleGuildRoleCreateEvent(ev *gateway.GuildRoleCreateEvent) {
+	c.invalidate(ev.GuildID)
+}
+
+func (c *roleCache) HandleGuildRoleUpdateEvent(ev *gateway.GuildRoleUpdateEvent) {
+	c.invalidate(ev.GuildID)
+}
+
+func (c *roleCache) HandleGuildRoleDeleteEvent(ev *gateway.GuildRoleDeleteEvent) {
+	c.invalidate(ev.GuildID)
+}
+
+// guildRoles returns the roles of the guild ordered from the highest
+// position to the lowest.
+func (s *Server) guildRoles(guildID discord.GuildID) ([]discord.Role, error) {
+	s.roles.mu.Lock()
+	defer s.roles.mu.Unlock()
+	if roles, ok := s.roles.roles[guildID]; ok {
+		return roles, nil
+	}
+	roles, err := s.bots.forGuild(guildID).Roles(guildID)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]discord.Role, len(roles))
+	copy(sorted, roles)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Position != sorted[j].Position {
+			return sorted[i].Position > sorted[j].Position
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	s.roles.roles[guildID] = sorted
+	return sorted, nil
+}
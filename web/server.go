@@ -0,0 +1,1499 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/httputil"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/exp/slices"
+)
+
+// Server serves the pages of the forums of the guilds its bots are in.
+type Server struct {
+	r *chi.Mux
+
+	bots         bots
+	db           database.Database
+	messageCache *cache.Messages
+	fsys         fs.FS
+
+	fetchedInactiveMu sync.Mutex
+	fetchedInactive   map[discord.ChannelID]struct{}
+
+	// membersRequested are the users of each guild whose members have
+	// been requested from the gateway, so each is only requested once.
+	requestMembers   sync.Mutex
+	membersRequested map[discord.GuildID]map[discord.UserID]struct{}
+
+	sitemapMu     sync.Mutex
+	sitemapDirty  map[discord.GuildID]bool
+	updateSitemap chan struct{}
+	// publishFeed asks for the feed of new posts to be published to
+	// webSubHub, if there is one.
+	publishFeed chan struct{}
+	webSubHub   string
+
+	frozen  *frozenGuilds
+	optOuts *optOuts
+	cards   *cardCache
+	media   *mediaProxy
+	roles   *roleCache
+	users   *userCache
+	members *memberCounts
+	stats   *stats
+	// guildStatsCache holds the statistics of guilds for their pages,
+	// while stats are the server's own, for the admin page.
+	guildStatsCache *guildStatsCache
+	recent          *recentPosts
+	invites         *inviteCache
+	gateways        *gatewayStates
+	limiter         *rateLimiter
+	// pageViews counts the views of pages for the analytics page, or is
+	// nil if Analytics is off.
+	pageViews *pageViews
+	// warmer keeps the pages of the most viewed posts rendered, or is nil
+	// if WarmPages is 0.
+	warmer *pageWarmer
+	// renderCache keeps the pages of locked posts on disk, or is nil if
+	// RenderCacheDir isn't set.
+	renderCache *renderCache
+	// live wakes up the polls for new messages, or is nil if LiveUpdates
+	// is off.
+	live *liveUpdates
+	// bundles are the tarballs of guilds made from the admin dashboard.
+	bundles *guildBundles
+	// proxies are the networks of the reverse proxies that proxyHeaders
+	// believes.
+	proxies    []*net.IPNet
+	httpClient *http.Client
+
+	// configuration options
+	opts       atomic.Pointer[siteOptions]
+	SitemapDir string
+	purgeToken string
+	// reportWebhook and reportUsers are where reports are sent, and
+	// reportLimiter limits how many each client can send.
+	reportWebhook     string
+	reportUsers       []discord.UserID
+	reportLimiter     *rateLimiter
+	adminToken        string
+	editHistory       bool
+	debugErrors       bool
+	tombstones        bool
+	lazyFetching      bool
+	webhooks          []WebhookConfig
+	executeTemplateFn ExecuteTemplateFunc
+
+	buffers *sync.Pool
+
+	optionsRegex *regexp.Regexp
+
+	// assets are the fingerprinted paths of the static files, which static
+	// serves.
+	assets *assets
+	static http.Handler
+
+	locales map[string]*Locale
+}
+
+// New returns a server for the guilds that bots are in, with the resources
+// and options of c, which keeps their messages in db. The server handles the
+// events of the bots, so it should be made before their gateways are
+// opened, and its background work is started with Start.
+func New(bots []*state.State, db database.Database, c Config) (*Server, error) {
+	fsys, err := resourceFS(&c)
+	if err != nil {
+		return nil, fmt.Errorf("using resources: %w", err)
+	}
+	staticAssets := noAssets
+	if !c.ReloadTemplates {
+		if staticAssets, err = loadAssets(fsys); err != nil {
+			return nil, fmt.Errorf("hashing static files: %w", err)
+		}
+	}
+	funcMap["asset"] = staticAssets.path
+	var tmplfn ExecuteTemplateFunc
+	if c.ReloadTemplates {
+		tmpl, err := newReloadingTemplates(fsys)
+		if err != nil {
+			return nil, fmt.Errorf("parsing templates: %w", err)
+		}
+		tmplfn = tmpl.ExecuteTemplate
+	} else {
+		tmpl, err := parseTemplates(fsys)
+		if err != nil {
+			return nil, fmt.Errorf("parsing templates: %w", err)
+		}
+		tmplfn = tmpl.ExecuteTemplate
+	}
+	srv, err := newServer(bots, fsys, db, c)
+	if err != nil {
+		return nil, err
+	}
+	srv.executeTemplateFn = tmplfn
+	srv.assets = staticAssets
+	return srv, nil
+}
+
+// Start starts what the server does in the background: writing sitemaps,
+// counting the statistics of guilds and the views of pages, and warming
+// the pages of the most viewed posts.
+func (s *Server) Start() {
+	go s.UpdateSitemap()
+	go s.UpdateGuildStats()
+	go s.UpdatePageViews()
+	if s.warmer != nil {
+		go s.WarmPages()
+	}
+	if s.webSubHub != "" {
+		go s.PublishFeeds()
+	}
+}
+
+// Close saves what the server holds in memory that would otherwise be
+// lost, which are the page views counted since they were last saved. It
+// should be called once the server has stopped serving.
+func (s *Server) Close() {
+	s.flushPageViews()
+}
+
+type ExecuteTemplateFunc func(w io.Writer, name string, data interface{}) error
+
+func newServer(bots bots, fsys fs.FS, db database.Database, config Config) (*Server, error) {
+	optionsRegex, err := regexp.Compile(`<\?dforum (.*?)\?>`)
+	if err != nil {
+		return nil, err
+	}
+	locales, err := loadLocales(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("loading locales: %w", err)
+	}
+	frozenAt, err := db.FrozenGuilds(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading frozen guilds: %w", err)
+	}
+	frozen := newFrozenGuilds(frozenAt)
+	optedOut, err := db.OptedOut(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading opted out users: %w", err)
+	}
+	srv := &Server{
+		fetchedInactive:  make(map[discord.ChannelID]struct{}),
+		membersRequested: make(map[discord.GuildID]map[discord.UserID]struct{}),
+		bots:             bots,
+		db:               db,
+		messageCache:     cache.New(bots.cacheDiscord, db, frozen.isFrozen, config.MaxCachedChannels),
+		frozen:           frozen,
+		optOuts:          newOptOuts(optedOut),
+		fsys:             fsys,
+		buffers:          &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+		sitemapDirty:     make(map[discord.GuildID]bool),
+		updateSitemap:    make(chan struct{}, 1),
+		publishFeed:      make(chan struct{}, 1),
+		webSubHub:        config.WebSubHub,
+		optionsRegex:     optionsRegex,
+		SitemapDir:       config.SitemapDir,
+		purgeToken:       config.PurgeToken,
+		editHistory:      config.EditHistory && config.MaxRevisions > 0,
+		debugErrors:      config.DebugErrors,
+		tombstones:       config.Tombstones,
+		lazyFetching:     config.LazyFetching,
+		webhooks:         config.Webhooks,
+		adminToken:       config.AdminToken,
+		assets:           noAssets,
+		static:           http.FileServer(http.FS(fsys)),
+		locales:          locales,
+		roles:            newRoleCache(),
+		users:            newUserCache(),
+		members:          newMemberCounts(),
+		stats:            newStats(),
+		guildStatsCache:  newGuildStatsCache(),
+		recent:           &recentPosts{},
+		bundles:          newGuildBundles(),
+		invites:          newInviteCache(),
+		gateways:         newGatewayStates(len(bots)),
+		httpClient:       newHTTPClient(requestHeader(config), 10*time.Second),
+	}
+	opts, err := srv.newSiteOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	if srv.reportUsers, err = parseUserIDs(config.ReportUsers); err != nil {
+		return nil, err
+	}
+	if config.ReportWebhook != "" || len(srv.reportUsers) > 0 {
+		srv.reportWebhook = config.ReportWebhook
+		if srv.reportLimiter, err = newRateLimiter(reportLimit, reportBurst, config.RateLimitExempt); err != nil {
+			return nil, err
+		}
+	}
+	srv.opts.Store(opts)
+	if config.WebSubHub != "" && !validHubURL(config.WebSubHub) {
+		return nil, fmt.Errorf("invalid WebSub hub %q", config.WebSubHub)
+	}
+	if config.RateLimit > 0 {
+		srv.limiter, err = newRateLimiter(config.RateLimit, config.RateLimitBurst, config.RateLimitExempt)
+		if err != nil {
+			return nil, fmt.Errorf("creating rate limiter: %w", err)
+		}
+	}
+	if config.Analytics {
+		srv.pageViews = newPageViews()
+		if config.WarmPages > 0 {
+			srv.warmer = newPageWarmer(config.WarmPages)
+		}
+	}
+	if config.LiveUpdates {
+		srv.live = newLiveUpdates()
+	}
+	if srv.proxies, err = parseNetworks(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if srv.cards, err = newCardCache(config.CardFont); err != nil {
+		return nil, fmt.Errorf("loading card font: %w", err)
+	}
+	if config.MediaDir != "" {
+		srv.media, err = newMediaProxy(config.MediaDir, newHTTPClient(requestHeader(config), 60*time.Second))
+		if err != nil {
+			return nil, fmt.Errorf("creating media cache: %w", err)
+		}
+	}
+	// Pages rendered with templates that are reloaded would go out of
+	// date without anything noticing.
+	if config.RenderCacheDir != "" && !config.ReloadTemplates {
+		srv.renderCache, err = newRenderCache(config.RenderCacheDir, fsys)
+		if err != nil {
+			return nil, fmt.Errorf("creating render cache: %w", err)
+		}
+	}
+	for i, st := range bots {
+		st := st
+		st.AddHandler(srv.gateways.handler(i))
+		st.AddHandler(srv.handleGuildJoin)
+		st.AddHandler(func(ev *state.GuildLeaveEvent) {
+			srv.handleGuildLeave(st, ev)
+		})
+		if len(srv.webhooks) > 0 {
+			st.AddHandler(func(ev *gateway.ThreadCreateEvent) {
+				srv.notifyNewPost(st, ev)
+			})
+		}
+		st.AddHandler(func(m *gateway.MessageCreateEvent) {
+			srv.messageCache.Set(context.Background(), m.Message, false)
+			srv.markSitemapDirty(m.GuildID)
+			if srv.live != nil {
+				srv.live.notify(m.ChannelID)
+			}
+		})
+		st.AddHandler(func(m *gateway.MessageUpdateEvent) {
+			srv.messageCache.Set(context.Background(), m.Message, true)
+		})
+		st.AddHandler(func(m *gateway.MessageDeleteEvent) {
+			srv.messageCache.Remove(context.Background(), m.ChannelID, m.ID)
+		})
+		st.AddHandler(func(m *gateway.MessageDeleteBulkEvent) {
+			if err := srv.messageCache.RemoveBulk(context.Background(), m.ChannelID, m.IDs); err != nil {
+				log.Printf("Error removing %d messages purged from %s: %v", len(m.IDs), m.ChannelID, err)
+			}
+			// The guild's statistics would count the purged messages
+			// until they are next computed.
+			srv.guildStatsCache.forget(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ThreadUpdateEvent) {
+			srv.messageCache.HandleThreadUpdateEvent(m)
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ThreadCreateEvent) {
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ThreadDeleteEvent) {
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ChannelCreateEvent) {
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ChannelUpdateEvent) {
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(func(m *gateway.ChannelDeleteEvent) {
+			srv.markSitemapDirty(m.GuildID)
+		})
+		st.AddHandler(srv.stats.HandleEvent)
+		st.AddHandler(srv.roles.HandleGuildRoleCreateEvent)
+		st.AddHandler(srv.roles.HandleGuildRoleUpdateEvent)
+		st.AddHandler(srv.roles.HandleGuildRoleDeleteEvent)
+		st.AddHandler(srv.handleMemberCount(st))
+		st.AddHandler(srv.handleMembers(st))
+		st.AddHandler(srv.handleRecentPosts())
+		st.AddHandler(srv.handleOptOuts(st))
+		if srv.warmer != nil {
+			st.AddHandler(srv.warmer.handleEvent)
+		}
+		if srv.renderCache != nil {
+			st.AddHandler(srv.renderCache.handleEvent)
+		}
+	}
+	r := chi.NewRouter()
+	srv.r = r
+	r.Use(srv.proxyHeaders)
+	if config.AccessLog != "" {
+		logs, err := newAccessLog(config.AccessLog, config.AccessLogFormat, config.AccessLogIPs,
+			config.AccessLogMaxSizeMB, config.AccessLogBackups)
+		if err != nil {
+			return nil, err
+		}
+		r.Use(logs.middleware)
+	} else {
+		r.Use(middleware.Logger)
+	}
+	r.Use(srv.recoverPanics)
+	r.Use(srv.stats.countRequests)
+	r.Use(srv.canonicalize)
+	r.Use(srv.localize)
+	r.Use(srv.resolveDomains)
+	r.Use(srv.resolveSlugs)
+	pages := r.With(srv.rateLimit, cacheControl(cachePage), timeout(pageTimeout), trackStale)
+	if srv.pageViews != nil {
+		pages = pages.With(srv.pageViews.count)
+	}
+	if srv.warmer != nil {
+		pages = pages.With(srv.serveWarmed)
+	}
+	if srv.renderCache != nil {
+		pages = pages.With(srv.serveRendered)
+	}
+	getHead(pages, `/sitemap/*`, srv.getSitemap)
+	getHead(pages, `/sitemap.xml`, srv.getSitemap)
+	getHead(r, "/status.json", srv.getStatus)
+	getHead(pages, "/", srv.getIndex)
+	getHead(pages, "/recent", srv.getRecent)
+	getHead(pages, "/recent.atom", srv.getRecentFeed)
+	pages.Route("/{guildID:\\d+}", func(r chi.Router) {
+		getHead(r, "/", srv.getGuild)
+		getHead(r, "/stats", srv.getGuildStats)
+		r.Route("/{forumID:\\d+}", func(r chi.Router) {
+			getHead(r, "/", srv.getForum)
+			getHead(r, "/search", srv.searchForum)
+			r.Route("/page/{page:\\d+}", func(r chi.Router) {
+				getHead(r, "/", srv.getForum)
+				getHead(r, "/search", srv.searchForum)
+			})
+			getHead(r, "/tags", srv.getForumTags)
+			r.Route("/tag/{tagID:\\d+}", func(r chi.Router) {
+				getHead(r, "/", srv.getForum)
+				getHead(r, "/page/{page:\\d+}", srv.getForum)
+			})
+			r.Route("/{postID:\\d+}", func(r chi.Router) {
+				getHead(r, "/", srv.getPost)
+				getHead(r, "/card.png", srv.getPostCard)
+				getHead(r, "/meta.json", srv.getPostMeta)
+				getHead(r, "/messages", srv.getPostMessages)
+			})
+		})
+	})
+
+	if srv.media != nil {
+		getHead(r.With(cacheControl(cacheMedia)), "/media/attachments/{channelID:\\d+}/{messageID:\\d+}/{attachmentID:\\d+}/*", srv.getAttachment)
+		getHead(r.With(cacheControl(cacheMedia)), "/avatars/{userID:\\d+}/{hash}.png", srv.getAvatar)
+		// ZIP files of attachments take longer to put together than
+		// pages are given.
+		getHead(r.With(srv.rateLimit, cacheControl(cachePage)), "/{guildID:\\d+}/{forumID:\\d+}/{postID:\\d+}/attachments.zip", srv.getPostAttachments)
+		getHead(r.With(cacheControl(cacheImmutable)), "/branding/{guildID:\\d+}/{kind}/{hash}.png", srv.getGuildImage)
+	}
+	if srv.live != nil {
+		// Polls wait for messages rather than being pages, so they aren't
+		// cached, counted or timed out like them.
+		getHead(r.With(srv.rateLimit, timeout(pollWait)), "/{guildID:\\d+}/{forumID:\\d+}/{postID:\\d+}/poll", srv.getPostPoll)
+	}
+	getHead(pages, "/embed/{guildID:\\d+}/{forumID:\\d+}/{postID:\\d+}/{messageID:\\d+}", srv.getEmbed)
+	r.Post("/confirm-age", srv.confirmAge)
+	if srv.reportLimiter != nil {
+		getHead(pages, "/report", srv.getReport)
+		r.With(timeout(reportTimeout+pageTimeout)).Post("/report", srv.postReport)
+	}
+	if srv.purgeToken != "" {
+		r.Post("/purge", srv.purge)
+	}
+	if srv.adminToken != "" {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(srv.adminAuth, cacheControl(cacheNone))
+			getHead(r, "/", srv.getAdmin)
+			r.Post("/invalidate", srv.adminInvalidate)
+			r.Post("/refetch", srv.adminRefetch)
+			r.Post("/export", srv.adminBundle)
+			getHead(r, "/export/{guildID:\\d+}.tar.gz", srv.getAdminBundle)
+			if srv.pageViews != nil {
+				getHead(r, "/analytics", srv.getAdminAnalytics)
+			}
+		})
+	}
+	getHead(pages, "/privacy", srv.PrivacyPage)
+	getHead(pages, "/tos", srv.TOSPage)
+	getHead(r, "/static/*", srv.getStatic)
+	r.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.displayErr(w, r, http.StatusNotFound, nil)
+	}))
+	return srv, nil
+}
+
+func getHead(r chi.Router, path string, handler http.HandlerFunc) {
+	r.Get(path, handler)
+	r.Head(path, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.r.ServeHTTP(w, r)
+}
+
+// maxBufferedPage is the size up to which pages are rendered into a buffer
+// before they are sent, so that they get an ETag and conditional requests
+// can be answered with 304s. Larger pages, like those of posts with huge
+// messages, are sent while they are rendered instead, so that they aren't
+// held in memory whole and start arriving sooner.
+const maxBufferedPage = 512 << 10
+
+// streamFlushSize is how much of a streamed page is written before it is
+// flushed to the client.
+const streamFlushSize = 32 << 10
+
+func (s *Server) executeTemplate(w http.ResponseWriter, r *http.Request,
+	name string, ctx any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf := s.buffers.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		s.buffers.Put(buf)
+	}()
+	pw := &pageWriter{w: w, buf: buf}
+	err := s.executeTemplateFn(pw, name, ctx)
+	if pw.streaming {
+		// The status was already sent, so an error can't be shown anymore.
+		if err != nil {
+			log.Printf("Error rendering %s: %v", r.URL.Path, err)
+		}
+		pw.flush()
+		return
+	}
+	if err == nil {
+		if stale := staleFlag(r); stale != nil && stale.Load() {
+			// The page is kept by caches until it is fetched again,
+			// which it should be as soon as Discord is back.
+			w.Header().Set("Cache-Control", cacheNone)
+		}
+		checksum := crc32.ChecksumIEEE(buf.Bytes())
+		w.Header().Set("ETag", fmt.Sprintf("\"%x\"", checksum))
+		rdr := bytes.NewReader(buf.Bytes())
+		http.ServeContent(w, r, name, time.Time{}, rdr)
+	} else {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// pageWriter buffers a page up to maxBufferedPage, and streams it to the
+// client with chunked encoding once it grows past that.
+type pageWriter struct {
+	w         http.ResponseWriter
+	buf       *bytes.Buffer
+	streaming bool
+	// unflushed is how much has been written since the last flush.
+	unflushed int
+}
+
+func (pw *pageWriter) Write(p []byte) (int, error) {
+	if !pw.streaming {
+		if pw.buf.Len()+len(p) <= maxBufferedPage {
+			return pw.buf.Write(p)
+		}
+		pw.streaming = true
+		pw.w.WriteHeader(http.StatusOK)
+		if _, err := pw.w.Write(pw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		pw.unflushed = pw.buf.Len()
+	}
+	n, err := pw.w.Write(p)
+	pw.unflushed += n
+	if pw.unflushed >= streamFlushSize {
+		pw.flush()
+	}
+	return n, err
+}
+
+func (pw *pageWriter) flush() {
+	if f, ok := pw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	pw.unflushed = 0
+}
+
+func (s *Server) displayErr(w http.ResponseWriter, r *http.Request, status int, err error) {
+	ctx := struct {
+		Page
+		StatusText string
+		StatusCode int
+		// Message explains the error to readers, and Error is the error
+		// itself, which is only shown if DebugErrors is set.
+		Message string
+		Error   error
+	}{Page: s.page(w, r), StatusText: http.StatusText(status), StatusCode: status}
+	if rerr := s.asReaderError(status, err); rerr != nil {
+		ctx.StatusCode, ctx.StatusText, ctx.Message = rerr.status, rerr.title, rerr.message
+	}
+	if s.debugErrors {
+		ctx.Error = err
+	}
+	status = ctx.StatusCode
+	switch status {
+	case http.StatusGatewayTimeout, http.StatusServiceUnavailable:
+		// Pages that couldn't be loaded from Discord are worth trying
+		// again.
+		w.Header().Set("Retry-After", "10")
+	}
+	if status >= 500 || status == http.StatusTooManyRequests {
+		w.Header().Set("Cache-Control", cacheNone)
+	} else if cc := w.Header().Get("Cache-Control"); cc != "" && cc != cachePrivate {
+		w.Header().Set("Cache-Control", cachePage)
+	}
+	if status >= 500 && err != nil {
+		log.Printf("Error serving %s: %v", r.URL.Path, err)
+		s.stats.logError(r, err)
+	}
+	w.WriteHeader(status)
+	s.executeTemplateFn(w, "error.gohtml", ctx)
+}
+
+func discordStatusIs(err error, status int) bool {
+	var httperr *httputil.HTTPError
+	if ok := errors.As(err, &httperr); !ok {
+		return false
+	}
+	return httperr.Status == status
+}
+
+func (s *Server) publicActiveThreads(gid discord.GuildID) ([]discord.Channel, error) {
+	channels, err := s.bots.forGuild(gid).Cabinet.Channels(gid)
+	if err != nil {
+		return nil, err
+	}
+	var threads []discord.Channel
+	for _, channel := range channels {
+		if channel.Type != discord.GuildPublicThread {
+			continue
+		}
+		parent, err := s.channel(channel.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.Type != discord.GuildForum {
+			continue
+		}
+		threads = append(threads, channel)
+	}
+	return threads, nil
+}
+
+func filter(ss []Post, test func(Post) bool) (ret []Post) {
+	for _, s := range ss {
+		if test(s) {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+type ForumChannel struct {
+	discord.Channel
+	Posts             []discord.Channel
+	TotalMessageCount int
+	LastActive        time.Time
+}
+
+// newForumChannel collects the posts of a forum from the channels of its
+// guild.
+func newForumChannel(forum discord.Channel, channels []discord.Channel) ForumChannel {
+	var posts []discord.Channel
+	for _, t := range channels {
+		if t.ParentID == forum.ID &&
+			t.Type == discord.GuildPublicThread {
+			posts = append(posts, t)
+		}
+	}
+	var msgcount int
+	for _, post := range posts {
+		msgcount += post.MessageCount
+	}
+	var lastactive time.Time
+	if forum.LastMessageID.IsValid() {
+		lastactive = forum.LastMessageID.Time()
+	}
+	for _, post := range posts {
+		if post.LastMessageID.Time().After(lastactive) {
+			lastactive = post.LastMessageID.Time()
+		}
+	}
+	return ForumChannel{forum, posts, msgcount, lastactive}
+}
+
+// ForumCategory is a category of a guild's channels with the forums in it.
+// The forums that aren't in a category, or are in one that the bot can't
+// see, are in one without a Category, so that the names of categories that
+// are hidden from it aren't shown.
+type ForumCategory struct {
+	Category *discord.Channel
+	Forums   []ForumChannel
+}
+
+// groupForums sorts forums into their categories, in the order that Discord
+// lists categories in, after the forums that aren't in one.
+func (s *Server) groupForums(channels []discord.Channel, forums []ForumChannel) []ForumCategory {
+	visible := make(map[discord.ChannelID]*discord.Channel)
+	for i, ch := range channels {
+		if ch.Type == discord.GuildCategory && s.canServe(&channels[i]) {
+			visible[ch.ID] = &channels[i]
+		}
+	}
+	var groups []ForumCategory
+	index := make(map[discord.ChannelID]int)
+	for _, forum := range forums {
+		var id discord.ChannelID
+		if visible[forum.ParentID] != nil {
+			id = forum.ParentID
+		}
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			groups = append(groups, ForumCategory{Category: visible[id]})
+		}
+		groups[i].Forums = append(groups[i].Forums, forum)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Category, groups[j].Category
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return a.Position < b.Position
+	})
+	return groups
+}
+
+func (s *Server) getGuild(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	ctx := GuildContext{
+		Page:        s.guildPage(w, r, guild.ID),
+		Guild:       guild,
+		MemberCount: s.members.get(guild.ID),
+		Invite:      s.guildInvite(guild.ID),
+	}
+	ctx.Meta.Breadcrumbs = s.breadcrumbs(r, guild, nil, nil)
+	for _, m := range s.rules(guild) {
+		ctx.Rules = append(ctx.Rules, s.message(m, ctx.Locale))
+	}
+	rules := make([]*Message, len(ctx.Rules))
+	for i := range ctx.Rules {
+		rules[i] = &ctx.Rules[i]
+	}
+	s.addInvites(rules)
+
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching guild channels: %s", err))
+		return
+	}
+	var forums []ForumChannel
+	for _, forum := range channels {
+		typ := channelTypes[forum.Type]
+		if typ.policy == channelHidden {
+			continue
+		}
+		if !s.canServe(&forum) {
+			continue
+		}
+		if typ.policy == channelUnservable {
+			ctx.OtherChannels = append(ctx.OtherChannels, listedChannel{forum, typ.name})
+			continue
+		}
+		if fc := newForumChannel(forum, channels); s.site().listsForum(fc) {
+			forums = append(forums, fc)
+		}
+	}
+	sort.SliceStable(forums, func(i, j int) bool {
+		return forums[i].LastActive.After(forums[j].LastActive)
+	})
+	ctx.Categories = s.groupForums(channels, forums)
+	sort.SliceStable(ctx.OtherChannels, func(i, j int) bool {
+		return ctx.OtherChannels[i].Position < ctx.OtherChannels[j].Position
+	})
+	s.executeTemplate(w, r, "guild.gohtml", ctx)
+}
+
+func (s *Server) searchGuild(w http.ResponseWriter, r *http.Request) {
+	s.executeTemplate(w, r, "searchguild.gohtml", s.page(w, r))
+}
+
+func (s *Server) searchForum(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+
+	if query == "" {
+		s.getForum(w, r)
+		return
+	}
+
+	ctx := struct {
+		Page
+		Guild       *discord.Guild
+		Forum       *discord.Channel
+		Posts       []Post
+		Prev        int
+		Next        int
+		Query       string
+		AppendedStr string
+	}{Page: s.guildPage(w, r, guild.ID),
+		Guild:       guild,
+		Forum:       forum,
+		Query:       query,
+		AppendedStr: "/search?q=" + query,
+	}
+	ctx.Meta.Breadcrumbs = append(s.breadcrumbs(r, guild, nil, nil),
+		Breadcrumb{Name: ctx.Locale.T("Searching %s", forum.Name)})
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching guild threads: %w", err))
+		return
+	}
+	arr := strings.Split(query, " ")
+
+	if query == "" {
+		// Show blank page.
+		s.executeTemplate(w, r, "searchforum.gohtml", ctx)
+		return
+	}
+	var posts []Post
+	titles := []string{}
+	for _, thread := range channels {
+		if thread.ParentID != forum.ID ||
+			thread.Type != discord.GuildPublicThread {
+			continue
+		}
+		post := Post{Channel: thread, Tags: postTags(forum, &thread)}
+		if slices.Contains(titles, post.Channel.Name) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(post.Channel.Name), query) {
+			posts = append(posts, post)
+			titles = append(titles, post.Channel.Name)
+		} else {
+			for _, str := range arr {
+				if len(str) <= 1 {
+					continue
+				}
+				if strings.Contains(strings.ToLower(post.Channel.Name), strings.ToLower(str)) {
+					posts = append(posts, post)
+					titles = append(titles, post.Channel.Name)
+				}
+			}
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return strings.Contains(strings.ToLower(posts[i].Channel.Name), strings.ToLower(query))
+	})
+	page, err := strconv.Atoi(chi.URLParam(r, "page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > 1 {
+		ctx.Prev = page - 1
+	}
+	per := s.site().PostsPerPage
+	if len(posts) > page*per {
+		ctx.Next = page + 1
+		posts = posts[(page-1)*per : page*per]
+	} else if len(posts) >= (page-1)*per {
+		posts = posts[(page-1)*per:]
+	} else {
+		posts = nil
+	}
+	ctx.Posts = posts
+	s.executeTemplate(w, r, "searchforum.gohtml", ctx)
+}
+
+// Post is a thread in a forum. Its state comes from the bot's cache, which
+// ThreadUpdate events keep current, so posts that are pinned, locked or
+// archived on Discord show as such right away.
+type Post struct {
+	discord.Channel
+	Tags []discord.Tag
+	// Excerpt is the start of the post's starter message, and Thumbnail
+	// the URL of the thumbnail of its first image, if the message is
+	// stored.
+	Excerpt   string
+	Thumbnail template.URL
+}
+
+func (p Post) IsPinned() bool {
+	return p.Channel.Flags&discord.PinnedThread != 0
+}
+
+// IsLocked reports whether the post is locked, so that only moderators can
+// reopen it.
+func (p Post) IsLocked() bool {
+	return p.ThreadMetadata != nil && p.ThreadMetadata.Locked
+}
+
+func (p Post) IsArchived() bool {
+	return p.ThreadMetadata != nil && p.ThreadMetadata.Archived
+}
+
+// AutoArchive returns how long the post has to be inactive for before it is
+// archived, as a string to be translated, or "" if it isn't known.
+func (p Post) AutoArchive() string {
+	if p.ThreadMetadata == nil {
+		return ""
+	}
+	switch p.ThreadMetadata.AutoArchiveDuration {
+	case discord.OneHourArchive:
+		return "1 hour"
+	case discord.OneDayArchive:
+		return "1 day"
+	case discord.ThreeDaysArchive:
+		return "3 days"
+	case discord.SevenDaysArchive:
+		return "1 week"
+	}
+	return ""
+}
+
+// postTags resolves the tags applied to a post against the forum's available
+// tags.
+func postTags(forum, post *discord.Channel) []discord.Tag {
+	var tags []discord.Tag
+	for _, tag := range post.AppliedTags {
+		for _, availtag := range forum.AvailableTags {
+			if availtag.ID == tag {
+				tags = append(tags, availtag)
+			}
+		}
+	}
+	return tags
+}
+
+func (s *Server) getForum(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+
+	tag, ok := s.tagFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+
+	ctx := ForumContext{Page: s.guildPage(w, r, guild.ID),
+		Guild:   guild,
+		Forum:   forum,
+		Tag:     tag,
+		Sort:    forumSort(forum, r.URL.Query().Get("sort")),
+		Sorts:   postSorts,
+		Layout:  forumLayout(forum, r.URL.Query().Get("layout")),
+		Layouts: forumLayouts,
+	}
+	if ctx.Sort != forumSort(forum, "") {
+		ctx.SortParam = ctx.Sort
+	}
+	if ctx.Layout != forumLayout(forum, "") {
+		ctx.LayoutParam = ctx.Layout
+	}
+	pageQuery := url.Values{}
+	if ctx.SortParam != "" {
+		pageQuery.Set("sort", ctx.SortParam)
+	}
+	if ctx.LayoutParam != "" {
+		pageQuery.Set("layout", ctx.LayoutParam)
+	}
+	if len(pageQuery) > 0 {
+		ctx.PageQuery = "?" + pageQuery.Encode()
+	}
+	ctx.PagePath = ctx.GuildPath + "/" + forum.ID.String()
+	ctx.Meta.Breadcrumbs = s.breadcrumbs(r, guild, forum, nil)
+	if tag != nil {
+		ctx.PagePath += "/tag/" + tag.ID.String()
+		ctx.Meta.Breadcrumbs = append(ctx.Meta.Breadcrumbs,
+			Breadcrumb{Name: tag.Name, Path: ctx.PagePath})
+	}
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching guild threads: %w", err))
+		return
+	}
+	var posts []Post
+	for _, thread := range channels {
+
+		if thread.ParentID != forum.ID ||
+			thread.Type != discord.GuildPublicThread {
+			continue
+		}
+
+		parent, err := s.channel(thread.ParentID)
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching parent channel's type: %w", err))
+		}
+		if parent == nil {
+			continue
+		}
+		if parent.Type != discord.GuildForum {
+			continue
+		}
+		post := Post{Channel: thread, Tags: postTags(forum, &thread)}
+		posts = append(posts, post)
+	}
+	if tag != nil {
+		posts = filter(posts, func(p Post) bool {
+			return slices.Contains(p.AppliedTags, tag.ID)
+		})
+	}
+	sortPosts(posts, ctx.Sort)
+	page, err := strconv.Atoi(chi.URLParam(r, "page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > 1 {
+		ctx.Prev = page - 1
+	}
+	per := s.site().PostsPerPage
+	ctx.PostCount = len(posts)
+	ctx.Pages = pageCount(len(posts), per)
+	ctx.PageNumbers = pageWindow(page, ctx.Pages, 2)
+	if len(posts) > page*per {
+		ctx.Next = page + 1
+		posts = posts[(page-1)*per : page*per]
+	} else if len(posts) >= (page-1)*per {
+		posts = posts[(page-1)*per:]
+	} else {
+		posts = nil
+	}
+	size := uint(previewSize)
+	if ctx.Layout == "gallery" {
+		size = galleryPreviewSize
+	}
+	if err := s.addPreviews(r.Context(), posts, size); err != nil {
+		log.Printf("Error fetching previews of posts in %s: %v", forum.ID, err)
+	}
+	ctx.Posts = posts
+	ctx.Meta.PageNumber = page
+	pageURL := func(n int) string {
+		return fmt.Sprintf("%s%s/page/%d%s", ctx.SiteURL, ctx.PagePath, n, ctx.PageQuery)
+	}
+	if ctx.Prev != 0 {
+		ctx.Meta.Prev = pageURL(ctx.Prev)
+	}
+	if ctx.Next != 0 {
+		ctx.Meta.Next = pageURL(ctx.Next)
+	}
+	setPageLinks(w, ctx.Meta)
+	s.executeTemplate(w, r, "forum.gohtml", ctx)
+}
+
+// latestCursor is a before cursor that is after every message, for the
+// last page of a post.
+const latestCursor = discord.MessageID(math.MaxInt64)
+
+func (s *Server) getPost(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	post, ok := s.postFromReq(w, r, forum)
+	if !ok {
+		return
+	}
+
+	if s.lazyFetching && answerFromMetadata(w, r, postModTime(post)) {
+		return
+	}
+	ctx := PostContext{Page: s.guildPage(w, r, guild.ID),
+		Guild: guild,
+		Forum: forum,
+		Post:  Post{Channel: *post, Tags: postTags(forum, post)},
+	}
+	ctx.Meta.Breadcrumbs = s.breadcrumbs(r, guild, forum, post)
+	if s.media != nil {
+		ctx.AttachmentsZip = postPath(ctx.GuildPath, forum.ID, post.ID) + "/attachments.zip"
+	}
+	ctx.Snapshot = s.postSnapshot(r.Context(), post.ID)
+	// The links to the adjacent posts are only a convenience, so the page
+	// is shown without them if they can't be found.
+	if prev, next, err := s.adjacentPosts(ctx.GuildPath, forum, post); err == nil {
+		ctx.PrevPost, ctx.NextPost = prev, next
+	} else {
+		log.Printf("Error finding the posts next to %s: %v", post.ID, err)
+	}
+	asOf, ok := s.asOfFromReq(w, r, ctx.Locale)
+	if !ok {
+		return
+	}
+	if asOf != nil {
+		ctx.AsOf = asOf
+		ctx.AsOfParam = r.URL.Query().Get("asof")
+	}
+
+	ctx.Descending = s.site().NewestFirst
+	switch order := r.URL.Query().Get("order"); order {
+	case "asc", "desc":
+		ctx.Descending = order == "desc"
+		if ctx.Descending != s.site().NewestFirst {
+			ctx.OrderParam = order
+		}
+	case "":
+	default:
+		s.displayErr(w, r, http.StatusBadRequest, errInvalidOrder)
+		return
+	}
+
+	var curstr string
+	asc := true
+	if after := r.URL.Query().Get("after"); after != "" {
+		curstr = after
+	} else if before := r.URL.Query().Get("before"); before != "" {
+		asc = false
+		curstr = before
+	}
+	var cur discord.MessageID
+	if curstr != "" {
+		sf, err := discord.ParseSnowflake(curstr)
+		if err != nil {
+			s.displayErr(w, r, http.StatusBadRequest,
+				fmt.Errorf("invalid snowflake: %w", err))
+			return
+		}
+		cur = discord.MessageID(sf)
+	} else if ctx.Descending {
+		// Posts shown newest first start at their last page.
+		asc = false
+		cur = latestCursor
+	}
+	var msgs []discord.Message
+	var hasbefore, hasafter bool
+	var err error
+	per := uint(s.site().MessagesPerPage)
+	if asOf != nil {
+		msgs, hasbefore, hasafter, err = s.messagesAsOf(r.Context(), post.ID, *asOf, cur, asc, int(per))
+	} else if asc {
+		msgs, hasbefore, hasafter, err = s.messageCache.MessagesAfter(r.Context(), post.ID, cur, per)
+	} else {
+		msgs, hasbefore, hasafter, err = s.messageCache.MessagesBefore(r.Context(), post.ID, cur, per)
+	}
+	if err == nil && len(msgs) == 0 && cur.IsValid() && asOf == nil {
+		// The cursor's side of the post is empty, most likely because the
+		// messages a link was made from were deleted. Show the page that
+		// ends or starts at the closest message that still exists.
+		var nearest discord.MessageID
+		nearest, err = s.messageCache.NearestMessage(r.Context(), post.ID, cur)
+		if err == nil && nearest.IsValid() {
+			if nearest < cur {
+				msgs, hasbefore, hasafter, err = s.messageCache.MessagesBefore(r.Context(), post.ID, nearest+1, per)
+			} else {
+				msgs, hasbefore, hasafter, err = s.messageCache.MessagesAfter(r.Context(), post.ID, nearest-1, per)
+			}
+		}
+	}
+	if hasafter && len(msgs) > 0 {
+		ctx.Next = msgs[len(msgs)-1].ID
+	}
+	if !hasafter && len(msgs) > 0 && asOf == nil && !ctx.Descending {
+		// New messages would be added after the last one on the page.
+		ctx.Poll = s.pollURL(r, guild.ID, post, msgs[len(msgs)-1].ID)
+		ctx.PollRefresh = pollRefresh
+	}
+	ctx.MessageCount = post.MessageCount + 1
+	ctx.Pages = pageCount(ctx.MessageCount, int(per))
+	switch {
+	case len(msgs) == 0:
+	case !hasbefore:
+		ctx.PageNumber = 1
+	case !hasafter:
+		ctx.PageNumber = ctx.Pages
+	}
+	if ctx.Descending && ctx.PageNumber != 0 {
+		ctx.PageNumber = ctx.Pages + 1 - ctx.PageNumber
+	}
+	if hasbefore && len(msgs) != 0 {
+		ctx.Prev = msgs[0].ID
+	}
+	postQuery := func(cursor string, id discord.MessageID) string {
+		q := url.Values{}
+		if cursor != "" {
+			q.Set(cursor, id.String())
+		}
+		if ctx.AsOfParam != "" {
+			q.Set("asof", ctx.AsOfParam)
+		}
+		if ctx.OrderParam != "" {
+			q.Set("order", ctx.OrderParam)
+		}
+		if len(q) == 0 {
+			return ""
+		}
+		return "?" + q.Encode()
+	}
+	postURL := func(cursor string, id discord.MessageID) string {
+		return ctx.SiteURL + ctx.Meta.Current().Path + postQuery(cursor, id)
+	}
+	// The pages before and after this one are the ones before and after it
+	// in reading order, which is the other way around for posts shown
+	// newest first.
+	older, newer := &ctx.Meta.Prev, &ctx.Meta.Next
+	if ctx.Descending {
+		older, newer = newer, older
+	}
+	if ctx.Prev.IsValid() {
+		ctx.PrevLink = postQuery("before", ctx.Prev)
+		*older = postURL("before", ctx.Prev)
+	}
+	if ctx.Next.IsValid() {
+		ctx.NextLink = postQuery("after", ctx.Next)
+		*newer = postURL("after", ctx.Next)
+		switch {
+		case ctx.Descending:
+			ctx.Latest = ctx.Meta.Current().Path + postQuery("", 0)
+		case post.LastMessageID.IsValid():
+			ctx.Latest = ctx.Meta.Current().Path + postQuery("before", post.LastMessageID+1)
+		}
+	}
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching post's messages: %w", err))
+		return
+	}
+	// Authors whose members can't be found are shown as the messages
+	// have them, which is better than no page.
+	if err := s.ensureMembers(r.Context(), *post, msgs); err != nil {
+		log.Printf("Error looking up members of %s: %v", post.ID, err)
+	}
+
+	restrictRole, err := s.consentRole(forum)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if asOf == nil {
+		ctx.TableOfContents, err = s.tableOfContents(r.Context(), post, msgs, func(id discord.MessageID) string {
+			return ctx.Meta.Current().Path + postQuery("after", id-1) + "#m" + id.String()
+		})
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching table of contents: %w", err))
+			return
+		}
+	}
+
+	if asOf == nil {
+		ctx.Participants, ctx.ParticipantCount, err = s.participants(r.Context(), post, restrictRole)
+		if err != nil {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching participants: %w", err))
+			return
+		}
+	}
+
+	ctx.MessageGroups, err = s.messageGroups(r.Context(), guild.ID, post, msgs, restrictRole, ctx.Locale, asOf == nil, ctx.Descending)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.StructuredData = postStructuredData(ctx.Page, ctx.Post, ctx.MessageGroups)
+	setPageLinks(w, ctx.Meta)
+	s.executeTemplate(w, r, "post.gohtml", ctx)
+}
+
+// messageGroups renders the messages of a post, which are oldest first,
+// and groups them by author, in reverse order if descending is set. The
+// messages have their edit history, when they were deleted and what they
+// forwarded, unless current is unset for posts shown as of an earlier
+// time. It fails with errNoConsent if one of the authors didn't consent to
+// being shown.
+func (s *Server) messageGroups(ctx context.Context, guildID discord.GuildID, post *discord.Channel,
+	msgs []discord.Message, restrictRole int, loc *Locale, current, descending bool) ([]MessageGroup, error) {
+	var revisions map[discord.MessageID][]discord.Message
+	var err error
+	if s.editHistory && current && len(msgs) > 0 {
+		revisions, err = s.db.Revisions(ctx, post.ID, msgs[0].ID, msgs[len(msgs)-1].ID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching edit history: %w", err)
+		}
+	}
+
+	var deleted map[discord.MessageID]time.Time
+	if s.tombstones && current && len(msgs) > 0 {
+		deleted, err = s.db.Tombstones(ctx, post.ID, msgs[0].ID, msgs[len(msgs)-1].ID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching deleted messages: %w", err)
+		}
+	}
+
+	forwarded, err := s.snapshots(ctx, post, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("fetching forwarded messages: %w", err)
+	}
+
+	if descending {
+		// msgs can be the cache's own slice, so it isn't reversed in place.
+		reversed := make([]discord.Message, len(msgs))
+		for i, m := range msgs {
+			reversed[len(msgs)-1-i] = m
+		}
+		msgs = reversed
+	}
+	var msgrps []MessageGroup
+	i := -1
+	for _, m := range msgs {
+		m.GuildID = guildID
+		msg := s.message(m, loc)
+		msg.DeletedAt = deleted[m.ID]
+		if !msg.Anonymized {
+			for _, rev := range revisions[m.ID] {
+				rev.GuildID = guildID
+				msg.Revisions = append(msg.Revisions, s.revision(rev, loc))
+			}
+			for _, fwd := range forwarded[m.ID] {
+				fwd.GuildID = guildID
+				msg.Forwarded = append(msg.Forwarded, s.message(fwd, loc))
+			}
+		}
+		if i == -1 || msgrps[i].Author.ID != m.Author.ID {
+			auth := s.author(m)
+			if !consented(auth, restrictRole) {
+				return nil, errNoConsent
+			}
+
+			msgrps = append(msgrps, MessageGroup{auth, []Message{msg}})
+			i++
+		} else {
+			msgrps[i].Messages = append(msgrps[i].Messages, msg)
+		}
+	}
+	var shown []*Message
+	for i := range msgrps {
+		for j := range msgrps[i].Messages {
+			msg := &msgrps[i].Messages[j]
+			shown = append(shown, msg)
+			for k := range msg.Forwarded {
+				shown = append(shown, &msg.Forwarded[k])
+			}
+		}
+	}
+	s.addInvites(shown)
+	return msgrps, nil
+}
+
+// consentRole returns the role that authors must have for their messages in
+// a forum to be shown, set with a consentrole option in the forum's topic,
+// or 0 if there is none.
+func (s *Server) consentRole(forum *discord.Channel) (int, error) {
+	if !strings.Contains(forum.Topic, "<?dforum ") {
+		return 0, nil
+	}
+	restrictRole := 0
+	sections := s.optionsRegex.FindStringSubmatch(forum.Topic)
+	for _, section := range sections[1:] {
+		options := strings.Split(section, ",")
+		for _, option := range options {
+			parts := strings.Split(option, "=")
+			if len(parts) < 2 {
+				continue
+			}
+			key := parts[0]
+			value := parts[1]
+			switch key {
+			case "consentrole":
+				var err error
+				restrictRole, err = strconv.Atoi(value)
+				if err != nil {
+					return 0, fmt.Errorf("error parsing the ID for the server's consent role: %w", err)
+				}
+			}
+		}
+	}
+	return restrictRole, nil
+}
+
+// consented reports whether an author has the consent role, if there is
+// one. Authors that opted out count as having it, since nothing of theirs
+// is shown.
+func consented(auth Author, role int) bool {
+	if role == 0 || auth.Anonymous {
+		return true
+	}
+	for _, rl := range auth.OtherRoles {
+		if int(rl.ID) == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) guildFromReq(w http.ResponseWriter, r *http.Request) (*discord.Guild, bool) {
+	guildIDsf, err := discord.ParseSnowflake(chi.URLParam(r, "guildID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return nil, false
+	}
+	guildID := discord.GuildID(guildIDsf)
+	addSurrogateKey(w, guildID.String())
+	guild, err := s.bots.forGuild(guildID).Cabinet.Guild(guildID)
+	if err != nil {
+		// Discord answers requests for guilds the bots aren't in with a
+		// 403, and for ones that don't exist with a 404.
+		if discordStatusIs(err, http.StatusNotFound) || discordStatusIs(err, http.StatusForbidden) {
+			s.displayErr(w, r, http.StatusNotFound, errGuildNotServed)
+		} else {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching guild: %w", err))
+		}
+		return nil, false
+	}
+	return guild, true
+}
+
+// forumFromReq returns the forum of a request, which has to be a channel
+// of guild that may be served, after asking readers to confirm their age
+// if it is NSFW.
+func (s *Server) forumFromReq(w http.ResponseWriter, r *http.Request, guild *discord.Guild) (*discord.Channel, bool) {
+	forumIDsf, err := discord.ParseSnowflake(chi.URLParam(r, "forumID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return nil, false
+	}
+	forumID := discord.ChannelID(forumIDsf)
+	addSurrogateKey(w, forumID.String())
+	forum, err := s.channel(forumID)
+	if err != nil {
+		if discordStatusIs(err, http.StatusNotFound) {
+			s.displayErr(w, r, http.StatusNotFound, nil)
+		} else {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching forum: %w", err))
+		}
+		return nil, false
+	}
+	if forum.GuildID != guild.ID {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+	if !s.servableForum(w, r, forum) {
+		return nil, false
+	}
+	if !s.canServe(forum) {
+		s.displayErr(w, r, http.StatusForbidden, errChannelPrivate)
+		return nil, false
+	}
+
+	if forum.NSFW && !s.nsfwAllowed(w, r) {
+		return nil, false
+	}
+	return forum, true
+}
+
+// postFromReq returns the post of a request, which has to be a thread of
+// forum, since the age of readers is only confirmed for the forum in the
+// URL and pages show its guild's license and badges.
+func (s *Server) postFromReq(w http.ResponseWriter, r *http.Request, forum *discord.Channel) (*discord.Channel, bool) {
+	postIDsf, err := discord.ParseSnowflake(chi.URLParam(r, "postID"))
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return nil, false
+	}
+	postID := discord.ChannelID(postIDsf)
+	addSurrogateKey(w, postID.String())
+	post, err := s.channel(postID)
+	if err != nil {
+		if discordStatusIs(err, http.StatusNotFound) {
+			s.displayErr(w, r, http.StatusNotFound, nil)
+		} else if discordStatusIs(err, http.StatusForbidden) {
+			s.displayErr(w, r, http.StatusForbidden, errThreadPrivate)
+		} else {
+			s.displayErr(w, r, http.StatusInternalServerError,
+				fmt.Errorf("fetching post: %w", err))
+		}
+		return nil, false
+	}
+	if forum.Type != discord.GuildForum {
+		s.displayErr(w, r, http.StatusNotFound, errNotForumPost)
+		return nil, false
+	}
+	if post.ParentID != forum.ID {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+	if post.Type == discord.GuildPrivateThread {
+		s.displayErr(w, r, http.StatusForbidden, errThreadPrivate)
+		return nil, false
+	}
+	if !s.canServe(post) {
+		s.displayErr(w, r, http.StatusForbidden, errChannelPrivate)
+		return nil, false
+	}
+	return post, true
+}
+
+func (s *Server) PrivacyPage(w http.ResponseWriter, r *http.Request) {
+	s.executeTemplate(w, r, "privacy.gohtml", s.page(w, r))
+}
+
+func (s *Server) TOSPage(w http.ResponseWriter, r *http.Request) {
+	ctx := struct {
+		Page
+		ServiceName    string
+		ServerHostedIn string
+	}{s.page(w, r), s.site().ServiceName, s.site().ServerHostedIn}
+	s.executeTemplate(w, r, "tos.gohtml", ctx)
+}
@@ -0,0 +1,153 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// GuildConfig holds the settings an operator can set for a single guild.
+// They are read from the [Guilds.<guild ID>] tables in config.toml.
+type GuildConfig struct {
+	// License is the SPDX identifier of the license that the guild's
+	// content is published under, e.g. "CC-BY-SA-4.0". It is only kept
+	// here, not in the database, so pages and exports always show the
+	// license that is configured now, even for what was posted before it
+	// was changed.
+	License string
+	// Slug is used in place of the guild's ID in URLs. It defaults to the
+	// guild's vanity invite code, if it has one.
+	Slug string
+	// Invite is the invite, as a link or its code, that the guild's page
+	// offers readers to join the guild with.
+	Invite string
+	// Domain is a host name, like forum.example.com, that the guild's
+	// pages are served at the root of, with links made relative to it.
+	Domain string
+	// Badges are the badges shown next to the authors that have roles,
+	// keyed by the roles' IDs, e.g. "Moderator" for the moderator role.
+	Badges map[string]string
+	// Unlisted leaves the guild out of the directory on the index page.
+	// Its pages are still served to anyone with a link to them.
+	Unlisted bool
+	// AccentColor is the color, as #rrggbb, that the guild's pages are
+	// trimmed with, which Discord has no setting for.
+	AccentColor string
+}
+
+type License struct {
+	ID   string
+	Name string
+	URL  string
+}
+
+var licenses = map[string]License{
+	"CC0-1.0": {
+		"CC0-1.0", "CC0 1.0 Universal",
+		"https://creativecommons.org/publicdomain/zero/1.0/",
+	},
+	"CC-BY-4.0": {
+		"CC-BY-4.0", "Creative Commons Attribution 4.0",
+		"https://creativecommons.org/licenses/by/4.0/",
+	},
+	"CC-BY-SA-4.0": {
+		"CC-BY-SA-4.0", "Creative Commons Attribution-ShareAlike 4.0",
+		"https://creativecommons.org/licenses/by-sa/4.0/",
+	},
+	"CC-BY-NC-4.0": {
+		"CC-BY-NC-4.0", "Creative Commons Attribution-NonCommercial 4.0",
+		"https://creativecommons.org/licenses/by-nc/4.0/",
+	},
+	"CC-BY-NC-SA-4.0": {
+		"CC-BY-NC-SA-4.0", "Creative Commons Attribution-NonCommercial-ShareAlike 4.0",
+		"https://creativecommons.org/licenses/by-nc-sa/4.0/",
+	},
+	"CC-BY-ND-4.0": {
+		"CC-BY-ND-4.0", "Creative Commons Attribution-NoDerivatives 4.0",
+		"https://creativecommons.org/licenses/by-nd/4.0/",
+	},
+}
+
+// parseGuildConfigs validates the per-guild settings from the config and
+// keys them by guild ID.
+func parseGuildConfigs(cfgs map[string]GuildConfig) (map[discord.GuildID]GuildConfig, error) {
+	guilds := make(map[discord.GuildID]GuildConfig, len(cfgs))
+	for key, cfg := range cfgs {
+		sf, err := discord.ParseSnowflake(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid guild ID %q in config: %w", key, err)
+		}
+		if _, ok := licenses[cfg.License]; cfg.License != "" && !ok {
+			return nil, fmt.Errorf("unknown license %q for guild %s", cfg.License, key)
+		}
+		if cfg.Slug != "" && !validSlug(cfg.Slug) {
+			return nil, fmt.Errorf("invalid slug %q for guild %s", cfg.Slug, key)
+		}
+		if cfg.Invite != "" {
+			cfg.Invite = inviteCode(cfg.Invite)
+			if !validInviteCode(cfg.Invite) {
+				return nil, fmt.Errorf("invalid invite %q for guild %s", cfg.Invite, key)
+			}
+		}
+		if cfg.Domain != "" {
+			cfg.Domain = strings.ToLower(cfg.Domain)
+			if !validDomain(cfg.Domain) {
+				return nil, fmt.Errorf("invalid domain %q for guild %s", cfg.Domain, key)
+			}
+		}
+		if cfg.AccentColor != "" && !accentColorRegex.MatchString(cfg.AccentColor) {
+			return nil, fmt.Errorf("invalid accent color %q for guild %s", cfg.AccentColor, key)
+		}
+		for role, badge := range cfg.Badges {
+			if _, err := discord.ParseSnowflake(role); err != nil {
+				return nil, fmt.Errorf("invalid role ID %q in badges of guild %s: %w", role, key, err)
+			}
+			if badge == "" {
+				return nil, fmt.Errorf("empty badge for role %s of guild %s", role, key)
+			}
+		}
+		guilds[discord.GuildID(sf)] = cfg
+	}
+	return guilds, nil
+}
+
+// guildSlugs maps the configured slugs to their guilds.
+func guildSlugs(guilds map[discord.GuildID]GuildConfig) (map[string]discord.GuildID, error) {
+	slugs := make(map[string]discord.GuildID)
+	for id, cfg := range guilds {
+		if cfg.Slug == "" {
+			continue
+		}
+		if other, ok := slugs[cfg.Slug]; ok {
+			return nil, fmt.Errorf("guilds %s and %s have the same slug %q", other, id, cfg.Slug)
+		}
+		slugs[cfg.Slug] = id
+	}
+	return slugs, nil
+}
+
+// guildConfig returns the settings for a guild, or the zero GuildConfig if
+// the operator hasn't configured it.
+func (s *Server) guildConfig(id discord.GuildID) GuildConfig {
+	return s.site().guilds[id]
+}
+
+// guildInvite returns the link of the invite that a guild's page offers,
+// or "" if the operator hasn't configured one.
+func (s *Server) guildInvite(id discord.GuildID) string {
+	if code := s.guildConfig(id).Invite; code != "" {
+		return "https://discord.gg/" + code
+	}
+	return ""
+}
+
+// guildLicense returns the license that a guild's content is published
+// under, or nil if the guild hasn't declared one.
+func (s *Server) guildLicense(id discord.GuildID) *License {
+	l, ok := licenses[s.guildConfig(id).License]
+	if !ok {
+		return nil
+	}
+	return &l
+}
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// shardIdentifiers returns the identifiers of the gateway connections that
+// a bot opens, one for each of the shards in ids out of count, or for all of
+// them if ids is empty. The count Discord recommends is used if count is 0,
+// which only splits bots in enough guilds to require it. The shards share
+// the identify rate limits, since Discord counts identifies per bot.
+func shardIdentifiers(ctx context.Context, token string, count int, ids []int) ([]gateway.Identifier, error) {
+	if count == 0 {
+		data, err := gateway.BotURL(ctx, token)
+		if err != nil {
+			return nil, fmt.Errorf("querying the recommended number of shards: %w", err)
+		}
+		count = data.Shards
+	}
+	if count < 1 {
+		count = 1
+	}
+	if len(ids) == 0 {
+		for i := 0; i < count; i++ {
+			ids = append(ids, i)
+		}
+	}
+	base := gateway.DefaultIdentifier(token)
+	idents := make([]gateway.Identifier, 0, len(ids))
+	for _, id := range ids {
+		if id < 0 || id >= count {
+			return nil, fmt.Errorf("shard %d isn't one of the %d shards", id, count)
+		}
+		ident := base
+		ident.SetShard(id, count)
+		idents = append(idents, ident)
+	}
+	return idents, nil
+}
@@ -0,0 +1,392 @@
+package web
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type Sitemap struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+}
+
+type URL struct {
+	XMLName   string  `xml:"url"`
+	Location  string  `xml:"loc"`
+	LastMod   string  `xml:"lastmod,omitempty"`
+	Frequency string  `xml:"changefreq,omitempty"`
+	Priority  float32 `xml:"priority,omitempty"`
+}
+
+const (
+	XMLSitemapIndexStart = `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+	XMLSitemapIndexEnd   = `</sitemapindex>`
+	XMLURLSetStart       = `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+	XMLURLSetEnd         = `</urlset>`
+)
+
+const MaxSitemapURLs = 50000
+const MaxSitemapSize = 52_428_800
+
+// sitemapDelay is how long changes are collected for before the sitemap is
+// regenerated, so that a busy guild doesn't have its sitemap rewritten for
+// every message.
+const sitemapDelay = time.Minute
+
+func (s *Server) getSitemap(w http.ResponseWriter, r *http.Request) {
+	id, onDomain := hostGuild(r)
+	if r.URL.Path == "/sitemap.xml" {
+		// Custom domains have an index of their guild's files only, since
+		// sitemaps can only list URLs on their own host.
+		name := "sitemap.xml"
+		if onDomain {
+			name = domainSitemapName(id)
+		}
+		var wr = middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		http.ServeFile(wr, r, path.Join(s.SitemapDir, name))
+		if wr.Status() == http.StatusNotFound {
+			s.markAllSitemapsDirty()
+		}
+		return
+	}
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/sitemap/")
+	if onDomain && !strings.HasPrefix(r.URL.Path, "sitemap-"+id.String()+"-") {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	http.ServeFile(w, r, path.Join(s.SitemapDir, r.URL.Path))
+}
+
+// markSitemapDirty marks a guild's part of the sitemap as needing to be
+// regenerated, and asks for it to be.
+func (s *Server) markSitemapDirty(id discord.GuildID) {
+	if !id.IsValid() {
+		return
+	}
+	s.sitemapMu.Lock()
+	s.sitemapDirty[id] = true
+	s.sitemapMu.Unlock()
+	s.requestSitemapUpdate()
+}
+
+// requestSitemapUpdate asks for the sitemap to be regenerated, unless that
+// has already been asked for.
+func (s *Server) requestSitemapUpdate() {
+	select {
+	case s.updateSitemap <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) markAllSitemapsDirty() {
+	guilds, err := s.bots.guilds()
+	if err != nil {
+		log.Println("Error listing guilds for sitemap:", err)
+		return
+	}
+	for _, g := range guilds {
+		s.markSitemapDirty(g.ID)
+	}
+}
+
+// UpdateSitemap keeps the sitemap up to date. Each guild has its own
+// sitemap files, which are only regenerated after something in the guild
+// changed, and all of them are regenerated every 6 hours. Files are
+// replaced atomically, so the last complete sitemap is always served.
+func (s *Server) UpdateSitemap() {
+	log.Println("Waiting 60 seconds before generating sitemap.")
+	time.Sleep(60 * time.Second)
+	stat, err := os.Stat(filepath.Join(s.SitemapDir, "sitemap.xml"))
+	if err != nil || time.Since(stat.ModTime()) >= 6*time.Hour {
+		s.markAllSitemapsDirty()
+	}
+	ticker := time.NewTicker(6 * time.Hour)
+	for {
+		s.sitemapMu.Lock()
+		dirty := s.sitemapDirty
+		s.sitemapDirty = make(map[discord.GuildID]bool)
+		s.sitemapMu.Unlock()
+		if len(dirty) > 0 {
+			if err := s.writeSitemaps(dirty); err != nil {
+				log.Println("Error occured while writing sitemap:", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+			s.markAllSitemapsDirty()
+		case <-s.updateSitemap:
+			time.Sleep(sitemapDelay)
+		}
+	}
+}
+
+// writeSitemaps regenerates the sitemap files of the given guilds, and then
+// the sitemap index.
+func (s *Server) writeSitemaps(guilds map[discord.GuildID]bool) error {
+	if err := os.MkdirAll(s.SitemapDir, 0755); err != nil {
+		return err
+	}
+	for id := range guilds {
+		if err := s.writeGuildSitemap(id); err != nil {
+			return fmt.Errorf("writing sitemap of %s: %w", id, err)
+		}
+	}
+	return s.writeSitemapIndex()
+}
+
+// guildSitemapPattern matches the sitemap files of all guilds.
+const guildSitemapPattern = "sitemap-*-*.xml"
+
+func guildSitemapName(id discord.GuildID, n int) string {
+	return fmt.Sprintf("sitemap-%s-%d.xml", id, n)
+}
+
+// domainSitemapPattern matches the sitemap indexes of custom domains.
+const domainSitemapPattern = "sitemap.*.xml"
+
+func domainSitemapName(id discord.GuildID) string {
+	return fmt.Sprintf("sitemap.%s.xml", id)
+}
+
+// writeGuildSitemap writes the sitemap files of a guild, split so that no
+// file has more URLs or bytes than allowed, and removes the files it no
+// longer needs. A guild that none of the bots are in has no files.
+func (s *Server) writeGuildSitemap(id discord.GuildID) error {
+	var files [][]byte
+	if _, err := s.bots.forGuild(id).Cabinet.Guild(id); err == nil {
+		urls, err := s.guildSitemapURLs(id)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		var count int
+		for _, u := range urls {
+			b, err := xml.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if count > 0 && (count == MaxSitemapURLs ||
+				len(xml.Header)+len(XMLURLSetStart)+buf.Len()+len(b)+len(XMLURLSetEnd) > MaxSitemapSize) {
+				files = append(files, append([]byte(nil), buf.Bytes()...))
+				buf.Reset()
+				count = 0
+			}
+			buf.Write(b)
+			count++
+		}
+		if count > 0 {
+			files = append(files, buf.Bytes())
+		}
+	}
+	for i, urls := range files {
+		p := filepath.Join(s.SitemapDir, guildSitemapName(id, i+1))
+		err := writeSitemapFile(p, func(w io.Writer) error {
+			if _, err := io.WriteString(w, xml.Header+XMLURLSetStart); err != nil {
+				return err
+			}
+			if _, err := w.Write(urls); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, XMLURLSetEnd)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for n := len(files) + 1; ; n++ {
+		err := os.Remove(filepath.Join(s.SitemapDir, guildSitemapName(id, n)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// guildSitemapURLs returns the URLs of a guild's pages that go in the
+// sitemap.
+func (s *Server) guildSitemapURLs(id discord.GuildID) ([]URL, error) {
+	guild, err := s.bots.forGuild(id).Cabinet.Guild(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	guildURL := s.guildURL(id)
+	urls := []URL{{Location: guildURL, Frequency: "daily", Priority: 0.8}}
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching channels: %w", err)
+	}
+	for _, forum := range channels {
+		if forum.Type != discord.GuildForum || forum.NSFW {
+			continue
+		}
+		if !s.canServe(&forum) {
+			continue
+		}
+		fc := newForumChannel(forum, channels)
+		if !s.site().listsForum(fc) {
+			continue
+		}
+		freq, priority := activityHints(fc.LastActive, now)
+		urls = append(urls, URL{
+			Location:  fmt.Sprintf("%s/%s", guildURL, forum.ID),
+			Frequency: freq,
+			Priority:  priority,
+		})
+	}
+	for _, post := range channels {
+		if post.Type != discord.GuildPublicThread {
+			continue
+		}
+		parent, err := s.channel(post.ParentID)
+		if err != nil {
+			continue
+		}
+		if parent.Type != discord.GuildForum || parent.NSFW || !s.canServe(parent) {
+			continue
+		}
+		freq, priority := postHints(&post, now)
+		urls = append(urls, URL{
+			Location:  fmt.Sprintf("%s/%s/%s", guildURL, post.ParentID, post.ID),
+			LastMod:   postModTime(&post).UTC().Format(time.RFC3339),
+			Frequency: freq,
+			Priority:  priority,
+		})
+	}
+	return urls, nil
+}
+
+// sitemapActivity are the change frequencies and priorities that pages are
+// given in the sitemap by how recently what they show last changed, so that
+// crawlers spend more of their time on pages that are active. Pages that
+// haven't changed in longer than all of them get yearlyPriority.
+var sitemapActivity = []struct {
+	within   time.Duration
+	freq     string
+	priority float32
+}{
+	{24 * time.Hour, "hourly", 0.9},
+	{7 * 24 * time.Hour, "daily", 0.8},
+	{30 * 24 * time.Hour, "weekly", 0.6},
+	{365 * 24 * time.Hour, "monthly", 0.4},
+}
+
+const yearlyPriority = 0.3
+
+// activityHints returns the change frequency and priority of a page whose
+// content last changed at last.
+func activityHints(last time.Time, now time.Time) (string, float32) {
+	for _, a := range sitemapActivity {
+		if now.Sub(last) < a.within {
+			return a.freq, a.priority
+		}
+	}
+	return "yearly", yearlyPriority
+}
+
+// postHints returns the change frequency and priority of a post's page.
+// Locked posts only change if moderators edit them, and archived ones only
+// once someone replies to them, so they are ranked below any that are open.
+func postHints(post *discord.Channel, now time.Time) (string, float32) {
+	if md := post.ThreadMetadata; md != nil {
+		switch {
+		case md.Locked:
+			return "never", 0.1
+		case md.Archived:
+			return "yearly", 0.2
+		}
+	}
+	return activityHints(postModTime(post), now)
+}
+
+// writeSitemapIndex writes the sitemap index, which lists the sitemap files
+// of every guild, except for those of guilds with custom domains, which
+// get an index of their own served on their domain.
+func (s *Server) writeSitemapIndex() error {
+	names, err := filepath.Glob(filepath.Join(s.SitemapDir, guildSitemapPattern))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	var main []string
+	byDomain := make(map[discord.GuildID][]string)
+	for _, name := range names {
+		var id discord.GuildID
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(name), "sitemap-%d-%d.xml", &id, &n); err == nil && s.guildConfig(id).Domain != "" {
+			byDomain[id] = append(byDomain[id], name)
+		} else {
+			main = append(main, name)
+		}
+	}
+	old, err := filepath.Glob(filepath.Join(s.SitemapDir, domainSitemapPattern))
+	if err != nil {
+		return err
+	}
+	for _, name := range old {
+		var id discord.GuildID
+		if _, err := fmt.Sscanf(filepath.Base(name), "sitemap.%d.xml", &id); err == nil && byDomain[id] == nil {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	for id, names := range byDomain {
+		p := filepath.Join(s.SitemapDir, domainSitemapName(id))
+		if err := s.writeSitemapIndexFile(p, s.domainURL(s.guildConfig(id).Domain), names); err != nil {
+			return err
+		}
+	}
+	return s.writeSitemapIndexFile(filepath.Join(s.SitemapDir, "sitemap.xml"), s.site().URL, main)
+}
+
+// writeSitemapIndexFile writes a sitemap index listing the sitemap files
+// with the given names, as served under base.
+func (s *Server) writeSitemapIndexFile(p, base string, names []string) error {
+	return writeSitemapFile(p, func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, XMLSitemapIndexStart); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		for _, name := range names {
+			if err := enc.Encode(Sitemap{
+				Loc: fmt.Sprintf("%s/sitemap/%s", base, filepath.Base(name)),
+			}); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, XMLSitemapIndexEnd); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err
+	})
+}
+
+// writeSitemapFile replaces a sitemap file atomically, so that requests
+// never see it half written.
+func writeSitemapFile(p string, write func(io.Writer) error) error {
+	if err := writeFileAtomic(p, write); err != nil {
+		return err
+	}
+	return os.Chmod(p, 0644)
+}
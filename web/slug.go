@@ -0,0 +1,129 @@
+package web
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// reservedSlugs are the top level paths of the site that a guild's slug
+// would shadow.
+var reservedSlugs = map[string]bool{
+	"static":      true,
+	"sitemap":     true,
+	"sitemap.xml": true,
+	"status.json": true,
+	"media":       true,
+	"privacy":     true,
+	"tos":         true,
+	"confirm-age": true,
+	"embed":       true,
+	"purge":       true,
+	"admin":       true,
+	"avatars":     true,
+	"recent":      true,
+	"recent.atom": true,
+	"report":      true,
+}
+
+// validSlug reports whether s can be used in place of a guild ID in URLs.
+// Slugs that are all digits would be taken for guild IDs.
+func validSlug(s string) bool {
+	return slugRegex.MatchString(s) && !reservedSlugs[s] &&
+		strings.Trim(s, "0123456789") != ""
+}
+
+// guildSlug returns the slug of a guild, which is the one configured for it
+// or else its vanity invite code. It returns "" if the guild has neither.
+func (s *Server) guildSlug(id discord.GuildID) string {
+	if slug := s.guildConfig(id).Slug; slug != "" {
+		return slug
+	}
+	guild, err := s.bots.forGuild(id).Cabinet.Guild(id)
+	if err != nil {
+		return ""
+	}
+	if slug := strings.ToLower(guild.VanityURLCode); validSlug(slug) {
+		if _, taken := s.site().slugs[slug]; !taken {
+			return slug
+		}
+	}
+	return ""
+}
+
+// guildPath returns the path of a guild's page, using its slug if it has
+// one.
+func (s *Server) guildPath(id discord.GuildID) string {
+	if slug := s.guildSlug(id); slug != "" {
+		return "/" + slug
+	}
+	return "/" + id.String()
+}
+
+// guildBySlug returns the guild that a slug belongs to.
+func (s *Server) guildBySlug(slug string) (discord.GuildID, bool) {
+	if id, ok := s.site().slugs[slug]; ok {
+		return id, true
+	}
+	guilds, err := s.bots.guilds()
+	if err != nil {
+		return 0, false
+	}
+	for _, g := range guilds {
+		if s.guildConfig(g.ID).Slug == "" && strings.ToLower(g.VanityURLCode) == slug {
+			return g.ID, true
+		}
+	}
+	return 0, false
+}
+
+// resolveSlugs is a middleware that rewrites paths starting with a guild's
+// slug to start with its ID instead, so that the slug works anywhere the ID
+// does.
+func (s *Server) resolveSlugs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if validSlug(first) {
+			if id, ok := s.guildBySlug(first); ok {
+				u := *r.URL
+				u.Path = "/" + id.String()
+				if rest != "" {
+					u.Path += "/" + rest
+				}
+				u.RawPath = ""
+				r2 := *r
+				r2.URL = &u
+				r = &r2
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canonicalURL returns the canonical URL of a page showing a guild's
+// content, which uses the guild's slug and drops the parameters that only
+// change how the page looks.
+func (s *Server) canonicalURL(r *http.Request, id discord.GuildID) string {
+	path := strings.TrimPrefix(r.URL.Path, "/"+id.String())
+	if !strings.HasPrefix(path, "/") && path != "" {
+		return ""
+	}
+	u := s.baseURL(r) + s.requestGuildPath(r, id)
+	if d := s.guildConfig(id).Domain; d != "" {
+		// Guilds with a custom domain are indexed there, even when they
+		// are found through the site's own URL.
+		u = s.domainURL(d)
+	}
+	u += strings.TrimSuffix(path, "/")
+	q := r.URL.Query()
+	q.Del("theme")
+	q.Del("tz")
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
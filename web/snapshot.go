@@ -0,0 +1,66 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// messageHasSnapshot is the flag of messages that forward other messages
+// and carry copies of them, which arikawa doesn't know of.
+const messageHasSnapshot discord.MessageFlags = 1 << 14
+
+// snapshots returns the copies of the messages that the given messages of
+// a post forwarded. arikawa drops them when it decodes messages, so they
+// are fetched again by themselves and stored the first time they are
+// needed. Frozen guilds only have what was stored.
+func (s *Server) snapshots(ctx context.Context, post *discord.Channel, msgs []discord.Message) (map[discord.MessageID][]discord.Message, error) {
+	var forwards []discord.Message
+	for _, m := range msgs {
+		if m.Flags&messageHasSnapshot != 0 {
+			forwards = append(forwards, m)
+		}
+	}
+	if len(forwards) == 0 {
+		return nil, nil
+	}
+	db := s.db
+	snapshots, err := db.Snapshots(ctx, post.ID, forwards[0].ID, forwards[len(forwards)-1].ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.frozen.frozenAt(post.GuildID); ok {
+		return snapshots, nil
+	}
+	for _, m := range forwards {
+		if _, ok := snapshots[m.ID]; ok {
+			continue
+		}
+		var raw struct {
+			Snapshots []struct {
+				Message discord.Message `json:"message"`
+			} `json:"message_snapshots"`
+		}
+		err := s.bots.forChannel(post.ID).Client.WithContext(ctx).RequestJSON(&raw, "GET",
+			api.EndpointChannels+post.ID.String()+"/messages/"+m.ID.String())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Error fetching forwarded message %s: %v", m.ID, err)
+			continue
+		}
+		var copies []discord.Message
+		for _, snap := range raw.Snapshots {
+			copies = append(copies, snap.Message)
+		}
+		if err := db.SaveSnapshots(ctx, post.ID, m.ID, copies); err != nil {
+			return nil, fmt.Errorf("storing forwarded message: %w", err)
+		}
+		snapshots[m.ID] = copies
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// postSort is a way that a forum's posts can be ordered, chosen with the
+// sort parameter.
+type postSort struct {
+	Key  string
+	Name string
+	less func(a, b *Post) bool
+}
+
+var postSorts = []postSort{
+	{"active", "Recently active", func(a, b *Post) bool {
+		return a.LastMessageID.Time().After(b.LastMessageID.Time())
+	}},
+	{"created", "Newest", func(a, b *Post) bool {
+		return a.ID > b.ID
+	}},
+	{"messages", "Most messages", func(a, b *Post) bool {
+		return a.MessageCount > b.MessageCount
+	}},
+	{"title", "Title", func(a, b *Post) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}},
+}
+
+// forumSort returns the key of the order that a forum's posts are listed
+// in, which is the one asked for if it's valid or else the forum's default
+// sort order.
+func forumSort(forum *discord.Channel, key string) string {
+	for _, s := range postSorts {
+		if s.Key == key {
+			return key
+		}
+	}
+	if forum.DefaultSoftOrder != nil && *forum.DefaultSoftOrder == discord.SoftOrderTypeCreationDate {
+		return "created"
+	}
+	return "active"
+}
+
+// forumLayouts are the ways that a forum's posts can be shown, chosen with
+// the layout parameter: as a list, or as a gallery of their thumbnails like
+// Discord's media forums.
+var forumLayouts = []struct{ Key, Name string }{
+	{"list", "List"},
+	{"gallery", "Gallery"},
+}
+
+// forumLayout returns the key of the layout that a forum's posts are shown
+// in, which is the one asked for if it's valid or else the forum's default
+// layout.
+func forumLayout(forum *discord.Channel, key string) string {
+	for _, l := range forumLayouts {
+		if l.Key == key {
+			return key
+		}
+	}
+	if forum.DefaultForumLayout == discord.ForumLayoutTypeGalleryView {
+		return "gallery"
+	}
+	return "list"
+}
+
+// sortPosts orders posts by the sort with the given key, keeping pinned
+// posts first.
+func sortPosts(posts []Post, key string) {
+	less := postSorts[0].less
+	for _, s := range postSorts {
+		if s.Key == key {
+			less = s.less
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].IsPinned() != posts[j].IsPinned() {
+			return posts[i].IsPinned()
+		}
+		return less(&posts[i], &posts[j])
+	})
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// StatusVersion is the version of the /status.json schema. It is bumped
+// whenever a field is removed or changes meaning; fields may be added
+// without bumping it.
+const StatusVersion = 1
+
+// stats tracks the health of the server for /status.json.
+type stats struct {
+	startedAt time.Time
+
+	lastEvent atomic.Int64 // unix nanoseconds
+
+	requests     atomic.Uint64
+	serverErrors atomic.Uint64
+
+	errorsMu     sync.Mutex
+	recentErrors []loggedError // oldest first
+}
+
+// maxRecentErrors is how many of the last server errors are kept for the
+// admin dashboard.
+const maxRecentErrors = 50
+
+// loggedError is a server error that a request ended in.
+type loggedError struct {
+	Time  time.Time
+	Path  string
+	Error string
+}
+
+// logError keeps a server error for the admin dashboard.
+func (st *stats) logError(r *http.Request, err error) {
+	st.errorsMu.Lock()
+	defer st.errorsMu.Unlock()
+	if len(st.recentErrors) == maxRecentErrors {
+		st.recentErrors = st.recentErrors[1:]
+	}
+	st.recentErrors = append(st.recentErrors, loggedError{time.Now(), r.URL.Path, err.Error()})
+}
+
+// errors returns the last server errors, newest first.
+func (st *stats) errors() []loggedError {
+	st.errorsMu.Lock()
+	defer st.errorsMu.Unlock()
+	errs := make([]loggedError, len(st.recentErrors))
+	for i, e := range st.recentErrors {
+		errs[len(errs)-1-i] = e
+	}
+	return errs
+}
+
+func newStats() *stats {
+	return &stats{startedAt: time.Now()}
+}
+
+func (st *stats) HandleEvent(ev interface{}) {
+	st.lastEvent.Store(time.Now().UnixNano())
+}
+
+// countRequests is a middleware counting requests and the ones that ended
+// in a server error.
+func (st *stats) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wr := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(wr, r)
+		st.requests.Add(1)
+		if wr.Status() >= 500 {
+			st.serverErrors.Add(1)
+		}
+	})
+}
+
+type Status struct {
+	Version       int     `json:"version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Gateway       struct {
+		Connected bool `json:"connected"`
+		// LastEvent is when the last gateway event was received, or null
+		// if none has been received yet.
+		LastEvent *time.Time `json:"last_event"`
+		LatencyMS int64      `json:"latency_ms"`
+	} `json:"gateway"`
+	Caches struct {
+		Guilds   int `json:"guilds"`
+		Channels int `json:"channels"`
+		// ChannelEvictions is how many channels have been dropped from
+		// the cache to keep it under MaxCachedChannels.
+		ChannelEvictions uint64 `json:"channel_evictions"`
+		FetchedForums    int    `json:"fetched_forums"`
+		RoleLists        int    `json:"role_lists"`
+		SocialCards      int    `json:"social_cards"`
+	} `json:"caches"`
+	Crawl struct {
+		// PendingFetches is the number of channels whose message history
+		// is being fetched from Discord.
+		PendingFetches int64 `json:"pending_fetches"`
+	} `json:"crawl"`
+	HTTP struct {
+		Requests     uint64  `json:"requests"`
+		ServerErrors uint64  `json:"server_errors"`
+		ErrorRate    float64 `json:"error_rate"`
+	} `json:"http"`
+}
+
+func (s *Server) status() Status {
+	var st Status
+	st.Version = StatusVersion
+	st.UptimeSeconds = time.Since(s.stats.startedAt).Seconds()
+
+	st.Gateway.Connected = !s.gateways.degraded()
+	if last := s.stats.lastEvent.Load(); last != 0 {
+		t := time.Unix(0, last).UTC()
+		st.Gateway.LastEvent = &t
+	}
+	st.Gateway.LatencyMS = s.bots[0].Gateway().Latency().Milliseconds()
+
+	guilds, _ := s.bots.guilds()
+	st.Caches.Guilds = len(guilds)
+	st.Caches.Channels = s.messageCache.Len()
+	st.Caches.ChannelEvictions = s.messageCache.Evictions()
+	s.fetchedInactiveMu.Lock()
+	st.Caches.FetchedForums = len(s.fetchedInactive)
+	s.fetchedInactiveMu.Unlock()
+	s.roles.mu.Lock()
+	st.Caches.RoleLists = len(s.roles.roles)
+	s.roles.mu.Unlock()
+	s.cards.mu.Lock()
+	st.Caches.SocialCards = s.cards.cards.Len()
+	s.cards.mu.Unlock()
+
+	st.Crawl.PendingFetches = s.messageCache.Pending()
+
+	st.HTTP.Requests = s.stats.requests.Load()
+	st.HTTP.ServerErrors = s.stats.serverErrors.Load()
+	if st.HTTP.Requests > 0 {
+		st.HTTP.ErrorRate = float64(st.HTTP.ServerErrors) / float64(st.HTTP.Requests)
+	}
+	return st
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(s.status())
+}
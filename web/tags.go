@@ -0,0 +1,92 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/exp/slices"
+)
+
+// tagFromReq returns the tag that a forum's posts are to be filtered by,
+// given either in the path or as the tag query parameter. It returns nil if
+// the posts aren't filtered.
+func (s *Server) tagFromReq(w http.ResponseWriter, r *http.Request, forum *discord.Channel) (*discord.Tag, bool) {
+	param := chi.URLParam(r, "tagID")
+	if param == "" {
+		param = r.URL.Query().Get("tag")
+	}
+	if param == "" {
+		return nil, true
+	}
+	sf, err := discord.ParseSnowflake(param)
+	if err != nil {
+		s.displayErr(w, r, http.StatusBadRequest, err)
+		return nil, false
+	}
+	i := slices.IndexFunc(forum.AvailableTags, func(tag discord.Tag) bool {
+		return tag.ID == discord.TagID(sf)
+	})
+	if i < 0 {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return nil, false
+	}
+	return &forum.AvailableTags[i], true
+}
+
+// TagCount is a tag available in a forum and the number of posts it is
+// applied to.
+type TagCount struct {
+	discord.Tag
+	Posts int
+}
+
+func (s *Server) getForumTags(w http.ResponseWriter, r *http.Request) {
+	guild, ok := s.guildFromReq(w, r)
+	if !ok {
+		return
+	}
+	forum, ok := s.forumFromReq(w, r, guild)
+	if !ok {
+		return
+	}
+	if forum.Type != discord.GuildForum || forum.GuildID != guild.ID {
+		s.displayErr(w, r, http.StatusNotFound, nil)
+		return
+	}
+	channels, err := s.channels(guild.ID)
+	if err != nil {
+		s.displayErr(w, r, http.StatusInternalServerError,
+			fmt.Errorf("fetching guild threads: %w", err))
+		return
+	}
+	counts := make(map[discord.TagID]int)
+	for _, thread := range channels {
+		if thread.ParentID != forum.ID ||
+			thread.Type != discord.GuildPublicThread {
+			continue
+		}
+		for _, id := range thread.AppliedTags {
+			counts[id]++
+		}
+	}
+	tags := make([]TagCount, len(forum.AvailableTags))
+	for i, tag := range forum.AvailableTags {
+		tags[i] = TagCount{Tag: tag, Posts: counts[tag.ID]}
+	}
+	ctx := struct {
+		Page
+		Guild *discord.Guild
+		Forum *discord.Channel
+		Tags  []TagCount
+	}{
+		Page:  s.guildPage(w, r, guild.ID),
+		Guild: guild,
+		Forum: forum,
+		Tags:  tags,
+	}
+	ctx.Meta.Breadcrumbs = append(s.breadcrumbs(r, guild, forum, nil),
+		Breadcrumb{Name: ctx.Locale.T("Tags"), Path: ctx.GuildPath + "/" + forum.ID.String() + "/tags"})
+	s.executeTemplate(w, r, "tags.gohtml", ctx)
+}
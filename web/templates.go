@@ -0,0 +1,62 @@
+package web
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"sync"
+)
+
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	tmpl := template.New("")
+	tmpl.Funcs(funcMap)
+	if _, err := tmpl.ParseFS(fsys, "templates/*"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// reloadingTemplates parses the templates again for every page, so that
+// changes to them show up right away. Pages are rendered with the last set
+// that parsed while the templates have an error, so a mistake in one of
+// them doesn't break every page.
+type reloadingTemplates struct {
+	fsys fs.FS
+
+	mu   sync.Mutex
+	good *template.Template
+	// lastErr is the parse error last logged, so that it is only logged
+	// once and not for every page.
+	lastErr string
+}
+
+// newReloadingTemplates parses the templates, which have to parse to start
+// with.
+func newReloadingTemplates(fsys fs.FS) (*reloadingTemplates, error) {
+	tmpl, err := parseTemplates(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadingTemplates{fsys: fsys, good: tmpl}, nil
+}
+
+func (t *reloadingTemplates) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	tmpl, err := parseTemplates(t.fsys)
+	t.mu.Lock()
+	if err != nil {
+		if err.Error() != t.lastErr {
+			log.Println("Error parsing templates, keeping the last ones that parsed:", err)
+			t.lastErr = err.Error()
+		}
+		tmpl = t.good
+	} else {
+		if t.lastErr != "" {
+			log.Println("Templates parse again")
+			t.lastErr = ""
+		}
+		t.good = tmpl
+	}
+	t.mu.Unlock()
+	return tmpl.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// themeCookie remembers the theme a reader picked with ?theme=.
+const themeCookie = "dforum_theme"
+
+// findThemes lists the themes available in the resources, which are the
+// stylesheets in static/themes.
+func findThemes(fsys fs.FS) ([]string, error) {
+	matches, err := fs.Glob(fsys, "static/themes/*.css")
+	if err != nil {
+		return nil, err
+	}
+	themes := make([]string, 0, len(matches))
+	for _, m := range matches {
+		themes = append(themes, strings.TrimSuffix(path.Base(m), ".css"))
+	}
+	sort.Strings(themes)
+	return themes, nil
+}
+
+func (s *Server) validTheme(name string) bool {
+	return containsTheme(s.site().themes, name)
+}
+
+func containsTheme(themes []string, name string) bool {
+	i := sort.SearchStrings(themes, name)
+	return i < len(themes) && themes[i] == name
+}
+
+// theme returns the theme to render the request with. It is chosen server
+// side, since the browsers we support can't select stylesheets with media
+// queries like prefers-color-scheme. A ?theme= parameter switches the theme
+// and is remembered in a cookie. Readers who haven't picked one get the
+// configured default theme.
+func (s *Server) theme(w http.ResponseWriter, r *http.Request) string {
+	if q, ok := r.URL.Query()["theme"]; ok {
+		name := q[0]
+		if !s.validTheme(name) {
+			http.SetCookie(w, &http.Cookie{Name: themeCookie, Path: "/", MaxAge: -1})
+			return s.site().DefaultTheme
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    name,
+			Path:     "/",
+			Expires:  time.Now().Add(365 * 24 * time.Hour),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return name
+	}
+	if c, err := r.Cookie(themeCookie); err == nil && s.validTheme(c.Value) {
+		return c.Value
+	}
+	return s.site().DefaultTheme
+}
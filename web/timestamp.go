@@ -0,0 +1,91 @@
+package web
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"time"
+	// Readers can pick any timezone, so don't rely on the host having a
+	// timezone database.
+	_ "time/tzdata"
+)
+
+// tzCookie remembers the timezone a reader picked with ?tz=.
+const tzCookie = "dforum_tz"
+
+// timezone returns the timezone to show times in for the request. Like the
+// theme, it is chosen with a ?tz= parameter and remembered in a cookie.
+func (s *Server) timezone(w http.ResponseWriter, r *http.Request) *time.Location {
+	if q, ok := r.URL.Query()["tz"]; ok {
+		loc, err := time.LoadLocation(q[0])
+		if q[0] == "" || err != nil {
+			http.SetCookie(w, &http.Cookie{Name: tzCookie, Path: "/", MaxAge: -1})
+			return s.site().defaultTimezone
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     tzCookie,
+			Value:    q[0],
+			Path:     "/",
+			Expires:  time.Now().Add(365 * 24 * time.Hour),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return loc
+	}
+	if c, err := r.Cookie(tzCookie); err == nil {
+		if loc, err := time.LoadLocation(c.Value); err == nil {
+			return loc
+		}
+	}
+	return s.site().defaultTimezone
+}
+
+// Timestamp renders t as a <time> element in one of Discord's timestamp
+// styles, with the full date and time as its title.
+func (l *Locale) Timestamp(t time.Time, style string) template.HTML {
+	var s string
+	if style == "R" {
+		s = l.Relative(t, time.Now())
+	} else {
+		s = l.Format(t, style)
+	}
+	return template.HTML(`<time datetime="` + t.UTC().Format(time.RFC3339) +
+		`" title="` + html.EscapeString(l.Format(t, "F")) + `">` +
+		html.EscapeString(s) + `</time>`)
+}
+
+// Relative describes t relative to now, like "3 days ago" or "in 2 hours".
+func (l *Locale) Relative(t, now time.Time) string {
+	d := now.Sub(t)
+	past := d >= 0
+	if !past {
+		d = -d
+	}
+	const day = 24 * time.Hour
+	var n time.Duration
+	var unit string
+	switch {
+	case d < time.Minute:
+		n, unit = d/time.Second, "second"
+	case d < time.Hour:
+		n, unit = d/time.Minute, "minute"
+	case d < day:
+		n, unit = d/time.Hour, "hour"
+	case d < 30*day:
+		n, unit = d/day, "day"
+	case d < 365*day:
+		n, unit = d/(30*day), "month"
+	default:
+		n, unit = d/(365*day), "year"
+	}
+	if n == 1 {
+		if past {
+			return l.T("1 " + unit + " ago")
+		}
+		return l.T("in 1 " + unit)
+	}
+	if past {
+		return l.T("%d "+unit+"s ago", int(n))
+	}
+	return l.T("in %d "+unit+"s", int(n))
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// TOCEntry is an entry of the table of contents of a post, which lists the
+// starter message and the messages that start with a markdown heading.
+type TOCEntry struct {
+	Title string
+	// Level is the level of the heading, from 1 for # to 3 for ###. The
+	// starter message is at level 1.
+	Level int
+	// Link is the page that starts with the message, and Current is set
+	// if it is on the page being shown.
+	Link    string
+	Current bool
+}
+
+// headingRegex matches the markdown heading at the start of a message.
+var headingRegex = regexp.MustCompile(`^(#{1,3}) +([^\n]+)`)
+
+// maxTOCTitle is how many characters of a heading are shown in the table of
+// contents.
+const maxTOCTitle = 80
+
+// tableOfContents returns the table of contents of a post, or nil if it
+// has no headings. Only the messages that are stored are looked at, so it
+// is missing headings until the post's history has been fetched. link
+// returns the link to the page that starts with a message, and shown are
+// the messages on the page being shown.
+func (s *Server) tableOfContents(ctx context.Context, post *discord.Channel, shown []discord.Message,
+	link func(discord.MessageID) string) ([]TOCEntry, error) {
+	headings, err := s.db.Headings(ctx, post.ID)
+	if err != nil || len(headings) == 0 {
+		return nil, err
+	}
+	onPage := make(map[discord.MessageID]bool, len(shown))
+	for _, m := range shown {
+		onPage[m.ID] = true
+	}
+	// The starter message has the ID of the post.
+	starter := discord.MessageID(post.ID)
+	toc := []TOCEntry{{Title: post.Name, Level: 1, Link: link(starter), Current: onPage[starter]}}
+	for _, m := range headings {
+		match := headingRegex.FindStringSubmatch(m.Content)
+		if match == nil || m.ID == starter || s.optOuts.has(m.Author.ID) {
+			continue
+		}
+		title := strings.TrimSpace(s.site().redact(match[2]))
+		if r := []rune(title); len(r) > maxTOCTitle {
+			title = string(r[:maxTOCTitle-1]) + "…"
+		}
+		toc = append(toc, TOCEntry{
+			Title:   title,
+			Level:   len(match[1]),
+			Link:    link(m.ID),
+			Current: onPage[m.ID],
+		})
+	}
+	if len(toc) == 1 {
+		return nil, nil
+	}
+	return toc, nil
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IoIxD/dforum/cache"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// maxCachedUsers is how many users fetched over REST are kept, and
+// userCacheTTL is how long they are kept before being fetched again.
+const (
+	maxCachedUsers = 1000
+	userCacheTTL   = time.Hour
+)
+
+// userCache holds the users of authors that have left the guilds of their
+// messages, which the bots' caches don't have since they only keep members,
+// and which users each guild's members are known to have left.
+type userCache struct {
+	mu     sync.Mutex
+	users  *cache.LRU[discord.UserID, cachedUser]
+	former map[discord.GuildID]map[discord.UserID]struct{}
+}
+
+type cachedUser struct {
+	// user is nil if it couldn't be fetched.
+	user      *discord.User
+	fetchedAt time.Time
+}
+
+func newUserCache() *userCache {
+	return &userCache{
+		users:  cache.NewLRU[discord.UserID, cachedUser](maxCachedUsers, nil),
+		former: make(map[discord.GuildID]map[discord.UserID]struct{}),
+	}
+}
+
+// setFormer records whether a user has left a guild.
+func (c *userCache) setFormer(guildID discord.GuildID, id discord.UserID, former bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !former {
+		delete(c.former[guildID], id)
+		return
+	}
+	if c.former[guildID] == nil {
+		c.former[guildID] = make(map[discord.UserID]struct{})
+	}
+	c.former[guildID][id] = struct{}{}
+}
+
+func (c *userCache) isFormer(guildID discord.GuildID, id discord.UserID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.former[guildID][id]
+	return ok
+}
+
+func (c *userCache) forgetGuild(guildID discord.GuildID) {
+	c.mu.Lock()
+	delete(c.former, guildID)
+	c.mu.Unlock()
+}
+
+// formerMember returns the user of an author who has left a guild, whose
+// avatar in their messages may not be on the CDN anymore, or nil if Discord
+// doesn't answer in time. Users that couldn't be fetched aren't tried again
+// until the cache expires, so a page never waits on more than one attempt.
+func (s *Server) formerMember(guildID discord.GuildID, id discord.UserID) *discord.User {
+	s.users.mu.Lock()
+	cached, ok := s.users.users.Get(id)
+	s.users.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < userCacheTTL {
+		return cached.user
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	u, err := s.bots.forGuild(guildID).Client.WithContext(ctx).User(id)
+	if err != nil {
+		u = nil
+	}
+	s.users.mu.Lock()
+	s.users.users.Add(id, cachedUser{user: u, fetchedAt: time.Now()})
+	s.users.mu.Unlock()
+	return u
+}
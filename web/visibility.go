@@ -0,0 +1,58 @@
+package web
+
+import (
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// servePermissions are what the bot needs in a channel to archive it.
+const servePermissions = discord.PermissionViewChannel | discord.PermissionReadMessageHistory
+
+// servableFromCache reports whether the page of a post may be served from
+// the rendered pages that are kept, which skip the checks of the handlers.
+// It is checked on every hit, so that posts whose forum was made private
+// since they were rendered aren't served by a direct link. Posts in NSFW
+// forums are left to the handlers, which ask readers to confirm their age.
+func (s *Server) servableFromCache(post *discord.Channel) bool {
+	if !s.canServe(post) {
+		return false
+	}
+	forum, err := s.channel(post.ParentID)
+	return err == nil && !forum.NSFW
+}
+
+// canServe reports whether what is in a channel may be shown on the site.
+// Channels are only shown if the bot can read them and @everyone can see
+// them, so that channels that are private on Discord, as staff forums are,
+// aren't published because the bot was given a role that can see them.
+// Threads are shown if they are public and their channel may be, since
+// threads have no permission overwrites of their own, and private threads
+// only ever to those added to them.
+//
+// Everything that lists or shows channels checks them with canServe, so
+// that private ones can't be reached with a direct link, or show up in
+// sitemaps, feeds or exports. Pages found by the IDs in their URL are also
+// checked to be in the guild and forum that the URL names, by forumFromReq
+// and postFromReq, since the age of readers is confirmed for those.
+func (s *Server) canServe(ch *discord.Channel) bool {
+	if threadTypes[ch.Type] {
+		if ch.Type == discord.GuildPrivateThread {
+			return false
+		}
+		parent, err := s.channel(ch.ParentID)
+		return err == nil && !threadTypes[parent.Type] && s.canServe(parent)
+	}
+	guild, err := s.bots.forGuild(ch.GuildID).Cabinet.Guild(ch.GuildID)
+	if err != nil {
+		return false
+	}
+	self, err := s.selfMember(ch.GuildID)
+	if err != nil {
+		return false
+	}
+	if !discord.CalcOverwrites(*guild, *ch, *self).Has(servePermissions) {
+		return false
+	}
+	// A member without roles has the permissions of @everyone.
+	everyone := discord.Member{}
+	return discord.CalcOverwrites(*guild, *ch, everyone).Has(discord.PermissionViewChannel)
+}
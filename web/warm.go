@@ -0,0 +1,318 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+)
+
+// warmInterval is how often the most viewed posts are looked up and their
+// pages rendered again. Pages show relative times, so warmed pages are
+// only served for twice as long after they were rendered.
+const warmInterval = 5 * time.Minute
+
+// warmDelay is how long a warmed page is left after a change to its post
+// before it is rendered again, so that a burst of messages renders it once.
+const warmDelay = 5 * time.Second
+
+// postPathRegex matches the paths of the first pages of posts, which are
+// the ones that are warmed.
+var postPathRegex = regexp.MustCompile(`^/\d+/\d+/(\d+)$`)
+
+// warmedPage is a post's page as readers without cookies get it.
+type warmedPage struct {
+	path       string
+	header     http.Header
+	body, gzip []byte
+	etag       string
+	renderedAt time.Time
+}
+
+// pageWarmer keeps the pages of the most viewed posts rendered and
+// compressed, so that they are served without rendering them.
+type pageWarmer struct {
+	top int
+
+	mu    sync.Mutex
+	pages map[discord.ChannelID]*warmedPage
+	// dirty are the warmed posts that changed since they were rendered.
+	dirty   map[discord.ChannelID]bool
+	changed chan struct{}
+}
+
+func newPageWarmer(top int) *pageWarmer {
+	return &pageWarmer{
+		top:     top,
+		pages:   make(map[discord.ChannelID]*warmedPage),
+		dirty:   make(map[discord.ChannelID]bool),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// renderingKey marks the requests that the server makes to itself to render
+// pages with, which aren't counted as views or served from its caches.
+type renderingKey struct{}
+
+func isRendering(r *http.Request) bool {
+	return r.Context().Value(renderingKey{}) != nil
+}
+
+// touch marks the page of a post to be rendered again, if it is warmed.
+func (pw *pageWarmer) touch(id discord.ChannelID) {
+	pw.mu.Lock()
+	_, ok := pw.pages[id]
+	if ok {
+		pw.dirty[id] = true
+	}
+	pw.mu.Unlock()
+	if ok {
+		select {
+		case pw.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forget drops the warmed pages of posts, which are warmed again once they
+// are among the most viewed the next time those are looked up.
+func (pw *pageWarmer) forget(ids map[discord.ChannelID]bool) {
+	pw.mu.Lock()
+	for id := range ids {
+		delete(pw.pages, id)
+		delete(pw.dirty, id)
+	}
+	pw.mu.Unlock()
+}
+
+// handleEvent marks the pages of posts to be rendered again when their
+// messages or the posts themselves change.
+func (pw *pageWarmer) handleEvent(ev interface{}) {
+	switch ev := ev.(type) {
+	case *gateway.MessageCreateEvent:
+		pw.touch(ev.ChannelID)
+	case *gateway.MessageUpdateEvent:
+		pw.touch(ev.ChannelID)
+	case *gateway.MessageDeleteEvent:
+		pw.touch(ev.ChannelID)
+	case *gateway.MessageDeleteBulkEvent:
+		pw.touch(ev.ChannelID)
+	case *gateway.ThreadUpdateEvent:
+		pw.touch(ev.ID)
+	case *gateway.ThreadDeleteEvent:
+		pw.forget(map[discord.ChannelID]bool{ev.ID: true})
+	case *gateway.ChannelUpdateEvent:
+		// Forums and roles can change who may see any post.
+		if ev.Type == discord.GuildForum {
+			pw.clear()
+		}
+	case *gateway.GuildRoleUpdateEvent, *gateway.GuildRoleDeleteEvent:
+		pw.clear()
+	}
+}
+
+func (pw *pageWarmer) clear() {
+	pw.mu.Lock()
+	pw.pages = make(map[discord.ChannelID]*warmedPage)
+	pw.dirty = make(map[discord.ChannelID]bool)
+	pw.mu.Unlock()
+}
+
+// serveWarmed is a middleware that answers plain requests for warmed pages
+// with them.
+func (s *Server) serveWarmed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := s.warmer
+		m := postPathRegex.FindStringSubmatch(r.URL.Path)
+		if m == nil || isRendering(r) || !s.plainRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sf, _ := discord.ParseSnowflake(m[1])
+		id := discord.ChannelID(sf)
+		pw.mu.Lock()
+		page, ok := pw.pages[id]
+		pw.mu.Unlock()
+		if !ok || page.path != r.URL.Path || time.Since(page.renderedAt) > 2*warmInterval {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if post, err := s.bots.forChannel(id).Cabinet.Channel(id); err != nil || !s.servableFromCache(post) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for k, v := range page.header {
+			w.Header()[k] = append([]string(nil), v...)
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		body, etag := page.body, page.etag
+		if acceptsGzip(r) {
+			body, etag = page.gzip, strings.TrimSuffix(etag, `"`)+`-gzip"`
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	})
+}
+
+// plainRequest reports whether a request gets a page as it looks by
+// default, which is the same for all such requests, so that it can be
+// served from a cache: it has no cookies or query parameters, doesn't ask
+// for another language than the default and is made to the host of
+// SiteURL. Pages say so while a gateway is disconnected, so none are plain
+// then.
+func (s *Server) plainRequest(r *http.Request) bool {
+	if r.URL.RawQuery != "" || r.Header.Get("Cookie") != "" || s.gateways.degraded() {
+		return false
+	}
+	if site, err := url.Parse(s.site().URL); err != nil || site.Host != r.Host {
+		return false
+	}
+	def := s.site().defaultLocale
+	return matchLocale(s.locales, r.Header.Get("Accept-Language"), def) == def
+}
+
+// acceptsGzip reports whether a client accepts responses compressed with
+// gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(name, "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// WarmPages renders the pages of the most viewed posts of the last day
+// every warmInterval, and those that changed shortly after they did.
+func (s *Server) WarmPages() {
+	s.warmTopPages()
+	ticker := time.NewTicker(warmInterval)
+	for {
+		select {
+		case <-ticker.C:
+			s.warmTopPages()
+		case <-s.warmer.changed:
+			time.Sleep(warmDelay)
+			s.warmer.mu.Lock()
+			dirty := s.warmer.dirty
+			s.warmer.dirty = make(map[discord.ChannelID]bool)
+			var paths []string
+			for id := range dirty {
+				if page, ok := s.warmer.pages[id]; ok {
+					paths = append(paths, page.path)
+				}
+			}
+			s.warmer.mu.Unlock()
+			for _, path := range paths {
+				s.warmPage(path)
+			}
+		}
+	}
+}
+
+// warmTopPages renders the pages of the most viewed posts, and drops the
+// warmed pages of the posts that aren't among them anymore.
+func (s *Server) warmTopPages() {
+	ctx, cancel := context.WithTimeout(context.Background(), pageTimeout)
+	defer cancel()
+	// More paths than are warmed are looked up, since the most viewed
+	// pages include guilds' and forums' too.
+	counts, err := s.db.PageViews(ctx, time.Now().UTC().AddDate(0, 0, -1), "path", 4*s.warmer.top)
+	if err != nil {
+		log.Println("Error looking up the most viewed pages:", err)
+		return
+	}
+	top := make(map[discord.ChannelID]string)
+	for _, c := range counts {
+		if len(top) == s.warmer.top {
+			break
+		}
+		if m := postPathRegex.FindStringSubmatch(c.Key); m != nil {
+			sf, _ := discord.ParseSnowflake(m[1])
+			top[discord.ChannelID(sf)] = c.Key
+		}
+	}
+	s.warmer.mu.Lock()
+	for id := range s.warmer.pages {
+		if _, ok := top[id]; !ok {
+			delete(s.warmer.pages, id)
+		}
+	}
+	s.warmer.mu.Unlock()
+	for _, path := range top {
+		s.warmPage(path)
+	}
+}
+
+// warmPage renders the page at path as a plain reader would get it, and
+// keeps it if it was found.
+func (s *Server) warmPage(path string) {
+	m := postPathRegex.FindStringSubmatch(path)
+	if m == nil {
+		return
+	}
+	sf, _ := discord.ParseSnowflake(m[1])
+	rec, ok := s.renderPlainPage(path)
+	if !ok {
+		return
+	}
+	body := rec.Body.Bytes()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		log.Printf("Error compressing %s: %v", path, err)
+		return
+	}
+	header := make(http.Header)
+	for _, k := range []string{"Content-Type", "Cache-Control", "Vary", "Last-Modified", "Surrogate-Key", "Link", "X-Robots-Tag", "Content-Language"} {
+		if v := rec.Header().Values(k); len(v) > 0 {
+			header[k] = v
+		}
+	}
+	page := &warmedPage{
+		path:       path,
+		header:     header,
+		body:       body,
+		gzip:       gz.Bytes(),
+		etag:       fmt.Sprintf("\"%x\"", crc32.ChecksumIEEE(body)),
+		renderedAt: time.Now(),
+	}
+	s.warmer.mu.Lock()
+	s.warmer.pages[discord.ChannelID(sf)] = page
+	s.warmer.mu.Unlock()
+}
+
+// renderPlainPage renders the page at path as plain requests get it. It
+// reports whether the page was found, and isn't stale because Discord
+// couldn't be reached.
+func (s *Server) renderPlainPage(path string) (*httptest.ResponseRecorder, bool) {
+	site, err := url.Parse(s.site().URL)
+	if err != nil || site.Host == "" {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pageTimeout)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(context.WithValue(ctx, renderingKey{}, true))
+	req.Host, req.RemoteAddr = site.Host, ""
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") == cacheNone {
+		return nil, false
+	}
+	return rec, true
+}
@@ -0,0 +1,441 @@
+// Package web serves the forum channels of the Discord guilds that bots are
+// in as web pages. Main runs the dforum command, and New makes a server that
+// other programs can serve next to their own bots.
+package web
+
+import (
+	"context"
+	"embed"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/IoIxD/dforum/database"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/httputil/httpdriver"
+)
+
+//go:embed resources
+var embedfs embed.FS
+
+// Config is the configuration of a server, as read from config.toml by
+// ReadConfig.
+type Config struct {
+	BotToken             string
+	BotTokens            []string
+	ListenAddr           string
+	HTTPListenAddr       string
+	TLSCert              string
+	TLSKey               string
+	ACMEDomains          []string
+	ACMEEmail            string
+	ACMEDirectory        string
+	ACMEDir              string
+	Resources            resourceDirs
+	SiteURL              string
+	ServiceName          string
+	ServerHostedIn       string
+	SitemapDir           string
+	MediaDir             string
+	RenderCacheDir       string
+	ReloadTemplates      bool
+	TraceDiscordREST     bool
+	DiscordConcurrency   int
+	DiscordRate          float64
+	DebugErrors          bool
+	ServeNSFW            bool
+	DefaultLocale        string
+	DefaultTimezone      string
+	DefaultTheme         string
+	PostsPerPage         int
+	MessagesPerPage      int
+	NewestFirst          bool
+	MinForumPosts        int
+	MaxForumInactiveDays int
+	Participants         int
+	LiveUpdates          bool
+	CustomHeadHTML       string
+	CustomFooterFile     string
+	CardFont             string
+	UserAgent            string
+	OperatorContact      string
+	PurgeToken           string
+	AdminToken           string
+	Analytics            bool
+	WarmPages            int
+	RateLimit            float64
+	RateLimitBurst       int
+	RateLimitExempt      []string
+	TrustedProxies       []string
+	AccessLog            string
+	AccessLogFormat      string
+	AccessLogIPs         string
+	AccessLogMaxSizeMB   int
+	AccessLogBackups     int
+	LazyFetching         bool
+	MembersIntent        bool
+	ShardCount           int
+	ShardIDs             []int
+	MaxCachedChannels    int
+	Webhooks             []WebhookConfig
+	ReportWebhook        string
+	WebSubHub            string
+	ReportUsers          []string
+	Redactions           []RedactionConfig
+	EditHistory          bool
+	MaxRevisions         int
+	Tombstones           bool
+	TombstoneContent     bool
+	Database             string
+	Guilds               map[string]GuildConfig
+}
+
+type TraceClient struct {
+	httpdriver.Client
+}
+
+func (c TraceClient) Do(req httpdriver.Request) (httpdriver.Response, error) {
+	then := time.Now()
+	resp, err := c.Client.Do(req)
+	log.Printf("Discord REST: %s in %s", req.GetPath(), time.Since(then))
+	return resp, err
+}
+
+// databaseOptions returns the options of the database that the config
+// sets.
+func databaseOptions(c Config) database.Options {
+	var opts database.Options
+	if c.EditHistory {
+		opts.MaxRevisions = c.MaxRevisions
+	}
+	opts.Tombstones = c.Tombstones
+	opts.RedactTombstones = !c.TombstoneContent
+	return opts
+}
+
+func Main() {
+	cfgpath := flag.String("config", "config.toml", "path to config.toml")
+	jobs := flag.Int("jobs", 4, "number of posts backfill fetches at once")
+	addConfigFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	var freezeGuild discord.GuildID
+	var exportDir string
+	var exportGuildID discord.GuildID
+	var exportPath string
+	var backfill bool
+	var backfillGuilds []discord.GuildID
+	switch flag.Arg(0) {
+	case "", "serve", "check", "dump-config", "demo":
+	case "purge-cache":
+		if flag.NArg() < 2 {
+			log.Fatalln("Usage: dforum [-config path] purge-cache <guild or channel ID...>")
+		}
+	case "backfill":
+		backfill = true
+		for _, arg := range flag.Args()[1:] {
+			sf, err := discord.ParseSnowflake(arg)
+			if err != nil {
+				log.Fatalln("Usage: dforum [-config path] [-jobs n] backfill [guild ID...]")
+			}
+			backfillGuilds = append(backfillGuilds, discord.GuildID(sf))
+		}
+	case "import":
+		if flag.NArg() < 2 {
+			log.Fatalln("Usage: dforum [-config path] import <export.json...>")
+		}
+	case "export":
+		if flag.NArg() != 3 {
+			log.Fatalln("Usage: dforum [-config path] export <guild ID> <file.tar.gz>")
+		}
+		sf, err := discord.ParseSnowflake(flag.Arg(1))
+		if err != nil {
+			log.Fatalln("Invalid guild ID:", err)
+		}
+		exportGuildID, exportPath = discord.GuildID(sf), flag.Arg(2)
+	case "freeze":
+		if flag.NArg() != 3 {
+			log.Fatalln("Usage: dforum [-config path] freeze <guild ID> <export directory>")
+		}
+		sf, err := discord.ParseSnowflake(flag.Arg(1))
+		if err != nil {
+			log.Fatalln("Invalid guild ID:", err)
+		}
+		freezeGuild, exportDir = discord.GuildID(sf), flag.Arg(2)
+	default:
+		fmt.Fprintln(flag.CommandLine.Output(), "Unknown command:", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	config, err := ReadConfig(*cfgpath)
+	if err != nil {
+		log.Fatalln("Error loading config:", err)
+	}
+	switch flag.Arg(0) {
+	case "check":
+		if !check(config) {
+			os.Exit(1)
+		}
+		return
+	case "dump-config":
+		if err := dumpConfig(os.Stdout, config); err != nil {
+			log.Fatalln("Error writing config:", err)
+		}
+		return
+	case "purge-cache":
+		if err := purgeCache(config, flag.Args()[1:]); err != nil {
+			log.Fatalln("Error purging cache:", err)
+		}
+		return
+	case "import":
+		if err := importExports(config, flag.Args()[1:]); err != nil {
+			log.Fatalln("Error importing:", err)
+		}
+		return
+	}
+	var demo *fakeDiscord
+	if flag.Arg(0) == "demo" {
+		demo = newDemoDiscord(time.Now())
+		err = validateOptions(config)
+	} else {
+		err = validateConfig(config)
+	}
+	if err != nil {
+		log.Fatalln("Invalid config:", err)
+	}
+	ctx, done := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer done()
+
+	// The limiter is shared by all the bots, since they make their
+	// requests from the same address.
+	limiter := newDiscordLimiter(config.DiscordConcurrency, config.DiscordRate)
+	var bots bots
+	tokens := config.tokens()
+	if demo != nil {
+		bots, tokens = append(bots, demo.bot()), nil
+	}
+	for _, token := range tokens {
+		idents, err := shardIdentifiers(ctx, "Bot "+token, config.ShardCount, config.ShardIDs)
+		if err != nil {
+			log.Fatalln("Error setting up shards:", err)
+		}
+		intents := gateway.IntentGuildMessages | gateway.IntentGuilds
+		for i, ident := range idents {
+			state := state.NewWithIdentifier(ident)
+			setDiscordHeader(state.Client, requestHeader(config))
+			if config.TraceDiscordREST {
+				state.Client.Client.Client = TraceClient{state.Client.Client.Client}
+			}
+			if limiter != nil {
+				state.Client.Client.Client = limitedClient{state.Client.Client.Client, limiter}
+			}
+			state.Client.Client.Client = retryingClient{state.Client.Client.Client}
+			if i == 0 && config.MembersIntent {
+				// Discord closes the connection of bots that ask for a
+				// privileged intent they weren't granted, so the members
+				// are looked up over REST instead.
+				granted, err := membersIntentGranted(state)
+				switch {
+				case err != nil:
+					log.Printf("Bot %d: %v", len(bots)+1, err)
+					intents |= gateway.IntentGuildMembers
+				case !granted:
+					log.Printf("Bot %d isn't granted the Server Members intent, looking up members over REST instead. Turn it on in the Discord developer portal to request them from the gateway.", len(bots)+1)
+				default:
+					intents |= gateway.IntentGuildMembers
+				}
+			}
+			state.AddIntents(intents)
+			bots = append(bots, state)
+		}
+	}
+	dbopts := databaseOptions(config)
+	var db database.Database
+	if demo != nil {
+		db = database.OpenMemory(dbopts)
+	} else if db, err = database.OpenPostgres(config.Database, dbopts); err != nil {
+		log.Fatalln("Opening database connection:", err)
+	}
+	server, err := New(bots, db, config)
+	if err != nil {
+		log.Fatalln("Error setting up the server:", err)
+	}
+	if demo != nil {
+		demo.ready(bots[0])
+		log.Printf("Serving the demo guild %q (%s)", demo.guild.Name, demo.guild.ID)
+	} else {
+		for _, state := range bots {
+			ready, cancel := state.ChanFor(func(e interface{}) bool {
+				_, ok := e.(*gateway.ReadyEvent)
+				return ok
+			})
+			if err = state.Open(ctx); err != nil {
+				log.Fatalln("Error while opening gateway connection to Discord:", err)
+			}
+			self, err := state.Me()
+			if err != nil {
+				log.Fatalln("Error fetching self:", err)
+			}
+			select {
+			case <-ready:
+			case <-ctx.Done():
+				return
+			}
+			cancel()
+			log.Printf("Connected to Discord as %s#%s (%s)\n", self.Username, self.Discriminator, self.ID)
+		}
+	}
+	if freezeGuild.IsValid() {
+		if err := server.freezeGuild(ctx, freezeGuild, exportDir); err != nil {
+			log.Fatalln("Error freezing guild:", err)
+		}
+		return
+	}
+	if exportGuildID.IsValid() {
+		if err := server.writeBundle(ctx, exportGuildID, exportPath); err != nil {
+			log.Fatalln("Error exporting guild:", err)
+		}
+		return
+	}
+	if backfill {
+		if err := server.backfill(ctx, backfillGuilds, *jobs); err != nil {
+			log.Fatalln("Error backfilling:", err)
+		}
+		return
+	}
+	server.Start()
+	go reloadOnHangup(server, *cfgpath)
+	httpserver := &http.Server{
+		Addr:           config.ListenAddr,
+		Handler:        server,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+	inherited, err := inheritedListeners()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	ln, err := listen(inherited, 0, config.ListenAddr)
+	if err != nil {
+		log.Fatalln("Error listening:", err)
+	}
+	lns := []net.Listener{ln}
+	httperr := make(chan error, 2)
+	serve := func() error {
+		return httpserver.Serve(ln)
+	}
+	// redirect serves HTTP next to HTTPS, redirecting to it.
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS)
+	switch {
+	case config.TLSCert != "":
+		serve = func() error {
+			return httpserver.ServeTLS(ln, config.TLSCert, config.TLSKey)
+		}
+	case len(config.ACMEDomains) > 0:
+		acme := newACMEManager(config, newHTTPClient(requestHeader(config), 30*time.Second))
+		httpserver.TLSConfig = acme.TLSConfig()
+		serve = func() error {
+			return httpserver.ServeTLS(ln, "", "")
+		}
+		// The CA checks that the domains point here with HTTP-01
+		// challenges, which are answered on plain HTTP.
+		redirect = acme.HTTPHandler(redirect)
+		if config.HTTPListenAddr == "" {
+			config.HTTPListenAddr = ":80"
+		}
+	default:
+		config.HTTPListenAddr = ""
+	}
+	var httpRedirect *http.Server
+	if config.HTTPListenAddr != "" {
+		ln, err := listen(inherited, 1, config.HTTPListenAddr)
+		if err != nil {
+			log.Fatalln("Error listening:", err)
+		}
+		lns = append(lns, ln)
+		httpRedirect = &http.Server{
+			Addr:           config.HTTPListenAddr,
+			Handler:        redirect,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		}
+		go func() {
+			httperr <- httpRedirect.Serve(ln)
+		}()
+	}
+	go func() {
+		httperr <- serve()
+	}()
+	signalReady()
+	go restartOnSignal(lns, done)
+	select {
+	case <-ctx.Done():
+		done()
+		// Requests that are being served are given some time to finish,
+		// but not so long that a stuck one holds up a restart.
+		shutdownctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if httpRedirect != nil {
+			httpRedirect.Shutdown(shutdownctx)
+		}
+		err := httpserver.Shutdown(shutdownctx)
+		server.Close()
+		if err != nil {
+			log.Fatalln("HTTP server shutdown:", err)
+		}
+	case err := <-httperr:
+		if err != nil {
+			log.Fatalln("HTTP server encountered error:", err)
+		}
+	}
+}
+
+// reloadOnHangup reloads the config whenever the process gets a SIGHUP.
+func reloadOnHangup(server *Server, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		config, err := ReadConfig(path)
+		if err == nil {
+			err = server.reload(config)
+		}
+		if err != nil {
+			log.Println("Error reloading config, keeping the current one:", err)
+			continue
+		}
+		log.Println("Reloaded config")
+	}
+}
+
+// shutdownTimeout is how long requests being served when the server stops
+// have to finish.
+const shutdownTimeout = 30 * time.Second
+
+// restartOnSignal hands the listeners over to a new process when the process
+// gets a SIGUSR2, and stops this one once the new one is serving.
+func restartOnSignal(lns []net.Listener, stop func()) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	for range usr2 {
+		log.Println("Restarting")
+		if err := restart(lns); err != nil {
+			log.Println("Error restarting, keeping this process:", err)
+			continue
+		}
+		stop()
+		return
+	}
+}
@@ -0,0 +1,159 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// WebhookConfig is a URL that is notified of new posts.
+type WebhookConfig struct {
+	URL string
+	// Forums are the IDs of the forums whose new posts are sent. New posts
+	// in all forums are sent if there are none.
+	Forums []string
+	// Discord sends the notifications as a Discord webhook message with an
+	// embed instead of a PostNotification.
+	Discord bool
+}
+
+// PostNotification is the JSON payload sent to webhooks when a post is
+// created.
+type PostNotification struct {
+	Event     string            `json:"event"`
+	GuildID   discord.GuildID   `json:"guild_id"`
+	Guild     string            `json:"guild"`
+	ForumID   discord.ChannelID `json:"forum_id"`
+	Forum     string            `json:"forum"`
+	PostID    discord.ChannelID `json:"post_id"`
+	Title     string            `json:"title"`
+	AuthorID  discord.UserID    `json:"author_id"`
+	Tags      []string          `json:"tags"`
+	URL       string            `json:"url"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type discordWebhookMessage struct {
+	Username string          `json:"username,omitempty"`
+	Embeds   []discord.Embed `json:"embeds"`
+}
+
+// newPostAge is how old a thread can be when it is announced for it to be
+// taken as newly created. Threads are also announced to bots when they are
+// added to them.
+const newPostAge = 10 * time.Minute
+
+// webhookRetries is how many times sending a notification is retried when
+// the webhook fails, waiting webhookBackoff and then more each time.
+const (
+	webhookRetries = 3
+	webhookBackoff = 5 * time.Second
+)
+
+// notifyNewPost sends the webhooks watching a forum a notification when a
+// post is created in it. Only the bot serving the guild sends them, so
+// guilds that several bots are in aren't announced twice.
+func (s *Server) notifyNewPost(st *state.State, ev *gateway.ThreadCreateEvent) {
+	if ev.Type != discord.GuildPublicThread || time.Since(ev.ID.Time()) > newPostAge ||
+		s.bots.forGuild(ev.GuildID) != st {
+		return
+	}
+	forum, err := s.channel(ev.ParentID)
+	if err != nil || forum.Type != discord.GuildForum || (forum.NSFW && !s.site().ServeNSFW) || !s.canServe(forum) {
+		return
+	}
+	guild, err := st.Cabinet.Guild(ev.GuildID)
+	if err != nil {
+		return
+	}
+	n := PostNotification{
+		Event:     "post_created",
+		GuildID:   guild.ID,
+		Guild:     guild.Name,
+		ForumID:   forum.ID,
+		Forum:     forum.Name,
+		PostID:    ev.ID,
+		Title:     ev.Name,
+		AuthorID:  ev.OwnerID,
+		Tags:      []string{},
+		URL:       fmt.Sprintf("%s%s/%s/%s", s.site().URL, s.guildPath(guild.ID), forum.ID, ev.ID),
+		CreatedAt: ev.ID.Time().UTC(),
+	}
+	for _, tag := range postTags(forum, &ev.Channel) {
+		n.Tags = append(n.Tags, tag.Name)
+	}
+	for _, hook := range s.webhooks {
+		if !hook.watches(forum.ID) {
+			continue
+		}
+		hook := hook
+		go s.sendWebhook(hook, n)
+	}
+}
+
+func (hook WebhookConfig) watches(forum discord.ChannelID) bool {
+	if len(hook.Forums) == 0 {
+		return true
+	}
+	for _, id := range hook.Forums {
+		if id == forum.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) sendWebhook(hook WebhookConfig, n PostNotification) {
+	var payload any = n
+	if hook.Discord {
+		payload = discordWebhookMessage{
+			Username: s.site().ServiceName,
+			Embeds: []discord.Embed{{
+				Title:       n.Title,
+				URL:         n.URL,
+				Description: fmt.Sprintf("New post in %s on %s", n.Forum, n.Guild),
+				Timestamp:   discord.NewTimestamp(n.CreatedAt),
+			}},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling webhook payload: %v", err)
+		return
+	}
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.postWebhook(hook.URL, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookRetries {
+			log.Printf("Error notifying %s of %s: %v", hook.URL, n.PostID, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 5
+	}
+}
+
+// postWebhook sends a payload to a webhook, reporting whether it is worth
+// trying again if that fails.
+func (s *Server) postWebhook(url string, body []byte) (retry bool, err error) {
+	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook responded with %s", resp.Status)
+}
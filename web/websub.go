@@ -0,0 +1,75 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webSubDelay is how long new posts are collected for before the hub is
+// told that the feed changed, so that a burst of them is published once.
+const webSubDelay = 10 * time.Second
+
+// feedPath is the path of the feed of new posts, which is published to the
+// WebSub hub.
+const feedPath = "/recent.atom"
+
+// requestFeedPublish asks for the feed to be published to the WebSub hub,
+// unless that has already been asked for or there is no hub.
+func (s *Server) requestFeedPublish() {
+	if s.webSubHub == "" {
+		return
+	}
+	select {
+	case s.publishFeed <- struct{}{}:
+	default:
+	}
+}
+
+// PublishFeeds tells the WebSub hub whenever the feed of new posts changes,
+// so that the hub pushes it to its subscribers instead of them polling it.
+func (s *Server) PublishFeeds() {
+	for range s.publishFeed {
+		time.Sleep(webSubDelay)
+		topic := s.site().URL + feedPath
+		backoff := webhookBackoff
+		for attempt := 0; ; attempt++ {
+			retry, err := s.postWebSub(topic)
+			if err == nil {
+				break
+			}
+			if !retry || attempt == webhookRetries {
+				log.Printf("Error publishing %s to %s: %v", topic, s.webSubHub, err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 5
+		}
+	}
+}
+
+// postWebSub tells the hub that a topic changed, reporting whether it is
+// worth trying again if that fails.
+func (s *Server) postWebSub(topic string) (retry bool, err error) {
+	form := url.Values{"hub.mode": {"publish"}, "hub.url": {topic}}
+	resp, err := s.httpClient.Post(s.webSubHub, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("hub responded with %s", resp.Status)
+}
+
+// validHubURL reports whether u is the absolute http or https URL of a
+// hub.
+func validHubURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}